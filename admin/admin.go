@@ -0,0 +1,141 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/CoderI421/tcp-service/logger"
+	"github.com/CoderI421/tcp-service/server"
+)
+
+/*
+管理接口
+
+	GET    /sessions        列出所有存活的会话（包括尚未握手的）
+	GET    /sessions/{sid}  查看单个会话
+	DELETE /sessions/{sid}  关闭会话
+	GET    /loglevel        查看日志级别
+	PUT    /loglevel        设置日志级别，body 为 {"level":"debug"}
+	POST   /drain           停止接受新连接，已有连接继续服务
+
+返回内容均为 json，出错时为 {"error":"..."}
+*/
+
+// Handler 返回 srv 的管理接口
+func Handler(srv *server.Server) http.Handler {
+	h := &handler{srv: srv}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sessions", h.sessions)
+	mux.HandleFunc("/sessions/", h.session)
+	mux.HandleFunc("/loglevel", h.logLevel)
+	mux.HandleFunc("/drain", h.drain)
+	return mux
+}
+
+type handler struct {
+	srv *server.Server
+}
+
+// levelBody /loglevel 的请求与响应
+type levelBody struct {
+	Level string `json:"level"`
+}
+
+func (h *handler) sessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	list := h.srv.LiveSessions()
+	infos := make([]server.SessionInfo, 0, len(list))
+	for _, sess := range list {
+		infos = append(infos, sess.Info())
+	}
+	writeJSON(w, http.StatusOK, infos)
+}
+
+func (h *handler) session(w http.ResponseWriter, r *http.Request) {
+	sid, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/sessions/"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid sid: %w", err))
+		return
+	}
+	sess, ok := h.srv.LiveSession(sid)
+	if !ok {
+		writeError(w, http.StatusNotFound, server.ErrSessionNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, sess.Info())
+	case http.MethodDelete:
+		info := sess.Info()
+		sess.Close()
+		logger.Infof("admin: close session sid=%d id=%s remote=%s", info.SID, info.ClientID, info.RemoteAddr)
+		writeJSON(w, http.StatusOK, info)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+	}
+}
+
+func (h *handler) logLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var body levelBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		l, err := logger.ParseLevel(body.Level)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		logger.SetLevel(l)
+		logger.Infof("admin: set log level to %s", l)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPut)
+		return
+	}
+	writeJSON(w, http.StatusOK, levelBody{Level: logger.GetLevel().String()})
+}
+
+func (h *handler) drain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if err := h.srv.Drain(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	logger.Infof("admin: draining, %d sessions left", len(h.srv.LiveSessions()))
+	writeJSON(w, http.StatusAccepted, struct {
+		Draining bool `json:"draining"`
+		Sessions int  `json:"sessions"`
+	}{true, len(h.srv.LiveSessions())})
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow ...string) {
+	w.Header().Set("Allow", strings.Join(allow, ", "))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Warnf("admin: write response error: %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CoderI421/tcp-service/client"
+	"github.com/CoderI421/tcp-service/logger"
+	"github.com/CoderI421/tcp-service/server"
+	"github.com/CoderI421/tcp-service/transport"
+)
+
+func startServer(t *testing.T) (*server.Server, *transport.PipeListener) {
+	t.Helper()
+	srv := &server.Server{}
+	l := transport.NewPipeListener()
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return srv, l
+}
+
+func dial(t *testing.T, l *transport.PipeListener, id string) *client.Client {
+	t.Helper()
+	conn, err := l.Dial()
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	c, err := client.New(conn, client.Config{ID: id})
+	if err != nil {
+		t.Fatalf("handshake error: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func do(t *testing.T, h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return w
+}
+
+func TestHandler_Sessions(t *testing.T) {
+	srv, l := startServer(t)
+	c := dial(t, l, "00000001")
+	h := Handler(srv)
+
+	w := do(t, h, http.MethodGet, "/sessions", "")
+	var infos []server.SessionInfo
+	if err := json.NewDecoder(w.Body).Decode(&infos); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if w.Code != http.StatusOK || len(infos) != 1 || infos[0].ClientID != "00000001" {
+		t.Fatalf("GET /sessions = %d, %+v", w.Code, infos)
+	}
+
+	target := "/sessions/" + strconv.FormatUint(infos[0].SID, 10)
+	if w = do(t, h, http.MethodGet, target, ""); w.Code != http.StatusOK {
+		t.Errorf("GET %s = %d, want %d", target, w.Code, http.StatusOK)
+	}
+	if w = do(t, h, http.MethodDelete, target, ""); w.Code != http.StatusOK {
+		t.Errorf("DELETE %s = %d, want %d", target, w.Code, http.StatusOK)
+	}
+	select {
+	case <-c.Done():
+	case <-time.After(time.Second):
+		t.Error("client not disconnected")
+	}
+
+	tests := []struct {
+		method string
+		target string
+		want   int
+	}{
+		{method: http.MethodGet, target: "/sessions/abc", want: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/sessions/999", want: http.StatusNotFound},
+		{method: http.MethodPost, target: "/sessions", want: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+tt.target, func(t *testing.T) {
+			if w := do(t, h, tt.method, tt.target, ""); w.Code != tt.want {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.target, w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler_LogLevel(t *testing.T) {
+	defer logger.SetLevel(logger.GetLevel())
+	h := Handler(&server.Server{})
+
+	tests := []struct {
+		body     string
+		wantCode int
+		want     logger.Level
+	}{
+		{body: `{"level":"debug"}`, wantCode: http.StatusOK, want: logger.DebugLevel},
+		{body: `{"level":"warn"}`, wantCode: http.StatusOK, want: logger.WarnLevel},
+		{body: `{"level":"trace"}`, wantCode: http.StatusBadRequest, want: logger.WarnLevel},
+		{body: `level=info`, wantCode: http.StatusBadRequest, want: logger.WarnLevel},
+	}
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			if w := do(t, h, http.MethodPut, "/loglevel", tt.body); w.Code != tt.wantCode {
+				t.Errorf("PUT /loglevel = %d, want %d", w.Code, tt.wantCode)
+			}
+			if got := logger.GetLevel(); got != tt.want {
+				t.Errorf("level = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	w := do(t, h, http.MethodGet, "/loglevel", "")
+	if got := strings.TrimSpace(w.Body.String()); got != `{"level":"warn"}` {
+		t.Errorf("GET /loglevel = %s", got)
+	}
+}
+
+func TestHandler_Drain(t *testing.T) {
+	srv, l := startServer(t)
+	c := dial(t, l, "00000001")
+	h := Handler(srv)
+
+	if w := do(t, h, http.MethodPost, "/drain", ""); w.Code != http.StatusAccepted {
+		t.Fatalf("POST /drain = %d, want %d", w.Code, http.StatusAccepted)
+	}
+	if !srv.Draining() {
+		t.Error("Draining() = false after drain")
+	}
+	select {
+	case <-c.Done():
+		t.Error("existing client disconnected by drain")
+	default:
+	}
+	if _, err := l.Dial(); err == nil {
+		t.Error("Dial() after drain succeeded")
+	}
+}
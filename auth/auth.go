@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"sync"
+)
+
+/*
+Con 握手鉴权
+
+客户端在 Con 的 Payload 中携带凭证，服务端按客户端标识校验
+*/
+
+// Authenticator 校验 Con 握手中客户端标识对应的凭证
+type Authenticator interface {
+	Authenticate(id string, credential []byte) bool
+}
+
+// Static 静态凭证表，客户端标识 -> 密钥，并发安全
+// 凭证可在运行时通过 Set 整体替换，已建立的会话不受影响
+type Static struct {
+	mu    sync.RWMutex
+	creds map[string]string
+}
+
+// NewStatic 创建静态凭证表，creds 会被复制
+func NewStatic(creds map[string]string) *Static {
+	s := &Static{}
+	s.Set(creds)
+	return s
+}
+
+// Set 替换全部凭证
+func (s *Static) Set(creds map[string]string) {
+	m := make(map[string]string, len(creds))
+	for id, secret := range creds {
+		m[id] = secret
+	}
+
+	s.mu.Lock()
+	s.creds = m
+	s.mu.Unlock()
+}
+
+// Len 返回凭证数量
+func (s *Static) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.creds)
+}
+
+// Authenticate 客户端标识存在且凭证一致时通过，比较为常数时间
+func (s *Static) Authenticate(id string, credential []byte) bool {
+	s.mu.RLock()
+	secret, ok := s.creds[id]
+	s.mu.RUnlock()
+
+	if !ok {
+		// 未知标识同样做一次比较，避免通过耗时区分标识是否存在
+		subtle.ConstantTimeCompare(credential, credential)
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(secret), credential) == 1
+}
@@ -0,0 +1,43 @@
+package auth
+
+import "testing"
+
+func TestStatic_Authenticate(t *testing.T) {
+	s := NewStatic(map[string]string{"00000001": "secret"})
+
+	tests := []struct {
+		name       string
+		id         string
+		credential string
+		want       bool
+	}{
+		{name: "OK", id: "00000001", credential: "secret", want: true},
+		{name: "WrongSecret", id: "00000001", credential: "secreT", want: false},
+		{name: "Empty", id: "00000001", credential: "", want: false},
+		{name: "UnknownID", id: "00000002", credential: "secret", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.Authenticate(tt.id, []byte(tt.credential)); got != tt.want {
+				t.Errorf("Authenticate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatic_Set(t *testing.T) {
+	creds := map[string]string{"00000001": "old"}
+	s := NewStatic(creds)
+	creds["00000001"] = "changed" // NewStatic 复制了凭证
+	if !s.Authenticate("00000001", []byte("old")) {
+		t.Fatal("Authenticate() with old secret = false, want true")
+	}
+
+	s.Set(map[string]string{"00000001": "new"})
+	if s.Authenticate("00000001", []byte("old")) {
+		t.Error("Authenticate() after Set with old secret = true, want false")
+	}
+	if !s.Authenticate("00000001", []byte("new")) || s.Len() != 1 {
+		t.Error("Authenticate() after Set with new secret = false, want true")
+	}
+}
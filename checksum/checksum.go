@@ -0,0 +1,277 @@
+package checksum
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cespare/xxhash/v2"
+
+	"github.com/CoderI421/tcp-service/frame"
+)
+
+/*
+frame 的校验和，用于发现 TCP 没有察觉的数据损坏（如有问题的中间设备）
+
+客户端在 Con 的 OptionChecksum 中按优先级列出支持的算法，服务端选出双方都支持的第一个，
+在 ConAck 的 OptionChecksum 中返回。之后双方发送的每个 frame 都带上校验和
+
+带校验和的 frame 带有 frame.FlagChecksum，只有带版本号的帧头（frame.HeaderCodec）能携带：
+
+	frameHeader（flags 含 FlagChecksum）
+	1 byte:  algorithm
+	payload
+	4/8 bytes: 对 algorithm 与 payload 计算的校验和，大端序
+
+frame 自带算法，接收方按 frame 中的标记校验，不需要与对方切换的时机对齐
+帧头不在校验范围内，长度被改动时之后的 frame 无法对齐，一般会以其他错误结束连接
+也可以不经协商，由双方直接设置 Codec 使用的算法
+*/
+
+// Algorithm 校验和算法
+type Algorithm uint8
+
+const (
+	None   Algorithm = iota // 不校验
+	CRC32                   // crc32，IEEE 多项式，4 字节
+	CRC32C                  // crc32，Castagnoli 多项式，4 字节，多数 CPU 有硬件加速
+	XXHash                  // xxhash64，8 字节
+)
+
+var (
+	// ErrUnsupported 不支持的校验和算法
+	ErrUnsupported = errors.New("checksum: unsupported algorithm")
+	// ErrUnexpected 设置了算法之后收到不带校验和、或使用其他算法的 frame
+	ErrUnexpected = errors.New("checksum: unexpected algorithm")
+)
+
+var names = map[Algorithm]string{
+	None:   "none",
+	CRC32:  "crc32",
+	CRC32C: "crc32c",
+	XXHash: "xxhash",
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func (a Algorithm) String() string {
+	if s, ok := names[a]; ok {
+		return s
+	}
+	return fmt.Sprintf("algorithm(%d)", uint8(a))
+}
+
+// Supported 是否支持该算法，None 不算
+func (a Algorithm) Supported() bool {
+	_, ok := names[a]
+	return ok && a != None
+}
+
+// Size 校验和的字节数，不支持的算法返回 0
+func (a Algorithm) Size() int {
+	switch a {
+	case CRC32, CRC32C:
+		return 4
+	case XXHash:
+		return 8
+	default:
+		return 0
+	}
+}
+
+// sum 把 b 的校验和追加到 dst 之后
+func (a Algorithm) sum(dst, b []byte) []byte {
+	var s [8]byte
+	switch a {
+	case CRC32:
+		binary.BigEndian.PutUint32(s[:], crc32.ChecksumIEEE(b))
+	case CRC32C:
+		binary.BigEndian.PutUint32(s[:], crc32.Checksum(b, castagnoli))
+	default:
+		binary.BigEndian.PutUint64(s[:], xxhash.Sum64(b))
+	}
+	return append(dst, s[:a.Size()]...)
+}
+
+// Parse 按名称解析校验和算法
+func Parse(s string) (Algorithm, error) {
+	for a, name := range names {
+		if strings.EqualFold(s, name) {
+			return a, nil
+		}
+	}
+	return None, fmt.Errorf("%w [%s]", ErrUnsupported, s)
+}
+
+// ParseList 解析以逗号分隔的算法列表，空字符串返回 nil
+func ParseList(s string) ([]Algorithm, error) {
+	var algs []Algorithm
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		a, err := Parse(name)
+		if err != nil {
+			return nil, err
+		}
+		if a != None {
+			algs = append(algs, a)
+		}
+	}
+	return algs, nil
+}
+
+// Encode 编码为 OptionChecksum 的 value
+func Encode(algs []Algorithm) []byte {
+	b := make([]byte, len(algs))
+	for i, a := range algs {
+		b[i] = byte(a)
+	}
+	return b
+}
+
+// Decode 解码 OptionChecksum 的 value
+func Decode(b []byte) []Algorithm {
+	algs := make([]Algorithm, len(b))
+	for i, v := range b {
+		algs[i] = Algorithm(v)
+	}
+	return algs
+}
+
+// Negotiate 按客户端的优先级选出服务端也支持的第一个算法，没有时返回 None
+func Negotiate(offered, supported []Algorithm) Algorithm {
+	for _, a := range offered {
+		if !a.Supported() {
+			continue
+		}
+		for _, s := range supported {
+			if a == s {
+				return a
+			}
+		}
+	}
+	return None
+}
+
+// Codec 带校验和的 frame 编解码器，实现 frame.FlagCodec
+// 发送使用的算法可在运行时切换，读写可在不同协程并发进行
+// 算法为 None 时解码按 frame 中的标记校验；设置了算法（即协商完成）之后只接受该算法的 frame，
+// 不带校验和或使用其他算法的 frame 返回 ErrUnexpected，清除 flag 不能绕过校验
+type Codec struct {
+	// Frame 底层的帧格式，需要能携带 frame.FlagChecksum，nil 表示 frame.HeaderCodec
+	Frame frame.FlagCodec
+	// Observe 每校验一个 frame 调用一次，ok 为 false 表示校验和不一致
+	Observe func(alg Algorithm, ok bool)
+
+	alg uint32 // 原子操作，发送使用的算法
+}
+
+// NewCodec 创建编解码器，发送方向默认不带校验和
+func NewCodec() *Codec {
+	return &Codec{}
+}
+
+// SetAlgorithm 切换发送使用的算法，None 表示不带校验和，不为 None 时接收的 frame 也必须使用该算法
+func (c *Codec) SetAlgorithm(a Algorithm) {
+	atomic.StoreUint32(&c.alg, uint32(a))
+}
+
+// Algorithm 返回发送使用的算法
+func (c *Codec) Algorithm() Algorithm {
+	return Algorithm(atomic.LoadUint32(&c.alg))
+}
+
+func (c *Codec) base() frame.FlagCodec {
+	if c.Frame != nil {
+		return c.Frame
+	}
+	return header
+}
+
+var header = frame.NewHeaderCodec()
+
+// Encode 编码一个 frame，设置了算法时在末尾加上校验和
+func (c *Codec) Encode(w io.Writer, framePayload frame.Payload) error {
+	return c.EncodeFlags(w, framePayload, 0)
+}
+
+// EncodeFlags 同 Encode，带校验和时在 flags 中加上 frame.FlagChecksum
+func (c *Codec) EncodeFlags(w io.Writer, framePayload frame.Payload, flags frame.Flags) error {
+	alg := c.Algorithm()
+	if alg == None {
+		return c.base().EncodeFlags(w, framePayload, flags)
+	}
+	if !alg.Supported() {
+		return fmt.Errorf("%w [%d]", ErrUnsupported, alg)
+	}
+	buf := make([]byte, 0, 1+len(framePayload)+alg.Size())
+	buf = append(append(buf, byte(alg)), framePayload...)
+	buf = alg.sum(buf, buf)
+	return c.base().EncodeFlags(w, buf, flags|frame.FlagChecksum)
+}
+
+// Decode 解码一个 frame 并校验，压缩或加密的 frame 返回 frame.ErrUnsupportedFlags
+func (c *Codec) Decode(r io.Reader) (frame.Payload, error) {
+	p, flags, err := c.DecodeFlags(r)
+	if err != nil {
+		return nil, err
+	}
+	if flags&(frame.FlagCompressed|frame.FlagEncrypted) != 0 {
+		return nil, frame.ErrUnsupportedFlags
+	}
+	return p, nil
+}
+
+// DecodeFlags 同 Decode，返回去掉校验和的 payload，flags 不含 frame.FlagChecksum
+// 校验和不一致时返回 frame.ErrChecksumMismatch，与设置的算法不符时返回 ErrUnexpected
+func (c *Codec) DecodeFlags(r io.Reader) (frame.Payload, frame.Flags, error) {
+	buf, flags, err := c.base().DecodeFlags(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	want := c.Algorithm()
+	if flags&frame.FlagChecksum == 0 {
+		if want != None {
+			return nil, 0, c.unexpected(want, None)
+		}
+		return buf, flags, nil
+	}
+	if len(buf) == 0 {
+		return nil, 0, frame.ErrInvalidLength
+	}
+	alg := Algorithm(buf[0])
+	if want != None && alg != want {
+		return nil, 0, c.unexpected(want, alg)
+	}
+	if !alg.Supported() {
+		return nil, 0, fmt.Errorf("%w [%d]", ErrUnsupported, alg)
+	}
+	n := len(buf) - alg.Size()
+	if n < 1 {
+		return nil, 0, frame.ErrInvalidLength
+	}
+	var sum [8]byte
+	ok := bytes.Equal(alg.sum(sum[:0], buf[:n]), buf[n:])
+	if c.Observe != nil {
+		c.Observe(alg, ok)
+	}
+	if !ok {
+		return nil, 0, fmt.Errorf("%w [%s]", frame.ErrChecksumMismatch, alg)
+	}
+	return buf[1:n], flags &^ frame.FlagChecksum, nil
+}
+
+// unexpected 收到的 frame 没有使用设置的算法 want，按校验失败记录
+func (c *Codec) unexpected(want, got Algorithm) error {
+	if c.Observe != nil {
+		c.Observe(want, false)
+	}
+	return fmt.Errorf("%w: %s, want %s", ErrUnexpected, got, want)
+}
@@ -0,0 +1,223 @@
+package checksum
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/CoderI421/tcp-service/frame"
+)
+
+func TestCodec(t *testing.T) {
+	payload := []byte("hello tcp-service")
+	tests := []struct {
+		name     string
+		alg      Algorithm
+		wantFlag bool
+	}{
+		{name: "None", alg: None, wantFlag: false},
+		{name: "CRC32", alg: CRC32, wantFlag: true},
+		{name: "CRC32C", alg: CRC32C, wantFlag: true},
+		{name: "XXHash", alg: XXHash, wantFlag: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCodec()
+			c.SetAlgorithm(tt.alg)
+			var buf bytes.Buffer
+			if err := c.Encode(&buf, payload); err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			raw, flags, err := frame.NewHeaderCodec().DecodeFlags(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("DecodeFlags() error = %v", err)
+			}
+			if got := flags&frame.FlagChecksum != 0; got != tt.wantFlag {
+				t.Errorf("Encode() checksum flag = %v, want %v", got, tt.wantFlag)
+			}
+			wantLen := len(payload)
+			if tt.wantFlag {
+				wantLen += 1 + tt.alg.Size()
+			}
+			if len(raw) != wantLen {
+				t.Errorf("Encode() payload length = %d, want %d", len(raw), wantLen)
+			}
+
+			// 解码方不需要设置算法
+			var observed []bool
+			dec := NewCodec()
+			dec.Observe = func(alg Algorithm, ok bool) { observed = append(observed, ok) }
+			got, err := dec.Decode(&buf)
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Errorf("Decode() = %q, want %q", got, payload)
+			}
+			if tt.wantFlag && !reflect.DeepEqual(observed, []bool{true}) {
+				t.Errorf("Observe() calls = %v, want [true]", observed)
+			}
+		})
+	}
+}
+
+func TestCodec_Decode(t *testing.T) {
+	encode := func(alg Algorithm, payload []byte) []byte {
+		c := NewCodec()
+		c.SetAlgorithm(alg)
+		var buf bytes.Buffer
+		if err := c.Encode(&buf, payload); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	corrupt := func(b []byte, i int) []byte {
+		b = append([]byte(nil), b...)
+		b[i] ^= 0x01
+		return b
+	}
+	header := func(flags frame.Flags, payload []byte) []byte {
+		var buf bytes.Buffer
+		if err := frame.NewHeaderCodec().EncodeFlags(&buf, payload, flags); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	good := encode(CRC32C, []byte("hello"))
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{name: "PayloadCorrupted", data: corrupt(good, frame.HeaderSize+2), wantErr: frame.ErrChecksumMismatch},
+		{name: "TrailerCorrupted", data: corrupt(good, len(good)-1), wantErr: frame.ErrChecksumMismatch},
+		{name: "XXHashCorrupted", data: corrupt(encode(XXHash, []byte("hello")), frame.HeaderSize+1), wantErr: frame.ErrChecksumMismatch},
+		{name: "Unsupported", data: header(frame.FlagChecksum, []byte{9, 1, 2, 3, 4}), wantErr: ErrUnsupported},
+		{name: "Empty", data: header(frame.FlagChecksum, nil), wantErr: frame.ErrInvalidLength},
+		{name: "Short", data: header(frame.FlagChecksum, []byte{byte(CRC32), 1, 2}), wantErr: frame.ErrInvalidLength},
+		{name: "Compressed", data: header(frame.FlagCompressed, []byte("x")), wantErr: frame.ErrUnsupportedFlags},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failures := 0
+			c := NewCodec()
+			c.Observe = func(alg Algorithm, ok bool) {
+				if !ok {
+					failures++
+				}
+			}
+			if _, err := c.Decode(bytes.NewReader(tt.data)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Decode() error = %v, want %v", err, tt.wantErr)
+			}
+			if want := tt.wantErr == frame.ErrChecksumMismatch; (failures == 1) != want {
+				t.Errorf("Observe() failures = %d, want mismatch %v", failures, want)
+			}
+		})
+	}
+}
+
+func TestCodec_Negotiated(t *testing.T) {
+	encode := func(alg Algorithm) []byte {
+		c := NewCodec()
+		c.SetAlgorithm(alg)
+		var buf bytes.Buffer
+		if err := c.Encode(&buf, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	tests := []struct {
+		name    string
+		alg     Algorithm // 解码方设置的算法
+		data    []byte
+		wantErr error
+	}{
+		{name: "Negotiated", alg: CRC32C, data: encode(CRC32C)},
+		// 清除 FlagChecksum 不能绕过校验
+		{name: "Stripped", alg: CRC32C, data: encode(None), wantErr: ErrUnexpected},
+		{name: "OtherAlgorithm", alg: CRC32C, data: encode(XXHash), wantErr: ErrUnexpected},
+		{name: "UnsupportedAlgorithm", alg: CRC32C, data: []byte{frame.HeaderMagic, frame.HeaderVersion, byte(frame.FlagChecksum), 0, 0, 0, 0, 5, 9, 1, 2, 3, 4}, wantErr: ErrUnexpected},
+		// 协商之前按 frame 中的标记校验
+		{name: "NotNegotiated", alg: None, data: encode(None)},
+		{name: "NotNegotiatedAnyAlgorithm", alg: None, data: encode(XXHash)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failures := 0
+			c := NewCodec()
+			c.SetAlgorithm(tt.alg)
+			c.Observe = func(alg Algorithm, ok bool) {
+				if !ok {
+					failures++
+				}
+			}
+			got, err := c.Decode(bytes.NewReader(tt.data))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Decode() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && string(got) != "hello" {
+				t.Errorf("Decode() = %q, want %q", got, "hello")
+			}
+			if want := tt.wantErr != nil; (failures == 1) != want {
+				t.Errorf("Observe() failures = %d, want failure %v", failures, want)
+			}
+		})
+	}
+}
+
+func TestCodec_Legacy(t *testing.T) {
+	// 旧格式不能携带 FlagChecksum
+	c := NewCodec()
+	c.Frame = &frame.Codec{}
+	c.SetAlgorithm(CRC32)
+	var buf bytes.Buffer
+	if err := c.Encode(&buf, []byte("hello")); !errors.Is(err, frame.ErrUnsupportedFlags) {
+		t.Errorf("Encode() error = %v, want %v", err, frame.ErrUnsupportedFlags)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name      string
+		offered   []Algorithm
+		supported []Algorithm
+		want      Algorithm
+	}{
+		{name: "ClientPreference", offered: []Algorithm{XXHash, CRC32C}, supported: []Algorithm{CRC32C, XXHash}, want: XXHash},
+		{name: "NoCommon", offered: []Algorithm{CRC32}, supported: []Algorithm{CRC32C}, want: None},
+		{name: "Unknown", offered: []Algorithm{Algorithm(9)}, supported: []Algorithm{Algorithm(9)}, want: None},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Negotiate(tt.offered, tt.supported); got != tt.want {
+				t.Errorf("Negotiate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseList(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []Algorithm
+		wantErr bool
+	}{
+		{name: "Empty", s: "", want: nil},
+		{name: "List", s: "xxhash, CRC32C,crc32", want: []Algorithm{XXHash, CRC32C, CRC32}},
+		{name: "Unknown", s: "md5", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseList(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseList() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
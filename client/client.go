@@ -0,0 +1,478 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CoderI421/tcp-service/checksum"
+	"github.com/CoderI421/tcp-service/compression"
+	"github.com/CoderI421/tcp-service/encryption"
+	"github.com/CoderI421/tcp-service/frame"
+	"github.com/CoderI421/tcp-service/message"
+	"github.com/CoderI421/tcp-service/packet"
+	"github.com/CoderI421/tcp-service/textpacket"
+	"github.com/CoderI421/tcp-service/transport"
+)
+
+// defaultHandshakeTimeout 默认的 Con 握手超时
+const defaultHandshakeTimeout = 5 * time.Second
+
+var (
+	// ErrClosed 客户端已关闭
+	ErrClosed = errors.New("client: closed")
+	// ErrHandshake Con 握手失败
+	ErrHandshake = errors.New("client: handshake failed")
+	// ErrInvalidID Config.ID 不是 8 字节
+	ErrInvalidID = errors.New("client: invalid id")
+)
+
+// idLen Con.ID 的长度
+const idLen = 8
+
+// Config 客户端配置
+type Config struct {
+	ID      string // Con.ID，8 字节客户端标识
+	Payload []byte // Con.Payload，服务端开启鉴权时为凭证
+
+	// OnDeliver 收到服务端推送时回调，返回值作为 DeliverAck 的 result
+	// 在读协程中同步调用，耗时操作应自行异步处理；nil 时直接回复 ResultOK
+	OnDeliver func(d *packet.Deliver) uint8
+
+	HandshakeTimeout time.Duration // Con 握手超时，0 表示使用默认值
+
+	// FrameFormat 帧格式，零值在识别出服务端的格式之前按旧格式发送，文本格式收发文本 packet
+	FrameFormat frame.Format
+
+	// Compression 在 Con 中提出的 payload 压缩算法，按优先级排列，为空表示不压缩
+	// FrameFormat 不能携带 flags 时无法标记压缩的 frame，忽略
+	Compression []compression.Algorithm
+	// CompressThreshold 不小于该长度的 payload 才压缩，0 表示使用默认值
+	CompressThreshold int
+
+	// Checksum 在 Con 中提出的 frame 校验和算法，按优先级排列，为空表示不校验
+	// 只有 FrameFormat 为 frame.FormatHeader 时能携带校验和，否则忽略
+	Checksum []checksum.Algorithm
+
+	// Encryption 在 Con 中提出的端到端加密算法，按优先级排列，为空表示不加密
+	// 与 Checksum 一样只有 FrameFormat 为 frame.FormatHeader 时能加密，否则忽略
+	Encryption []encryption.Cipher
+	// ServerKey 固定的服务端 X25519 静态公钥，设置后以此认证服务端，凭证（Payload）也加密发送
+	// nil 表示不认证服务端，此时加密无法抵御主动的中间人
+	ServerKey []byte
+	// RequireEncryption 提出了加密但服务端未协商出算法、或无法认证服务端时握手失败，需要设置 ServerKey
+	RequireEncryption bool
+
+	// ContentType SubmitTyped 使用的序列化格式，零值表示 message.JSON
+	ContentType message.ContentType
+
+	TLSConfig *tls.Config // Dial tls:// 地址时使用，nil 表示默认配置
+}
+
+// Client tcp-service 客户端，建立连接后完成 Con 握手，之后可并发调用 Submit
+type Client struct {
+	cfg    Config
+	conn   net.Conn
+	codec  frame.StreamFrameCodec
+	rbuf   *bufio.Reader
+	comp   *compression.Codec    // 提出压缩时不为 nil
+	sum    *checksum.Codec       // 提出校验和时不为 nil
+	enc    *encryption.Codec     // 提出加密时不为 nil
+	alg    compression.Algorithm // 协商出的压缩算法
+	sumAlg checksum.Algorithm    // 协商出的校验和算法
+	cipher encryption.Cipher     // 协商出的加密算法
+
+	wmu  sync.Mutex // 保护 wbuf
+	wbuf *bufio.Writer
+
+	seq     uint32 // 原子操作，submit ID 序号
+	mu      sync.Mutex
+	pending map[string]chan uint8 // 等待 ack 的请求，值为 ack 的 result
+
+	closing   int32 // 原子操作，Close 被调用后非 0
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error // 读协程退出的原因，done 关闭后可读
+}
+
+// Dial 连接服务端并完成 Con 握手，addr 格式见 transport 包
+func Dial(addr string, cfg Config) (*Client, error) {
+	conn, err := transport.DialTLS(addr, cfg.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	c, err := New(conn, cfg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// New 在已建立的连接上完成 Con 握手，Config.ID 不是 8 字节时返回 ErrInvalidID
+func New(conn net.Conn, cfg Config) (*Client, error) {
+	if len(cfg.ID) != idLen {
+		return nil, fmt.Errorf("%w: length %d, want %d", ErrInvalidID, len(cfg.ID), idLen)
+	}
+	c := &Client{
+		cfg:     cfg,
+		conn:    conn,
+		codec:   textpacket.NewFormatCodec(cfg.FrameFormat),
+		rbuf:    bufio.NewReader(conn),
+		wbuf:    bufio.NewWriter(conn),
+		pending: make(map[string]chan uint8),
+		done:    make(chan struct{}),
+	}
+	flags := cfg.FrameFormat.Flags()
+	if flags&frame.FlagCompressed == 0 {
+		c.cfg.Compression = nil
+	}
+	if flags&frame.FlagChecksum == 0 {
+		c.cfg.Checksum = nil
+	}
+	if flags&frame.FlagEncrypted == 0 {
+		c.cfg.Encryption = nil
+	}
+	// 发送时依次压缩、加密、计算校验和，接收时依次校验、解密、解压
+	var inner frame.FlagCodec = frame.NewFormatCodec(cfg.FrameFormat)
+	if len(c.cfg.Checksum) > 0 {
+		c.sum = checksum.NewCodec()
+		c.sum.Frame = inner
+		inner = c.sum
+		c.codec = c.sum
+	}
+	if len(c.cfg.Encryption) > 0 {
+		c.enc = encryption.NewCodec()
+		c.enc.Frame = inner
+		inner = c.enc
+		c.codec = c.enc
+	}
+	if len(c.cfg.Compression) > 0 {
+		c.comp = compression.NewCodec()
+		c.comp.Frame = inner
+		c.comp.Threshold = cfg.CompressThreshold
+		c.codec = c.comp
+	}
+	if err := c.handshake(); err != nil {
+		return nil, err
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+func (c *Client) handshake() error {
+	timeout := c.cfg.HandshakeTimeout
+	if timeout == 0 {
+		timeout = defaultHandshakeTimeout
+	}
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	con := &packet.Con{ID: c.cfg.ID, Payload: c.cfg.Payload}
+	if len(c.cfg.Compression) > 0 {
+		con.Options = append(con.Options, packet.Option{
+			Type:  packet.OptionCompression,
+			Value: compression.Encode(c.cfg.Compression),
+		})
+	}
+	if len(c.cfg.Checksum) > 0 {
+		con.Options = append(con.Options, packet.Option{
+			Type:  packet.OptionChecksum,
+			Value: checksum.Encode(c.cfg.Checksum),
+		})
+	}
+	var (
+		h     *encryption.Handshake
+		offer []byte
+	)
+	if c.cfg.RequireEncryption && (len(c.cfg.Encryption) == 0 || c.cfg.ServerKey == nil) {
+		return fmt.Errorf("%w: encryption required without server key", ErrHandshake)
+	}
+	if len(c.cfg.Encryption) > 0 {
+		var err error
+		if h, err = encryption.NewClientHandshake(c.cfg.ServerKey); err != nil {
+			return err
+		}
+		o := encryption.Offer{Public: h.Public(), Ciphers: c.cfg.Encryption}
+		// 固定了服务端静态公钥时凭证只有服务端能解密，offer 参与认证
+		o.Sealed = h.Authenticated() && len(con.Payload) > 0
+		offer = o.Encode()
+		if o.Sealed {
+			if con.Payload, err = h.SealCredential(con.ID, offer, con.Payload); err != nil {
+				return err
+			}
+		}
+		con.Options = append(con.Options, packet.Option{
+			Type:  packet.OptionEncryption,
+			Value: offer,
+		})
+	}
+	if err := c.write(con); err != nil {
+		return err
+	}
+	p, err := c.read()
+	if err != nil {
+		return err
+	}
+	ack, ok := p.(*packet.ConAck)
+	if !ok {
+		return fmt.Errorf("%w: unexpected packet %T", ErrHandshake, p)
+	}
+	if ack.Result != packet.ResultOK {
+		return fmt.Errorf("%w: result %d", ErrHandshake, ack.Result)
+	}
+	// 旧版本的服务端不返回选项，不压缩
+	if v, ok := packet.FindOption(ack.Options, packet.OptionCompression); ok && len(v) == 1 {
+		alg := compression.Algorithm(v[0])
+		if alg != compression.None && compression.Negotiate([]compression.Algorithm{alg}, c.cfg.Compression) != alg {
+			return fmt.Errorf("%w: unexpected compression %s", ErrHandshake, alg)
+		}
+		if c.comp != nil {
+			c.comp.SetAlgorithm(alg)
+			c.alg = alg
+		}
+	}
+	if v, ok := packet.FindOption(ack.Options, packet.OptionChecksum); ok && len(v) == 1 {
+		alg := checksum.Algorithm(v[0])
+		if alg != checksum.None && checksum.Negotiate([]checksum.Algorithm{alg}, c.cfg.Checksum) != alg {
+			return fmt.Errorf("%w: unexpected checksum %s", ErrHandshake, alg)
+		}
+		if c.sum != nil {
+			c.sum.SetAlgorithm(alg)
+			c.sumAlg = alg
+		}
+	}
+	if h != nil {
+		return c.startEncryption(h, offer, ack.Options)
+	}
+	return nil
+}
+
+// startEncryption 按 ConAck 中的 OptionEncryption 派生密钥，之后收发的 frame 都加密
+// offer 为 Con 中 OptionEncryption 的 value，参与密钥派生
+func (c *Client) startEncryption(h *encryption.Handshake, offer []byte, opts []packet.Option) error {
+	var a encryption.Accept
+	// 旧版本的服务端不返回选项，不加密
+	if v, ok := packet.FindOption(opts, packet.OptionEncryption); ok {
+		var err error
+		if a, err = encryption.DecodeAccept(v); err != nil {
+			return fmt.Errorf("%w: %v", ErrHandshake, err)
+		}
+	}
+	ci := a.Cipher
+	if ci == encryption.None {
+		if c.cfg.RequireEncryption {
+			return fmt.Errorf("%w: encryption not negotiated", ErrHandshake)
+		}
+		return nil
+	}
+	if encryption.Negotiate([]encryption.Cipher{ci}, c.cfg.Encryption) != ci {
+		return fmt.Errorf("%w: unexpected cipher %s", ErrHandshake, ci)
+	}
+	sendKey, recvKey, err := h.Finish(offer, a)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrHandshake, err)
+	}
+	if err = c.enc.SetRecvKey(ci, recvKey); err != nil {
+		return err
+	}
+	if err = c.enc.SetSendKey(ci, sendKey); err != nil {
+		return err
+	}
+	c.cipher = ci
+	return nil
+}
+
+// Compression 返回协商出的压缩算法，未压缩时为 compression.None
+func (c *Client) Compression() compression.Algorithm {
+	return c.alg
+}
+
+// Checksum 返回协商出的校验和算法，不校验时为 checksum.None
+func (c *Client) Checksum() checksum.Algorithm {
+	return c.sumAlg
+}
+
+// Encryption 返回协商出的加密算法，未加密时为 encryption.None
+func (c *Client) Encryption() encryption.Cipher {
+	return c.cipher
+}
+
+// Submit 发送 payload 并等待服务端的 SubmitAck，返回 ack 的 result
+func (c *Client) Submit(ctx context.Context, payload []byte) (uint8, error) {
+	return c.request(ctx, func(id string) packet.Packet {
+		return &packet.Submit{ID: id, Payload: payload}
+	})
+}
+
+// SubmitTyped 按 Config.ContentType 序列化 v 并以带类型的 submit 发送，返回 SubmitAck 的 result
+// kind 由 message.KindOf(v) 决定，服务端按 kind 反序列化为注册的类型后交给 handler
+func (c *Client) SubmitTyped(ctx context.Context, v interface{}) (uint8, error) {
+	kind := message.KindOf(v)
+	if kind == "" {
+		return 0, fmt.Errorf("%w [%T]", message.ErrNoKind, v)
+	}
+	ct := c.cfg.ContentType
+	if ct == 0 {
+		ct = message.JSON
+	}
+	payload, err := message.Marshal(ct, v)
+	if err != nil {
+		return 0, err
+	}
+	return c.request(ctx, func(id string) packet.Packet {
+		return &packet.Submit{ID: id, Kind: kind, ContentType: uint8(ct), Payload: payload}
+	})
+}
+
+// Publish 向 topic 发布 payload，服务端会推送给所有匹配的订阅者，返回 SubmitAck 的 result
+func (c *Client) Publish(ctx context.Context, topic string, payload []byte) (uint8, error) {
+	return c.request(ctx, func(id string) packet.Packet {
+		return &packet.Submit{ID: id, Topic: topic, Payload: payload}
+	})
+}
+
+// Subscribe 订阅 topic，可含通配符 + 与 #，推送通过 Config.OnDeliver 回调
+func (c *Client) Subscribe(ctx context.Context, topic string) (uint8, error) {
+	return c.request(ctx, func(id string) packet.Packet {
+		return &packet.Subscribe{ID: id, Topic: topic}
+	})
+}
+
+// Unsubscribe 取消订阅 topic
+func (c *Client) Unsubscribe(ctx context.Context, topic string) (uint8, error) {
+	return c.request(ctx, func(id string) packet.Packet {
+		return &packet.Unsubscribe{ID: id, Topic: topic}
+	})
+}
+
+// request 发送 newPacket 生成的请求并等待对应的 ack，返回 ack 的 result
+func (c *Client) request(ctx context.Context, newPacket func(id string) packet.Packet) (uint8, error) {
+	id := fmt.Sprintf("%08d", atomic.AddUint32(&c.seq, 1)%100000000)
+	ackc := make(chan uint8, 1)
+
+	c.mu.Lock()
+	c.pending[id] = ackc
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.write(newPacket(id)); err != nil {
+		return 0, err
+	}
+
+	select {
+	case result := <-ackc:
+		return result, nil
+	case <-c.done:
+		return 0, c.err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// Done 连接断开后关闭
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err 连接断开的原因，Done 关闭之前返回 nil
+func (c *Client) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// Close 关闭连接
+func (c *Client) Close() error {
+	atomic.StoreInt32(&c.closing, 1)
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
+
+func (c *Client) write(p packet.Packet) error {
+	framePayload, err := packet.Encode(p)
+	if err != nil {
+		return err
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err = c.codec.Encode(c.wbuf, framePayload); err != nil {
+		return err
+	}
+	return c.wbuf.Flush()
+}
+
+func (c *Client) read() (packet.Packet, error) {
+	framePayload, err := c.codec.Decode(c.rbuf)
+	if err != nil {
+		return nil, err
+	}
+	return packet.Decode(framePayload)
+}
+
+// readLoop 读协程，分发各类 ack 并处理服务端推送
+func (c *Client) readLoop() {
+	var err error
+	defer func() {
+		c.closeOnce.Do(func() {
+			if atomic.LoadInt32(&c.closing) != 0 {
+				err = ErrClosed
+			}
+			c.err = err
+			close(c.done)
+		})
+	}()
+
+	for {
+		var p packet.Packet
+		p, err = c.read()
+		if err != nil {
+			return
+		}
+
+		switch p := p.(type) {
+		case *packet.SubmitAck:
+			c.ack(p.ID, p.Result)
+		case *packet.SubscribeAck:
+			c.ack(p.ID, p.Result)
+		case *packet.UnsubscribeAck:
+			c.ack(p.ID, p.Result)
+		case *packet.Deliver:
+			result := packet.ResultOK
+			if c.cfg.OnDeliver != nil {
+				result = c.cfg.OnDeliver(p)
+			}
+			if err = c.write(&packet.DeliverAck{ID: p.ID, Result: result}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// ack 把 ack 的 result 交给等待中的请求
+func (c *Client) ack(id string, result uint8) {
+	c.mu.Lock()
+	ackc, ok := c.pending[id]
+	c.mu.Unlock()
+	if ok {
+		select {
+		case ackc <- result:
+		default: // 重复的 ack
+		}
+	}
+}
@@ -0,0 +1,598 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CoderI421/tcp-service/auth"
+	"github.com/CoderI421/tcp-service/checksum"
+	"github.com/CoderI421/tcp-service/compression"
+	"github.com/CoderI421/tcp-service/connlimit"
+	"github.com/CoderI421/tcp-service/encryption"
+	"github.com/CoderI421/tcp-service/frame"
+	"github.com/CoderI421/tcp-service/message"
+	"github.com/CoderI421/tcp-service/packet"
+	"github.com/CoderI421/tcp-service/server"
+	"github.com/CoderI421/tcp-service/transport"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func startServer(t *testing.T, s *server.Server) *transport.PipeListener {
+	t.Helper()
+	l := transport.NewPipeListener()
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return l
+}
+
+func dial(t *testing.T, l *transport.PipeListener, cfg Config) *Client {
+	t.Helper()
+	conn, err := l.Dial()
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	c, err := New(conn, cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestClient_Submit(t *testing.T) {
+	l := startServer(t, &server.Server{})
+	c := dial(t, l, Config{ID: "00000001"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		result, err := c.Submit(ctx, []byte("hello"))
+		if err != nil {
+			t.Fatalf("Submit() error = %v", err)
+		}
+		if result != packet.ResultOK {
+			t.Errorf("Submit() result = %d, want %d", result, packet.ResultOK)
+		}
+	}
+}
+
+func TestClient_Deliver(t *testing.T) {
+	s := &server.Server{}
+	l := startServer(t, s)
+
+	got := make(chan string, 1)
+	dial(t, l, Config{
+		ID: "00000001",
+		OnDeliver: func(d *packet.Deliver) uint8 {
+			got <- string(d.Payload)
+			return packet.ResultOK
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.Deliver(ctx, "00000001", []byte("push")); err != nil {
+		t.Fatalf("Deliver() error = %v", err)
+	}
+	if payload := <-got; payload != "push" {
+		t.Errorf("OnDeliver() payload = %q, want %q", payload, "push")
+	}
+
+	if err := s.Deliver(ctx, "00000002", []byte("push")); !errors.Is(err, server.ErrSessionNotFound) {
+		t.Errorf("Deliver() to unknown id error = %v, want %v", err, server.ErrSessionNotFound)
+	}
+}
+
+func TestClient_DeliverFailed(t *testing.T) {
+	s := &server.Server{}
+	l := startServer(t, s)
+	dial(t, l, Config{
+		ID:        "00000001",
+		OnDeliver: func(d *packet.Deliver) uint8 { return packet.ResultFailed },
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.Deliver(ctx, "00000001", []byte("push")); !errors.Is(err, server.ErrDeliverFailed) {
+		t.Errorf("Deliver() error = %v, want %v", err, server.ErrDeliverFailed)
+	}
+}
+
+func TestClient_HandshakeRefused(t *testing.T) {
+	// net.Pipe 没有缓冲，拒绝包与 Con 会互相阻塞，这里使用 tcp
+	gate, _ := connlimit.New(connlimit.Config{Deny: []string{"127.0.0.1"}})
+	l, err := transport.Listen("tcp://127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error: %v", err)
+	}
+	s := &server.Server{Gate: gate}
+	go s.Serve(l)
+	defer s.Close()
+
+	if _, err = Dial(l.Addr().String(), Config{ID: "00000002"}); !errors.Is(err, ErrHandshake) {
+		t.Errorf("New() error = %v, want %v", err, ErrHandshake)
+	}
+}
+
+func TestClient_InvalidID(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+	}{
+		{name: "Empty", id: ""},
+		{name: "Short", id: "0001"},
+		{name: "Long", id: "000000001"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := startServer(t, &server.Server{})
+			conn, err := l.Dial()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			// 在发送 Con 之前返回，不会 panic 也不会截断
+			if _, err = New(conn, Config{ID: tt.id}); !errors.Is(err, ErrInvalidID) {
+				t.Errorf("New() error = %v, want %v", err, ErrInvalidID)
+			}
+		})
+	}
+}
+
+func TestClient_Close(t *testing.T) {
+	l := startServer(t, &server.Server{})
+	c := dial(t, l, Config{ID: "00000001"})
+
+	c.Close()
+	if _, err := c.Submit(context.Background(), []byte("hello")); err == nil {
+		t.Error("Submit() after Close error = nil, want non-nil")
+	}
+	if err := c.Err(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Err() = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestClient_PubSub(t *testing.T) {
+	l := startServer(t, &server.Server{})
+
+	got := make(chan *packet.Deliver, 1)
+	sub := dial(t, l, Config{
+		ID: "00000001",
+		OnDeliver: func(d *packet.Deliver) uint8 {
+			got <- d
+			return packet.ResultOK
+		},
+	})
+	pub := dial(t, l, Config{ID: "00000002"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if result, err := sub.Subscribe(ctx, "sensor/+/temp"); err != nil || result != packet.ResultOK {
+		t.Fatalf("Subscribe() = %d, %v, want ResultOK", result, err)
+	}
+	if result, err := sub.Subscribe(ctx, "sensor/#/temp"); err != nil || result != packet.ResultFailed {
+		t.Errorf("Subscribe(invalid) = %d, %v, want ResultFailed", result, err)
+	}
+
+	if result, err := pub.Publish(ctx, "sensor/room1/temp", []byte("21")); err != nil || result != packet.ResultOK {
+		t.Fatalf("Publish() = %d, %v, want ResultOK", result, err)
+	}
+	select {
+	case d := <-got:
+		if d.Topic != "sensor/room1/temp" || string(d.Payload) != "21" {
+			t.Errorf("OnDeliver() = %s %q, want sensor/room1/temp %q", d.Topic, d.Payload, "21")
+		}
+	case <-ctx.Done():
+		t.Fatal("subscriber got no deliver")
+	}
+
+	if result, err := sub.Unsubscribe(ctx, "sensor/+/temp"); err != nil || result != packet.ResultOK {
+		t.Fatalf("Unsubscribe() = %d, %v, want ResultOK", result, err)
+	}
+	pub.Publish(ctx, "sensor/room1/temp", []byte("22"))
+	select {
+	case d := <-got:
+		t.Errorf("OnDeliver() after Unsubscribe = %q", d.Payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestClient_Compression(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  []compression.Algorithm
+		offered []compression.Algorithm
+		want    compression.Algorithm
+	}{
+		{name: "Negotiated", server: []compression.Algorithm{compression.Gzip, compression.Snappy}, offered: []compression.Algorithm{compression.Snappy, compression.Gzip}, want: compression.Snappy},
+		{name: "NoCommon", server: []compression.Algorithm{compression.Deflate}, offered: []compression.Algorithm{compression.Snappy}, want: compression.None},
+		{name: "ServerDisabled", server: nil, offered: []compression.Algorithm{compression.Gzip}, want: compression.None},
+		{name: "ClientDisabled", server: []compression.Algorithm{compression.Gzip}, offered: nil, want: compression.None},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &server.Server{Compression: tt.server}
+			l := startServer(t, s)
+
+			large := bytes.Repeat([]byte("compressible "), 100)
+			got := make(chan []byte, 1)
+			c := dial(t, l, Config{
+				ID:          "00000001",
+				Compression: tt.offered,
+				OnDeliver: func(d *packet.Deliver) uint8 {
+					got <- d.Payload
+					return packet.ResultOK
+				},
+			})
+			if c.Compression() != tt.want {
+				t.Errorf("Compression() = %v, want %v", c.Compression(), tt.want)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			if result, err := c.Submit(ctx, large); err != nil || result != packet.ResultOK {
+				t.Fatalf("Submit() = %d, %v, want ResultOK", result, err)
+			}
+			if err := s.Deliver(ctx, "00000001", large); err != nil {
+				t.Fatalf("Deliver() error = %v", err)
+			}
+			if payload := <-got; !bytes.Equal(payload, large) {
+				t.Errorf("OnDeliver() payload = %d bytes, want %d", len(payload), len(large))
+			}
+		})
+	}
+}
+
+func TestClient_Checksum(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  frame.Format
+		sums    []checksum.Algorithm
+		client  frame.Format
+		offered []checksum.Algorithm
+		want    checksum.Algorithm
+	}{
+		{name: "Negotiated", server: frame.FormatHeader, sums: []checksum.Algorithm{checksum.CRC32C, checksum.XXHash}, client: frame.FormatHeader, offered: []checksum.Algorithm{checksum.XXHash, checksum.CRC32C}, want: checksum.XXHash},
+		{name: "AutoServer", server: frame.FormatAuto, sums: []checksum.Algorithm{checksum.CRC32}, client: frame.FormatHeader, offered: []checksum.Algorithm{checksum.CRC32}, want: checksum.CRC32},
+		{name: "LegacyClient", server: frame.FormatAuto, sums: []checksum.Algorithm{checksum.CRC32}, client: frame.FormatLegacy, offered: []checksum.Algorithm{checksum.CRC32}, want: checksum.None},
+		{name: "ServerDisabled", server: frame.FormatHeader, sums: nil, client: frame.FormatHeader, offered: []checksum.Algorithm{checksum.CRC32}, want: checksum.None},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 同时开启压缩，检查两者的先后顺序
+			s := &server.Server{FrameFormat: tt.server, Checksum: tt.sums, Compression: []compression.Algorithm{compression.Gzip}}
+			l := startServer(t, s)
+
+			large := bytes.Repeat([]byte("compressible "), 100)
+			got := make(chan []byte, 1)
+			c := dial(t, l, Config{
+				ID:          "00000001",
+				FrameFormat: tt.client,
+				Checksum:    tt.offered,
+				Compression: []compression.Algorithm{compression.Gzip},
+				OnDeliver: func(d *packet.Deliver) uint8 {
+					got <- d.Payload
+					return packet.ResultOK
+				},
+			})
+			if c.Checksum() != tt.want {
+				t.Errorf("Checksum() = %v, want %v", c.Checksum(), tt.want)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			if result, err := c.Submit(ctx, large); err != nil || result != packet.ResultOK {
+				t.Fatalf("Submit() = %d, %v, want ResultOK", result, err)
+			}
+			if err := s.Deliver(ctx, "00000001", large); err != nil {
+				t.Fatalf("Deliver() error = %v", err)
+			}
+			if payload := <-got; !bytes.Equal(payload, large) {
+				t.Errorf("OnDeliver() payload = %d bytes, want %d", len(payload), len(large))
+			}
+		})
+	}
+}
+
+func TestClient_Encryption(t *testing.T) {
+	both := []encryption.Cipher{encryption.AESGCM, encryption.ChaCha20Poly1305}
+	priv, pub, err := encryption.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 中间人持有另一个静态密钥，冒充服务端
+	otherPriv, _, err := encryption.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		server    frame.Format
+		ciphers   []encryption.Cipher
+		serverKey []byte
+		client    frame.Format
+		offered   []encryption.Cipher
+		pinned    []byte
+		require   bool
+		want      encryption.Cipher
+		wantErr   bool
+	}{
+		{name: "Negotiated", server: frame.FormatHeader, ciphers: both, client: frame.FormatHeader, offered: []encryption.Cipher{encryption.ChaCha20Poly1305, encryption.AESGCM}, want: encryption.ChaCha20Poly1305},
+		{name: "Authenticated", server: frame.FormatHeader, ciphers: both, serverKey: priv, client: frame.FormatHeader, offered: []encryption.Cipher{encryption.AESGCM}, pinned: pub, require: true, want: encryption.AESGCM},
+		{name: "UnpinnedWithServerKey", server: frame.FormatHeader, ciphers: both, serverKey: priv, client: frame.FormatHeader, offered: []encryption.Cipher{encryption.AESGCM}, want: encryption.AESGCM},
+		{name: "AutoServer", server: frame.FormatAuto, ciphers: both, client: frame.FormatHeader, offered: []encryption.Cipher{encryption.AESGCM}, want: encryption.AESGCM},
+		{name: "LegacyClient", server: frame.FormatAuto, ciphers: both, client: frame.FormatLegacy, offered: []encryption.Cipher{encryption.AESGCM}, want: encryption.None},
+		{name: "ServerDisabled", server: frame.FormatHeader, ciphers: nil, client: frame.FormatHeader, offered: []encryption.Cipher{encryption.AESGCM}, want: encryption.None},
+		{name: "Required", server: frame.FormatHeader, ciphers: nil, serverKey: priv, client: frame.FormatHeader, offered: []encryption.Cipher{encryption.AESGCM}, pinned: pub, require: true, wantErr: true},
+		{name: "RequiredWithoutServerKey", server: frame.FormatHeader, ciphers: both, client: frame.FormatHeader, offered: []encryption.Cipher{encryption.AESGCM}, require: true, wantErr: true},
+		{name: "Impersonated", server: frame.FormatHeader, ciphers: both, serverKey: otherPriv, client: frame.FormatHeader, offered: []encryption.Cipher{encryption.AESGCM}, pinned: pub, wantErr: true},
+		{name: "ServerWithoutKey", server: frame.FormatHeader, ciphers: both, client: frame.FormatHeader, offered: []encryption.Cipher{encryption.AESGCM}, pinned: pub, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 同时开启压缩与校验和，检查三者的先后顺序
+			s := &server.Server{
+				FrameFormat:   tt.server,
+				Encryption:    tt.ciphers,
+				EncryptionKey: tt.serverKey,
+				Checksum:      []checksum.Algorithm{checksum.CRC32C},
+				Compression:   []compression.Algorithm{compression.Gzip},
+			}
+			l := startServer(t, s)
+			cfg := Config{
+				ID:                "00000001",
+				FrameFormat:       tt.client,
+				Encryption:        tt.offered,
+				ServerKey:         tt.pinned,
+				RequireEncryption: tt.require,
+				Checksum:          []checksum.Algorithm{checksum.CRC32C},
+				Compression:       []compression.Algorithm{compression.Gzip},
+				HandshakeTimeout:  time.Second,
+			}
+			if tt.wantErr {
+				conn, err := l.Dial()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+				if _, err = New(conn, cfg); !errors.Is(err, ErrHandshake) {
+					t.Errorf("New() error = %v, want %v", err, ErrHandshake)
+				}
+				return
+			}
+
+			large := bytes.Repeat([]byte("compressible "), 100)
+			got := make(chan []byte, 1)
+			cfg.OnDeliver = func(d *packet.Deliver) uint8 {
+				got <- d.Payload
+				return packet.ResultOK
+			}
+			c := dial(t, l, cfg)
+			if c.Encryption() != tt.want {
+				t.Errorf("Encryption() = %v, want %v", c.Encryption(), tt.want)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			for _, payload := range [][]byte{large, []byte("hello")} {
+				if result, err := c.Submit(ctx, payload); err != nil || result != packet.ResultOK {
+					t.Fatalf("Submit() = %d, %v, want ResultOK", result, err)
+				}
+				if err := s.Deliver(ctx, "00000001", payload); err != nil {
+					t.Fatalf("Deliver() error = %v", err)
+				}
+				if p := <-got; !bytes.Equal(p, payload) {
+					t.Errorf("OnDeliver() payload = %d bytes, want %d", len(p), len(payload))
+				}
+			}
+		})
+	}
+}
+
+func TestClient_SealedCredential(t *testing.T) {
+	priv, pub, err := encryption.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		serverKey []byte
+		payload   string
+		wantErr   bool
+	}{
+		{name: "Sealed", serverKey: priv, payload: "secret"},
+		{name: "WrongCredential", serverKey: priv, payload: "guess", wantErr: true},
+		// 服务端没有静态私钥，无法解密凭证
+		{name: "ServerWithoutKey", payload: "secret", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &server.Server{
+				FrameFormat:   frame.FormatHeader,
+				Encryption:    []encryption.Cipher{encryption.AESGCM},
+				EncryptionKey: tt.serverKey,
+				Auth:          auth.NewStatic(map[string]string{"00000001": "secret"}),
+			}
+			l := startServer(t, s)
+			conn, err := l.Dial()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			// 记录客户端发出的字节，凭证不能以明文出现
+			rec := &recordConn{Conn: conn}
+			c, err := New(rec, Config{
+				ID:               "00000001",
+				Payload:          []byte(tt.payload),
+				FrameFormat:      frame.FormatHeader,
+				Encryption:       []encryption.Cipher{encryption.AESGCM},
+				ServerKey:        pub,
+				HandshakeTimeout: time.Second,
+			})
+			if bytes.Contains(rec.bytes(), []byte(tt.payload)) {
+				t.Errorf("Con payload %q sent in plaintext", tt.payload)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				c.Close()
+			}
+		})
+	}
+}
+
+// recordConn 记录写出的字节
+type recordConn struct {
+	net.Conn
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (c *recordConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	c.buf.Write(b)
+	c.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+func (c *recordConn) bytes() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]byte(nil), c.buf.Bytes()...)
+}
+
+type order struct {
+	ID    string `json:"id" msgpack:"id"`
+	Count int    `json:"count" msgpack:"count"`
+}
+
+type refund struct {
+	ID string `json:"id"`
+}
+
+type coupon struct{}
+
+func TestClient_SubmitTyped(t *testing.T) {
+	tests := []struct {
+		name       string
+		format     frame.Format
+		ct         message.ContentType
+		v          interface{}
+		noRegistry bool
+		want       interface{}
+		wantResult uint8
+	}{
+		{name: "JSON", ct: message.JSON, v: &order{ID: "a", Count: 2}, want: &order{ID: "a", Count: 2}, wantResult: packet.ResultOK},
+		{name: "DefaultJSON", v: order{ID: "b"}, want: &order{ID: "b"}, wantResult: packet.ResultOK},
+		{name: "MsgPack", ct: message.MsgPack, v: &order{ID: "c", Count: 3}, want: &order{ID: "c", Count: 3}, wantResult: packet.ResultOK},
+		{name: "Protobuf", ct: message.Protobuf, v: wrapperspb.String("hello"), want: wrapperspb.String("hello"), wantResult: packet.ResultOK},
+		{name: "Line", format: frame.FormatLine, ct: message.JSON, v: &order{ID: "d"}, want: &order{ID: "d"}, wantResult: packet.ResultOK},
+		{name: "HandlerResult", ct: message.JSON, v: &refund{ID: "e"}, want: &refund{ID: "e"}, wantResult: packet.ResultThrottled},
+		{name: "UnknownKind", ct: message.JSON, v: &coupon{}, wantResult: packet.ResultFailed},
+		{name: "NoRegistry", ct: message.JSON, v: &order{}, noRegistry: true, wantResult: packet.ResultFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(chan interface{}, 1)
+			handle := func(result uint8) message.Handler {
+				return func(ctx context.Context, clientID string, v interface{}) uint8 {
+					if clientID != "00000001" {
+						t.Errorf("handler clientID = %s, want 00000001", clientID)
+					}
+					got <- v
+					return result
+				}
+			}
+			s := &server.Server{FrameFormat: tt.format}
+			if !tt.noRegistry {
+				s.Messages = message.NewRegistry()
+				s.Messages.Register("", &order{}, handle(packet.ResultOK))
+				s.Messages.Register("", &refund{}, handle(packet.ResultThrottled))
+				s.Messages.Register("", &wrapperspb.StringValue{}, handle(packet.ResultOK))
+			}
+			l := startServer(t, s)
+			c := dial(t, l, Config{ID: "00000001", FrameFormat: tt.format, ContentType: tt.ct})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			result, err := c.SubmitTyped(ctx, tt.v)
+			if err != nil {
+				t.Fatalf("SubmitTyped() error = %v", err)
+			}
+			if result != tt.wantResult {
+				t.Errorf("SubmitTyped() result = %d, want %d", result, tt.wantResult)
+			}
+			if tt.want == nil {
+				return
+			}
+			v := <-got
+			if m, ok := v.(proto.Message); ok {
+				if !proto.Equal(m, tt.want.(proto.Message)) {
+					t.Errorf("handler got %v, want %v", v, tt.want)
+				}
+			} else if !reflect.DeepEqual(v, tt.want) {
+				t.Errorf("handler got %#v, want %#v", v, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_FrameFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  frame.Format
+		client  frame.Format
+		wantErr bool
+	}{
+		{name: "AutoLegacy", server: frame.FormatAuto, client: frame.FormatLegacy},
+		{name: "AutoHeader", server: frame.FormatAuto, client: frame.FormatHeader},
+		{name: "Header", server: frame.FormatHeader, client: frame.FormatHeader},
+		{name: "Varint", server: frame.FormatVarint, client: frame.FormatVarint},
+		{name: "Line", server: frame.FormatLine, client: frame.FormatLine},
+		{name: "Delimiter", server: frame.FormatDelimiter, client: frame.FormatDelimiter},
+		{name: "Mismatch", server: frame.FormatLegacy, client: frame.FormatHeader, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &server.Server{FrameFormat: tt.server, Compression: []compression.Algorithm{compression.Snappy}}
+			l := startServer(t, s)
+			conn, err := l.Dial()
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := New(conn, Config{
+				ID:               "00000001",
+				FrameFormat:      tt.client,
+				Compression:      []compression.Algorithm{compression.Snappy},
+				HandshakeTimeout: time.Second,
+			})
+			if tt.wantErr {
+				if err == nil {
+					c.Close()
+					t.Fatal("New() error = nil, want error")
+				}
+				conn.Close()
+				return
+			}
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer c.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			if result, err := c.Submit(ctx, bytes.Repeat([]byte("compressible "), 100)); err != nil || result != packet.ResultOK {
+				t.Fatalf("Submit() = %d, %v, want ResultOK", result, err)
+			}
+		})
+	}
+}
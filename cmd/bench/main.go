@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/CoderI421/tcp-service/checksum"
+	"github.com/CoderI421/tcp-service/client"
+	"github.com/CoderI421/tcp-service/compression"
+	"github.com/CoderI421/tcp-service/encryption"
+	"github.com/CoderI421/tcp-service/frame"
+	"github.com/CoderI421/tcp-service/packet"
+	"golang.org/x/time/rate"
+)
+
+var (
+	addr     = flag.String("addr", ":8888", "服务端地址，如 tcp://:8888、tls://:8443、unix:///var/run/tcp-service.sock")
+	conns    = flag.Int("conns", 10, "连接数")
+	pipeline = flag.Int("pipeline", 1, "每个连接上同时等待 ack 的请求数")
+	qps      = flag.Float64("rate", 0, "所有连接合计的目标速率（每秒请求数），0 表示尽可能快")
+	duration = flag.Duration("duration", 10*time.Second, "压测时长")
+	size     = flag.String("size", "128", "payload 大小分布：固定 128，均匀 64-1024，按权重 64:8,1024:2")
+	timeout  = flag.Duration("timeout", 5*time.Second, "单个请求等待 ack 的超时")
+	idBase   = flag.Int("id-base", 0, "客户端标识的起始序号，第 i 个连接的标识为 id-base+i 的 8 位数字")
+	secret   = flag.String("auth", "", "Con 握手的凭证，服务端开启鉴权时使用")
+	insecure = flag.Bool("insecure", false, "tls:// 地址不校验服务端证书")
+	jsonOut  = flag.Bool("json", false, "以 JSON 输出结果")
+	fmtName  = flag.String("frame-format", "legacy", "帧格式: legacy | header | varint | line | delimiter")
+	compress = flag.String("compression", "", "在 Con 中提出的压缩算法，按优先级逗号分隔: gzip | deflate | snappy，设置后 payload 为可压缩的文本")
+	sumName  = flag.String("checksum", "", "在 Con 中提出的校验和算法，按优先级逗号分隔: crc32 | crc32c | xxhash，只对 header 帧格式生效")
+	encName  = flag.String("encryption", "", "在 Con 中提出的端到端加密算法，按优先级逗号分隔: aes-gcm | chacha20-poly1305，只对 header 帧格式生效")
+	encKey   = flag.String("encryption-key", "", "固定的服务端加密公钥（十六进制，见服务端启动日志），设置后认证服务端并加密凭证")
+)
+
+func main() {
+	flag.Parse()
+	dist, err := parseSizeDist(*size)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	algs, err := compression.ParseList(*compress)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	sums, err := checksum.ParseList(*sumName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	ciphers, err := encryption.ParseList(*encName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	var serverKey []byte
+	if *encKey != "" {
+		if serverKey, err = encryption.ParseKey(*encKey); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	format, err := frame.ParseFormat(*fmtName)
+	if err != nil || format == frame.FormatAuto {
+		fmt.Fprintf(os.Stderr, "invalid frame format [%s]\n", *fmtName)
+		os.Exit(2)
+	}
+	if *conns < 1 || *pipeline < 1 {
+		fmt.Fprintln(os.Stderr, "conns and pipeline must be positive")
+		os.Exit(2)
+	}
+
+	r := run(dist, client.Config{Compression: algs, Checksum: sums, Encryption: ciphers, ServerKey: serverKey, FrameFormat: format})
+	if *jsonOut {
+		err = r.WriteJSON(os.Stdout)
+	} else {
+		r.WriteText(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run 建立连接并压测，Ctrl-C 提前结束时同样输出结果
+func run(dist *sizeDist, cfg client.Config) *Report {
+	total := newStats()
+	clients := dial(total, cfg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	var lim *rate.Limiter
+	if *qps > 0 {
+		burst := *conns * *pipeline
+		lim = rate.NewLimiter(rate.Limit(*qps), burst)
+		// 开始时桶是满的，先取空，避免第一秒超出目标速率
+		lim.AllowN(time.Now(), burst)
+	}
+	payload := make([]byte, dist.maxSize())
+	// 压缩时需要可压缩的数据，文本格式的 payload 中不能有换行
+	if len(cfg.Compression) > 0 || cfg.FrameFormat.Text() {
+		text := []byte("tcp-service bench payload ")
+		for i := range payload {
+			payload[i] = text[i%len(text)]
+		}
+	} else {
+		rand.Read(payload)
+	}
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	start := time.Now()
+	for i, c := range clients {
+		for j := 0; j < *pipeline; j++ {
+			wg.Add(1)
+			go func(c *client.Client, seed int64) {
+				defer wg.Done()
+				s := worker(ctx, c, lim, dist, payload, rand.New(rand.NewSource(seed)))
+				mu.Lock()
+				total.merge(s)
+				mu.Unlock()
+			}(c, start.UnixNano()+int64(i*(*pipeline)+j))
+		}
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	for _, c := range clients {
+		c.Close()
+	}
+
+	r := newReport(total, elapsed)
+	r.Addr, r.Conns, r.Pipeline, r.Rate, r.Size = *addr, *conns, *pipeline, *qps, *size
+	return r
+}
+
+// dial 按 cfg 建立所有连接，失败的连接计入 s 的错误
+func dial(s *stats, cfg client.Config) []*client.Client {
+	cfg.Payload = []byte(*secret)
+	if *insecure {
+		cfg.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	var clients []*client.Client
+	for i := 0; i < *conns; i++ {
+		cfg.ID = fmt.Sprintf("%08d", (*idBase+i)%100000000)
+		c, err := client.Dial(*addr, cfg)
+		if err != nil {
+			s.errors["dial"]++
+			fmt.Fprintf(os.Stderr, "dial error: %v\n", err)
+			continue
+		}
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// worker 在连接 c 上循环发送 Submit 直到 ctx 结束，一次只等待一个 ack
+// 同一连接上的多个 worker 构成 pipeline
+func worker(ctx context.Context, c *client.Client, lim *rate.Limiter, dist *sizeDist, payload []byte, rng *rand.Rand) *stats {
+	s := newStats()
+	for ctx.Err() == nil {
+		if lim != nil && lim.Wait(ctx) != nil {
+			break
+		}
+		p := payload[:dist.next(rng)]
+
+		// 请求使用独立的超时，压测结束时不取消已发出的请求
+		reqCtx, cancel := context.WithTimeout(context.Background(), *timeout)
+		begin := time.Now()
+		result, err := c.Submit(reqCtx, p)
+		cancel()
+		if err != nil {
+			s.errors[errorKind(err)]++
+			if errors.Is(err, context.DeadlineExceeded) {
+				continue
+			}
+			// 连接已断开
+			break
+		}
+		s.latencies = append(s.latencies, time.Since(begin))
+		if result != packet.ResultOK {
+			s.errors[resultKind(result)]++
+			continue
+		}
+		s.ok++
+		s.bytes += uint64(len(p))
+	}
+	return s
+}
+
+func errorKind(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	return "conn"
+}
+
+func resultKind(result uint8) string {
+	switch result {
+	case packet.ResultFailed:
+		return "failed"
+	case packet.ResultThrottled:
+		return "throttled"
+	case packet.ResultRefused:
+		return "refused"
+	case packet.ResultDuplicate:
+		return "duplicate"
+	}
+	return fmt.Sprintf("result_%d", result)
+}
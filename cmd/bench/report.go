@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// stats 单个压测协程的统计，结束后合并
+type stats struct {
+	latencies []time.Duration // 收到 ack 的请求的延迟
+	ok        uint64
+	bytes     uint64 // 成功请求的 payload 字节数
+	errors    map[string]uint64
+}
+
+func newStats() *stats {
+	return &stats{errors: make(map[string]uint64)}
+}
+
+func (s *stats) merge(o *stats) {
+	s.latencies = append(s.latencies, o.latencies...)
+	s.ok += o.ok
+	s.bytes += o.bytes
+	for k, v := range o.errors {
+		s.errors[k] += v
+	}
+}
+
+// Latency 延迟分布，单位毫秒
+type Latency struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// Report 压测结果，-json 时原样输出，便于比较不同版本的结果
+type Report struct {
+	Addr     string  `json:"addr"`
+	Conns    int     `json:"conns"`
+	Pipeline int     `json:"pipeline"`
+	Rate     float64 `json:"rate"` // 目标速率，0 表示不限速
+	Size     string  `json:"size"`
+	Duration float64 `json:"duration_sec"` // 实际运行时长
+
+	Requests    uint64            `json:"requests"` // 发出的请求数，含出错的
+	OK          uint64            `json:"ok"`
+	Errors      map[string]uint64 `json:"errors"`
+	Throughput  float64           `json:"throughput"` // 每秒成功的请求数
+	BytesPerSec float64           `json:"bytes_per_sec"`
+	Latency     Latency           `json:"latency_ms"`
+}
+
+// newReport 根据合并后的统计生成结果，会对 s.latencies 排序
+func newReport(s *stats, elapsed time.Duration) *Report {
+	r := &Report{
+		Duration: elapsed.Seconds(),
+		OK:       s.ok,
+		Errors:   s.errors,
+	}
+	r.Requests = s.ok
+	for _, n := range s.errors {
+		r.Requests += n
+	}
+	if elapsed > 0 {
+		r.Throughput = float64(s.ok) / elapsed.Seconds()
+		r.BytesPerSec = float64(s.bytes) / elapsed.Seconds()
+	}
+
+	lat := s.latencies
+	if len(lat) == 0 {
+		return r
+	}
+	sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+	var sum time.Duration
+	for _, d := range lat {
+		sum += d
+	}
+	r.Latency = Latency{
+		Mean: ms(sum / time.Duration(len(lat))),
+		P50:  ms(percentile(lat, 50)),
+		P95:  ms(percentile(lat, 95)),
+		P99:  ms(percentile(lat, 99)),
+		Max:  ms(lat[len(lat)-1]),
+	}
+	return r
+}
+
+// percentile 取已排序的 lat 中的第 p 百分位（nearest-rank）
+func percentile(lat []time.Duration, p float64) time.Duration {
+	i := int(float64(len(lat))*p/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(lat) {
+		i = len(lat) - 1
+	}
+	return lat[i]
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// WriteJSON 以 JSON 输出
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteText 以便于阅读的文本输出
+func (r *Report) WriteText(w io.Writer) {
+	rate := "max"
+	if r.Rate > 0 {
+		rate = fmt.Sprintf("%.0f/s", r.Rate)
+	}
+	fmt.Fprintf(w, "target:      %s, %d conns, pipeline %d, rate %s, size %s\n", r.Addr, r.Conns, r.Pipeline, rate, r.Size)
+	fmt.Fprintf(w, "duration:    %.2fs\n", r.Duration)
+	fmt.Fprintf(w, "requests:    %d total, %d ok\n", r.Requests, r.OK)
+	fmt.Fprintf(w, "throughput:  %.1f req/s, %.1f KiB/s\n", r.Throughput, r.BytesPerSec/1024)
+	fmt.Fprintf(w, "latency:     mean %.3fms, p50 %.3fms, p95 %.3fms, p99 %.3fms, max %.3fms\n",
+		r.Latency.Mean, r.Latency.P50, r.Latency.P95, r.Latency.P99, r.Latency.Max)
+	if len(r.Errors) == 0 {
+		fmt.Fprintln(w, "errors:      0")
+		return
+	}
+	keys := make([]string, 0, len(r.Errors))
+	for k := range r.Errors {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	items := make([]string, 0, len(keys))
+	for _, k := range keys {
+		items = append(items, fmt.Sprintf("%s %d", k, r.Errors[k]))
+	}
+	fmt.Fprintf(w, "errors:      %s\n", strings.Join(items, ", "))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	lat := make([]time.Duration, 100)
+	for i := range lat {
+		lat[i] = time.Duration(i+1) * time.Millisecond
+	}
+	tests := []struct {
+		name string
+		lat  []time.Duration
+		p    float64
+		want time.Duration
+	}{
+		{name: "P50", lat: lat, p: 50, want: 50 * time.Millisecond},
+		{name: "P99", lat: lat, p: 99, want: 99 * time.Millisecond},
+		{name: "P100", lat: lat, p: 100, want: 100 * time.Millisecond},
+		{name: "P0", lat: lat, p: 0, want: time.Millisecond},
+		{name: "Single", lat: []time.Duration{time.Second}, p: 99, want: time.Second},
+		{name: "NearestRank", lat: []time.Duration{1, 2, 3, 4}, p: 50, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(tt.lat, tt.p); got != tt.want {
+				t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// sizeDist payload 大小分布，支持三种写法：
+//
+//	128              固定大小
+//	64-1024          [64, 1024] 内均匀分布
+//	64:8,1024:2      按权重选择大小，这里 80% 为 64 字节，20% 为 1024 字节
+type sizeDist struct {
+	min, max int   // 均匀分布的范围，固定大小时 min == max
+	sizes    []int // 按权重选择时的大小
+	weights  []int // 累计权重
+}
+
+func parseSizeDist(s string) (*sizeDist, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.Contains(s, ":"):
+		d := &sizeDist{}
+		total := 0
+		for _, item := range strings.Split(s, ",") {
+			parts := strings.SplitN(strings.TrimSpace(item), ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid size weight [%s]", item)
+			}
+			size, err := parseSize(parts[0])
+			if err != nil {
+				return nil, err
+			}
+			w, err := strconv.Atoi(parts[1])
+			if err != nil || w <= 0 {
+				return nil, fmt.Errorf("invalid size weight [%s]", item)
+			}
+			total += w
+			d.sizes = append(d.sizes, size)
+			d.weights = append(d.weights, total)
+		}
+		return d, nil
+	case strings.Contains(s, "-"):
+		parts := strings.SplitN(s, "-", 2)
+		min, err := parseSize(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		max, err := parseSize(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		if min > max {
+			return nil, fmt.Errorf("invalid size range [%s]", s)
+		}
+		return &sizeDist{min: min, max: max}, nil
+	default:
+		size, err := parseSize(s)
+		if err != nil {
+			return nil, err
+		}
+		return &sizeDist{min: size, max: size}, nil
+	}
+}
+
+func parseSize(s string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid payload size [%s]", s)
+	}
+	return n, nil
+}
+
+// next 按分布取一个大小，rng 非并发安全，每个协程各用一个
+func (d *sizeDist) next(rng *rand.Rand) int {
+	if len(d.sizes) > 0 {
+		n := rng.Intn(d.weights[len(d.weights)-1])
+		for i, w := range d.weights {
+			if n < w {
+				return d.sizes[i]
+			}
+		}
+	}
+	if d.min == d.max {
+		return d.min
+	}
+	return d.min + rng.Intn(d.max-d.min+1)
+}
+
+// maxSize 分布中最大的大小，用于预先生成 payload
+func (d *sizeDist) maxSize() int {
+	max := d.max
+	for _, s := range d.sizes {
+		if s > max {
+			max = s
+		}
+	}
+	return max
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestParseSizeDist(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    *sizeDist
+		wantErr bool
+	}{
+		{name: "Fixed", s: "128", want: &sizeDist{min: 128, max: 128}},
+		{name: "Range", s: "64-1024", want: &sizeDist{min: 64, max: 1024}},
+		{name: "Weighted", s: "64:8, 1024:2", want: &sizeDist{sizes: []int{64, 1024}, weights: []int{8, 10}}},
+		{name: "WeightMissing", s: "64:8,1024", wantErr: true},
+		{name: "WeightZero", s: "64:0", wantErr: true},
+		{name: "WeightInvalid", s: "64:x", wantErr: true},
+		{name: "ReversedRange", s: "1024-64", wantErr: true},
+		{name: "Negative", s: "-1", wantErr: true},
+		{name: "Empty", s: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSizeDist(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSizeDist(%q) error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSizeDist(%q) = %+v, want %+v", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSizeDist_Next(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	tests := []struct {
+		name string
+		s    string
+		max  int
+	}{
+		{name: "Fixed", s: "128", max: 128},
+		{name: "Range", s: "64-1024", max: 1024},
+		{name: "Weighted", s: "64:8,1024:2", max: 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := parseSizeDist(tt.s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.maxSize() != tt.max {
+				t.Errorf("maxSize() = %d, want %d", d.maxSize(), tt.max)
+			}
+			for i := 0; i < 100; i++ {
+				if n := d.next(rng); n > tt.max || n < 0 {
+					t.Fatalf("next() = %d, want <= %d", n, tt.max)
+				}
+			}
+		})
+	}
+}
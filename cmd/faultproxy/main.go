@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/CoderI421/tcp-service/faultproxy"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	listen   = flag.String("listen", ":9888", "代理监听的地址，客户端连接此地址")
+	upstream = flag.String("upstream", ":8888", "服务端地址")
+	rules    = flag.String("rules", "", "规则文件（yaml），见 rules.example.yaml，为空时只转发；SIGHUP 时重新加载")
+	seed     = flag.Int64("seed", 0, "随机数种子，非 0 时可复现概率命中与改写的字节")
+)
+
+// ruleFile 规则文件的格式
+type ruleFile struct {
+	Rules []faultproxy.Rule `yaml:"rules"`
+}
+
+func loadRules(name string) ([]faultproxy.Rule, error) {
+	if name == "" {
+		return nil, nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	var rf ruleFile
+	if err = dec.Decode(&rf); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return rf.Rules, nil
+}
+
+func main() {
+	flag.Parse()
+	rs, err := loadRules(*rules)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	p, err := faultproxy.New(*upstream, rs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *seed != 0 {
+		p.Seed(*seed)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for s := range sig {
+			if s != syscall.SIGHUP {
+				fmt.Println("hits:", p.Hits())
+				p.Close()
+				return
+			}
+			rs, err := loadRules(*rules)
+			if err == nil {
+				err = p.SetRules(rs)
+			}
+			if err != nil {
+				fmt.Println("reload rules failed:", err)
+				continue
+			}
+			fmt.Printf("reloaded %d rules\n", len(rs))
+		}
+	}()
+
+	fmt.Printf("fault proxy %s -> %s with %d rules\n", *listen, *upstream, len(rs))
+	if err = p.ListenAndServe(*listen); err != nil && !errors.Is(err, faultproxy.ErrProxyClosed) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -1,138 +1,253 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"github.com/CoderI421/tcp-service/admin"
+	"github.com/CoderI421/tcp-service/auth"
+	"github.com/CoderI421/tcp-service/config"
+	"github.com/CoderI421/tcp-service/connlimit"
+	"github.com/CoderI421/tcp-service/encryption"
 	"github.com/CoderI421/tcp-service/frame"
+	"github.com/CoderI421/tcp-service/limiter"
+	"github.com/CoderI421/tcp-service/logger"
 	"github.com/CoderI421/tcp-service/metrics"
-	"github.com/CoderI421/tcp-service/packet"
+	"github.com/CoderI421/tcp-service/server"
+	"github.com/CoderI421/tcp-service/upgrade"
+	"io"
+	"log"
 	"net"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
-	// 启动 pprof
-	go func() {
-		http.ListenAndServe(":6060", nil)
-	}()
-
-	listen, err := net.Listen("tcp", ":8888")
+	// 配置优先级：默认值 < -config 指定的 yaml 文件 < TCP_SERVICE_* 环境变量 < 命令行参数
+	cfg, opts, err := config.Load(os.Args[0], os.Args[1:], os.LookupEnv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
-		fmt.Println("listen error: ", err)
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	if opts.Print {
+		if err = cfg.WriteYAML(os.Stdout); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 		return
 	}
 
-	fmt.Println("server listening on(*:8888)")
+	if err = setupLog(cfg.Log); err != nil {
+		fmt.Println("log config error:", err)
+		os.Exit(1)
+	}
+
+	srv, rt, err := newServer(cfg)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
-	for {
-		accept, aErr := listen.Accept()
-		if aErr != nil {
-			fmt.Println("accept error:", aErr)
-			break
+	// SIGHUP 时重新加载配置，只应用可在运行时生效的变更
+	reloader := config.NewReloader(cfg, func() (*config.Config, error) {
+		cfg, _, err := config.Load(os.Args[0], os.Args[1:], os.LookupEnv, io.Discard)
+		return cfg, err
+	}, rt)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloader.Reload()
 		}
+	}()
 
-		// start a new goroutine to handle
-		// the new connection.
-		// 每个客户端连接，由一个协成进行处理
-		go handleConn(accept)
+	// 优先使用从旧进程或 systemd 继承的 listener
+	upg, err := upgrade.New()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
-}
 
-// handleConn 第一层，解析 Frame 层
-func handleConn(c net.Conn) {
+	// 启动 pprof
+	if l := listenAux(upg, "pprof", cfg.Pprof.Addr); l != nil {
+		go serveAux("pprof", func() error { return http.Serve(l, nil) })
+	}
 
-	metrics.ClientConnected.Inc() // conn 连接数 +1
-	defer func() {
-		metrics.ClientConnected.Dec() // conn 连接数 -1
-		// c -> 和每个客户端的连接
-		defer c.Close()
-	}()
+	// 启动 prometheus metrics
+	if l := listenAux(upg, "prometheus-exporter", cfg.Metrics.Addr); l != nil {
+		go serveAux("prometheus-exporter", func() error { return metrics.Serve(l) })
+	}
 
-	frameCodec := frame.NewCodec()
-	// 建立 connection 的读缓冲区
-	rbuf := bufio.NewReader(c)
-	// 建立 connection 的写缓冲区
-	wbuf := bufio.NewWriter(c)
-	defer wbuf.Flush()
+	// 启动 WebSocket 网关
+	if l := listenAux(upg, "websocket gateway", cfg.WS.Addr); l != nil {
+		mux := http.NewServeMux()
+		mux.HandleFunc(cfg.WS.Path, srv.ServeWebSocket)
+		hs := &http.Server{Handler: mux}
+		go serveAux("websocket gateway", func() error {
+			if cfg.WS.TLS {
+				hs.TLSConfig = srv.TLSConfig
+				return hs.ServeTLS(l, "", "")
+			}
+			return hs.Serve(l)
+		})
+		fmt.Printf("websocket gateway listening on(%s%s)\n", cfg.WS.Addr, cfg.WS.Path)
+	}
 
-	for {
-		// read from the connection
+	// 启动管理接口
+	if l := listenAux(upg, "admin", cfg.Admin.Addr); l != nil {
+		go serveAux("admin", func() error { return http.Serve(l, admin.Handler(srv)) })
+		fmt.Printf("admin listening on(%s)\n", cfg.Admin.Addr)
+	}
 
-		// decode the frame to get the payload
-		// is undecoded packet
-		framePayload, err := frameCodec.Decode(rbuf)
+	var listeners []net.Listener
+	for _, addr := range cfg.Listen {
+		l, err := upg.ListenTLS(addr, srv.TLSConfig)
 		if err != nil {
-			fmt.Println("handleConn: frame decode error:", err)
-			return
+			fmt.Println(err)
+			upg.Close()
+			os.Exit(1)
 		}
-		// prometheus 接收数据数 +1
-		metrics.ReqRecvTotal.Add(1)
+		listeners = append(listeners, l)
+	}
+	if err = upg.Ready(); err != nil {
+		logger.Warnf("upgrade: notify parent failed: %v", err)
+	}
 
-		// do something with the packet
-		// packet层的响应
-		ackFramePayload, err := handlePacket(framePayload)
-		if err != nil {
-			fmt.Println("handleConn: frame decode error:", err)
-			return
+	// SIGUSR2 时启动新进程并交出 listener，新进程就绪后当前进程 drain 并退出
+	usr2 := make(chan os.Signal, 1)
+	signal.Notify(usr2, syscall.SIGUSR2)
+	go func() {
+		for range usr2 {
+			p, err := upg.Upgrade()
+			if err != nil {
+				logger.Errorf("upgrade failed: %v", err)
+				continue
+			}
+			logger.Infof("upgrade: new process %d ready, draining", p.Pid)
+			srv.Drain()
+			upg.Close()
 		}
+	}()
 
-		// write ack frame to the connection
-		// Frame 层编码
-		// 使用 写缓冲区替换 c
-		err = frameCodec.Encode(wbuf, ackFramePayload)
-		if err != nil {
-			fmt.Println("handleConn: frame encode error:", err)
-			return
+	fmt.Printf("server listening on(%v)\n", cfg.Listen)
+	err = srv.ServeAll(listeners...)
+	if errors.Is(err, server.ErrServerClosed) {
+		// 通过管理接口 drain 或已交接给新进程，等待已有连接断开
+		fmt.Println("server draining, waiting for sessions to finish")
+		ctx := context.Background()
+		if cfg.Timeouts.Shutdown > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, cfg.Timeouts.Shutdown)
+			defer cancel()
 		}
-
-		// prometheus 响应数据数 +1
-		metrics.RspSendTotal.Inc()
+		srv.Shutdown(ctx)
 	}
+	fmt.Println("server exit:", err)
 }
 
-// handlePacket 第二层，解析 packet 层
-func handlePacket(framePayload []byte) (ackFramePayload []byte, err error) {
-	var p packet.Packet
-	// 解析后，获取 packet 实例 或是 submit submitAck conn connAck
-	p, err = packet.Decode(framePayload)
+// listenAux 为辅助的 http 服务创建 listener，addr 为空或失败时返回 nil
+func listenAux(upg *upgrade.Upgrader, name, addr string) net.Listener {
+	if addr == "" {
+		return nil
+	}
+	l, err := upg.Listen(addr)
 	if err != nil {
-		fmt.Println("handleConn: packet decode error:", err)
-		return
+		fmt.Printf("%s http server start failed: %v\n", name, err)
+		return nil
 	}
+	return l
+}
 
-	switch p.(type) {
-	case *packet.Submit:
-		// 获取请求信息
-		submit := p.(*packet.Submit)
-		fmt.Printf("recv submit: id = %s, payload=%s\n", submit.ID, string(submit.Payload))
-		// 根据请求信息，响应信息
-		submitAck := &packet.SubmitAck{
-			ID:     submit.ID,
-			Result: 0,
-		}
-		packet.SubmitPool.Put(submit) // put back to submit pool
-		ackFramePayload, err = packet.Encode(submitAck)
+// serveAux 运行辅助的 http 服务，交接给新进程后 listener 被关闭，不视为出错
+func serveAux(name string, serve func() error) {
+	if err := serve(); err != nil && !errors.Is(err, net.ErrClosed) {
+		fmt.Printf("%s http server exit: %v\n", name, err)
+	}
+}
+
+// newServer 按配置创建 Server，并返回可热更新的对象
+func newServer(cfg *config.Config) (*server.Server, config.Runtime, error) {
+	var rt config.Runtime
+	gate, err := connlimit.New(cfg.Limits.GateConfig())
+	if err != nil {
+		return nil, rt, fmt.Errorf("connlimit config error: %w", err)
+	}
+	rt.Gate = gate
+	rt.Limiter = limiter.New(cfg.Limits.LimiterConfig())
+
+	srv := &server.Server{
+		Limiter:          rt.Limiter,
+		Gate:             rt.Gate,
+		SubQueueSize:     cfg.Limits.SubQueueSize,
+		HandshakeTimeout: cfg.Timeouts.Handshake,
+		IdleTimeout:      cfg.Timeouts.Idle,
+		WriteTimeout:     cfg.Timeouts.Write,
+
+		CompressThreshold: cfg.Compression.Threshold,
+	}
+	// Validate 已经校验过，这里不会出错
+	srv.FrameFormat, _ = frame.ParseFormat(cfg.Frame.Format)
+	srv.FrameDelimiter, _ = cfg.Frame.DelimiterCodec()
+	srv.MaxFrameLength = cfg.Frame.MaxLength
+	srv.Compression, _ = cfg.Compression.Parse()
+	srv.Checksum, _ = cfg.Checksum.Parse()
+	srv.Encryption, _ = cfg.Encryption.Parse()
+	srv.RequireEncryption = cfg.Encryption.Required
+	srv.SlowConsumer, _ = cfg.Limits.SlowPolicy()
+	srv.Registry.Policy, _ = cfg.Limits.DuplicatePolicy()
+	if len(cfg.Auth.Credentials) > 0 {
+		rt.Auth = auth.NewStatic(cfg.Auth.Credentials)
+		srv.Auth = rt.Auth
+	}
+	if srv.EncryptionKey, err = cfg.Encryption.LoadKey(); err != nil {
+		return nil, rt, err
+	}
+	if srv.EncryptionKey != nil {
+		pub, err := encryption.PublicKey(srv.EncryptionKey)
 		if err != nil {
-			fmt.Println("handleConn: packet decode error:", err)
-			return nil, err
+			return nil, rt, err
 		}
-		return ackFramePayload, nil
-	case *packet.Con:
-		// 获取请求信息
-		conn := p.(*packet.Con)
-		fmt.Printf("recv conn: id = %s, payload=%s\n", conn.ID, string(conn.Payload))
-		connAck := &packet.Con{
-			ID:      conn.ID,
-			Payload: nil,
-		}
-		ackFramePayload, err = packet.Encode(connAck)
+		logger.Infof("encryption server key: %x", pub)
+	}
+	tlsConfig, err := cfg.TLS.Load()
+	if err != nil {
+		return nil, rt, err
+	}
+	if tlsConfig != nil {
+		rt.TLS = config.NewDynamicTLS(tlsConfig)
+		srv.TLSConfig = rt.TLS.Config()
+	}
+	return srv, rt, nil
+}
+
+// setupLog 设置日志级别与输出
+func setupLog(cfg config.LogConfig) error {
+	level, err := logger.ParseLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+	logger.SetLevel(level)
+
+	var w io.Writer
+	switch cfg.Output {
+	case "stderr":
+		w = os.Stderr
+	case "stdout":
+		w = os.Stdout
+	default:
+		f, err := os.OpenFile(cfg.Output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
 		if err != nil {
-			fmt.Println("handleConnAck:packet decode error:", err)
-			return nil, err
+			return err
 		}
-		return ackFramePayload, nil
-	default:
-		return nil, fmt.Errorf("unknown packet type")
+		w = f
 	}
+	log.SetOutput(w)
+	return nil
 }
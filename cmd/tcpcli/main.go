@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CoderI421/tcp-service/frame"
+	"github.com/CoderI421/tcp-service/internal/pktfmt"
+	"github.com/CoderI421/tcp-service/message"
+	"github.com/CoderI421/tcp-service/packet"
+	"github.com/CoderI421/tcp-service/textpacket"
+	"github.com/CoderI421/tcp-service/transport"
+)
+
+var (
+	addr     = flag.String("addr", ":8888", "服务端地址，如 tcp://:8888、tls://:8443、unix:///var/run/tcp-service.sock")
+	id       = flag.String("id", "00000001", "Con 握手的客户端标识，8 字节")
+	secret   = flag.String("auth", "", "Con 握手的凭证，服务端开启鉴权时使用")
+	noCon    = flag.Bool("no-con", false, "连接后不自动发送 Con")
+	autoAck  = flag.Bool("auto-ack", true, "收到 Deliver 时自动回复 result 为 ok 的 DeliverAck")
+	dump     = flag.Bool("dump", false, "以 hex 显示收发的完整 frame")
+	insecure = flag.Bool("insecure", false, "tls:// 地址不校验服务端证书")
+	fmtName  = flag.String("frame-format", "legacy", "帧格式: legacy | header | varint | line | delimiter，后两种收发文本 packet")
+)
+
+const usage = `命令：
+  con <id> [credential]      发送 Con 握手
+  submit <text>              发送 Submit，payload 为文本
+  submit -x <hex>            发送 Submit，payload 为 hex
+  pub <topic> <text>         发送带 topic 的 Submit，-x 同上
+  typed <ct> <kind> <text>   发送带类型的 Submit，ct 为 json | protobuf | msgpack，-x 同上
+  sub <topic>                订阅 topic
+  unsub <topic>              取消订阅 topic
+  ack <id> [result]          回复 DeliverAck，result 默认为 0
+  raw <hex>                  发送原始 packet（commandID + body），自动加上 frame 头
+  dump [on|off]              切换 frame 的 hex 显示
+  help                       显示本帮助
+  quit                       退出`
+
+// cli 一个调试连接，读协程与命令行协程共用输出
+type cli struct {
+	conn  net.Conn
+	codec frame.StreamFrameCodec
+
+	mu      sync.Mutex // 保护以下字段及输出
+	seq     int
+	pending map[string]time.Time // 已发出请求的发送时间，键见 pendingKey，收到 ack 时计算耗时
+	dump    bool
+	closing bool
+}
+
+func main() {
+	flag.Parse()
+	format, err := frame.ParseFormat(*fmtName)
+	if err != nil || format == frame.FormatAuto {
+		fmt.Fprintf(os.Stderr, "invalid frame format [%s]\n", *fmtName)
+		os.Exit(2)
+	}
+
+	var tlsConfig *tls.Config
+	if *insecure {
+		tlsConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	conn, err := transport.DialTLS(*addr, tlsConfig)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "dial error:", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	c := &cli{
+		conn:    conn,
+		codec:   textpacket.NewFormatCodec(format),
+		pending: make(map[string]time.Time),
+		dump:    *dump,
+	}
+	c.printf("connected to %s (%s)", conn.RemoteAddr(), *addr)
+	go c.readLoop()
+
+	if !*noCon {
+		c.send(&packet.Con{ID: *id, Payload: []byte(*secret)})
+	}
+
+	sc := bufio.NewScanner(os.Stdin)
+	fmt.Print("> ")
+	for sc.Scan() {
+		if quit := c.exec(strings.TrimSpace(sc.Text())); quit {
+			break
+		}
+		fmt.Print("> ")
+	}
+	c.mu.Lock()
+	c.closing = true
+	c.mu.Unlock()
+}
+
+// exec 执行一行命令，返回是否退出
+func (c *cli) exec(line string) bool {
+	if line == "" {
+		return false
+	}
+	cmd, args := line, ""
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		cmd, args = line[:i], strings.TrimSpace(line[i+1:])
+	}
+
+	switch cmd {
+	case "quit", "exit":
+		return true
+	case "help":
+		fmt.Println(usage)
+	case "dump":
+		c.mu.Lock()
+		c.dump = args != "off"
+		c.mu.Unlock()
+	case "con":
+		fields := strings.SplitN(args, " ", 2)
+		if fields[0] == "" {
+			c.printf("usage: con <id> [credential]")
+			break
+		}
+		con := &packet.Con{ID: fields[0]}
+		if len(fields) > 1 {
+			con.Payload = []byte(fields[1])
+		}
+		c.send(con)
+	case "submit":
+		payload, err := parsePayload(args)
+		if err != nil {
+			c.printf("%v", err)
+			break
+		}
+		c.send(&packet.Submit{ID: c.nextID(), Payload: payload})
+	case "pub":
+		fields := strings.SplitN(args, " ", 2)
+		hexMode := fields[0] == "-x"
+		if hexMode && len(fields) > 1 {
+			fields = strings.SplitN(fields[1], " ", 2)
+		}
+		if fields[0] == "" {
+			c.printf("usage: pub [-x] <topic> <payload>")
+			break
+		}
+		rest := ""
+		if len(fields) > 1 {
+			rest = fields[1]
+		}
+		if hexMode {
+			rest = "-x " + rest
+		}
+		payload, err := parsePayload(rest)
+		if err != nil {
+			c.printf("%v", err)
+			break
+		}
+		c.send(&packet.Submit{ID: c.nextID(), Topic: fields[0], Payload: payload})
+	case "typed":
+		fields := strings.SplitN(args, " ", 3)
+		if len(fields) < 2 || fields[1] == "" {
+			c.printf("usage: typed <content-type> <kind> [-x] <payload>")
+			break
+		}
+		ct, err := message.Parse(fields[0])
+		if err != nil {
+			c.printf("%v", err)
+			break
+		}
+		rest := ""
+		if len(fields) > 2 {
+			rest = fields[2]
+		}
+		payload, err := parsePayload(rest)
+		if err != nil {
+			c.printf("%v", err)
+			break
+		}
+		c.send(&packet.Submit{ID: c.nextID(), Kind: fields[1], ContentType: uint8(ct), Payload: payload})
+	case "sub", "unsub":
+		if args == "" {
+			c.printf("usage: %s <topic>", cmd)
+			break
+		}
+		if cmd == "sub" {
+			c.send(&packet.Subscribe{ID: c.nextID(), Topic: args})
+		} else {
+			c.send(&packet.Unsubscribe{ID: c.nextID(), Topic: args})
+		}
+	case "ack":
+		fields := strings.Fields(args)
+		if len(fields) == 0 {
+			c.printf("usage: ack <id> [result]")
+			break
+		}
+		var result uint64
+		if len(fields) > 1 {
+			var err error
+			if result, err = strconv.ParseUint(fields[1], 10, 8); err != nil {
+				c.printf("invalid result [%s]", fields[1])
+				break
+			}
+		}
+		c.send(&packet.DeliverAck{ID: fields[0], Result: uint8(result)})
+	case "raw":
+		b, err := hex.DecodeString(strings.ReplaceAll(args, " ", ""))
+		if err != nil || len(b) == 0 {
+			c.printf("invalid hex [%s]", args)
+			break
+		}
+		c.write(b, "raw packet")
+	default:
+		c.printf("unknown command [%s], type help for usage", cmd)
+	}
+	return false
+}
+
+// parsePayload 解析文本或 -x 开头的 hex
+func parsePayload(s string) ([]byte, error) {
+	if s == "-x" || strings.HasPrefix(s, "-x ") {
+		h := strings.ReplaceAll(strings.TrimPrefix(s, "-x"), " ", "")
+		b, err := hex.DecodeString(h)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex [%s]", h)
+		}
+		return b, nil
+	}
+	return []byte(s), nil
+}
+
+func (c *cli) nextID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	return fmt.Sprintf("%08d", c.seq%100000000)
+}
+
+// send 编码并发送 p，记录请求的发送时间
+func (c *cli) send(p packet.Packet) {
+	var key string
+	switch t := p.(type) {
+	case *packet.Con:
+		if len(t.ID) != 8 {
+			c.printf("id must be 8 bytes [%s]", t.ID)
+			return
+		}
+		key = pendingKey(packet.CommandConnAck, t.ID)
+	case *packet.Submit:
+		key = pendingKey(packet.CommandSubmitAck, t.ID)
+	case *packet.Subscribe:
+		key = pendingKey(packet.CommandSubscribeAck, t.ID)
+	case *packet.Unsubscribe:
+		key = pendingKey(packet.CommandUnsubscribeAck, t.ID)
+	case *packet.DeliverAck:
+		if len(t.ID) != 8 {
+			c.printf("id must be 8 bytes [%s]", t.ID)
+			return
+		}
+	}
+	b, err := packet.Encode(p)
+	if err != nil {
+		c.printf("encode error: %v", err)
+		return
+	}
+	if key != "" {
+		c.mu.Lock()
+		c.pending[key] = time.Now()
+		c.mu.Unlock()
+	}
+	c.write(b, pktfmt.Format(p))
+}
+
+func (c *cli) write(b []byte, desc string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.codec.Encode(c.conn, b); err != nil {
+		c.printfLocked("write error: %v", err)
+		return
+	}
+	c.printfLocked("-> %s", desc)
+	if c.dump {
+		fmt.Print(pktfmt.Frame(c.codec, b))
+	}
+}
+
+// readLoop 解析并显示服务端发来的 packet，连接断开时退出进程
+func (c *cli) readLoop() {
+	r := bufio.NewReader(c.conn)
+	for {
+		payload, err := c.codec.Decode(r)
+		if err != nil {
+			c.mu.Lock()
+			closing := c.closing
+			c.mu.Unlock()
+			if closing {
+				return
+			}
+			if err == io.EOF {
+				c.printf("connection closed by server")
+			} else {
+				c.printf("read error: %v", err)
+			}
+			os.Exit(0)
+		}
+		now := time.Now()
+		c.show(payload, now)
+	}
+}
+
+func (c *cli) show(payload frame.Payload, now time.Time) {
+	p, err := packet.Decode(payload)
+
+	c.mu.Lock()
+	if err != nil {
+		c.printfLocked("<- undecodable packet: %v", err)
+	} else {
+		desc := pktfmt.Format(p)
+		if key, ok := ackKey(p); ok {
+			if sent, ok := c.pending[key]; ok {
+				delete(c.pending, key)
+				desc += fmt.Sprintf(" (%.3fms)", float64(now.Sub(sent))/float64(time.Millisecond))
+			}
+		}
+		c.printfLocked("<- %s", desc)
+	}
+	if c.dump {
+		fmt.Print(pktfmt.Frame(c.codec, payload))
+	}
+	c.mu.Unlock()
+
+	if d, ok := p.(*packet.Deliver); ok && *autoAck {
+		c.send(&packet.DeliverAck{ID: d.ID, Result: packet.ResultOK})
+	}
+}
+
+// pendingKey 不同类型的请求可能使用相同的 ID，以 ack 的 commandID 区分
+func pendingKey(ackCommand uint8, id string) string {
+	return fmt.Sprintf("%02x/%s", ackCommand, id)
+}
+
+// ackKey 返回 ack 对应请求的 pendingKey
+func ackKey(p packet.Packet) (string, bool) {
+	switch t := p.(type) {
+	case *packet.ConAck:
+		return pendingKey(packet.CommandConnAck, t.ID), true
+	case *packet.SubmitAck:
+		return pendingKey(packet.CommandSubmitAck, t.ID), true
+	case *packet.SubscribeAck:
+		return pendingKey(packet.CommandSubscribeAck, t.ID), true
+	case *packet.UnsubscribeAck:
+		return pendingKey(packet.CommandUnsubscribeAck, t.ID), true
+	}
+	return "", false
+}
+
+func (c *cli) printf(format string, args ...interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.printfLocked(format, args...)
+}
+
+// printfLocked 带时间戳输出一行，调用方需持有 mu
+func (c *cli) printfLocked(format string, args ...interface{}) {
+	fmt.Printf("\r%s %s\n", time.Now().Format("15:04:05.000"), fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/CoderI421/tcp-service/checksum"
+	"github.com/CoderI421/tcp-service/compression"
+	"github.com/CoderI421/tcp-service/frame"
+	"github.com/CoderI421/tcp-service/internal/pktfmt"
+	"github.com/CoderI421/tcp-service/packet"
+)
+
+// streamDecoder 从单向字节流中切分 frame 并解析 packet
+// 数据按到达顺序通过 feed 送入，完整的 frame 以收齐时的时间戳输出
+type streamDecoder struct {
+	label    string // 流的描述，如 C>S 10.0.0.1:5000 -> 10.0.0.2:8888
+	maxFrame int
+	dump     bool
+	w        io.Writer
+
+	codec  *compression.Codec
+	sum    *checksum.Codec // codec 的底层，校验并去掉 frame 的校验和
+	format frame.Format    // 流的帧格式，由第一个 frame 识别
+	note   string          // 当前 frame 的校验和与压缩信息，由 Observe 设置
+	buf    []byte
+	offset int64 // buf[0] 在流中的偏移
+	broken bool  // 出现无法恢复的错误后不再解析
+}
+
+func newStreamDecoder(label string, maxFrame int, dump bool, w io.Writer) *streamDecoder {
+	d := &streamDecoder{
+		label:    label,
+		maxFrame: maxFrame,
+		dump:     dump,
+		w:        w,
+		codec:    compression.NewCodec(),
+		sum:      checksum.NewCodec(),
+	}
+	d.codec.Frame = d.sum
+	d.codec.MaxSize = maxFrame
+	d.codec.Observe = func(alg compression.Algorithm, out bool, raw, wire int) {
+		d.note += fmt.Sprintf(" [%s %d->%d]", alg, raw, wire)
+	}
+	d.sum.Observe = func(alg checksum.Algorithm, ok bool) {
+		if ok {
+			d.note += fmt.Sprintf(" [%s]", alg)
+		}
+	}
+	return d
+}
+
+// feed 送入流中的下一段数据
+func (d *streamDecoder) feed(data []byte, ts time.Time) {
+	if d.broken {
+		return
+	}
+	d.buf = append(d.buf, data...)
+	for len(d.buf) >= 4 {
+		if d.format == frame.FormatAuto {
+			// 流的第一个 frame 决定帧格式
+			d.format = frame.FormatLegacy
+			if d.buf[0] == frame.HeaderMagic {
+				d.format = frame.FormatHeader
+			}
+			d.sum.Frame = frame.NewFormatCodecLimit(d.format, d.maxFrame)
+		}
+		total, hdr := d.frameLen()
+		if total < 0 {
+			return
+		}
+		if total < hdr+1 || total > d.maxFrame {
+			// 长度不可信，之后的数据无法再对齐到 frame 边界
+			d.malformed(ts, fmt.Sprintf("frame length %d out of range [%d, %d]", total, hdr+1, d.maxFrame), d.buf[:hdr])
+			d.broken = true
+			d.buf = nil
+			return
+		}
+		if len(d.buf) < total {
+			return
+		}
+		d.note = ""
+		raw := d.buf[:total]
+		payload, flags, err := d.codec.DecodeFlags(bytes.NewReader(raw))
+		switch {
+		case err != nil:
+			d.malformed(ts, "frame: "+err.Error(), raw)
+		case flags&frame.FlagEncrypted != 0:
+			d.encrypted(ts, payload, raw)
+		default:
+			d.packet(ts, payload, raw)
+		}
+		d.offset += int64(total)
+		d.buf = d.buf[total:]
+	}
+}
+
+// frameLen 按帧头返回完整 frame 的长度与帧头的长度，帧头不完整时 total 为 -1
+func (d *streamDecoder) frameLen() (total, hdr int) {
+	if d.format == frame.FormatHeader {
+		if len(d.buf) < frame.HeaderSize {
+			return -1, frame.HeaderSize
+		}
+		return frame.HeaderSize + int(binary.BigEndian.Uint32(d.buf[4:8])), frame.HeaderSize
+	}
+	// 压缩的 frame 在长度头的最高位有标记
+	return int(binary.BigEndian.Uint32(d.buf) &^ frame.LegacyCompressedBit), 4
+}
+
+// gap 流中缺失了数据，之后无法对齐到 frame 边界，reason 说明缺失的情况
+func (d *streamDecoder) gap(reason string, ts time.Time) {
+	if d.broken {
+		return
+	}
+	d.printf(ts, "GAP %s, skipping rest of stream", reason)
+	d.broken = true
+	d.buf = nil
+}
+
+// end 流结束，剩余不足一个 frame 的数据视为截断
+func (d *streamDecoder) end(ts time.Time) {
+	if d.broken || len(d.buf) == 0 {
+		return
+	}
+	want := "?"
+	if len(d.buf) >= 4 {
+		if total, _ := d.frameLen(); total >= 0 {
+			want = fmt.Sprint(total)
+		}
+	}
+	d.malformed(ts, fmt.Sprintf("truncated frame: have %d of %s bytes", len(d.buf), want), d.buf)
+	d.buf = nil
+}
+
+// packet 解析 frame 中的 packet，raw 为抓到的完整 frame，-hex 时原样输出
+func (d *streamDecoder) packet(ts time.Time, payload frame.Payload, raw []byte) {
+	p, err := packet.Decode(payload)
+	if err != nil {
+		d.malformed(ts, fmt.Sprintf("packet (command 0x%02x): %v", payload[0], err), payload)
+		return
+	}
+	d.printf(ts, "%s%s", pktfmt.Format(p), d.note)
+	if d.dump {
+		fmt.Fprint(d.w, hex.Dump(raw))
+	}
+	// Submit 来自对象池，这里不归还
+}
+
+// encrypted 端到端加密的 frame 无法解析，只输出序号与密文长度
+func (d *streamDecoder) encrypted(ts time.Time, payload frame.Payload, raw []byte) {
+	if len(payload) < 8 {
+		d.malformed(ts, "encrypted frame too short", payload)
+		return
+	}
+	d.printf(ts, "ENCRYPTED seq=%d len=%d%s", binary.BigEndian.Uint64(payload), len(payload)-8, d.note)
+	if d.dump {
+		fmt.Fprint(d.w, hex.Dump(raw))
+	}
+}
+
+// malformed 标记出错的数据，总是输出 hex
+func (d *streamDecoder) malformed(ts time.Time, reason string, data []byte) {
+	d.printf(ts, "MALFORMED %s", reason)
+	fmt.Fprint(d.w, hex.Dump(data))
+}
+
+func (d *streamDecoder) printf(ts time.Time, format string, args ...interface{}) {
+	prefix := ""
+	if !ts.IsZero() {
+		prefix = ts.Format("2006-01-02 15:04:05.000000") + " "
+	}
+	fmt.Fprintf(d.w, "%s%s @%d %s\n", prefix, d.label, d.offset, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CoderI421/tcp-service/checksum"
+	"github.com/CoderI421/tcp-service/compression"
+	"github.com/CoderI421/tcp-service/frame"
+	"github.com/CoderI421/tcp-service/packet"
+)
+
+// wire 按帧格式编码 packet，alg 与 sum 不为 None 时压缩、加上校验和
+func wire(t *testing.T, f frame.Format, alg compression.Algorithm, sum checksum.Algorithm, p packet.Packet) []byte {
+	t.Helper()
+	payload, err := packet.Encode(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := checksum.NewCodec()
+	s.Frame = frame.NewFormatCodecLimit(f, 64<<20)
+	s.SetAlgorithm(sum)
+	c := compression.NewCodec()
+	c.Frame = s
+	c.Threshold = 1
+	c.MaxSize = 64 << 20
+	c.SetAlgorithm(alg)
+	var buf bytes.Buffer
+	if err = c.Encode(&buf, payload); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// split 把 b 切成每段 n 字节
+func split(b []byte, n int) [][]byte {
+	var chunks [][]byte
+	for len(b) > n {
+		chunks = append(chunks, b[:n])
+		b = b[n:]
+	}
+	return append(chunks, b)
+}
+
+func TestStreamDecoder(t *testing.T) {
+	submit := &packet.Submit{ID: "00000001", Payload: bytes.Repeat([]byte("hello "), 20)}
+	ack := &packet.SubmitAck{ID: "00000001", Result: packet.ResultOK}
+	legacy := wire(t, frame.FormatLegacy, compression.None, checksum.None, submit)
+	header := wire(t, frame.FormatHeader, compression.None, checksum.None, ack)
+	compressed := wire(t, frame.FormatHeader, compression.Gzip, checksum.None, submit)
+	summed := wire(t, frame.FormatHeader, compression.None, checksum.CRC32C, ack)
+	both := wire(t, frame.FormatHeader, compression.Gzip, checksum.XXHash, submit)
+	// 超过默认 16MiB 的 frame，-max-frame 更大时需要能解析
+	large := wire(t, frame.FormatLegacy, compression.None, checksum.None, &packet.Submit{ID: "00000002", Payload: make([]byte, 17<<20)})
+	largeCompressed := wire(t, frame.FormatHeader, compression.Gzip, checksum.None, &packet.Submit{ID: "00000003", Payload: make([]byte, 17<<20)})
+
+	tests := []struct {
+		name     string
+		chunks   [][]byte
+		maxFrame int
+		dump     bool
+		want     []string // 按顺序出现在输出中的内容
+		notWant  []string
+	}{
+		{name: "Split", chunks: split(append(append([]byte(nil), legacy...), legacy...), 3),
+			want: []string{"@0 Submit id=00000001", "@" + strconv.Itoa(len(legacy)) + " Submit id=00000001"}},
+		{name: "Header", chunks: [][]byte{header}, want: []string{"@0 SubmitAck id=00000001 result=ok"}},
+		{name: "Compressed", chunks: split(compressed, 5), dump: true,
+			want: []string{"Submit id=00000001", "[gzip", hex.Dump(compressed)}},
+		{name: "Checksum", chunks: [][]byte{summed}, dump: true,
+			want: []string{"SubmitAck id=00000001 result=ok [crc32c]", hex.Dump(summed)}},
+		{name: "CompressedChecksum", chunks: [][]byte{both}, dump: true,
+			want: []string{"Submit id=00000001", "[xxhash]", hex.Dump(both)}},
+		{name: "Truncated", chunks: [][]byte{header, compressed[:len(compressed)-3]},
+			want:    []string{"SubmitAck", "MALFORMED truncated frame: have " + strconv.Itoa(len(compressed)-3) + " of " + strconv.Itoa(len(compressed)) + " bytes"},
+			notWant: []string{"Submit id"}},
+		{name: "TruncatedHeader", chunks: [][]byte{header[:6]}, want: []string{"MALFORMED truncated frame: have 6 of ? bytes"}},
+		{name: "TooLarge", chunks: [][]byte{legacy}, maxFrame: 16, want: []string{"MALFORMED frame length"}, notWant: []string{"Submit id"}},
+		{name: "LargeFrame", chunks: split(large, 1<<20), maxFrame: 32 << 20, want: []string{"Submit id=00000002"}, notWant: []string{"MALFORMED"}},
+		{name: "LargeCompressed", chunks: [][]byte{largeCompressed}, maxFrame: 32 << 20, want: []string{"Submit id=00000003"}, notWant: []string{"MALFORMED"}},
+		{name: "LargeCompressedDefault", chunks: [][]byte{largeCompressed}, want: []string{"MALFORMED frame:"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxFrame := tt.maxFrame
+			if maxFrame == 0 {
+				maxFrame = 16 << 20
+			}
+			var out bytes.Buffer
+			d := newStreamDecoder("C>S", maxFrame, tt.dump, &out)
+			for _, c := range tt.chunks {
+				d.feed(c, time.Time{})
+			}
+			d.end(time.Time{})
+
+			got := out.String()
+			rest := got
+			for _, w := range tt.want {
+				i := strings.Index(rest, w)
+				if i < 0 {
+					t.Fatalf("output missing %q after previous matches:\n%s", w, got)
+				}
+				rest = rest[i+len(w):]
+			}
+			for _, w := range tt.notWant {
+				if strings.Contains(got, w) {
+					t.Errorf("output contains %q:\n%s", w, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/google/gopacket/tcpassembly"
+)
+
+/*
+把抓包数据解析为可读的 packet
+
+	tcpdecode -r capture.pcap -port 8888     解析 pcap/pcapng 文件，重组 TCP 流
+	tcpdecode < stream.bin                    解析标准输入中的单向原始字节流
+
+每个 packet 输出一行：时间戳、方向（C>S 客户端到服务端，S>C 服务端到客户端）、地址、流中的偏移以及 packet 内容
+长度不合法、无法解析的 frame 以 MALFORMED 标记并输出 hex，抓包丢失的数据以 GAP 标记
+*/
+
+var (
+	file     = flag.String("r", "", "pcap 或 pcapng 文件，为空时从标准输入读取原始字节流")
+	port     = flag.Int("port", 8888, "服务端端口，用于区分方向")
+	maxFrame = flag.Int("max-frame", 16<<20, "frame 的最大长度，超出视为格式错误")
+	dump     = flag.Bool("hex", false, "同时输出每个 frame 的 hex")
+)
+
+func main() {
+	flag.Parse()
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	var err error
+	if *file == "" {
+		err = decodeRaw(os.Stdin, out)
+	} else {
+		err = decodePcap(*file, out)
+	}
+	if err != nil {
+		out.Flush()
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// decodeRaw 解析单向的原始字节流
+func decodeRaw(r io.Reader, w io.Writer) error {
+	d := newStreamDecoder("stdin", *maxFrame, *dump, w)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := r.Read(buf)
+		d.feed(buf[:n], time.Time{})
+		if err == io.EOF {
+			d.end(time.Time{})
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// packetSource pcap 与 pcapng 共同的读取接口
+type packetSource interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	LinkType() layers.LinkType
+}
+
+func openPcap(name string) (packetSource, io.Closer, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if r, err := pcapgo.NewReader(f); err == nil {
+		return r, f, nil
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	r, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("%s: not a pcap or pcapng file", name)
+	}
+	return r, f, nil
+}
+
+// decodePcap 读取抓包文件，按 TCP 流重组后解析
+func decodePcap(name string, w io.Writer) error {
+	src, c, err := openPcap(name)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	assembler := tcpassembly.NewAssembler(tcpassembly.NewStreamPool(&factory{w: w}))
+	var last time.Time
+	for {
+		data, ci, err := src.ReadPacketData()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		last = ci.Timestamp
+		p := gopacket.NewPacket(data, src.LinkType(), gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+		tcp, ok := p.TransportLayer().(*layers.TCP)
+		if !ok || p.NetworkLayer() == nil {
+			continue
+		}
+		assembler.AssembleWithTimestamp(p.NetworkLayer().NetworkFlow(), tcp, ci.Timestamp)
+	}
+	// 抓包结束时仍未关闭的流，缺失的数据按 GAP 处理
+	assembler.FlushOlderThan(last.Add(time.Second))
+	return nil
+}
+
+// factory 为每个方向的 TCP 流创建 stream
+type factory struct {
+	w io.Writer
+}
+
+func (f *factory) New(netFlow, tcpFlow gopacket.Flow) tcpassembly.Stream {
+	src, dst := tcpFlow.Endpoints()
+	dir := "?"
+	switch fmt.Sprint(*port) {
+	case dst.String():
+		dir = "C>S"
+	case src.String():
+		dir = "S>C"
+	}
+	nsrc, ndst := netFlow.Endpoints()
+	label := fmt.Sprintf("%s %s:%s -> %s:%s", dir, nsrc, src, ndst, dst)
+	return &stream{d: newStreamDecoder(label, *maxFrame, *dump, f.w)}
+}
+
+// stream 实现 tcpassembly.Stream，把重组后的数据交给 streamDecoder
+type stream struct {
+	d    *streamDecoder
+	last time.Time
+}
+
+func (s *stream) Reassembled(rs []tcpassembly.Reassembly) {
+	for _, r := range rs {
+		s.last = r.Seen
+		if r.Skip > 0 {
+			s.d.gap(fmt.Sprintf("%d bytes missing", r.Skip), r.Seen)
+		} else if r.Skip < 0 {
+			// 抓包开始时连接已建立，无法确定 frame 边界
+			s.d.gap("stream started before capture", r.Seen)
+		}
+		s.d.feed(r.Bytes, r.Seen)
+	}
+}
+
+func (s *stream) ReassemblyComplete() {
+	s.d.end(s.last)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+/*
+录制与回放
+
+	tcpproxy record -listen :9888 -upstream :8888 -out session.jsonl
+		作为代理转发客户端与服务端之间的 frame，解析并带时间戳录制到文件
+	tcpproxy replay -in session.jsonl -addr :8888 -speed 10
+		把录制中客户端发出的 frame 按原来的节奏（或加速）发给服务端，并与录制中的 ack 比较
+
+录制文件每行一个 JSON 对象，见 Record
+*/
+
+const usage = `usage:
+  tcpproxy record -listen <addr> -upstream <addr> -out <file>
+  tcpproxy replay -in <file> -addr <addr> [-speed n]
+
+run "tcpproxy <command> -h" for details`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "record":
+		err = record(os.Args[2:])
+	case "replay":
+		err = replay(os.Args[2:])
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/CoderI421/tcp-service/checksum"
+	"github.com/CoderI421/tcp-service/compression"
+	"github.com/CoderI421/tcp-service/frame"
+	"github.com/CoderI421/tcp-service/internal/pktfmt"
+	"github.com/CoderI421/tcp-service/packet"
+	"github.com/CoderI421/tcp-service/transport"
+)
+
+// 录制中的方向与事件
+const (
+	DirC2S = "c2s" // 客户端到服务端
+	DirS2C = "s2c" // 服务端到客户端
+
+	EventOpen  = "open"
+	EventClose = "close"
+)
+
+// Record 录制文件中的一行，Event 不为空时为连接的打开或关闭，否则为一个 frame
+type Record struct {
+	Time   time.Time `json:"t"`
+	Run    string    `json:"run,omitempty"` // 录制批次，同一个文件多次追加录制时区分连接序号
+	Conn   uint64    `json:"conn"`
+	Event  string    `json:"event,omitempty"`
+	Client string    `json:"client,omitempty"` // open 时客户端的地址
+	Dir    string    `json:"dir,omitempty"`
+	Frame  []byte    `json:"frame,omitempty"` // frame payload，不含长度头
+	Desc   string    `json:"desc,omitempty"`  // 解析后的 packet，便于阅读，回放时不使用
+}
+
+// recorder 并发安全地写入录制文件
+type recorder struct {
+	mu  sync.Mutex
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func newRecorder(w io.Writer) *recorder {
+	bw := bufio.NewWriter(w)
+	return &recorder{w: bw, enc: json.NewEncoder(bw)}
+}
+
+func (r *recorder) write(rec *Record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(rec); err != nil {
+		fmt.Fprintln(os.Stderr, "record error:", err)
+	}
+	r.w.Flush()
+}
+
+// describe 解析 frame payload 为可读的描述，无法解析时标记 MALFORMED
+func describe(payload []byte) string {
+	p, err := packet.Decode(payload)
+	if err != nil {
+		return "MALFORMED " + err.Error()
+	}
+	return pktfmt.Format(p)
+}
+
+func record(args []string) error {
+	fs := flag.NewFlagSet("tcpproxy record", flag.ExitOnError)
+	listen := fs.String("listen", ":9888", "代理监听的地址，客户端连接此地址")
+	upstream := fs.String("upstream", ":8888", "服务端地址")
+	out := fs.String("out", "session.jsonl", "录制文件，已存在时追加")
+	quiet := fs.Bool("q", false, "不在标准输出显示转发的 packet")
+	fs.Parse(args)
+
+	f, err := os.OpenFile(*out, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	l, err := transport.Listen(*listen)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("recording %s -> %s to %s\n", *listen, *upstream, *out)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		l.Close()
+	}()
+
+	// 连接序号每次录制从 1 开始，以启动时间作为批次区分追加到同一个文件的多次录制
+	run := time.Now().UTC().Format("20060102T150405.000000000")
+	p := &proxy{upstream: *upstream, rec: newRecorder(f), run: run, quiet: *quiet}
+	var wg sync.WaitGroup
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				break
+			}
+			return err
+		}
+		p.seq++
+		wg.Add(1)
+		go func(id uint64) {
+			defer wg.Done()
+			p.handle(id, c)
+		}(p.seq)
+	}
+	// 已有连接继续录制直到断开
+	wg.Wait()
+	return nil
+}
+
+// proxy 录制代理，每个客户端连接对应一个到服务端的连接
+type proxy struct {
+	upstream string
+	rec      *recorder
+	run      string // 录制批次
+	quiet    bool
+	seq      uint64 // 连接序号，只在 accept 协程中修改
+}
+
+func (p *proxy) handle(id uint64, client net.Conn) {
+	defer client.Close()
+	server, err := transport.Dial(p.upstream)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "conn %d: dial upstream error: %v\n", id, err)
+		return
+	}
+	defer server.Close()
+
+	p.rec.write(&Record{Time: time.Now(), Run: p.run, Conn: id, Event: EventOpen, Client: client.RemoteAddr().String()})
+	defer func() {
+		p.rec.write(&Record{Time: time.Now(), Run: p.run, Conn: id, Event: EventClose})
+	}()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		p.pipe(id, DirC2S, client, server)
+		done <- struct{}{}
+	}()
+	go func() {
+		p.pipe(id, DirS2C, server, client)
+		done <- struct{}{}
+	}()
+	// 任一方向结束时关闭两个连接，另一方向随之结束
+	<-done
+	client.Close()
+	server.Close()
+	<-done
+}
+
+// pipe 逐个 frame 地从 src 转发到 dst 并录制
+// 压缩的 frame 解压后录制并以未压缩的形式转发，双方都能接收未压缩的 frame；
+// 校验和校验后按原来的算法重新计算，协商了校验和的接收方不接受不带校验和的 frame
+// 转发时使用与 src 相同的帧格式；端到端加密的 frame 无法解密，原样转发，不录制
+func (p *proxy) pipe(id uint64, dir string, src, dst net.Conn) {
+	codec := frame.NewAutoCodec()
+	sum := checksum.NewCodec()
+	sum.Frame = codec
+	var alg checksum.Algorithm // 当前 frame 的校验和算法
+	sum.Observe = func(a checksum.Algorithm, ok bool) { alg = a }
+	dec := compression.NewCodec()
+	dec.Frame = sum
+	enc := checksum.NewCodec()
+	enc.Frame = codec
+	r := bufio.NewReader(src)
+	for {
+		alg = checksum.None
+		payload, flags, err := dec.DecodeFlags(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				fmt.Fprintf(os.Stderr, "conn %d %s: %v\n", id, dir, err)
+			}
+			return
+		}
+		if flags&frame.FlagEncrypted != 0 {
+			if !p.quiet {
+				fmt.Printf("%s conn %d %s ENCRYPTED len=%d\n", time.Now().Format("15:04:05.000000"), id, dir, len(payload))
+			}
+			enc.SetAlgorithm(alg)
+			if err = enc.EncodeFlags(dst, payload, flags); err != nil {
+				return
+			}
+			continue
+		}
+		rec := &Record{Time: time.Now(), Run: p.run, Conn: id, Dir: dir, Frame: payload, Desc: describe(payload)}
+		p.rec.write(rec)
+		if !p.quiet {
+			fmt.Printf("%s conn %d %s %s\n", rec.Time.Format("15:04:05.000000"), id, dir, rec.Desc)
+		}
+		enc.SetAlgorithm(alg)
+		if err = enc.Encode(dst, payload); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/CoderI421/tcp-service/checksum"
+	"github.com/CoderI421/tcp-service/packet"
+)
+
+func TestProxy_PipeChecksum(t *testing.T) {
+	for _, alg := range []checksum.Algorithm{checksum.None, checksum.CRC32C, checksum.XXHash} {
+		t.Run(alg.String(), func(t *testing.T) {
+			client, src := net.Pipe()
+			dst, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			p := &proxy{rec: newRecorder(io.Discard), quiet: true}
+			go p.pipe(1, DirC2S, src, dst)
+
+			// 客户端按协商的算法发送
+			enc := checksum.NewCodec()
+			enc.SetAlgorithm(alg)
+			payload := encodeFrame(t, &packet.Submit{ID: "00000001", Payload: []byte("hello")})
+			go enc.Encode(client, payload)
+
+			// 服务端协商了校验和时只接受该算法的 frame，转发时需要重新加上校验和
+			dec := checksum.NewCodec()
+			dec.SetAlgorithm(alg)
+			got, err := dec.Decode(bufio.NewReader(server))
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Errorf("Decode() = %x, want %x", got, payload)
+			}
+		})
+	}
+}
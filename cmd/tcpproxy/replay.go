@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/CoderI421/tcp-service/compression"
+	"github.com/CoderI421/tcp-service/frame"
+	"github.com/CoderI421/tcp-service/internal/pktfmt"
+	"github.com/CoderI421/tcp-service/packet"
+	"github.com/CoderI421/tcp-service/transport"
+)
+
+// session 录制中的一个连接
+type session struct {
+	run    string // 录制批次，旧的录制文件为空
+	id     uint64
+	open   time.Time // 连接打开的时间，没有 open 记录时为第一个 frame 的时间
+	sends  []*Record // 客户端发出的 frame
+	expect acks      // 录制中服务端回复的 ack
+}
+
+// sessionKey 连接序号只在一个录制批次内唯一
+type sessionKey struct {
+	run string
+	id  uint64
+}
+
+// name 日志中显示的连接名称
+func (s *session) name() string {
+	if s.run == "" {
+		return fmt.Sprintf("conn %d", s.id)
+	}
+	return fmt.Sprintf("run %s conn %d", s.run, s.id)
+}
+
+// acks 按 ackKey 归类的 ack result，同一个键可能出现多次（ID 重复使用），按顺序比较
+type acks map[string][]uint8
+
+// ackKey 返回 ack 的类型与 ID，如 SubmitAck 00000001，以及其 result
+func ackKey(payload []byte) (string, uint8, bool) {
+	p, err := packet.Decode(payload)
+	if err != nil {
+		return "", 0, false
+	}
+	switch t := p.(type) {
+	case *packet.ConAck:
+		return "ConAck " + t.ID, t.Result, true
+	case *packet.SubmitAck:
+		return "SubmitAck " + t.ID, t.Result, true
+	case *packet.SubscribeAck:
+		return "SubscribeAck " + t.ID, t.Result, true
+	case *packet.UnsubscribeAck:
+		return "UnsubscribeAck " + t.ID, t.Result, true
+	}
+	return "", 0, false
+}
+
+func (a acks) count() int {
+	n := 0
+	for _, rs := range a {
+		n += len(rs)
+	}
+	return n
+}
+
+// covers a 中是否已包含 want 的所有 ack
+func (a acks) covers(want acks) bool {
+	for k, rs := range want {
+		if len(a[k]) < len(rs) {
+			return false
+		}
+	}
+	return true
+}
+
+// diff 比较录制的 ack 与回放得到的 ack，返回按键排序的差异描述
+func diff(want, got acks) []string {
+	keys := make(map[string]bool)
+	for k := range want {
+		keys[k] = true
+	}
+	for k := range got {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var diffs []string
+	for _, k := range sorted {
+		w, g := want[k], got[k]
+		for i := 0; i < len(w) || i < len(g); i++ {
+			switch {
+			case i >= len(g):
+				diffs = append(diffs, fmt.Sprintf("MISSING %s recorded=%s", k, pktfmt.Result(w[i])))
+			case i >= len(w):
+				diffs = append(diffs, fmt.Sprintf("EXTRA %s replayed=%s", k, pktfmt.Result(g[i])))
+			case w[i] != g[i]:
+				diffs = append(diffs, fmt.Sprintf("DIFF %s recorded=%s replayed=%s", k, pktfmt.Result(w[i]), pktfmt.Result(g[i])))
+			}
+		}
+	}
+	return diffs
+}
+
+// load 读取录制文件，返回按连接打开时间排序的 session 以及录制的起始时间
+func load(r io.Reader) ([]*session, time.Time, error) {
+	byID := make(map[sessionKey]*session)
+	var base time.Time
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		rec := &Record{}
+		if err := dec.Decode(rec); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, base, err
+		}
+		if base.IsZero() || rec.Time.Before(base) {
+			base = rec.Time
+		}
+		key := sessionKey{run: rec.Run, id: rec.Conn}
+		s, ok := byID[key]
+		if !ok {
+			s = &session{run: rec.Run, id: rec.Conn, open: rec.Time, expect: make(acks)}
+			byID[key] = s
+		}
+		switch {
+		case rec.Event == EventOpen:
+			s.open = rec.Time
+		case rec.Dir == DirC2S:
+			s.sends = append(s.sends, rec)
+		case rec.Dir == DirS2C:
+			if k, result, ok := ackKey(rec.Frame); ok {
+				s.expect[k] = append(s.expect[k], result)
+			}
+		}
+	}
+	sessions := make([]*session, 0, len(byID))
+	for _, s := range byID {
+		sessions = append(sessions, s)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].open.Before(sessions[j].open) })
+	return sessions, base, nil
+}
+
+// withoutOptions 去掉 Con 中类型为 types 的选项，不是 Con 或没有这些选项时原样返回
+func withoutOptions(payload []byte, types ...uint8) []byte {
+	p, err := packet.Decode(payload)
+	if err != nil {
+		return payload
+	}
+	con, ok := p.(*packet.Con)
+	if !ok {
+		return payload
+	}
+	drop := func(t uint8) bool {
+		for _, d := range types {
+			if t == d {
+				return true
+			}
+		}
+		return false
+	}
+	opts := make([]packet.Option, 0, len(con.Options))
+	for _, o := range con.Options {
+		if !drop(o.Type) {
+			opts = append(opts, o)
+		}
+	}
+	if len(opts) == len(con.Options) {
+		return payload
+	}
+	con.Options = opts
+	if b, err := packet.Encode(con); err == nil {
+		return b
+	}
+	return payload
+}
+
+// replayFrame 回放时发送的 frame，去掉 Con 中的 OptionEncryption 与 OptionChecksum
+// 加密的 frame 没有录制，密钥也无法重现，回放时不协商加密；
+// 凭证用服务端静态公钥加密过时无法还原，开启鉴权的服务端会拒绝回放的连接
+// 回放按录制的 payload 发送，不带校验和，协商了校验和的服务端会拒绝，因此也不协商校验和
+func replayFrame(payload []byte) []byte {
+	return withoutOptions(payload, packet.OptionEncryption, packet.OptionChecksum)
+}
+
+// player 回放的参数
+type player struct {
+	addr      string
+	tlsConfig *tls.Config
+	format    frame.Format // 发送使用的帧格式
+	speed     float64
+	wait      time.Duration
+	verbose   bool
+	start     time.Time // 回放开始的时间，对应录制的 base
+	base      time.Time
+
+	mu sync.Mutex // 保护输出
+}
+
+// at 录制中时间 t 对应的回放时间
+func (p *player) at(t time.Time) time.Time {
+	if p.speed <= 0 {
+		return p.start
+	}
+	return p.start.Add(time.Duration(float64(t.Sub(p.base)) / p.speed))
+}
+
+func (p *player) printf(format string, args ...interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Printf(format+"\n", args...)
+}
+
+// play 回放一个连接，返回回放得到的 ack 与差异
+func (p *player) play(s *session) (acks, []string, error) {
+	time.Sleep(time.Until(p.at(s.open)))
+	conn, err := transport.DialTLS(p.addr, p.tlsConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+
+	var (
+		mu     sync.Mutex
+		got    = make(acks)
+		notify = make(chan struct{}, 1)
+		done   = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		// 录制的 Con 中可能协商了压缩，校验和不会协商，见 replayFrame
+		codec := compression.NewCodec()
+		codec.Frame = frame.NewAutoCodec()
+		r := bufio.NewReader(conn)
+		for {
+			payload, err := codec.Decode(r)
+			if err != nil {
+				return
+			}
+			if p.verbose {
+				p.printf("%s s2c %s", s.name(), describe(payload))
+			}
+			k, result, ok := ackKey(payload)
+			if !ok {
+				continue
+			}
+			mu.Lock()
+			got[k] = append(got[k], result)
+			mu.Unlock()
+			select {
+			case notify <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	codec := frame.NewFormatCodec(p.format)
+	w := bufio.NewWriter(conn)
+	for _, rec := range s.sends {
+		if d := time.Until(p.at(rec.Time)); d > 0 {
+			// 等待之前先把已写入的 frame 发出去
+			w.Flush()
+			time.Sleep(d)
+		}
+		if p.verbose {
+			p.printf("%s c2s %s", s.name(), describe(rec.Frame))
+		}
+		if err = codec.Encode(w, replayFrame(rec.Frame)); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return nil, nil, err
+	}
+
+	// 等待录制中的 ack 全部收到，或超时
+	timer := time.NewTimer(p.wait)
+	defer timer.Stop()
+wait:
+	for {
+		mu.Lock()
+		complete := got.covers(s.expect)
+		mu.Unlock()
+		if complete {
+			break
+		}
+		select {
+		case <-notify:
+		case <-done:
+			break wait
+		case <-timer.C:
+			break wait
+		}
+	}
+	conn.Close()
+	<-done
+	return got, diff(s.expect, got), nil
+}
+
+func replay(args []string) error {
+	fs := flag.NewFlagSet("tcpproxy replay", flag.ExitOnError)
+	in := fs.String("in", "session.jsonl", "录制文件")
+	addr := fs.String("addr", ":8888", "服务端地址")
+	speed := fs.Float64("speed", 1, "回放速度的倍数，1 为原速，0 表示不等待尽快发送")
+	wait := fs.Duration("wait", 2*time.Second, "发送完后等待 ack 的最长时间")
+	verbose := fs.Bool("v", false, "显示回放中收发的每个 packet")
+	insecure := fs.Bool("insecure", false, "tls:// 地址不校验服务端证书")
+	frameFormat := fs.String("frame-format", "legacy", "发送使用的帧格式: legacy | header | varint")
+	fs.Parse(args)
+	format, err := frame.ParseFormat(*frameFormat)
+	if err != nil || format == frame.FormatAuto || format.Text() {
+		return fmt.Errorf("invalid frame format [%s]", *frameFormat)
+	}
+
+	f, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	sessions, base, err := load(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", *in, err)
+	}
+
+	p := &player{addr: *addr, format: format, speed: *speed, wait: *wait, verbose: *verbose, start: time.Now(), base: base}
+	if *insecure {
+		p.tlsConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	type result struct {
+		s     *session
+		got   acks
+		diffs []string
+		err   error
+	}
+	results := make([]result, len(sessions))
+	var wg sync.WaitGroup
+	for i, s := range sessions {
+		wg.Add(1)
+		go func(i int, s *session) {
+			defer wg.Done()
+			got, diffs, err := p.play(s)
+			results[i] = result{s: s, got: got, diffs: diffs, err: err}
+		}(i, s)
+	}
+	wg.Wait()
+
+	var sent, total int
+	for _, r := range results {
+		if r.err != nil {
+			fmt.Printf("%s: %v\n", r.s.name(), r.err)
+			total++
+			continue
+		}
+		sent += len(r.s.sends)
+		for _, d := range r.diffs {
+			fmt.Printf("%s: %s\n", r.s.name(), d)
+		}
+		total += len(r.diffs)
+		fmt.Printf("%s: sent %d frames, acks recorded %d, replayed %d, %d differences\n",
+			r.s.name(), len(r.s.sends), r.s.expect.count(), r.got.count(), len(r.diffs))
+	}
+	fmt.Printf("replayed %d conns, %d frames in %.2fs, %d differences\n", len(sessions), sent, time.Since(p.start).Seconds(), total)
+	if total > 0 {
+		return errors.New("replay differs from recording")
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CoderI421/tcp-service/packet"
+)
+
+// encodeFrame 编码 packet 为 frame payload
+func encodeFrame(t *testing.T, p packet.Packet) []byte {
+	t.Helper()
+	b, err := packet.Encode(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestLoad(t *testing.T) {
+	base := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	at := func(s int) time.Time { return base.Add(time.Duration(s) * time.Second) }
+	submit := encodeFrame(t, &packet.Submit{ID: "00000001", Payload: []byte("a")})
+	ok := encodeFrame(t, &packet.SubmitAck{ID: "00000001", Result: packet.ResultOK})
+	failed := encodeFrame(t, &packet.SubmitAck{ID: "00000001", Result: packet.ResultFailed})
+
+	// 同一个文件追加了两次录制，连接序号都从 1 开始
+	records := []*Record{
+		{Time: at(0), Run: "a", Conn: 1, Event: EventOpen},
+		{Time: at(1), Run: "a", Conn: 1, Dir: DirC2S, Frame: submit},
+		{Time: at(2), Run: "a", Conn: 1, Dir: DirS2C, Frame: ok},
+		{Time: at(3), Run: "a", Conn: 1, Event: EventClose},
+		{Time: at(10), Run: "b", Conn: 1, Event: EventOpen},
+		{Time: at(11), Run: "b", Conn: 1, Dir: DirC2S, Frame: submit},
+		{Time: at(12), Run: "b", Conn: 1, Dir: DirS2C, Frame: failed},
+		{Time: at(13), Run: "b", Conn: 2, Dir: DirC2S, Frame: submit}, // 没有 open 记录
+		{Time: at(14), Run: "b", Conn: 1, Dir: DirS2C, Frame: []byte{0xff}},
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sessions, start, err := load(&buf)
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	if !start.Equal(base) {
+		t.Errorf("load() start = %v, want %v", start, base)
+	}
+	type result struct {
+		name   string
+		open   time.Time
+		sends  int
+		expect acks
+	}
+	var got []result
+	for _, s := range sessions {
+		got = append(got, result{name: s.name(), open: s.open, sends: len(s.sends), expect: s.expect})
+	}
+	want := []result{
+		{name: "run a conn 1", open: at(0), sends: 1, expect: acks{"SubmitAck 00000001": {packet.ResultOK}}},
+		{name: "run b conn 1", open: at(10), sends: 1, expect: acks{"SubmitAck 00000001": {packet.ResultFailed}}},
+		{name: "run b conn 2", open: at(13), sends: 1, expect: acks{}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("load() = %+v, want %+v", got, want)
+	}
+
+	// 旧的录制文件没有批次
+	buf.Reset()
+	json.NewEncoder(&buf).Encode(&Record{Time: base, Conn: 3, Event: EventOpen})
+	if sessions, _, err = load(&buf); err != nil || len(sessions) != 1 || sessions[0].name() != "conn 3" {
+		t.Errorf("load() = %v, %v, want conn 3", sessions, err)
+	}
+
+	if _, _, err = load(bytes.NewBufferString("{bad")); err == nil {
+		t.Errorf("load() error = nil, want error")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		want acks
+		got  acks
+		diff []string
+	}{
+		{name: "Equal", want: acks{"SubmitAck 1": {0, 0}}, got: acks{"SubmitAck 1": {0, 0}}},
+		{name: "Empty"},
+		{name: "Missing", want: acks{"SubmitAck 1": {0, 0}}, got: acks{"SubmitAck 1": {0}},
+			diff: []string{"MISSING SubmitAck 1 recorded=ok"}},
+		{name: "Extra", want: acks{}, got: acks{"ConAck 1": {packet.ResultRefused}},
+			diff: []string{"EXTRA ConAck 1 replayed=refused"}},
+		{name: "Differs", want: acks{"SubmitAck 1": {0, packet.ResultOK}}, got: acks{"SubmitAck 1": {0, packet.ResultThrottled}},
+			diff: []string{"DIFF SubmitAck 1 recorded=ok replayed=throttled"}},
+		{name: "Sorted",
+			want: acks{"SubmitAck 2": {0}, "ConAck 1": {0}},
+			got:  acks{},
+			diff: []string{"MISSING ConAck 1 recorded=ok", "MISSING SubmitAck 2 recorded=ok"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diff(tt.want, tt.got); !reflect.DeepEqual(got, tt.diff) {
+				t.Errorf("diff() = %q, want %q", got, tt.diff)
+			}
+		})
+	}
+}
+
+func TestReplayFrame(t *testing.T) {
+	compressionOpt := packet.Option{Type: packet.OptionCompression, Value: []byte{1}}
+	tests := []struct {
+		name string
+		p    packet.Packet
+		want packet.Packet
+	}{
+		{
+			name: "Con",
+			p: &packet.Con{ID: "00000001", Options: []packet.Option{
+				compressionOpt,
+				{Type: packet.OptionChecksum, Value: []byte{2}},
+				{Type: packet.OptionEncryption, Value: make([]byte, 33)},
+			}},
+			want: &packet.Con{ID: "00000001", Options: []packet.Option{compressionOpt}},
+		},
+		{name: "ConWithoutOptions", p: &packet.Con{ID: "00000001"}, want: &packet.Con{ID: "00000001"}},
+		{name: "Submit", p: &packet.Submit{ID: "00000001", Payload: []byte("a")}, want: &packet.Submit{ID: "00000001", Payload: []byte("a")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := replayFrame(encodeFrame(t, tt.p))
+			if want := encodeFrame(t, tt.want); !bytes.Equal(got, want) {
+				t.Errorf("replayFrame() = %x, want %x", got, want)
+			}
+		})
+	}
+	if got := replayFrame([]byte{0xff}); !bytes.Equal(got, []byte{0xff}) {
+		t.Errorf("replayFrame() = %x, want unchanged", got)
+	}
+}
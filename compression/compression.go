@@ -0,0 +1,328 @@
+package compression
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/golang/snappy"
+
+	"github.com/CoderI421/tcp-service/frame"
+)
+
+/*
+按连接协商的 payload 压缩
+
+客户端在 Con 的 OptionCompression 中按优先级列出支持的算法，服务端选出双方都支持的第一个，
+在 ConAck 的 OptionCompression 中返回。之后发送方对不小于阈值的 frame 压缩，压缩后不变小的原样发送
+
+压缩的 frame 带有 frame.FlagCompressed（旧格式中为长度头的最高位），payload 的第一个字节为算法：
+
+	frameHeader（flags 含 FlagCompressed）
+	1 byte:  algorithm
+	压缩后的 packet
+
+frame 自带算法，接收方无需知道协商的结果，只要支持该算法即可解压
+因此发起协商的一方从发出 Con 起就能解压对方的 frame，不需要与对方切换的时机对齐
+*/
+
+// Algorithm 压缩算法
+type Algorithm uint8
+
+const (
+	None    Algorithm = iota // 不压缩
+	Gzip                     // gzip
+	Deflate                  // deflate，无 gzip 头
+	Snappy                   // snappy，速度快、压缩率较低
+)
+
+const (
+	// DefaultThreshold 默认的压缩阈值，更小的 frame 压缩收益不大
+	DefaultThreshold = 256
+	// DefaultMaxSize 默认的解压后最大长度
+	DefaultMaxSize = 16 << 20
+)
+
+var (
+	// ErrUnsupported 不支持的压缩算法
+	ErrUnsupported = errors.New("compression: unsupported algorithm")
+	// ErrTooLarge 解压后超过最大长度
+	ErrTooLarge = errors.New("compression: decompressed frame too large")
+)
+
+var names = map[Algorithm]string{
+	None:    "none",
+	Gzip:    "gzip",
+	Deflate: "deflate",
+	Snappy:  "snappy",
+}
+
+func (a Algorithm) String() string {
+	if s, ok := names[a]; ok {
+		return s
+	}
+	return fmt.Sprintf("algorithm(%d)", uint8(a))
+}
+
+// Supported 是否支持该算法，None 不算
+func (a Algorithm) Supported() bool {
+	_, ok := names[a]
+	return ok && a != None
+}
+
+// Parse 按名称解析压缩算法
+func Parse(s string) (Algorithm, error) {
+	for a, name := range names {
+		if strings.EqualFold(s, name) {
+			return a, nil
+		}
+	}
+	return None, fmt.Errorf("%w [%s]", ErrUnsupported, s)
+}
+
+// ParseList 解析以逗号分隔的算法列表，空字符串返回 nil
+func ParseList(s string) ([]Algorithm, error) {
+	var algs []Algorithm
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		a, err := Parse(name)
+		if err != nil {
+			return nil, err
+		}
+		if a != None {
+			algs = append(algs, a)
+		}
+	}
+	return algs, nil
+}
+
+// Encode 编码为 OptionCompression 的 value
+func Encode(algs []Algorithm) []byte {
+	b := make([]byte, len(algs))
+	for i, a := range algs {
+		b[i] = byte(a)
+	}
+	return b
+}
+
+// Decode 解码 OptionCompression 的 value
+func Decode(b []byte) []Algorithm {
+	algs := make([]Algorithm, len(b))
+	for i, v := range b {
+		algs[i] = Algorithm(v)
+	}
+	return algs
+}
+
+// Negotiate 按客户端的优先级选出服务端也支持的第一个算法，没有时返回 None
+func Negotiate(offered, supported []Algorithm) Algorithm {
+	for _, a := range offered {
+		if !a.Supported() {
+			continue
+		}
+		for _, s := range supported {
+			if a == s {
+				return a
+			}
+		}
+	}
+	return None
+}
+
+// Codec 支持压缩的 frame 编解码器，实现 frame.FlagCodec
+// 发送使用的算法可在运行时切换，解码时按 frame 中的标记与算法解压，读写可在不同协程并发进行
+type Codec struct {
+	// Frame 底层的帧格式，nil 表示旧格式 frame.Codec
+	Frame frame.FlagCodec
+	// Threshold 不小于该长度的 payload 才压缩，0 表示使用默认值
+	Threshold int
+	// MaxSize 解压后的最大长度，0 表示使用默认值
+	MaxSize int
+	// Observe 每压缩或解压一个 frame 调用一次，out 为 true 表示发送方向，raw 与 wire 为压缩前后的长度
+	Observe func(alg Algorithm, out bool, raw, wire int)
+
+	alg uint32 // 原子操作，发送使用的算法
+}
+
+// NewCodec 创建编解码器，发送方向默认不压缩
+func NewCodec() *Codec {
+	return &Codec{}
+}
+
+// SetAlgorithm 切换发送使用的算法，None 表示不压缩
+func (c *Codec) SetAlgorithm(a Algorithm) {
+	atomic.StoreUint32(&c.alg, uint32(a))
+}
+
+// Algorithm 返回发送使用的算法
+func (c *Codec) Algorithm() Algorithm {
+	return Algorithm(atomic.LoadUint32(&c.alg))
+}
+
+func (c *Codec) base() frame.FlagCodec {
+	if c.Frame != nil {
+		return c.Frame
+	}
+	return legacy
+}
+
+var legacy = &frame.Codec{}
+
+func (c *Codec) threshold() int {
+	if c.Threshold > 0 {
+		return c.Threshold
+	}
+	return DefaultThreshold
+}
+
+func (c *Codec) maxSize() int {
+	if c.MaxSize > 0 {
+		return c.MaxSize
+	}
+	return DefaultMaxSize
+}
+
+// Encode 编码一个 frame，payload 不小于阈值且压缩后更小时压缩
+func (c *Codec) Encode(w io.Writer, framePayload frame.Payload) error {
+	return c.EncodeFlags(w, framePayload, 0)
+}
+
+// EncodeFlags 同 Encode，压缩时在 flags 中加上 frame.FlagCompressed
+func (c *Codec) EncodeFlags(w io.Writer, framePayload frame.Payload, flags frame.Flags) error {
+	alg := c.Algorithm()
+	if alg != None && len(framePayload) >= c.threshold() {
+		compressed, err := compress(alg, framePayload)
+		if err != nil {
+			return err
+		}
+		if len(compressed)+1 < len(framePayload) {
+			if c.Observe != nil {
+				c.Observe(alg, true, len(framePayload), len(compressed)+1)
+			}
+			return c.base().EncodeFlags(w, append([]byte{byte(alg)}, compressed...), flags|frame.FlagCompressed)
+		}
+	}
+	return c.base().EncodeFlags(w, framePayload, flags)
+}
+
+// Decode 解码一个 frame，压缩的 frame 返回解压后的 payload
+// 加密或带校验和的 frame 返回 frame.ErrUnsupportedFlags，校验和由 Frame（如 checksum.Codec）处理
+func (c *Codec) Decode(r io.Reader) (frame.Payload, error) {
+	p, flags, err := c.DecodeFlags(r)
+	if err != nil {
+		return nil, err
+	}
+	if flags&(frame.FlagEncrypted|frame.FlagChecksum) != 0 {
+		return nil, frame.ErrUnsupportedFlags
+	}
+	return p, nil
+}
+
+// DecodeFlags 同 Decode，返回的 flags 不含 frame.FlagCompressed
+// 加密的 frame 先压缩后加密，Frame 没有解密时原样返回，flags 不变
+func (c *Codec) DecodeFlags(r io.Reader) (frame.Payload, frame.Flags, error) {
+	buf, flags, err := c.base().DecodeFlags(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	if flags&frame.FlagCompressed == 0 || flags&frame.FlagEncrypted != 0 {
+		return buf, flags, nil
+	}
+	if len(buf) == 0 {
+		return nil, 0, frame.ErrInvalidLength
+	}
+	alg := Algorithm(buf[0])
+	p, err := decompress(alg, buf[1:], c.maxSize())
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.Observe != nil {
+		c.Observe(alg, false, len(p), len(buf))
+	}
+	return p, flags &^ frame.FlagCompressed, nil
+}
+
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+func compress(alg Algorithm, p []byte) ([]byte, error) {
+	if alg == Snappy {
+		return snappy.Encode(nil, p), nil
+	}
+
+	buf := bufPool.Get().(*bytes.Buffer)
+	defer bufPool.Put(buf)
+	buf.Reset()
+
+	var (
+		w   io.WriteCloser
+		err error
+	)
+	switch alg {
+	case Gzip:
+		w = gzip.NewWriter(buf)
+	case Deflate:
+		w, err = flate.NewWriter(buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w [%d]", ErrUnsupported, alg)
+	}
+	if _, err = w.Write(p); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), buf.Bytes()...), nil
+}
+
+func decompress(alg Algorithm, p []byte, maxSize int) ([]byte, error) {
+	var r io.Reader
+	switch alg {
+	case Snappy:
+		n, err := snappy.DecodedLen(p)
+		if err != nil {
+			return nil, err
+		}
+		if n > maxSize {
+			return nil, ErrTooLarge
+		}
+		return snappy.Decode(nil, p)
+	case Gzip:
+		zr, err := gzip.NewReader(bytes.NewReader(p))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	case Deflate:
+		fr := flate.NewReader(bytes.NewReader(p))
+		defer fr.Close()
+		r = fr
+	default:
+		return nil, fmt.Errorf("%w [%d]", ErrUnsupported, alg)
+	}
+	// 多读一个字节判断是否超过最大长度，防止压缩炸弹
+	out, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxSize {
+		return nil, ErrTooLarge
+	}
+	return out, nil
+}
@@ -0,0 +1,181 @@
+package compression
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/CoderI421/tcp-service/frame"
+)
+
+func TestCodec(t *testing.T) {
+	large := bytes.Repeat([]byte("hello tcp-service "), 64)
+	tests := []struct {
+		name           string
+		alg            Algorithm
+		payload        []byte
+		wantCompressed bool
+	}{
+		{name: "None", alg: None, payload: large, wantCompressed: false},
+		{name: "Gzip", alg: Gzip, payload: large, wantCompressed: true},
+		{name: "Deflate", alg: Deflate, payload: large, wantCompressed: true},
+		{name: "Snappy", alg: Snappy, payload: large, wantCompressed: true},
+		{name: "BelowThreshold", alg: Snappy, payload: []byte("hello"), wantCompressed: false},
+		{name: "Incompressible", alg: Gzip, payload: incompressible(512), wantCompressed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var observed int
+			c := NewCodec()
+			c.SetAlgorithm(tt.alg)
+			c.Observe = func(alg Algorithm, out bool, raw, wire int) {
+				observed++
+				if alg != tt.alg || raw != len(tt.payload) || wire >= raw {
+					t.Errorf("Observe() alg = %v, raw = %d, wire = %d", alg, raw, wire)
+				}
+			}
+
+			var buf bytes.Buffer
+			if err := c.Encode(&buf, tt.payload); err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			header := binary.BigEndian.Uint32(buf.Bytes())
+			if got := header&frame.LegacyCompressedBit != 0; got != tt.wantCompressed {
+				t.Errorf("Encode() compressed = %v, want %v", got, tt.wantCompressed)
+			}
+			if int(header&^frame.LegacyCompressedBit) != buf.Len() {
+				t.Errorf("Encode() length = %d, want %d", header&^frame.LegacyCompressedBit, buf.Len())
+			}
+
+			// 解码方不需要设置算法
+			got, err := NewCodec().Decode(&buf)
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !bytes.Equal(got, tt.payload) {
+				t.Errorf("Decode() got %d bytes, want %d", len(got), len(tt.payload))
+			}
+			want := 0
+			if tt.wantCompressed {
+				want = 1
+			}
+			if observed != want {
+				t.Errorf("Observe() called %d times, want %d", observed, want)
+			}
+		})
+	}
+}
+
+// incompressible 生成压缩后不会变小的数据
+func incompressible(n int) []byte {
+	b := make([]byte, n)
+	x := uint32(2463534242)
+	for i := range b {
+		x ^= x << 13
+		x ^= x >> 17
+		x ^= x << 5
+		b[i] = byte(x)
+	}
+	return b
+}
+
+func TestCodec_PlainFrame(t *testing.T) {
+	// 未压缩的 frame 与 frame.Codec 互通
+	var buf bytes.Buffer
+	if err := frame.NewCodec().Encode(&buf, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewCodec().Decode(&buf)
+	if err != nil || string(got) != "hello" {
+		t.Errorf("Decode() = %q, %v", got, err)
+	}
+}
+
+func TestCodec_Decode(t *testing.T) {
+	for _, alg := range []Algorithm{Gzip, Deflate, Snappy} {
+		t.Run(alg.String()+"TooLarge", func(t *testing.T) {
+			enc := NewCodec()
+			enc.SetAlgorithm(alg)
+			var buf bytes.Buffer
+			if err := enc.Encode(&buf, make([]byte, 4096)); err != nil {
+				t.Fatal(err)
+			}
+			dec := NewCodec()
+			dec.MaxSize = 1024
+			if _, err := dec.Decode(&buf); !errors.Is(err, ErrTooLarge) {
+				t.Errorf("Decode() error = %v, want %v", err, ErrTooLarge)
+			}
+		})
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{name: "Unsupported", data: []byte{0x80, 0, 0, 7, 9, 1, 2}, wantErr: ErrUnsupported},
+		{name: "EmptyCompressed", data: []byte{0x80, 0, 0, 4}, wantErr: frame.ErrInvalidLength},
+		{name: "InvalidLength", data: []byte{0, 0, 0, 3}, wantErr: frame.ErrInvalidLength},
+		{name: "Encrypted", data: []byte{frame.HeaderMagic, frame.HeaderVersion, 0, byte(frame.FlagCompressed | frame.FlagEncrypted), 0, 0, 0, 1, 9}, wantErr: frame.ErrUnsupportedFlags},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dec := NewCodec()
+			if tt.data[0] == frame.HeaderMagic {
+				dec.Frame = frame.NewHeaderCodec()
+			}
+			if _, err := dec.Decode(bytes.NewReader(tt.data)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Decode() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name      string
+		offered   []Algorithm
+		supported []Algorithm
+		want      Algorithm
+	}{
+		{name: "ClientPreference", offered: []Algorithm{Snappy, Gzip}, supported: []Algorithm{Gzip, Snappy}, want: Snappy},
+		{name: "Common", offered: []Algorithm{Deflate, Gzip}, supported: []Algorithm{Gzip}, want: Gzip},
+		{name: "NoCommon", offered: []Algorithm{Deflate}, supported: []Algorithm{Snappy}, want: None},
+		{name: "Unknown", offered: []Algorithm{Algorithm(9), None}, supported: []Algorithm{Algorithm(9), None}, want: None},
+		{name: "Empty", offered: nil, supported: []Algorithm{Gzip}, want: None},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Negotiate(tt.offered, tt.supported); got != tt.want {
+				t.Errorf("Negotiate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseList(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []Algorithm
+		wantErr bool
+	}{
+		{name: "Empty", s: "", want: nil},
+		{name: "List", s: "snappy, GZIP,deflate", want: []Algorithm{Snappy, Gzip, Deflate}},
+		{name: "None", s: "none", want: nil},
+		{name: "Unknown", s: "zstd", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseList(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseList() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,472 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/CoderI421/tcp-service/checksum"
+	"github.com/CoderI421/tcp-service/compression"
+	"github.com/CoderI421/tcp-service/connlimit"
+	"github.com/CoderI421/tcp-service/encryption"
+	"github.com/CoderI421/tcp-service/frame"
+	"github.com/CoderI421/tcp-service/limiter"
+	"github.com/CoderI421/tcp-service/logger"
+	"github.com/CoderI421/tcp-service/metrics"
+	"github.com/CoderI421/tcp-service/pubsub"
+	"github.com/CoderI421/tcp-service/server"
+	"github.com/CoderI421/tcp-service/transport"
+)
+
+/*
+服务端配置
+
+来源优先级从低到高：默认值 < 配置文件(yaml) < 环境变量 < 命令行参数
+环境变量与命令行参数一一对应，如 -conn-rate 对应 TCP_SERVICE_CONN_RATE
+*/
+
+// Config 服务端配置
+type Config struct {
+	Listen      []string          `yaml:"listen"` // 监听地址列表，格式见 transport 包
+	WS          WSConfig          `yaml:"ws"`
+	Admin       AddrConfig        `yaml:"admin"`
+	Pprof       AddrConfig        `yaml:"pprof"`
+	Metrics     AddrConfig        `yaml:"metrics"`
+	TLS         TLSConfig         `yaml:"tls"`
+	Limits      LimitsConfig      `yaml:"limits"`
+	Timeouts    TimeoutsConfig    `yaml:"timeouts"`
+	Frame       FrameConfig       `yaml:"frame"`
+	Compression CompressionConfig `yaml:"compression"`
+	Checksum    ChecksumConfig    `yaml:"checksum"`
+	Encryption  EncryptionConfig  `yaml:"encryption"`
+	Auth        AuthConfig        `yaml:"auth"`
+	Log         LogConfig         `yaml:"log"`
+}
+
+// AddrConfig 辅助 http 服务的监听地址，为空表示不启用
+type AddrConfig struct {
+	Addr string `yaml:"addr"`
+}
+
+// WSConfig WebSocket 网关
+type WSConfig struct {
+	Addr string `yaml:"addr"` // 为空表示不启用
+	Path string `yaml:"path"`
+	TLS  bool   `yaml:"tls"` // 使用 TLSConfig 提供 wss
+}
+
+// TLSConfig tls:// 地址以及 wss 使用的证书
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"` // 非空时要求并校验客户端证书
+}
+
+// Bucket 令牌桶，Rate 为 0 表示不限
+type Bucket struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// LimitsConfig 限流、连接准入以及会话相关的限制
+type LimitsConfig struct {
+	Conn         Bucket `yaml:"conn"`
+	Client       Bucket `yaml:"client"`
+	Global       Bucket `yaml:"global"`
+	ThrottleMode string `yaml:"throttle_mode"` // reject | delay
+
+	MaxConns      int      `yaml:"max_conns"`
+	MaxConnsPerIP int      `yaml:"max_conns_per_ip"`
+	Allow         []string `yaml:"allow"`
+	Deny          []string `yaml:"deny"`
+
+	SubQueueSize   int    `yaml:"sub_queue_size"`
+	SlowConsumer   string `yaml:"slow_consumer"`   // drop-oldest | disconnect
+	DuplicateLogin string `yaml:"duplicate_login"` // kick-old | reject-new
+}
+
+// TimeoutsConfig 超时设置，0 表示不限
+type TimeoutsConfig struct {
+	Handshake time.Duration `yaml:"handshake"`
+	Idle      time.Duration `yaml:"idle"`
+	Write     time.Duration `yaml:"write"`
+	Shutdown  time.Duration `yaml:"shutdown"` // drain 后等待已有连接断开的时限
+}
+
+// FrameConfig 帧格式
+type FrameConfig struct {
+	Format    string `yaml:"format"`     // auto | legacy | header | varint | line | delimiter
+	Delimiter string `yaml:"delimiter"`  // delimiter 格式的分隔符，单个字节，可写作 \n、\x00 等转义形式
+	Escape    string `yaml:"escape"`     // delimiter 格式的转义字符，为空表示不转义
+	MaxLength int    `yaml:"max_length"` // frame payload 的最大长度，所有帧格式与 WebSocket 消息共用，0 表示默认的 16MiB，不超过 frame.MaxLegacyLength
+}
+
+// DelimiterCodec 按分隔符与转义字符创建 delimiter 格式的编解码器
+func (c *FrameConfig) DelimiterCodec() (*frame.DelimiterCodec, error) {
+	delim, err := parseByte(c.Delimiter)
+	if err != nil {
+		return nil, fmt.Errorf("delimiter: %w", err)
+	}
+	codec := frame.NewDelimiterCodec(delim, 0)
+	if c.Escape == "" {
+		return codec, nil
+	}
+	if codec.Escape, err = parseByte(c.Escape); err != nil {
+		return nil, fmt.Errorf("escape: %w", err)
+	}
+	if codec.Escape == codec.Delimiter {
+		return nil, errors.New("escape: must differ from delimiter")
+	}
+	return codec, nil
+}
+
+// parseByte 解析单个字节，多于一个字符时按 Go 字符串的转义规则解析
+func parseByte(s string) (byte, error) {
+	if len(s) != 1 {
+		u, err := strconv.Unquote(`"` + s + `"`)
+		if err != nil || len(u) != 1 {
+			return 0, fmt.Errorf("[%s] is not a single byte", s)
+		}
+		s = u
+	}
+	return s[0], nil
+}
+
+// CompressionConfig 按连接协商的 payload 压缩，Algorithms 为空表示不压缩
+type CompressionConfig struct {
+	Algorithms []string `yaml:"algorithms"` // 支持的算法: gzip | deflate | snappy
+	Threshold  int      `yaml:"threshold"`  // 不小于该长度的 payload 才压缩
+}
+
+// Parse 解析算法列表
+func (c *CompressionConfig) Parse() ([]compression.Algorithm, error) {
+	return compression.ParseList(strings.Join(c.Algorithms, ","))
+}
+
+// ChecksumConfig 按连接协商的 frame 校验和，Algorithms 为空表示不校验
+type ChecksumConfig struct {
+	Algorithms []string `yaml:"algorithms"` // 支持的算法: crc32 | crc32c | xxhash
+}
+
+// Parse 解析算法列表
+func (c *ChecksumConfig) Parse() ([]checksum.Algorithm, error) {
+	return checksum.ParseList(strings.Join(c.Algorithms, ","))
+}
+
+// EncryptionConfig 按连接协商的端到端 payload 加密，Ciphers 为空表示不加密
+type EncryptionConfig struct {
+	Ciphers []string `yaml:"ciphers"` // 支持的算法: aes-gcm | chacha20-poly1305
+	// KeyFile 服务端 X25519 静态私钥文件，内容为 64 个十六进制字符，客户端固定对应的公钥以认证服务端
+	KeyFile string `yaml:"key_file"`
+	// Required 未协商出加密的连接握手失败，WebSocket 与非 header 帧格式的客户端都无法连接
+	Required bool `yaml:"required"`
+}
+
+// Parse 解析算法列表
+func (c *EncryptionConfig) Parse() ([]encryption.Cipher, error) {
+	return encryption.ParseList(strings.Join(c.Ciphers, ","))
+}
+
+// LoadKey 读取静态私钥，未配置时返回 nil
+func (c *EncryptionConfig) LoadKey() ([]byte, error) {
+	if c.KeyFile == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("config: load encryption key: %w", err)
+	}
+	key, err := encryption.ParseKey(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("config: load encryption key %s: %w", c.KeyFile, err)
+	}
+	return key, nil
+}
+
+// AuthConfig Con 握手鉴权，Credentials 为空表示不鉴权
+type AuthConfig struct {
+	Credentials map[string]string `yaml:"credentials"` // 客户端标识 -> 密钥
+}
+
+// LogConfig 日志输出
+type LogConfig struct {
+	Level  string `yaml:"level"`
+	Output string `yaml:"output"` // stderr | stdout | 文件路径
+}
+
+// Default 返回默认配置，与原先命令行参数的默认值一致
+func Default() *Config {
+	return &Config{
+		Listen:  []string{":8888"},
+		WS:      WSConfig{Path: "/ws"},
+		Admin:   AddrConfig{Addr: "127.0.0.1:8891"},
+		Pprof:   AddrConfig{Addr: ":6060"},
+		Metrics: AddrConfig{Addr: metrics.DefaultAddr},
+		Limits: LimitsConfig{
+			Conn:           Bucket{Burst: 1},
+			Client:         Bucket{Burst: 1},
+			Global:         Bucket{Burst: 1},
+			ThrottleMode:   "reject",
+			SubQueueSize:   256,
+			SlowConsumer:   "drop-oldest",
+			DuplicateLogin: "kick-old",
+		},
+		Timeouts:    TimeoutsConfig{Shutdown: 30 * time.Second},
+		Frame:       FrameConfig{Format: "auto", Delimiter: "\n"},
+		Compression: CompressionConfig{Threshold: compression.DefaultThreshold},
+		Log:         LogConfig{Level: "info", Output: "stderr"},
+	}
+}
+
+// ValidationError 配置校验错误，包含所有不合法的配置项
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "config: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate 校验配置，返回 *ValidationError
+func (c *Config) Validate() error {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if len(c.Listen) == 0 {
+		addf("listen: at least one address required")
+	}
+	for _, addr := range c.Listen {
+		scheme, _, err := transport.ParseAddr(addr)
+		if err != nil {
+			addf("listen: %v", err)
+		} else if scheme == transport.SchemeTLS && !c.TLS.Enabled() {
+			addf("listen: %s requires tls.cert_file and tls.key_file", addr)
+		}
+	}
+	if c.WS.Addr != "" && !strings.HasPrefix(c.WS.Path, "/") {
+		addf("ws.path: must start with /")
+	}
+	if c.WS.Addr != "" && c.WS.TLS && !c.TLS.Enabled() {
+		addf("ws.tls: requires tls.cert_file and tls.key_file")
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		addf("tls: cert_file and key_file must be set together")
+	}
+	if c.TLS.ClientCAFile != "" && !c.TLS.Enabled() {
+		addf("tls.client_ca_file: requires tls.cert_file and tls.key_file")
+	}
+
+	l := &c.Limits
+	for _, b := range []struct {
+		name string
+		Bucket
+	}{{"conn", l.Conn}, {"client", l.Client}, {"global", l.Global}} {
+		if b.Rate < 0 {
+			addf("limits.%s.rate: must be >= 0", b.name)
+		}
+		if b.Rate > 0 && b.Burst < 1 {
+			addf("limits.%s.burst: must be >= 1", b.name)
+		}
+	}
+	if _, err := l.mode(); err != nil {
+		addf("limits.throttle_mode: %v", err)
+	}
+	if l.MaxConns < 0 || l.MaxConnsPerIP < 0 {
+		addf("limits: max_conns and max_conns_per_ip must be >= 0")
+	}
+	if _, err := connlimit.ParseCIDRs(l.Allow); err != nil {
+		addf("limits.allow: %v", err)
+	}
+	if _, err := connlimit.ParseCIDRs(l.Deny); err != nil {
+		addf("limits.deny: %v", err)
+	}
+	if l.SubQueueSize < 1 {
+		addf("limits.sub_queue_size: must be >= 1")
+	}
+	if _, err := l.SlowPolicy(); err != nil {
+		addf("limits.slow_consumer: %v", err)
+	}
+	if _, err := l.DuplicatePolicy(); err != nil {
+		addf("limits.duplicate_login: %v", err)
+	}
+
+	t := &c.Timeouts
+	if t.Handshake < 0 || t.Idle < 0 || t.Write < 0 || t.Shutdown < 0 {
+		addf("timeouts: must be >= 0")
+	}
+	if _, err := frame.ParseFormat(c.Frame.Format); err != nil {
+		addf("frame.format: %v", err)
+	}
+	if _, err := c.Frame.DelimiterCodec(); err != nil {
+		addf("frame.%v", err)
+	}
+	if c.Frame.MaxLength < 0 || c.Frame.MaxLength > frame.MaxLegacyLength {
+		addf("frame.max_length: must be between 0 and %d", frame.MaxLegacyLength)
+	}
+	if _, err := c.Compression.Parse(); err != nil {
+		addf("compression.algorithms: %v", err)
+	}
+	if c.Compression.Threshold < 0 {
+		addf("compression.threshold: must be >= 0")
+	}
+	if _, err := c.Checksum.Parse(); err != nil {
+		addf("checksum.algorithms: %v", err)
+	}
+	if _, err := c.Encryption.Parse(); err != nil {
+		addf("encryption.ciphers: %v", err)
+	}
+	if c.Encryption.KeyFile != "" && len(c.Encryption.Ciphers) == 0 {
+		addf("encryption.key_file: requires encryption.ciphers")
+	}
+	if c.Encryption.Required && len(c.Encryption.Ciphers) == 0 {
+		addf("encryption.required: requires encryption.ciphers")
+	}
+	for id := range c.Auth.Credentials {
+		if len(id) != 8 {
+			addf("auth.credentials: client id [%s] must be 8 bytes", id)
+		}
+	}
+	if _, err := logger.ParseLevel(c.Log.Level); err != nil {
+		addf("log.level: %v", err)
+	}
+	if c.Log.Output == "" {
+		addf("log.output: must not be empty")
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// Enabled 是否配置了证书
+func (c *TLSConfig) Enabled() bool {
+	return c.CertFile != "" && c.KeyFile != ""
+}
+
+// Load 读取证书生成 tls.Config，未配置证书时返回 nil
+func (c *TLSConfig) Load() (*tls.Config, error) {
+	if !c.Enabled() {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("config: load tls cert: %w", err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if c.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("config: load tls client ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("config: load tls client ca: no certificate in %s", c.ClientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+func (l *LimitsConfig) mode() (limiter.Mode, error) {
+	switch l.ThrottleMode {
+	case "reject":
+		return limiter.ModeReject, nil
+	case "delay":
+		return limiter.ModeDelay, nil
+	default:
+		return 0, fmt.Errorf("unknown throttle mode [%s]", l.ThrottleMode)
+	}
+}
+
+// LimiterConfig 转换为 limiter.Config
+func (l *LimitsConfig) LimiterConfig() limiter.Config {
+	mode, _ := l.mode()
+	return limiter.Config{
+		Conn:   limiter.Bucket{Rate: l.Conn.Rate, Burst: l.Conn.Burst},
+		Client: limiter.Bucket{Rate: l.Client.Rate, Burst: l.Client.Burst},
+		Global: limiter.Bucket{Rate: l.Global.Rate, Burst: l.Global.Burst},
+		Mode:   mode,
+	}
+}
+
+// GateConfig 转换为 connlimit.Config
+func (l *LimitsConfig) GateConfig() connlimit.Config {
+	return connlimit.Config{
+		MaxConns:      l.MaxConns,
+		MaxConnsPerIP: l.MaxConnsPerIP,
+		Allow:         l.Allow,
+		Deny:          l.Deny,
+	}
+}
+
+// SlowPolicy 解析订阅者队列满时的处理方式
+func (l *LimitsConfig) SlowPolicy() (pubsub.SlowPolicy, error) {
+	switch l.SlowConsumer {
+	case "drop-oldest":
+		return pubsub.DropOldest, nil
+	case "disconnect":
+		return pubsub.Disconnect, nil
+	default:
+		return 0, fmt.Errorf("unknown slow consumer policy [%s]", l.SlowConsumer)
+	}
+}
+
+// DuplicatePolicy 解析重复登录的处理方式
+func (l *LimitsConfig) DuplicatePolicy() (server.DuplicatePolicy, error) {
+	switch l.DuplicateLogin {
+	case "kick-old":
+		return server.DuplicateKickOld, nil
+	case "reject-new":
+		return server.DuplicateRejectNew, nil
+	default:
+		return 0, fmt.Errorf("unknown duplicate login policy [%s]", l.DuplicateLogin)
+	}
+}
+
+// secretMask --print-config 时替换密钥
+const secretMask = "******"
+
+// WriteYAML 以 yaml 格式输出配置，密钥会被隐藏
+func (c *Config) WriteYAML(w io.Writer) error {
+	cp := *c
+	if len(c.Auth.Credentials) > 0 {
+		cp.Auth.Credentials = make(map[string]string, len(c.Auth.Credentials))
+		for id := range c.Auth.Credentials {
+			cp.Auth.Credentials[id] = secretMask
+		}
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&cp); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// ReadFile 读取 yaml 配置文件并覆盖 c 中对应的配置项，未知的配置项视为错误
+func (c *Config) ReadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err = dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config: parse %s: %w", path, err)
+	}
+	return nil
+}
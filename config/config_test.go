@@ -0,0 +1,222 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CoderI421/tcp-service/frame"
+	"github.com/CoderI421/tcp-service/internal/testcert"
+)
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write config error: %v", err)
+	}
+	return path
+}
+
+func env(m map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := m[key]
+		return v, ok
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeFile(t, `
+listen: ["tcp://:9001", "unix:///tmp/a.sock"]
+limits:
+  conn: {rate: 10, burst: 5}
+  throttle_mode: delay
+timeouts:
+  idle: 1m
+auth:
+  credentials:
+    "00000001": secret
+log:
+  level: warn
+`)
+	cfg, opts, err := Load("server", []string{"-config", path, "-conn-burst", "7", "-admin", ""},
+		env(map[string]string{"TCP_SERVICE_CONN_RATE": "20", "TCP_SERVICE_CONN_BURST": "9", "TCP_SERVICE_LOG_LEVEL": "debug"}),
+		io.Discard)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if opts.File != path || opts.Print {
+		t.Errorf("Load() opts = %+v", opts)
+	}
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{name: "FileList", got: cfg.Listen, want: []string{"tcp://:9001", "unix:///tmp/a.sock"}},
+		{name: "FileValue", got: cfg.Limits.ThrottleMode, want: "delay"},
+		{name: "FileDuration", got: cfg.Timeouts.Idle, want: time.Minute},
+		{name: "FileMap", got: cfg.Auth.Credentials, want: map[string]string{"00000001": "secret"}},
+		{name: "EnvOverFile", got: cfg.Limits.Conn.Rate, want: 20.0},
+		{name: "EnvOverDefault", got: cfg.Log.Level, want: "debug"},
+		{name: "FlagOverEnv", got: cfg.Limits.Conn.Burst, want: 7},
+		{name: "FlagEmpty", got: cfg.Admin.Addr, want: ""},
+		{name: "Default", got: cfg.Metrics.Addr, want: ":8889"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !reflect.DeepEqual(tt.got, tt.want) {
+				t.Errorf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		content string
+		want    string
+	}{
+		{name: "UnknownFlag", args: []string{"-nope"}, want: "not defined"},
+		{name: "BadEnv", env: map[string]string{"TCP_SERVICE_CONN_RATE": "fast"}, want: "TCP_SERVICE_CONN_RATE"},
+		{name: "UnknownField", content: "listn: [\":1\"]\n", want: "listn"},
+		{name: "BadDuration", content: "timeouts: {idle: soon}\n", want: "soon"},
+		{name: "Invalid", args: []string{"-throttle-mode", "drop", "-listen", "udp://:1"}, want: "throttle_mode"},
+		{name: "MissingFile", env: map[string]string{"TCP_SERVICE_CONFIG": "/nonexistent.yaml"}, want: "nonexistent"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.content != "" {
+				args = append([]string{"-config", writeFile(t, tt.content)}, args...)
+			}
+			_, _, err := Load("server", args, env(tt.env), io.Discard)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, _, err := Load("server", []string{"-h"}, env(nil), io.Discard); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("Load(-h) error = %v, want %v", err, flag.ErrHelp)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   []string
+	}{
+		{name: "Default", modify: func(c *Config) {}},
+		{name: "NoListen", modify: func(c *Config) { c.Listen = nil }, want: []string{"listen"}},
+		{name: "TLSWithoutCert", modify: func(c *Config) { c.Listen = []string{"tls://:8443"} }, want: []string{"listen"}},
+		{name: "HalfCert", modify: func(c *Config) { c.TLS.CertFile = "cert.pem" }, want: []string{"tls"}},
+		{name: "Bucket", modify: func(c *Config) { c.Limits.Global = Bucket{Rate: -1} }, want: []string{"limits.global.rate"}},
+		{name: "Burst", modify: func(c *Config) { c.Limits.Conn = Bucket{Rate: 1} }, want: []string{"limits.conn.burst"}},
+		{name: "CIDR", modify: func(c *Config) { c.Limits.Deny = []string{"10.0.0.0/33"} }, want: []string{"limits.deny"}},
+		{name: "Policies", modify: func(c *Config) {
+			c.Limits.SlowConsumer = "block"
+			c.Limits.DuplicateLogin = "both"
+		}, want: []string{"limits.slow_consumer", "limits.duplicate_login"}},
+		{name: "Timeout", modify: func(c *Config) { c.Timeouts.Write = -time.Second }, want: []string{"timeouts"}},
+		{name: "FrameFormat", modify: func(c *Config) { c.Frame.Format = "v2" }, want: []string{"frame.format"}},
+		{name: "FrameDelimiter", modify: func(c *Config) { c.Frame.Delimiter = "ab" }, want: []string{"frame.delimiter"}},
+		{name: "FrameEscape", modify: func(c *Config) { c.Frame.Escape = `\n` }, want: []string{"frame.escape"}},
+		{name: "FrameMaxLength", modify: func(c *Config) { c.Frame.MaxLength = -1 }, want: []string{"frame.max_length"}},
+		{name: "FrameMaxLengthLegacy", modify: func(c *Config) { c.Frame.MaxLength = frame.MaxLegacyLength + 1 }, want: []string{"frame.max_length"}},
+		{name: "FrameMaxLengthLimit", modify: func(c *Config) { c.Frame.MaxLength = frame.MaxLegacyLength }},
+		{name: "Compression", modify: func(c *Config) {
+			c.Compression.Algorithms = []string{"snappy", "zstd"}
+			c.Compression.Threshold = -1
+		}, want: []string{"compression.algorithms", "compression.threshold"}},
+		{name: "Checksum", modify: func(c *Config) { c.Checksum.Algorithms = []string{"md5"} }, want: []string{"checksum.algorithms"}},
+		{name: "Encryption", modify: func(c *Config) { c.Encryption.Ciphers = []string{"des"} }, want: []string{"encryption.ciphers"}},
+		{name: "EncryptionRequired", modify: func(c *Config) { c.Encryption.Required = true }, want: []string{"encryption.required"}},
+		{name: "ClientID", modify: func(c *Config) { c.Auth.Credentials = map[string]string{"abc": "x"} }, want: []string{"auth.credentials"}},
+		{name: "LogLevel", modify: func(c *Config) { c.Log.Level = "trace" }, want: []string{"log.level"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			tt.modify(c)
+			err := c.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			var verr *ValidationError
+			if !errors.As(err, &verr) || len(verr.Problems) != len(tt.want) {
+				t.Fatalf("Validate() error = %v, want %d problems", err, len(tt.want))
+			}
+			for i, prefix := range tt.want {
+				if !strings.HasPrefix(verr.Problems[i], prefix) {
+					t.Errorf("problem[%d] = %q, want prefix %q", i, verr.Problems[i], prefix)
+				}
+			}
+		})
+	}
+}
+
+func TestConfig_WriteYAML(t *testing.T) {
+	cfg := Default()
+	cfg.Auth.Credentials = map[string]string{"00000001": "secret"}
+	cfg.Timeouts.Idle = 90 * time.Second
+
+	var buf bytes.Buffer
+	if err := cfg.WriteYAML(&buf); err != nil {
+		t.Fatalf("WriteYAML() error = %v", err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "secret") || !strings.Contains(out, secretMask) || !strings.Contains(out, "idle: 1m30s") {
+		t.Errorf("WriteYAML() = %s", out)
+	}
+	if cfg.Auth.Credentials["00000001"] != "secret" {
+		t.Error("WriteYAML() modified the config")
+	}
+
+	// 输出的配置可以再次读取
+	got := Default()
+	if err := got.ReadFile(writeFile(t, out)); err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if got.Timeouts.Idle != cfg.Timeouts.Idle || !reflect.DeepEqual(got.Listen, cfg.Listen) {
+		t.Errorf("ReadFile() = %+v, want %+v", got, cfg)
+	}
+}
+
+func TestTLSConfig_Load(t *testing.T) {
+	certFile, keyFile, err := testcert.WriteFiles(t.TempDir())
+	if err != nil {
+		t.Fatalf("generate cert error: %v", err)
+	}
+
+	c := TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: certFile}
+	cfg, err := c.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(cfg.Certificates) != 1 || cfg.ClientCAs == nil {
+		t.Errorf("Load() = %+v", cfg)
+	}
+
+	if cfg, err = (&TLSConfig{}).Load(); cfg != nil || err != nil {
+		t.Errorf("Load() without cert = %v, %v, want nil, nil", cfg, err)
+	}
+	if _, err = (&TLSConfig{CertFile: keyFile, KeyFile: keyFile}).Load(); err == nil {
+		t.Error("Load() with bad cert error = nil")
+	}
+}
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// EnvPrefix 环境变量前缀
+const EnvPrefix = "TCP_SERVICE_"
+
+// Options 只影响启动方式、不属于配置本身的命令行参数
+type Options struct {
+	File  string // -config 配置文件路径
+	Print bool   // -print-config 输出生效的配置后退出
+}
+
+// Load 按 默认值 < 配置文件 < 环境变量 < 命令行参数 的优先级加载配置并校验
+// args 不含程序名，lookupEnv 通常为 os.LookupEnv，设置为空的环境变量同样生效
+// usage 输出到 output，-h 时返回 flag.ErrHelp
+func Load(name string, args []string, lookupEnv func(string) (string, bool), output io.Writer) (*Config, Options, error) {
+	var opts Options
+
+	// 第一遍解析只为拿到配置文件路径
+	pre := NewFlagSet(name, Default(), &opts)
+	pre.SetOutput(output)
+	if err := pre.Parse(args); err != nil {
+		return nil, opts, err
+	}
+	if opts.File == "" {
+		opts.File, _ = lookupEnv(EnvName("config"))
+	}
+
+	cfg := Default()
+	if opts.File != "" {
+		if err := cfg.ReadFile(opts.File); err != nil {
+			return nil, opts, err
+		}
+	}
+
+	fs := NewFlagSet(name, cfg, &opts)
+	fs.SetOutput(io.Discard)
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || f.Name == "config" || f.Name == "print-config" {
+			return
+		}
+		if v, ok := lookupEnv(EnvName(f.Name)); ok {
+			if serr := fs.Set(f.Name, v); serr != nil {
+				err = fmt.Errorf("config: env %s: %w", EnvName(f.Name), serr)
+			}
+		}
+	})
+	if err != nil {
+		return nil, opts, err
+	}
+	if err = fs.Parse(args); err != nil {
+		return nil, opts, err
+	}
+
+	if err = cfg.Validate(); err != nil {
+		return nil, opts, err
+	}
+	return cfg, opts, nil
+}
+
+// EnvName 返回命令行参数对应的环境变量名，如 conn-rate -> TCP_SERVICE_CONN_RATE
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// NewFlagSet 创建绑定到 cfg 的命令行参数，参数的默认值取 cfg 当前的值
+func NewFlagSet(name string, cfg *Config, opts *Options) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	fs.StringVar(&opts.File, "config", "", "yaml 配置文件路径")
+	fs.BoolVar(&opts.Print, "print-config", false, "输出生效的配置后退出")
+
+	fs.Var((*listValue)(&cfg.Listen), "listen", "监听地址列表，逗号分隔，如 tcp://:8888,tls://:8443,unix:///var/run/tcp-service.sock")
+	fs.StringVar(&cfg.WS.Addr, "ws", cfg.WS.Addr, "WebSocket 网关监听地址，如 :8890，为空表示不启用")
+	fs.StringVar(&cfg.WS.Path, "ws-path", cfg.WS.Path, "WebSocket 网关路径")
+	fs.BoolVar(&cfg.WS.TLS, "ws-tls", cfg.WS.TLS, "WebSocket 网关使用 TLS(wss)")
+	fs.StringVar(&cfg.Admin.Addr, "admin", cfg.Admin.Addr, "管理接口监听地址，为空表示不启用")
+	fs.StringVar(&cfg.Pprof.Addr, "pprof", cfg.Pprof.Addr, "pprof 监听地址，为空表示不启用")
+	fs.StringVar(&cfg.Metrics.Addr, "metrics", cfg.Metrics.Addr, "prometheus metrics 监听地址，为空表示不启用")
+
+	fs.StringVar(&cfg.TLS.CertFile, "tls-cert", cfg.TLS.CertFile, "TLS 证书文件")
+	fs.StringVar(&cfg.TLS.KeyFile, "tls-key", cfg.TLS.KeyFile, "TLS 私钥文件")
+	fs.StringVar(&cfg.TLS.ClientCAFile, "tls-client-ca", cfg.TLS.ClientCAFile, "校验客户端证书的 CA 文件，为空表示不校验")
+
+	l := &cfg.Limits
+	fs.Float64Var(&l.Conn.Rate, "conn-rate", l.Conn.Rate, "每个连接每秒允许的 submit 数，0 表示不限")
+	fs.IntVar(&l.Conn.Burst, "conn-burst", l.Conn.Burst, "每个连接的令牌桶容量")
+	fs.Float64Var(&l.Client.Rate, "client-rate", l.Client.Rate, "每个客户端标识每秒允许的 submit 数，0 表示不限")
+	fs.IntVar(&l.Client.Burst, "client-burst", l.Client.Burst, "每个客户端标识的令牌桶容量")
+	fs.Float64Var(&l.Global.Rate, "global-rate", l.Global.Rate, "全局每秒允许的 submit 数，0 表示不限")
+	fs.IntVar(&l.Global.Burst, "global-burst", l.Global.Burst, "全局令牌桶容量")
+	fs.StringVar(&l.ThrottleMode, "throttle-mode", l.ThrottleMode, "超限处理方式: reject(回复 throttled ack) | delay(延迟读取)")
+	fs.IntVar(&l.MaxConns, "max-conns", l.MaxConns, "全局最大连接数，0 表示不限")
+	fs.IntVar(&l.MaxConnsPerIP, "max-conns-per-ip", l.MaxConnsPerIP, "单个来源 IP 最大连接数，0 表示不限")
+	fs.Var((*listValue)(&l.Allow), "allow", "允许连接的 CIDR 列表，逗号分隔，为空表示全部允许")
+	fs.Var((*listValue)(&l.Deny), "deny", "拒绝连接的 CIDR 列表，逗号分隔")
+	fs.IntVar(&l.SubQueueSize, "sub-queue-size", l.SubQueueSize, "每个订阅者推送队列的长度")
+	fs.StringVar(&l.SlowConsumer, "slow-consumer", l.SlowConsumer, "订阅者队列满时: drop-oldest(丢弃最旧消息) | disconnect(断开连接)")
+	fs.StringVar(&l.DuplicateLogin, "duplicate-login", l.DuplicateLogin, "同一客户端标识重复登录: kick-old(断开旧会话) | reject-new(拒绝新会话)")
+
+	t := &cfg.Timeouts
+	fs.DurationVar(&t.Handshake, "handshake-timeout", t.Handshake, "连接建立后完成 Con 握手的时限，0 表示不限")
+	fs.DurationVar(&t.Idle, "idle-timeout", t.Idle, "两次收到请求之间的最长间隔，0 表示不限")
+	fs.DurationVar(&t.Write, "write-timeout", t.Write, "单次写连接的超时，0 表示不限")
+	fs.DurationVar(&t.Shutdown, "shutdown-timeout", t.Shutdown, "drain 后等待已有连接断开的时限，0 表示一直等待")
+
+	fs.StringVar(&cfg.Frame.Format, "frame-format", cfg.Frame.Format, "帧格式: auto(按客户端识别 legacy 或 header) | legacy(4 字节长度头) | header(带版本号的帧头) | varint(varint 长度前缀，不支持压缩) | line(CRLF 结尾的文本行) | delimiter(分隔符结尾的文本)")
+	fs.StringVar(&cfg.Frame.Delimiter, "frame-delimiter", cfg.Frame.Delimiter, "delimiter 格式的分隔符，单个字节，可写作 \\n、\\x00 等转义形式")
+	fs.StringVar(&cfg.Frame.Escape, "frame-escape", cfg.Frame.Escape, "delimiter 格式的转义字符，为空表示不转义")
+	fs.IntVar(&cfg.Frame.MaxLength, "frame-max-length", cfg.Frame.MaxLength, "frame payload 的最大长度，所有帧格式与 WebSocket 消息共用，0 表示默认的 16MiB")
+	fs.Var((*listValue)(&cfg.Compression.Algorithms), "compression", "支持的压缩算法，按优先级逗号分隔: gzip | deflate | snappy，为空表示不压缩")
+	fs.IntVar(&cfg.Compression.Threshold, "compression-threshold", cfg.Compression.Threshold, "不小于该长度的 payload 才压缩")
+	fs.Var((*listValue)(&cfg.Checksum.Algorithms), "checksum", "支持的 frame 校验和算法，按优先级逗号分隔: crc32 | crc32c | xxhash，为空表示不校验，只对 header 帧格式生效")
+	fs.Var((*listValue)(&cfg.Encryption.Ciphers), "encryption", "支持的端到端加密算法，按优先级逗号分隔: aes-gcm | chacha20-poly1305，为空表示不加密，只对 header 帧格式生效")
+	fs.StringVar(&cfg.Encryption.KeyFile, "encryption-key", cfg.Encryption.KeyFile, "服务端 X25519 静态私钥文件（64 个十六进制字符），客户端固定对应的公钥以认证服务端")
+	fs.BoolVar(&cfg.Encryption.Required, "encryption-required", cfg.Encryption.Required, "未协商出加密的连接握手失败")
+
+	fs.Var((*credentialsValue)(&cfg.Auth.Credentials), "auth", "Con 握手凭证，格式 id:secret，逗号分隔，为空表示不鉴权")
+
+	fs.StringVar(&cfg.Log.Level, "log-level", cfg.Log.Level, "日志级别: debug | info | warn | error")
+	fs.StringVar(&cfg.Log.Output, "log-output", cfg.Log.Output, "日志输出: stderr | stdout | 文件路径")
+	return fs
+}
+
+// listValue 逗号分隔的列表参数，设置时整体替换
+type listValue []string
+
+func (v *listValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return strings.Join(*v, ",")
+}
+
+func (v *listValue) Set(s string) error {
+	*v = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*v = append(*v, item)
+		}
+	}
+	return nil
+}
+
+// credentialsValue id:secret 列表参数，设置时整体替换
+type credentialsValue map[string]string
+
+func (v *credentialsValue) String() string {
+	if v == nil || len(*v) == 0 {
+		return ""
+	}
+	ids := make([]string, 0, len(*v))
+	for id := range *v {
+		ids = append(ids, id+":"+secretMask)
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+func (v *credentialsValue) Set(s string) error {
+	m := make(map[string]string)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		i := strings.IndexByte(item, ':')
+		if i <= 0 {
+			return fmt.Errorf("invalid credential [%s], want id:secret", item)
+		}
+		m[item[:i]] = item[i+1:]
+	}
+	*v = m
+	return nil
+}
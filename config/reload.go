@@ -0,0 +1,280 @@
+package config
+
+import (
+	"crypto/tls"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/CoderI421/tcp-service/auth"
+	"github.com/CoderI421/tcp-service/connlimit"
+	"github.com/CoderI421/tcp-service/limiter"
+	"github.com/CoderI421/tcp-service/logger"
+	"github.com/CoderI421/tcp-service/metrics"
+)
+
+/*
+配置热加载
+
+可在运行时生效的配置：
+	limits 中的限流与连接准入（conn/client/global/throttle_mode/max_conns/max_conns_per_ip/allow/deny）
+	auth.credentials  已开启鉴权时替换凭证，已建立的会话不受影响
+	log.level
+	tls               已开启 TLS 时替换证书，只影响之后建立的连接
+其余配置的变更只会被报告，需要重启才能生效
+*/
+
+// liveKeys 可在运行时生效的配置项，按前缀匹配
+var liveKeys = []string{
+	"limits.conn", "limits.client", "limits.global", "limits.throttle_mode",
+	"limits.max_conns", "limits.max_conns_per_ip", "limits.allow", "limits.deny",
+	"auth.credentials", "log.level", "tls",
+}
+
+// Change 一项配置变更
+type Change struct {
+	Key     string // 配置项，如 limits.conn.rate
+	Restart bool   // 需要重启才能生效
+}
+
+// Diff 比较两份配置，按配置项返回所有变更
+func Diff(old, new *Config) []Change {
+	var changes []Change
+	diffValue("", reflect.ValueOf(*old), reflect.ValueOf(*new), func(key string) {
+		changes = append(changes, Change{Key: key, Restart: !isLive(key)})
+	})
+	return changes
+}
+
+func diffValue(key string, a, b reflect.Value, add func(key string)) {
+	switch a.Kind() {
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			name := strings.Split(a.Type().Field(i).Tag.Get("yaml"), ",")[0]
+			if key != "" {
+				name = key + "." + name
+			}
+			diffValue(name, a.Field(i), b.Field(i), add)
+		}
+		return
+	case reflect.Slice, reflect.Map:
+		// nil 与空视为相同
+		if a.Len() == 0 && b.Len() == 0 {
+			return
+		}
+	}
+	if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+		add(key)
+	}
+}
+
+func isLive(key string) bool {
+	for _, k := range liveKeys {
+		if key == k || strings.HasPrefix(key, k+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// DynamicTLS 可替换证书的 TLS 配置，替换后只影响之后建立的连接，并发安全
+type DynamicTLS struct {
+	v atomic.Value // *tls.Config
+}
+
+// NewDynamicTLS 以 cfg 作为初始的 TLS 配置
+func NewDynamicTLS(cfg *tls.Config) *DynamicTLS {
+	d := &DynamicTLS{}
+	d.Set(cfg)
+	return d
+}
+
+// Set 替换 TLS 配置
+func (d *DynamicTLS) Set(cfg *tls.Config) {
+	d.v.Store(cfg)
+}
+
+// Config 返回给 listener 使用的 tls.Config，每次握手时取当前的配置
+func (d *DynamicTLS) Config() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return d.v.Load().(*tls.Config), nil
+		},
+		// net/http 启动 TLS 时要求提供证书来源
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cfg := d.v.Load().(*tls.Config)
+			if len(cfg.Certificates) == 0 {
+				return nil, errors.New("config: no tls certificate")
+			}
+			return &cfg.Certificates[0], nil
+		},
+	}
+}
+
+// Runtime 运行中可热更新的对象，为 nil 的对象对应的配置变更需要重启
+type Runtime struct {
+	Limiter *limiter.Limiter
+	Gate    *connlimit.Gate
+	Auth    *auth.Static // 未开启鉴权时为 nil
+	TLS     *DynamicTLS  // 未开启 TLS 时为 nil
+}
+
+// Reloader 重新加载配置，把可在运行时生效的变更应用到 Runtime，并发安全
+type Reloader struct {
+	load func() (*Config, error)
+	rt   Runtime
+
+	mu      sync.Mutex
+	running *Config // 当前生效的配置
+	gen     uint64  // 配置代数，启动时为 1，每次重新加载成功加 1
+}
+
+// NewReloader running 为启动时的配置，load 重新加载配置（通常为以启动参数再次调用 Load）
+func NewReloader(running *Config, load func() (*Config, error), rt Runtime) *Reloader {
+	metrics.ConfigGeneration.Set(1)
+	return &Reloader{
+		load:    load,
+		rt:      rt,
+		running: running,
+		gen:     1,
+	}
+}
+
+// Generation 返回当前的配置代数
+func (r *Reloader) Generation() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.gen
+}
+
+// Reload 重新加载配置并应用可在运行时生效的变更，返回所有变更
+// 加载、校验或准备（如读取证书）失败时不会应用任何变更
+func (r *Reloader) Reload() ([]Change, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := r.load()
+	if err != nil {
+		metrics.ConfigReloadTotal.WithLabelValues("error").Inc()
+		logger.Errorf("config reload failed: %v", err)
+		return nil, err
+	}
+	changes := Diff(r.running, cfg)
+	r.classify(cfg, changes)
+
+	// 先准备所有可能失败的部分，全部成功后再应用
+	var tlsConfig *tls.Config
+	if applied(changes, "tls") {
+		if tlsConfig, err = cfg.TLS.Load(); err != nil {
+			metrics.ConfigReloadTotal.WithLabelValues("error").Inc()
+			logger.Errorf("config reload failed: %v", err)
+			return nil, err
+		}
+	}
+	if r.rt.Gate != nil {
+		if err = r.rt.Gate.SetConfig(cfg.Limits.GateConfig()); err != nil {
+			metrics.ConfigReloadTotal.WithLabelValues("error").Inc()
+			logger.Errorf("config reload failed: %v", err)
+			return nil, err
+		}
+	}
+
+	if r.rt.Limiter != nil {
+		r.rt.Limiter.SetConfig(cfg.Limits.LimiterConfig())
+	}
+	if r.rt.Auth != nil && len(cfg.Auth.Credentials) > 0 {
+		r.rt.Auth.Set(cfg.Auth.Credentials)
+	}
+	level, _ := logger.ParseLevel(cfg.Log.Level)
+	logger.SetLevel(level)
+	if tlsConfig != nil {
+		r.rt.TLS.Set(tlsConfig)
+	}
+
+	r.running = r.merge(cfg, changes)
+	r.gen++
+	restart := 0
+	for _, c := range changes {
+		if c.Restart {
+			restart++
+			logger.Warnf("config reload: %s changed, restart required", c.Key)
+		} else {
+			logger.Infof("config reload: %s applied", c.Key)
+		}
+	}
+	metrics.ConfigGeneration.Set(float64(r.gen))
+	metrics.ConfigRestartPending.Set(float64(restart))
+	metrics.ConfigReloadTotal.WithLabelValues("ok").Inc()
+	logger.Infof("config reload: generation %d, %d changes, %d require restart", r.gen, len(changes), restart)
+	return changes, nil
+}
+
+// classify 按运行时的情况修正变更是否需要重启
+// 鉴权、TLS 的开启与关闭，以及缺少对应 Runtime 对象时都需要重启
+func (r *Reloader) classify(cfg *Config, changes []Change) {
+	authToggled := (len(r.running.Auth.Credentials) == 0) != (len(cfg.Auth.Credentials) == 0)
+	for i := range changes {
+		c := &changes[i]
+		if c.Restart {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(c.Key, "auth."):
+			c.Restart = r.rt.Auth == nil || authToggled
+		case strings.HasPrefix(c.Key, "tls."):
+			c.Restart = r.rt.TLS == nil || !cfg.TLS.Enabled()
+		case strings.HasPrefix(c.Key, "limits.max_conns"), c.Key == "limits.allow", c.Key == "limits.deny":
+			c.Restart = r.rt.Gate == nil
+		case strings.HasPrefix(c.Key, "limits."):
+			c.Restart = r.rt.Limiter == nil
+		}
+	}
+}
+
+// merge 返回新的生效配置：已应用的变更取 cfg，需要重启的变更保持原值
+func (r *Reloader) merge(cfg *Config, changes []Change) *Config {
+	running := *r.running
+	for _, c := range changes {
+		if c.Restart {
+			continue
+		}
+		l, nl := &running.Limits, &cfg.Limits
+		switch {
+		case strings.HasPrefix(c.Key, "limits.conn."):
+			l.Conn = nl.Conn
+		case strings.HasPrefix(c.Key, "limits.client."):
+			l.Client = nl.Client
+		case strings.HasPrefix(c.Key, "limits.global."):
+			l.Global = nl.Global
+		case c.Key == "limits.throttle_mode":
+			l.ThrottleMode = nl.ThrottleMode
+		case c.Key == "limits.max_conns":
+			l.MaxConns = nl.MaxConns
+		case c.Key == "limits.max_conns_per_ip":
+			l.MaxConnsPerIP = nl.MaxConnsPerIP
+		case c.Key == "limits.allow":
+			l.Allow = nl.Allow
+		case c.Key == "limits.deny":
+			l.Deny = nl.Deny
+		case c.Key == "auth.credentials":
+			running.Auth = cfg.Auth
+		case c.Key == "log.level":
+			running.Log.Level = cfg.Log.Level
+		case strings.HasPrefix(c.Key, "tls."):
+			running.TLS = cfg.TLS
+		}
+	}
+	return &running
+}
+
+// applied 是否有 prefix 下的变更可以在运行时生效
+func applied(changes []Change, prefix string) bool {
+	for _, c := range changes {
+		if !c.Restart && (c.Key == prefix || strings.HasPrefix(c.Key, prefix+".")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/CoderI421/tcp-service/auth"
+	"github.com/CoderI421/tcp-service/connlimit"
+	"github.com/CoderI421/tcp-service/internal/testcert"
+	"github.com/CoderI421/tcp-service/limiter"
+	"github.com/CoderI421/tcp-service/logger"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   []Change
+	}{
+		{name: "None", modify: func(c *Config) { c.Limits.Allow = []string{} }},
+		{name: "Limits", modify: func(c *Config) {
+			c.Limits.Conn.Rate = 10
+			c.Limits.Deny = []string{"10.0.0.0/8"}
+			c.Limits.SubQueueSize = 16
+		}, want: []Change{
+			{Key: "limits.conn.rate"},
+			{Key: "limits.deny"},
+			{Key: "limits.sub_queue_size", Restart: true},
+		}},
+		{name: "Restart", modify: func(c *Config) {
+			c.Listen = []string{":9999"}
+			c.Timeouts.Idle = 1
+			c.Log.Output = "stdout"
+		}, want: []Change{
+			{Key: "listen", Restart: true},
+			{Key: "timeouts.idle", Restart: true},
+			{Key: "log.output", Restart: true},
+		}},
+		{name: "Live", modify: func(c *Config) {
+			c.TLS.CertFile = "cert.pem"
+			c.Auth.Credentials = map[string]string{"00000001": "secret"}
+			c.Log.Level = "debug"
+		}, want: []Change{
+			{Key: "tls.cert_file"},
+			{Key: "auth.credentials"},
+			{Key: "log.level"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			tt.modify(c)
+			if got := Diff(Default(), c); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReloader_Reload(t *testing.T) {
+	defer logger.SetLevel(logger.GetLevel())
+	certFile, keyFile, err := testcert.WriteFiles(t.TempDir())
+	if err != nil {
+		t.Fatalf("generate cert error: %v", err)
+	}
+
+	boot := Default()
+	boot.Auth.Credentials = map[string]string{"00000001": "old"}
+	boot.TLS = TLSConfig{CertFile: certFile, KeyFile: keyFile}
+	tlsConfig, err := boot.TLS.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	gate, _ := connlimit.New(boot.Limits.GateConfig())
+	rt := Runtime{
+		Limiter: limiter.New(boot.Limits.LimiterConfig()),
+		Gate:    gate,
+		Auth:    auth.NewStatic(boot.Auth.Credentials),
+		TLS:     NewDynamicTLS(tlsConfig),
+	}
+
+	var next *Config
+	var loadErr error
+	r := NewReloader(boot, func() (*Config, error) { return next, loadErr }, rt)
+
+	// 可在运行时生效的变更立即应用，需要重启的变更只报告
+	next = Default()
+	next.Auth.Credentials = map[string]string{"00000001": "new"}
+	next.TLS = boot.TLS
+	next.Limits.Global = Bucket{Rate: 5, Burst: 5}
+	next.Limits.MaxConns = 1
+	next.Log.Level = "warn"
+	next.Listen = []string{":9999"}
+	changes, err := r.Reload()
+	if err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	want := []Change{
+		{Key: "listen", Restart: true},
+		{Key: "limits.global.rate"},
+		{Key: "limits.global.burst"},
+		{Key: "limits.max_conns"},
+		{Key: "auth.credentials"},
+		{Key: "log.level"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("Reload() = %+v, want %+v", changes, want)
+	}
+	if r.Generation() != 2 {
+		t.Errorf("Generation() = %d, want 2", r.Generation())
+	}
+	if got := rt.Limiter.Config().Global; got != (limiter.Bucket{Rate: 5, Burst: 5}) {
+		t.Errorf("limiter global = %+v", got)
+	}
+	if !rt.Auth.Authenticate("00000001", []byte("new")) {
+		t.Error("auth credentials not applied")
+	}
+	if logger.GetLevel() != logger.WarnLevel {
+		t.Errorf("log level = %v, want warn", logger.GetLevel())
+	}
+
+	// 需要重启的变更在下次加载时仍然报告
+	changes, _ = r.Reload()
+	if !reflect.DeepEqual(changes, []Change{{Key: "listen", Restart: true}}) {
+		t.Errorf("second Reload() = %+v", changes)
+	}
+
+	// 关闭鉴权需要重启
+	next2 := *next
+	next2.Auth.Credentials = nil
+	next = &next2
+	changes, _ = r.Reload()
+	if !reflect.DeepEqual(changes, []Change{{Key: "listen", Restart: true}, {Key: "auth.credentials", Restart: true}}) {
+		t.Errorf("Reload() disable auth = %+v", changes)
+	}
+	if !rt.Auth.Authenticate("00000001", []byte("new")) {
+		t.Error("auth credentials cleared on restart-required change")
+	}
+
+	// 失败时不应用任何变更
+	gen := r.Generation()
+	bad := *next
+	bad.Limits.Global = Bucket{Rate: 1, Burst: 1}
+	bad.TLS.CertFile = keyFile
+	next = &bad
+	if _, err = r.Reload(); err == nil {
+		t.Fatal("Reload() with bad cert error = nil")
+	}
+	loadErr = errors.New("load error")
+	if _, err = r.Reload(); !errors.Is(err, loadErr) {
+		t.Errorf("Reload() error = %v, want %v", err, loadErr)
+	}
+	if r.Generation() != gen || rt.Limiter.Config().Global.Rate != 5 {
+		t.Errorf("failed Reload() applied changes: generation %d, global %+v", r.Generation(), rt.Limiter.Config().Global)
+	}
+}
+
+func TestDynamicTLS(t *testing.T) {
+	newConfigs := func() (*tls.Config, []byte) {
+		certPEM, keyPEM, err := testcert.Generate()
+		if err != nil {
+			t.Fatalf("generate cert error: %v", err)
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			t.Fatalf("X509KeyPair() error = %v", err)
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}}, cert.Certificate[0]
+	}
+	first, firstDER := newConfigs()
+	second, secondDER := newConfigs()
+
+	d := NewDynamicTLS(first)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", d.Config())
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.(*tls.Conn).Handshake()
+			c.Close()
+		}
+	}()
+
+	peer := func() []byte {
+		c, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		defer c.Close()
+		return c.ConnectionState().PeerCertificates[0].Raw
+	}
+	if !bytes.Equal(peer(), firstDER) {
+		t.Error("peer certificate is not the first one")
+	}
+	d.Set(second)
+	if !bytes.Equal(peer(), secondDER) {
+		t.Error("peer certificate is not the second one after Set")
+	}
+}
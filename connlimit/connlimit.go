@@ -0,0 +1,193 @@
+package connlimit
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+/*
+连接准入控制
+
+Accept 之后立即判断，依次检查：
+	deny  列表：命中则拒绝
+	allow 列表：非空且未命中则拒绝
+	全局最大连接数
+	单个来源 IP 最大连接数
+*/
+
+// 拒绝原因，用于 metrics 的 label
+const (
+	ReasonDenied     = "denied"      // 命中 deny 列表
+	ReasonNotAllowed = "not_allowed" // 未命中 allow 列表
+	ReasonMaxConns   = "max_conns"   // 超过全局最大连接数
+	ReasonMaxPerIP   = "max_per_ip"  // 超过单 IP 最大连接数
+)
+
+// Config 准入配置，数值 <= 0 表示不限制
+type Config struct {
+	MaxConns      int
+	MaxConnsPerIP int
+	Allow         []string // CIDR 或单个 IP
+	Deny          []string // CIDR 或单个 IP
+}
+
+// Gate 连接准入控制器，并发安全
+type Gate struct {
+	mu    sync.Mutex
+	cfg   Config
+	allow []*net.IPNet
+	deny  []*net.IPNet
+	total int
+	perIP map[string]int
+}
+
+// New 创建准入控制器，CIDR 解析失败时返回错误
+func New(cfg Config) (*Gate, error) {
+	allow, err := ParseCIDRs(cfg.Allow)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := ParseCIDRs(cfg.Deny)
+	if err != nil {
+		return nil, err
+	}
+	return &Gate{
+		cfg:   cfg,
+		allow: allow,
+		deny:  deny,
+		perIP: make(map[string]int),
+	}, nil
+}
+
+// SetConfig 替换准入配置，只影响之后的 Admit，已接受的连接不会被断开
+// CIDR 解析失败时返回错误，原配置保持不变
+func (g *Gate) SetConfig(cfg Config) error {
+	allow, err := ParseCIDRs(cfg.Allow)
+	if err != nil {
+		return err
+	}
+	deny, err := ParseCIDRs(cfg.Deny)
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	g.cfg, g.allow, g.deny = cfg, allow, deny
+	g.mu.Unlock()
+	return nil
+}
+
+// Admit 判断是否接受来自 addr 的连接
+// 接受时返回的 release 必须在连接关闭后调用一次；拒绝时返回拒绝原因
+func (g *Gate) Admit(addr net.Addr) (release func(), reason string, ok bool) {
+	ip := addrIP(addr)
+	key := ""
+	if ip != nil {
+		key = ip.String()
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if ip != nil {
+		if contains(g.deny, ip) {
+			return nil, ReasonDenied, false
+		}
+		if len(g.allow) > 0 && !contains(g.allow, ip) {
+			return nil, ReasonNotAllowed, false
+		}
+	}
+
+	if g.cfg.MaxConns > 0 && g.total >= g.cfg.MaxConns {
+		return nil, ReasonMaxConns, false
+	}
+	if g.cfg.MaxConnsPerIP > 0 && key != "" && g.perIP[key] >= g.cfg.MaxConnsPerIP {
+		return nil, ReasonMaxPerIP, false
+	}
+	g.total++
+	g.perIP[key]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { g.release(key) })
+	}, "", true
+}
+
+// Count 返回当前已接受的连接数
+func (g *Gate) Count() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.total
+}
+
+func (g *Gate) release(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.total--
+	g.perIP[key]--
+	if g.perIP[key] <= 0 {
+		delete(g.perIP, key)
+	}
+}
+
+// ParseCIDRs 解析 CIDR 列表，不带掩码的 IP 视为单个地址
+func ParseCIDRs(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip [%s]", s)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr [%s]: %w", s, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// addrIP 取出连接的来源 IP，非 IP 类地址（如 unix socket）返回 nil
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+	if addr == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,123 @@
+package connlimit
+
+import (
+	"net"
+	"testing"
+)
+
+func tcpAddr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 12345}
+}
+
+func TestGate_Admit(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        Config
+		addrs      []string
+		wantReason []string
+	}{
+		{
+			name:       "Unlimited",
+			cfg:        Config{},
+			addrs:      []string{"10.0.0.1", "10.0.0.1", "10.0.0.2"},
+			wantReason: []string{"", "", ""},
+		},
+		{
+			name:       "MaxConns",
+			cfg:        Config{MaxConns: 2},
+			addrs:      []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+			wantReason: []string{"", "", ReasonMaxConns},
+		},
+		{
+			name:       "MaxPerIP",
+			cfg:        Config{MaxConnsPerIP: 1},
+			addrs:      []string{"10.0.0.1", "10.0.0.1", "10.0.0.2"},
+			wantReason: []string{"", ReasonMaxPerIP, ""},
+		},
+		{
+			name:       "Deny",
+			cfg:        Config{Deny: []string{"10.0.0.0/24"}},
+			addrs:      []string{"10.0.0.1", "10.0.1.1"},
+			wantReason: []string{ReasonDenied, ""},
+		},
+		{
+			name:       "Allow",
+			cfg:        Config{Allow: []string{"10.0.0.0/24", "192.168.1.1"}},
+			addrs:      []string{"10.0.0.1", "10.0.1.1", "192.168.1.1"},
+			wantReason: []string{"", ReasonNotAllowed, ""},
+		},
+		{
+			name:       "DenyBeforeAllow",
+			cfg:        Config{Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.0.0.1"}},
+			addrs:      []string{"10.0.0.1", "10.0.0.2"},
+			wantReason: []string{ReasonDenied, ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := New(tt.cfg)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			for i, a := range tt.addrs {
+				_, reason, ok := g.Admit(tcpAddr(a))
+				if reason != tt.wantReason[i] || ok != (tt.wantReason[i] == "") {
+					t.Errorf("Admit(%s) = %q, %v, want %q", a, reason, ok, tt.wantReason[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGate_Release(t *testing.T) {
+	g, _ := New(Config{MaxConns: 1, MaxConnsPerIP: 1})
+	release, _, ok := g.Admit(tcpAddr("10.0.0.1"))
+	if !ok {
+		t.Fatal("Admit() = false, want true")
+	}
+	release()
+	release() // 重复调用不应重复释放
+	if n := g.Count(); n != 0 {
+		t.Fatalf("Count() = %d, want 0", n)
+	}
+	if _, reason, ok := g.Admit(tcpAddr("10.0.0.1")); !ok {
+		t.Errorf("Admit() after release = %q, want ok", reason)
+	}
+}
+
+func TestNew_InvalidCIDR(t *testing.T) {
+	if _, err := New(Config{Deny: []string{"10.0.0.0/33"}}); err == nil {
+		t.Error("New() error = nil, want non-nil")
+	}
+	if _, err := New(Config{Allow: []string{"not-an-ip"}}); err == nil {
+		t.Error("New() error = nil, want non-nil")
+	}
+}
+
+func TestGate_SetConfig(t *testing.T) {
+	g, _ := New(Config{})
+	if _, _, ok := g.Admit(tcpAddr("10.0.0.1")); !ok {
+		t.Fatal("Admit() = false, want true")
+	}
+
+	if err := g.SetConfig(Config{Deny: []string{"10.0.0.0/33"}}); err == nil {
+		t.Error("SetConfig() error = nil, want non-nil")
+	}
+	if _, reason, ok := g.Admit(tcpAddr("10.0.0.2")); !ok {
+		t.Errorf("Admit() after failed SetConfig = %q, want ok", reason)
+	}
+
+	if err := g.SetConfig(Config{MaxConns: 3, Deny: []string{"10.0.1.0/24"}}); err != nil {
+		t.Fatalf("SetConfig() error = %v", err)
+	}
+	if _, reason, _ := g.Admit(tcpAddr("10.0.1.1")); reason != ReasonDenied {
+		t.Errorf("Admit() reason = %q, want %q", reason, ReasonDenied)
+	}
+	// 已接受的连接仍然计数
+	if _, reason, ok := g.Admit(tcpAddr("10.0.0.3")); !ok {
+		t.Errorf("Admit() = %q, want ok", reason)
+	}
+	if _, reason, _ := g.Admit(tcpAddr("10.0.0.4")); reason != ReasonMaxConns {
+		t.Errorf("Admit() reason = %q, want %q", reason, ReasonMaxConns)
+	}
+}
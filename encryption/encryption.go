@@ -0,0 +1,617 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/CoderI421/tcp-service/frame"
+)
+
+/*
+应用层端到端的 payload 加密，TLS 在负载均衡等中间设备上终结时 payload 仍保持加密
+
+客户端在 Con 的 OptionEncryption 中带上 X25519 临时公钥与按优先级排列的加密算法，服务端选出双方都支持的第一个，
+在 ConAck 的 OptionEncryption 中返回选定的算法、自己的临时公钥、密钥确认与静态公钥（配置了时），
+双方经 HKDF-SHA256 派生出两个方向各自的密钥，认证方式见 Handshake：
+
+	Con:    1 byte flags（凭证已加密） + 32 bytes 客户端公钥 + 每个算法 1 字节
+	ConAck: 1 byte 选定的算法 + 32 bytes 服务端临时公钥 + 32 bytes 密钥确认 [+ 32 bytes 服务端静态公钥]，
+	        未协商出时只有 1 字节的 None
+
+Con 与 ConAck 以明文发送，之后双方发送的每个 frame 都加密，带有 frame.FlagEncrypted，只有带版本号的帧头（frame.HeaderCodec）能携带：
+
+	frameHeader（flags 含 FlagEncrypted）
+	8 bytes: 序号，大端序，每个方向从 0 开始逐个加 1
+	AEAD 密文（含 16 字节的认证标签）
+
+nonce 为 4 字节 0 加上序号，frame 的 flags（不含 FlagChecksum）作为附加数据参与认证
+接收方要求序号与期望的下一个序号相同，重放、丢弃或乱序的 frame 都会被拒绝
+加密在压缩之后、校验和之前进行，校验和针对密文
+*/
+
+// Cipher 加密算法
+type Cipher uint8
+
+const (
+	None             Cipher = iota // 不加密
+	AESGCM                         // AES-256-GCM，多数 CPU 有硬件加速
+	ChaCha20Poly1305               // ChaCha20-Poly1305，没有 AES 硬件加速时更快
+)
+
+const (
+	// KeySize 每个方向的密钥长度
+	KeySize = 32
+	// PublicKeySize X25519 公钥的长度
+	PublicKeySize = curve25519.PointSize
+
+	seqSize     = 8
+	confirmSize = 32
+)
+
+var (
+	// ErrUnsupported 不支持的加密算法
+	ErrUnsupported = errors.New("encryption: unsupported cipher")
+	// ErrDecrypt frame 认证失败，或收到加密的 frame 时还没有密钥
+	ErrDecrypt = errors.New("encryption: frame authentication failed")
+	// ErrReplay frame 的序号不是期望的下一个，frame 被重放、丢弃或乱序
+	ErrReplay = errors.New("encryption: unexpected frame sequence")
+	// ErrPlaintext 开始加密之后收到未加密的 frame
+	ErrPlaintext = errors.New("encryption: unexpected plaintext frame")
+	// ErrInvalidOption OptionEncryption 的 value 格式错误
+	ErrInvalidOption = errors.New("encryption: invalid option")
+	// ErrInvalidKey 静态密钥格式错误
+	ErrInvalidKey = errors.New("encryption: invalid key")
+	// ErrAuthentication 无法认证服务端：服务端未使用固定的静态密钥，或密钥确认不一致（密钥交换被篡改）
+	ErrAuthentication = errors.New("encryption: server authentication failed")
+)
+
+var names = map[Cipher]string{
+	None:             "none",
+	AESGCM:           "aes-gcm",
+	ChaCha20Poly1305: "chacha20-poly1305",
+}
+
+func (c Cipher) String() string {
+	if s, ok := names[c]; ok {
+		return s
+	}
+	return fmt.Sprintf("cipher(%d)", uint8(c))
+}
+
+// Supported 是否支持该算法，None 不算
+func (c Cipher) Supported() bool {
+	_, ok := names[c]
+	return ok && c != None
+}
+
+// newAEAD 以 key 创建 AEAD
+func (c Cipher) newAEAD(key []byte) (cipher.AEAD, error) {
+	switch c {
+	case AESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case ChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+	default:
+		return nil, fmt.Errorf("%w [%d]", ErrUnsupported, c)
+	}
+}
+
+// Parse 按名称解析加密算法
+func Parse(s string) (Cipher, error) {
+	for c, name := range names {
+		if strings.EqualFold(s, name) {
+			return c, nil
+		}
+	}
+	return None, fmt.Errorf("%w [%s]", ErrUnsupported, s)
+}
+
+// ParseList 解析以逗号分隔的算法列表，空字符串返回 nil
+func ParseList(s string) ([]Cipher, error) {
+	var ciphers []Cipher
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		c, err := Parse(name)
+		if err != nil {
+			return nil, err
+		}
+		if c != None {
+			ciphers = append(ciphers, c)
+		}
+	}
+	return ciphers, nil
+}
+
+// Negotiate 按客户端的优先级选出服务端也支持的第一个算法，没有时返回 None
+func Negotiate(offered, supported []Cipher) Cipher {
+	for _, c := range offered {
+		if !c.Supported() {
+			continue
+		}
+		for _, s := range supported {
+			if c == s {
+				return c
+			}
+		}
+	}
+	return None
+}
+
+// Offer Con 中 OptionEncryption 的内容
+type Offer struct {
+	Public  []byte   // 客户端临时公钥
+	Ciphers []Cipher // 按优先级排列的算法
+	Sealed  bool     // Con.Payload 已用服务端静态公钥加密
+}
+
+// offerSealed Offer 的 flags 中表示凭证已加密的位
+const offerSealed = 0x01
+
+// Encode 编码为 OptionEncryption 的 value
+func (o Offer) Encode() []byte {
+	b := make([]byte, 0, 1+len(o.Public)+len(o.Ciphers))
+	var flags byte
+	if o.Sealed {
+		flags |= offerSealed
+	}
+	b = append(append(b, flags), o.Public...)
+	for _, c := range o.Ciphers {
+		b = append(b, byte(c))
+	}
+	return b
+}
+
+// DecodeOffer 解码 Con 中 OptionEncryption 的 value
+func DecodeOffer(b []byte) (Offer, error) {
+	if len(b) < 1+PublicKeySize {
+		return Offer{}, ErrInvalidOption
+	}
+	o := Offer{
+		Public:  b[1 : 1+PublicKeySize],
+		Ciphers: make([]Cipher, len(b)-1-PublicKeySize),
+		Sealed:  b[0]&offerSealed != 0,
+	}
+	for i, v := range b[1+PublicKeySize:] {
+		o.Ciphers[i] = Cipher(v)
+	}
+	return o, nil
+}
+
+// Accept ConAck 中 OptionEncryption 的内容，Cipher 为 None 时其余字段为空
+type Accept struct {
+	Cipher  Cipher
+	Public  []byte // 服务端临时公钥
+	Confirm []byte // 密钥确认，由派生出的密钥计算，客户端据此确认双方的密钥相同
+	Static  []byte // 服务端静态公钥，服务端未配置静态密钥时为空
+}
+
+// Encode 编码为 OptionEncryption 的 value
+func (a Accept) Encode() []byte {
+	if a.Cipher == None {
+		return []byte{byte(None)}
+	}
+	b := make([]byte, 0, 1+len(a.Public)+len(a.Confirm)+len(a.Static))
+	b = append(append(append(append(b, byte(a.Cipher)), a.Public...), a.Confirm...), a.Static...)
+	return b
+}
+
+// DecodeAccept 解码 ConAck 中 OptionEncryption 的 value
+func DecodeAccept(b []byte) (Accept, error) {
+	if len(b) == 1 && Cipher(b[0]) == None {
+		return Accept{}, nil
+	}
+	n := 1 + PublicKeySize + confirmSize
+	if len(b) != n && len(b) != n+PublicKeySize {
+		return Accept{}, ErrInvalidOption
+	}
+	a := Accept{
+		Cipher:  Cipher(b[0]),
+		Public:  b[1 : 1+PublicKeySize],
+		Confirm: b[1+PublicKeySize : n],
+	}
+	if len(b) > n {
+		a.Static = b[n:]
+	}
+	return a, nil
+}
+
+// GenerateKey 生成服务端的静态密钥，priv 配置在服务端，pub 配置在客户端
+func GenerateKey() (priv, pub []byte, err error) {
+	priv = make([]byte, curve25519.ScalarSize)
+	if _, err = io.ReadFull(rand.Reader, priv); err != nil {
+		return nil, nil, err
+	}
+	if pub, err = PublicKey(priv); err != nil {
+		return nil, nil, err
+	}
+	return priv, pub, nil
+}
+
+// PublicKey 返回静态私钥对应的公钥
+func PublicKey(priv []byte) ([]byte, error) {
+	if len(priv) != curve25519.ScalarSize {
+		return nil, fmt.Errorf("%w: private key length %d", ErrInvalidKey, len(priv))
+	}
+	return curve25519.X25519(priv, curve25519.Basepoint)
+}
+
+// ParseKey 解析十六进制编码的 32 字节密钥，首尾空白忽略
+func ParseKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("%w: length %d", ErrInvalidKey, len(key))
+	}
+	return key, nil
+}
+
+/*
+Handshake 一次 X25519 密钥交换，每个连接使用新的临时密钥
+
+服务端配置了静态密钥时，静态密钥与客户端临时密钥的共享密钥（es）和两个临时密钥的共享密钥（ee）一起派生会话密钥，
+只有持有静态私钥的服务端能得到相同的密钥。客户端固定（pin）了服务端的静态公钥时以此认证服务端：
+中间人替换任一方的临时公钥，都无法算出与客户端相同的密钥，ConAck 中的密钥确认校验失败
+
+派生时 Con 中的 offer 与选定的算法也参与计算，中间人删除 offer 中的算法降级同样会被发现
+凭证（Con.Payload）由客户端用 es 派生的密钥加密，只有服务端能解密
+*/
+type Handshake struct {
+	client bool
+	priv   [curve25519.ScalarSize]byte
+	pub    []byte
+	static []byte // 服务端为静态私钥，客户端为固定的服务端静态公钥，nil 表示没有
+}
+
+// NewClientHandshake 客户端生成临时密钥，serverKey 为固定的服务端静态公钥，nil 表示不认证服务端
+func NewClientHandshake(serverKey []byte) (*Handshake, error) {
+	if serverKey != nil && len(serverKey) != PublicKeySize {
+		return nil, fmt.Errorf("%w: public key length %d", ErrInvalidKey, len(serverKey))
+	}
+	return newHandshake(true, serverKey)
+}
+
+// NewServerHandshake 服务端生成临时密钥，key 为服务端静态私钥，nil 表示不使用静态密钥
+func NewServerHandshake(key []byte) (*Handshake, error) {
+	if key != nil && len(key) != curve25519.ScalarSize {
+		return nil, fmt.Errorf("%w: private key length %d", ErrInvalidKey, len(key))
+	}
+	return newHandshake(false, key)
+}
+
+func newHandshake(client bool, static []byte) (*Handshake, error) {
+	h := &Handshake{client: client, static: static}
+	if _, err := io.ReadFull(rand.Reader, h.priv[:]); err != nil {
+		return nil, err
+	}
+	pub, err := curve25519.X25519(h.priv[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	h.pub = pub
+	return h, nil
+}
+
+// Public 返回本方的临时公钥
+func (h *Handshake) Public() []byte {
+	return h.pub
+}
+
+// Authenticated 客户端是否固定了服务端的静态公钥
+func (h *Handshake) Authenticated() bool {
+	return h.client && h.static != nil
+}
+
+// es 客户端临时密钥与服务端静态密钥的共享密钥，staticPub 为服务端静态公钥
+func (h *Handshake) es(clientPub, staticPub []byte) ([]byte, error) {
+	if h.client {
+		return curve25519.X25519(h.priv[:], staticPub)
+	}
+	return curve25519.X25519(h.static, clientPub)
+}
+
+// credentialAEAD 加密凭证的 AEAD，密钥由 es 派生，每个临时密钥只使用一次，nonce 为 0
+func (h *Handshake) credentialAEAD(clientPub, staticPub []byte) (cipher.AEAD, error) {
+	es, err := h.es(clientPub, staticPub)
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, KeySize)
+	info := append(append([]byte("tcp-service e2e v2 credential"), clientPub...), staticPub...)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, es, nil, info), key); err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(key)
+}
+
+// credentialAD 加密凭证的附加数据：Con.ID 与完整的 offer
+// 删除 offer 中的算法后凭证无法解密，截获的凭证只能随原来的 offer 重放，而没有客户端临时私钥无法完成加密握手
+func credentialAD(id string, offer []byte) []byte {
+	return append([]byte(id), offer...)
+}
+
+// SealCredential 客户端用固定的服务端静态公钥加密凭证，id 为 Con.ID，offer 为 Sealed 已置位的 Offer 编码，均参与认证
+func (h *Handshake) SealCredential(id string, offer, credential []byte) ([]byte, error) {
+	if !h.Authenticated() {
+		return nil, ErrAuthentication
+	}
+	aead, err := h.credentialAEAD(h.pub, h.static)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, make([]byte, aead.NonceSize()), credential, credentialAD(id, offer)), nil
+}
+
+// OpenCredential 服务端解密客户端加密的凭证，offer 为 Con 中 OptionEncryption 的 value
+// 没有静态密钥、offer 无效或认证失败时返回 ErrDecrypt
+func (h *Handshake) OpenCredential(offer []byte, id string, sealed []byte) ([]byte, error) {
+	if h.client || h.static == nil {
+		return nil, ErrDecrypt
+	}
+	o, err := DecodeOffer(offer)
+	if err != nil || !o.Sealed {
+		return nil, ErrDecrypt
+	}
+	staticPub, err := PublicKey(h.static)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := h.credentialAEAD(o.Public, staticPub)
+	if err != nil {
+		return nil, err
+	}
+	credential, err := aead.Open(nil, make([]byte, aead.NonceSize()), sealed, credentialAD(id, offer))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return credential, nil
+}
+
+// derive 派生两个方向的密钥与密钥确认，offer 为 Con 中 OptionEncryption 的原始 value
+func (h *Handshake) derive(offer []byte, c Cipher, clientPub, serverPub, staticPub []byte) (c2s, s2c, confirm []byte, err error) {
+	peer := serverPub
+	if !h.client {
+		peer = clientPub
+	}
+	ikm, err := curve25519.X25519(h.priv[:], peer)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if staticPub != nil {
+		es, err := h.es(clientPub, staticPub)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		ikm = append(ikm, es...)
+	}
+	info := []byte("tcp-service e2e v2")
+	for _, b := range [][]byte{offer, {byte(c)}, serverPub, staticPub} {
+		info = append(info, b...)
+	}
+	keys := make([]byte, 2*KeySize+confirmSize)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, ikm, nil, info), keys); err != nil {
+		return nil, nil, nil, err
+	}
+	return keys[:KeySize], keys[KeySize : 2*KeySize], keys[2*KeySize:], nil
+}
+
+// Accept 服务端按客户端的 offer 与选定的算法完成密钥交换，返回 ConAck 的内容与本方发送、接收使用的密钥
+func (h *Handshake) Accept(offer []byte, c Cipher) (a Accept, send, recv []byte, err error) {
+	o, err := DecodeOffer(offer)
+	if err != nil {
+		return Accept{}, nil, nil, err
+	}
+	var staticPub []byte
+	if h.static != nil {
+		if staticPub, err = PublicKey(h.static); err != nil {
+			return Accept{}, nil, nil, err
+		}
+	}
+	c2s, s2c, confirm, err := h.derive(offer, c, o.Public, h.pub, staticPub)
+	if err != nil {
+		return Accept{}, nil, nil, err
+	}
+	return Accept{Cipher: c, Public: h.pub, Confirm: confirm, Static: staticPub}, s2c, c2s, nil
+}
+
+// Finish 客户端按 ConAck 的内容完成密钥交换，offer 为本方发送的 OptionEncryption 的 value
+// 固定了服务端静态公钥时，服务端未使用该静态密钥或密钥确认不一致返回 ErrAuthentication
+func (h *Handshake) Finish(offer []byte, a Accept) (send, recv []byte, err error) {
+	staticPub := a.Static
+	if h.static != nil {
+		if !bytes.Equal(a.Static, h.static) {
+			return nil, nil, fmt.Errorf("%w: unexpected server key", ErrAuthentication)
+		}
+		staticPub = h.static
+	}
+	c2s, s2c, confirm, err := h.derive(offer, a.Cipher, h.pub, a.Public, staticPub)
+	if err != nil {
+		return nil, nil, err
+	}
+	if subtle.ConstantTimeCompare(confirm, a.Confirm) != 1 {
+		return nil, nil, fmt.Errorf("%w: key confirmation mismatch", ErrAuthentication)
+	}
+	return c2s, s2c, nil
+}
+
+// direction 一个方向的加密状态
+type direction struct {
+	mu     sync.Mutex
+	cipher Cipher
+	aead   cipher.AEAD // 为 nil 表示该方向还没有开始加密
+	seq    uint64      // 下一个 frame 的序号
+}
+
+// set 切换密钥，序号从 0 开始
+func (d *direction) set(c Cipher, key []byte) error {
+	var aead cipher.AEAD
+	if c != None {
+		var err error
+		if aead, err = c.newAEAD(key); err != nil {
+			return err
+		}
+	}
+	d.mu.Lock()
+	d.cipher, d.aead, d.seq = c, aead, 0
+	d.mu.Unlock()
+	return nil
+}
+
+// Codec 加密 payload 的 frame 编解码器，实现 frame.FlagCodec
+// 发送与接收的密钥分别设置，开始加密之前原样收发，读写可在不同协程并发进行
+type Codec struct {
+	// Frame 底层的帧格式，需要能携带 frame.FlagEncrypted，nil 表示 frame.HeaderCodec
+	Frame frame.FlagCodec
+	// Observe 每解密一个 frame 调用一次，err 为解密失败的原因
+	Observe func(c Cipher, err error)
+
+	send direction
+	recv direction
+}
+
+// NewCodec 创建编解码器，两个方向默认都不加密
+func NewCodec() *Codec {
+	return &Codec{}
+}
+
+// SetSendKey 设置发送使用的算法与密钥，之后发送的 frame 都加密，None 表示不加密
+func (c *Codec) SetSendKey(ci Cipher, key []byte) error {
+	return c.send.set(ci, key)
+}
+
+// SetRecvKey 设置接收使用的算法与密钥，之后收到未加密的 frame 返回 ErrPlaintext，None 表示不加密
+func (c *Codec) SetRecvKey(ci Cipher, key []byte) error {
+	return c.recv.set(ci, key)
+}
+
+// Cipher 返回发送使用的算法
+func (c *Codec) Cipher() Cipher {
+	c.send.mu.Lock()
+	defer c.send.mu.Unlock()
+	return c.send.cipher
+}
+
+func (c *Codec) base() frame.FlagCodec {
+	if c.Frame != nil {
+		return c.Frame
+	}
+	return header
+}
+
+var header = frame.NewHeaderCodec()
+
+// nonce 由序号生成 nonce
+func nonce(aead cipher.AEAD, seq uint64) []byte {
+	n := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(n[len(n)-seqSize:], seq)
+	return n
+}
+
+// additionalData flags 中参与认证的部分，校验和在加密之后计算，不参与
+func additionalData(flags frame.Flags) []byte {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(flags&^frame.FlagChecksum))
+	return b[:]
+}
+
+// Encode 编码一个 frame，设置了发送密钥时加密
+func (c *Codec) Encode(w io.Writer, framePayload frame.Payload) error {
+	return c.EncodeFlags(w, framePayload, 0)
+}
+
+// EncodeFlags 同 Encode，加密时在 flags 中加上 frame.FlagEncrypted
+// 序号在写入之前分配，写失败时连接不再可用
+func (c *Codec) EncodeFlags(w io.Writer, framePayload frame.Payload, flags frame.Flags) error {
+	c.send.mu.Lock()
+	defer c.send.mu.Unlock()
+	aead := c.send.aead
+	if aead == nil {
+		return c.base().EncodeFlags(w, framePayload, flags)
+	}
+	flags |= frame.FlagEncrypted
+	seq := c.send.seq
+	c.send.seq++
+	buf := make([]byte, seqSize, seqSize+len(framePayload)+aead.Overhead())
+	binary.BigEndian.PutUint64(buf, seq)
+	buf = aead.Seal(buf, nonce(aead, seq), framePayload, additionalData(flags))
+	return c.base().EncodeFlags(w, buf, flags)
+}
+
+// Decode 解码一个 frame 并解密，压缩或带校验和的 frame 返回 frame.ErrUnsupportedFlags
+func (c *Codec) Decode(r io.Reader) (frame.Payload, error) {
+	p, flags, err := c.DecodeFlags(r)
+	if err != nil {
+		return nil, err
+	}
+	if flags&(frame.FlagCompressed|frame.FlagChecksum) != 0 {
+		return nil, frame.ErrUnsupportedFlags
+	}
+	return p, nil
+}
+
+// DecodeFlags 同 Decode，返回解密后的 payload，flags 不含 frame.FlagEncrypted
+func (c *Codec) DecodeFlags(r io.Reader) (frame.Payload, frame.Flags, error) {
+	buf, flags, err := c.base().DecodeFlags(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	c.recv.mu.Lock()
+	defer c.recv.mu.Unlock()
+	aead := c.recv.aead
+	if flags&frame.FlagEncrypted == 0 {
+		if aead != nil {
+			return nil, 0, c.fail(ErrPlaintext)
+		}
+		return buf, flags, nil
+	}
+	if aead == nil {
+		return nil, 0, c.fail(ErrDecrypt)
+	}
+	if len(buf) < seqSize+aead.Overhead() {
+		return nil, 0, frame.ErrInvalidLength
+	}
+	seq := binary.BigEndian.Uint64(buf)
+	if seq != c.recv.seq {
+		return nil, 0, c.fail(fmt.Errorf("%w: got %d, want %d", ErrReplay, seq, c.recv.seq))
+	}
+	p, err := aead.Open(buf[seqSize:seqSize], nonce(aead, seq), buf[seqSize:], additionalData(flags))
+	if err != nil {
+		return nil, 0, c.fail(ErrDecrypt)
+	}
+	c.recv.seq++
+	if c.Observe != nil {
+		c.Observe(c.recv.cipher, nil)
+	}
+	return p, flags &^ frame.FlagEncrypted, nil
+}
+
+// fail 记录解密失败，调用时持有 recv.mu
+func (c *Codec) fail(err error) error {
+	if c.Observe != nil {
+		c.Observe(c.recv.cipher, err)
+	}
+	return err
+}
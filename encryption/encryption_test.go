@@ -0,0 +1,411 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/CoderI421/tcp-service/frame"
+)
+
+// exchange 完成一次密钥交换，返回客户端与服务端的密钥
+func exchange(t *testing.T, c Cipher) (cs, cr, ss, sr []byte) {
+	t.Helper()
+	priv, pub, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, err := NewClientHandshake(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh, err := NewServerHandshake(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offer := Offer{Public: ch.Public(), Ciphers: []Cipher{c}}.Encode()
+	a, ss, sr, err := sh.Accept(offer, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs, cr, err = ch.Finish(offer, a); err != nil {
+		t.Fatal(err)
+	}
+	return cs, cr, ss, sr
+}
+
+// pair 完成一次密钥交换，返回客户端与服务端的编解码器
+func pair(t *testing.T, c Cipher) (*Codec, *Codec) {
+	t.Helper()
+	cs, cr, ss, sr := exchange(t, c)
+	if !bytes.Equal(cs, sr) || !bytes.Equal(cr, ss) || bytes.Equal(cs, cr) {
+		t.Fatalf("Keys() client send/recv do not match server recv/send")
+	}
+	client, server := NewCodec(), NewCodec()
+	if err := client.SetSendKey(c, cs); err != nil {
+		t.Fatal(err)
+	}
+	client.SetRecvKey(c, cr)
+	server.SetSendKey(c, ss)
+	server.SetRecvKey(c, sr)
+	return client, server
+}
+
+func TestCodec(t *testing.T) {
+	payloads := [][]byte{[]byte("hello tcp-service"), {}, bytes.Repeat([]byte("x"), 4096)}
+	for _, c := range []Cipher{AESGCM, ChaCha20Poly1305} {
+		t.Run(c.String(), func(t *testing.T) {
+			client, server := pair(t, c)
+			var buf bytes.Buffer
+			for _, p := range payloads {
+				if err := client.Encode(&buf, p); err != nil {
+					t.Fatalf("Encode() error = %v", err)
+				}
+			}
+			raw, flags, err := frame.NewHeaderCodec().DecodeFlags(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("DecodeFlags() error = %v", err)
+			}
+			if flags&frame.FlagEncrypted == 0 {
+				t.Errorf("Encode() flags = %v, want FlagEncrypted", flags)
+			}
+			if bytes.Contains(raw, payloads[0]) {
+				t.Errorf("Encode() payload is not encrypted")
+			}
+			for _, want := range payloads {
+				got, err := server.Decode(&buf)
+				if err != nil {
+					t.Fatalf("Decode() error = %v", err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("Decode() = %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCodec_Decode(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, KeySize)
+	client := NewCodec()
+	if err := client.SetSendKey(AESGCM, key); err != nil {
+		t.Fatal(err)
+	}
+	frames := make([][]byte, 2)
+	for i := range frames {
+		var buf bytes.Buffer
+		if err := client.Encode(&buf, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		frames[i] = buf.Bytes()
+	}
+	corrupt := func(b []byte, i int) []byte {
+		b = append([]byte(nil), b...)
+		b[i] ^= 0x01
+		return b
+	}
+	header := func(flags frame.Flags, payload []byte) []byte {
+		var buf bytes.Buffer
+		if err := frame.NewHeaderCodec().EncodeFlags(&buf, payload, flags); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	join := func(frames ...[]byte) []byte {
+		return bytes.Join(frames, nil)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{name: "InOrder", data: join(frames[0], frames[1])},
+		{name: "Replay", data: join(frames[0], frames[0]), wantErr: ErrReplay},
+		{name: "Dropped", data: frames[1], wantErr: ErrReplay},
+		{name: "Tampered", data: corrupt(frames[0], len(frames[0])-1), wantErr: ErrDecrypt},
+		{name: "FlagsTampered", data: corrupt(frames[0], 3), wantErr: ErrDecrypt},
+		{name: "Plaintext", data: header(0, []byte("hello")), wantErr: ErrPlaintext},
+		{name: "Short", data: header(frame.FlagEncrypted, []byte{0, 0, 0, 0, 0, 0, 0, 0}), wantErr: frame.ErrInvalidLength},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 每个用例使用新的接收方，序号从 0 开始
+			server := NewCodec()
+			server.SetRecvKey(AESGCM, key)
+			var err error
+			r := bytes.NewReader(tt.data)
+			for r.Len() > 0 && err == nil {
+				_, err = server.Decode(r)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Decode() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCodec_NoKey(t *testing.T) {
+	// 开始加密之前原样收发，没有密钥时不能解密
+	client, _ := pair(t, ChaCha20Poly1305)
+	var buf bytes.Buffer
+	if err := client.Encode(&buf, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCodec().Decode(&buf); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Decode() error = %v, want %v", err, ErrDecrypt)
+	}
+
+	plain := NewCodec()
+	if err := plain.Encode(&buf, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewCodec().Decode(&buf)
+	if err != nil || string(got) != "hello" {
+		t.Errorf("Decode() = %q, %v, want hello", got, err)
+	}
+}
+
+func TestOption(t *testing.T) {
+	h, err := NewClientHandshake(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Offer{Public: h.Public(), Ciphers: []Cipher{ChaCha20Poly1305, AESGCM}, Sealed: true}
+	got, err := DecodeOffer(want.Encode())
+	if err != nil {
+		t.Fatalf("DecodeOffer() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeOffer() = %+v, want %+v", got, want)
+	}
+	if _, err = DecodeOffer([]byte{0, 1}); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("DecodeOffer() error = %v, want %v", err, ErrInvalidOption)
+	}
+
+	confirm := bytes.Repeat([]byte{2}, confirmSize)
+	tests := []struct {
+		name    string
+		value   []byte
+		want    Accept
+		wantErr error
+	}{
+		{name: "Accepted", value: Accept{Cipher: AESGCM, Public: h.Public(), Confirm: confirm}.Encode(), want: Accept{Cipher: AESGCM, Public: h.Public(), Confirm: confirm}},
+		{name: "Static", value: Accept{Cipher: AESGCM, Public: h.Public(), Confirm: confirm, Static: h.Public()}.Encode(), want: Accept{Cipher: AESGCM, Public: h.Public(), Confirm: confirm, Static: h.Public()}},
+		{name: "None", value: Accept{Cipher: None, Public: h.Public()}.Encode(), want: Accept{}},
+		{name: "NoConfirm", value: append([]byte{byte(AESGCM)}, h.Public()...), wantErr: ErrInvalidOption},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeAccept(tt.value)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DecodeAccept() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeAccept() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	priv, pub, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPriv, _, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// mitm 中间人用自己的临时密钥分别与客户端、服务端完成交换，替换双方的临时公钥
+	mitm := func(offer []byte, a Accept) ([]byte, Accept) {
+		m, err := NewClientHandshake(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o, _ := DecodeOffer(offer)
+		o.Public = m.Public()
+		a.Public = m.Public()
+		return o.Encode(), a
+	}
+
+	tests := []struct {
+		name      string
+		pinned    []byte // 客户端固定的服务端静态公钥
+		serverKey []byte // 服务端静态私钥
+		tamper    func(offer []byte, a Accept) ([]byte, Accept)
+		wantErr   error
+	}{
+		{name: "Authenticated", pinned: pub, serverKey: priv},
+		{name: "Unauthenticated", serverKey: priv},
+		{name: "NoStaticKey"},
+		{name: "KeySubstitution", pinned: pub, serverKey: priv, tamper: mitm, wantErr: ErrAuthentication},
+		{name: "WrongServerKey", pinned: pub, serverKey: otherPriv, wantErr: ErrAuthentication},
+		{name: "ServerWithoutKey", pinned: pub, wantErr: ErrAuthentication},
+		{name: "StaticKeyStripped", pinned: pub, serverKey: priv, wantErr: ErrAuthentication, tamper: func(offer []byte, a Accept) ([]byte, Accept) {
+			a.Static = nil
+			return offer, a
+		}},
+		{name: "Downgrade", pinned: pub, serverKey: priv, wantErr: ErrAuthentication, tamper: func(offer []byte, a Accept) ([]byte, Accept) {
+			o, _ := DecodeOffer(offer)
+			o.Ciphers = o.Ciphers[1:]
+			return o.Encode(), a
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, err := NewClientHandshake(tt.pinned)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sh, err := NewServerHandshake(tt.serverKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			offer := Offer{Public: ch.Public(), Ciphers: []Cipher{ChaCha20Poly1305, AESGCM}}.Encode()
+			// 服务端收到的 offer 与客户端收到的 ConAck 可能被篡改
+			serverOffer := offer
+			if tt.tamper != nil {
+				serverOffer, _ = tt.tamper(offer, Accept{})
+			}
+			a, ss, sr, err := sh.Accept(serverOffer, AESGCM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.tamper != nil {
+				_, a = tt.tamper(offer, a)
+			}
+			cs, cr, err := ch.Finish(offer, a)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Finish() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (!bytes.Equal(cs, sr) || !bytes.Equal(cr, ss)) {
+				t.Errorf("Finish() keys do not match Accept()")
+			}
+		})
+	}
+}
+
+func TestHandshake_Credential(t *testing.T) {
+	priv, pub, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, err := NewClientHandshake(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offer := Offer{Public: ch.Public(), Ciphers: []Cipher{AESGCM, ChaCha20Poly1305}, Sealed: true}.Encode()
+	sealed, err := ch.SealCredential("00000001", offer, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("secret")) {
+		t.Errorf("SealCredential() credential is not encrypted")
+	}
+	unpinned, err := NewClientHandshake(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = unpinned.SealCredential("00000001", offer, []byte("secret")); !errors.Is(err, ErrAuthentication) {
+		t.Errorf("SealCredential() without server key error = %v, want %v", err, ErrAuthentication)
+	}
+
+	tests := []struct {
+		name    string
+		key     []byte
+		id      string
+		offer   []byte
+		want    []byte
+		wantErr error
+	}{
+		{name: "Opened", key: priv, id: "00000001", offer: offer, want: []byte("secret")},
+		{name: "OtherID", key: priv, id: "00000002", offer: offer, wantErr: ErrDecrypt},
+		{name: "NoKey", id: "00000001", offer: offer, wantErr: ErrDecrypt},
+		// 凭证与 offer 绑定，删除算法后无法解密
+		{name: "CiphersStripped", key: priv, id: "00000001", offer: Offer{Public: ch.Public(), Sealed: true}.Encode(), wantErr: ErrDecrypt},
+		{name: "CipherRemoved", key: priv, id: "00000001", offer: Offer{Public: ch.Public(), Ciphers: []Cipher{ChaCha20Poly1305}, Sealed: true}.Encode(), wantErr: ErrDecrypt},
+		{name: "NotSealed", key: priv, id: "00000001", offer: Offer{Public: ch.Public(), Ciphers: []Cipher{AESGCM, ChaCha20Poly1305}}.Encode(), wantErr: ErrDecrypt},
+		{name: "InvalidOffer", key: priv, id: "00000001", offer: []byte{offerSealed}, wantErr: ErrDecrypt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sh, err := NewServerHandshake(tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := sh.OpenCredential(tt.offer, tt.id, sealed)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("OpenCredential() error = %v, want %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("OpenCredential() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	priv, pub, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseKey(" " + hex.EncodeToString(priv) + "\n")
+	if err != nil || !bytes.Equal(got, priv) {
+		t.Fatalf("ParseKey() = %x, %v, want %x", got, err, priv)
+	}
+	if p, _ := PublicKey(got); !bytes.Equal(p, pub) {
+		t.Errorf("PublicKey() = %x, want %x", p, pub)
+	}
+	for _, s := range []string{"", "zz", hex.EncodeToString(priv[:16])} {
+		if _, err = ParseKey(s); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("ParseKey(%q) error = %v, want %v", s, err, ErrInvalidKey)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name      string
+		offered   []Cipher
+		supported []Cipher
+		want      Cipher
+	}{
+		{name: "ClientPreference", offered: []Cipher{ChaCha20Poly1305, AESGCM}, supported: []Cipher{AESGCM, ChaCha20Poly1305}, want: ChaCha20Poly1305},
+		{name: "NoCommon", offered: []Cipher{AESGCM}, supported: []Cipher{ChaCha20Poly1305}, want: None},
+		{name: "Unknown", offered: []Cipher{Cipher(9)}, supported: []Cipher{Cipher(9)}, want: None},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Negotiate(tt.offered, tt.supported); got != tt.want {
+				t.Errorf("Negotiate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseList(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []Cipher
+		wantErr bool
+	}{
+		{name: "Empty", s: "", want: nil},
+		{name: "List", s: "chacha20-poly1305, AES-GCM", want: []Cipher{ChaCha20Poly1305, AESGCM}},
+		{name: "Unknown", s: "des", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseList(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseList() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,452 @@
+package faultproxy
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/CoderI421/tcp-service/checksum"
+	"github.com/CoderI421/tcp-service/compression"
+	"github.com/CoderI421/tcp-service/frame"
+	"github.com/CoderI421/tcp-service/transport"
+)
+
+/*
+故障注入代理
+
+位于客户端与服务端之间，按 frame 转发，对命中规则的 frame 注入故障：
+延迟、丢弃、重复、改写字节、拆成多次小的写入、调换顺序、写入一半后断开
+规则按方向与 commandID 匹配，见 Rule
+
+用于测试客户端在网络异常、服务端行为异常时的表现，不应用于生产环境
+*/
+
+// ErrProxyClosed Close 之后 Serve 返回的错误
+var ErrProxyClosed = errors.New("faultproxy: proxy closed")
+
+// Proxy 故障注入代理，规则可在运行时替换，并发安全
+type Proxy struct {
+	upstream string
+
+	mu        sync.Mutex
+	rules     []Rule
+	hits      []int // 每条规则已命中的次数
+	rng       *rand.Rand
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// New 创建转发到 upstream 的代理，upstream 格式见 transport 包
+func New(upstream string, rules []Rule) (*Proxy, error) {
+	p := &Proxy{
+		upstream:  upstream,
+		rng:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+	if err := p.SetRules(rules); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// SetRules 替换规则，命中次数重新计算
+func (p *Proxy) SetRules(rules []Rule) error {
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rules = append([]Rule(nil), rules...)
+	p.hits = make([]int, len(rules))
+	return nil
+}
+
+// Seed 设置随机数种子，用于复现概率命中与改写的字节
+func (p *Proxy) Seed(seed int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rng = rand.New(rand.NewSource(seed))
+}
+
+// Hits 返回每条规则已命中的次数
+func (p *Proxy) Hits() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]int(nil), p.hits...)
+}
+
+// ListenAndServe 在 addr 上监听并调用 Serve
+func (p *Proxy) ListenAndServe(addr string) error {
+	l, err := transport.Listen(addr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(l)
+}
+
+// Serve 接受客户端连接并转发，Close 之后返回 ErrProxyClosed
+func (p *Proxy) Serve(l net.Listener) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		l.Close()
+		return ErrProxyClosed
+	}
+	p.listeners[l] = struct{}{}
+	p.mu.Unlock()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			p.mu.Lock()
+			closed := p.closed
+			delete(p.listeners, l)
+			p.mu.Unlock()
+			if closed {
+				return ErrProxyClosed
+			}
+			return err
+		}
+		if !p.track(c, true) {
+			c.Close()
+			continue
+		}
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			defer p.track(c, false)
+			p.handle(c)
+		}()
+	}
+}
+
+// Close 关闭所有 listener 与连接，并等待转发协程退出
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	for l := range p.listeners {
+		l.Close()
+	}
+	for c := range p.conns {
+		c.Close()
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+	return nil
+}
+
+// track 登记或移除连接，已关闭时登记失败
+func (p *Proxy) track(c net.Conn, add bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !add {
+		delete(p.conns, c)
+		return true
+	}
+	if p.closed {
+		return false
+	}
+	p.conns[c] = struct{}{}
+	return true
+}
+
+func (p *Proxy) handle(client net.Conn) {
+	defer client.Close()
+	server, err := transport.Dial(p.upstream)
+	if err != nil {
+		return
+	}
+	if !p.track(server, true) {
+		server.Close()
+		return
+	}
+	defer p.track(server, false)
+	defer server.Close()
+
+	closeBoth := func() {
+		client.Close()
+		server.Close()
+	}
+	done := make(chan struct{}, 2)
+	go func() {
+		p.pipe(&link{dir: DirC2S, src: client, dst: server, closeBoth: closeBoth})
+		done <- struct{}{}
+	}()
+	go func() {
+		p.pipe(&link{dir: DirS2C, src: server, dst: client, closeBoth: closeBoth})
+		done <- struct{}{}
+	}()
+	// 任一方向结束时关闭两个连接，另一方向随之结束
+	<-done
+	closeBoth()
+	<-done
+}
+
+// plan 一个 frame 命中的所有规则叠加后的处理方式
+type plan struct {
+	delay        time.Duration // 在读取时间上增加的延迟
+	drop         bool
+	duplicate    bool
+	corrupt      []int // 改写的 payload 下标
+	corruptXor   []byte
+	split        int
+	splitDelay   time.Duration
+	reorder      bool
+	reorderDelay time.Duration
+	cut          int // 断开前写入的字节数，0 表示不断开
+}
+
+// plan 按规则计算 raw（含帧头的完整 frame）的处理方式，hdr 为帧头的长度
+func (p *Proxy) plan(dir Direction, raw []byte, hdr int) plan {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var pl plan
+	for i, r := range p.rules {
+		if !r.matches(dir, raw[hdr]) {
+			continue
+		}
+		if r.Limit > 0 && p.hits[i] >= r.Limit {
+			continue
+		}
+		if r.Probability > 0 && p.rng.Float64() >= r.Probability {
+			continue
+		}
+		p.hits[i]++
+
+		switch r.Action {
+		case ActionDelay:
+			pl.delay += r.Delay
+			if r.Jitter > 0 {
+				pl.delay += time.Duration(p.rng.Int63n(int64(r.Jitter)))
+			}
+		case ActionDrop:
+			pl.drop = true
+		case ActionDuplicate:
+			pl.duplicate = true
+		case ActionCorrupt:
+			n := r.Size
+			if n == 0 {
+				n = 1
+			}
+			// 不改写帧头，以免之后的数据无法对齐 frame 边界
+			for j := 0; j < n; j++ {
+				pl.corrupt = append(pl.corrupt, hdr+p.rng.Intn(len(raw)-hdr))
+				pl.corruptXor = append(pl.corruptXor, byte(p.rng.Intn(255)+1))
+			}
+		case ActionSplit:
+			pl.split = r.Size
+			if pl.split == 0 {
+				pl.split = 1
+			}
+			pl.splitDelay = r.Delay
+			if pl.splitDelay == 0 {
+				pl.splitDelay = defaultSplitDelay
+			}
+		case ActionReorder:
+			pl.reorder = true
+			pl.reorderDelay = r.Delay
+			if pl.reorderDelay == 0 {
+				pl.reorderDelay = defaultReorderDelay
+			}
+		case ActionCut:
+			n := r.Size
+			if n == 0 {
+				n = len(raw) / 2
+			}
+			if n >= len(raw) {
+				n = len(raw) - 1
+			}
+			if n < 1 {
+				n = 1
+			}
+			pl.cut = n
+		}
+	}
+	return pl
+}
+
+// link 一个方向的转发
+type link struct {
+	dir       Direction
+	src, dst  net.Conn
+	closeBoth func()
+
+	wmu   sync.Mutex // 保护 dst 的写入与以下字段
+	held  []byte     // reorder 扣留的 frame
+	timer *time.Timer
+	gen   uint64 // 每次扣留加 1，用于识别过期的定时器
+}
+
+// pending 已读取、等待写出的 frame
+type pending struct {
+	raw []byte
+	pl  plan
+	due time.Time // 注入延迟后的写出时间
+}
+
+// pipe 读协程按规则生成处理方式后交给写协程，延迟的 frame 不阻塞之后 frame 的读取
+// 写出顺序与读取顺序一致，之后的 frame 不会早于之前被延迟的 frame
+func (p *Proxy) pipe(l *link) {
+	queue := make(chan pending, 1024)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.writeLoop(queue)
+	}()
+	defer func() {
+		close(queue)
+		<-done
+	}()
+
+	// 压缩的 frame 解压后按未压缩的形式转发，规则才能匹配 commandID，双方都能接收未压缩的 frame
+	// 转发时使用与 src 相同的帧格式；带校验和的 frame 校验后重新计算，改写的字节能被接收方发现
+	// 端到端加密的 frame 无法解密，不匹配规则，原样转发
+	base := frame.NewAutoCodec()
+	sum := checksum.NewCodec()
+	sum.Frame = base
+	var alg checksum.Algorithm // 当前 frame 的校验和算法
+	sum.Observe = func(a checksum.Algorithm, ok bool) { alg = a }
+	codec := compression.NewCodec()
+	codec.Frame = sum
+	enc := checksum.NewCodec()
+	enc.Frame = base
+	r := bufio.NewReader(l.src)
+	for {
+		alg = checksum.None
+		payload, flags, err := codec.DecodeFlags(r)
+		if err != nil {
+			return
+		}
+		enc.SetAlgorithm(alg)
+		raw, hdr, err := encode(enc, payload, flags, alg)
+		if err != nil {
+			return
+		}
+		var pl plan
+		// 没有 commandID 的 frame 无法匹配规则，原样转发
+		if len(payload) > 0 && flags&frame.FlagEncrypted == 0 {
+			pl = p.plan(l.dir, raw, hdr)
+		}
+		select {
+		case queue <- pending{raw: raw, pl: pl, due: time.Now().Add(pl.delay)}:
+		case <-done:
+			return
+		}
+	}
+}
+
+// writeLoop 按处理方式写出 frame，出错或断开连接时返回
+func (l *link) writeLoop(queue <-chan pending) {
+	defer l.release(0)
+	for f := range queue {
+		raw, pl := f.raw, f.pl
+		if d := time.Until(f.due); d > 0 {
+			time.Sleep(d)
+		}
+		if pl.drop {
+			continue
+		}
+		for i, j := range pl.corrupt {
+			raw[j] ^= pl.corruptXor[i]
+		}
+		if pl.cut > 0 {
+			l.release(0)
+			l.write(raw[:pl.cut], pl.split, pl.splitDelay)
+			l.closeBoth()
+			return
+		}
+		if pl.reorder && l.hold(raw, pl.reorderDelay) {
+			continue
+		}
+		if l.write(raw, pl.split, pl.splitDelay) != nil {
+			l.closeBoth()
+			return
+		}
+		if pl.duplicate && l.write(raw, pl.split, pl.splitDelay) != nil {
+			l.closeBoth()
+			return
+		}
+		// 扣留的 frame 在下一个 frame 之后发送
+		if l.release(0) != nil {
+			l.closeBoth()
+			return
+		}
+	}
+}
+
+// encode 生成含帧头的完整 frame，返回 payload 之前的长度（帧头与校验和算法）
+func encode(codec frame.FlagCodec, payload []byte, flags frame.Flags, alg checksum.Algorithm) ([]byte, int, error) {
+	var buf bytes.Buffer
+	if err := codec.EncodeFlags(&buf, payload, flags); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), buf.Len() - len(payload) - alg.Size(), nil
+}
+
+// write 写入 raw，split > 0 时每次写入 split 个字节，之间间隔 delay
+func (l *link) write(raw []byte, split int, delay time.Duration) error {
+	l.wmu.Lock()
+	defer l.wmu.Unlock()
+	return l.writeLocked(raw, split, delay)
+}
+
+func (l *link) writeLocked(raw []byte, split int, delay time.Duration) error {
+	if split <= 0 {
+		_, err := l.dst.Write(raw)
+		return err
+	}
+	for len(raw) > 0 {
+		n := split
+		if n > len(raw) {
+			n = len(raw)
+		}
+		if _, err := l.dst.Write(raw[:n]); err != nil {
+			return err
+		}
+		raw = raw[n:]
+		if len(raw) > 0 {
+			time.Sleep(delay)
+		}
+	}
+	return nil
+}
+
+// hold 扣留 raw，超过 delay 仍没有下一个 frame 时发送；已有扣留的 frame 时不扣留，返回 false
+func (l *link) hold(raw []byte, delay time.Duration) bool {
+	l.wmu.Lock()
+	defer l.wmu.Unlock()
+	if l.held != nil {
+		return false
+	}
+	l.held = raw
+	l.gen++
+	gen := l.gen
+	l.timer = time.AfterFunc(delay, func() { l.release(gen) })
+	return true
+}
+
+// release 发送扣留的 frame，由定时器触发时 gen 为扣留时的序号，已发送过的不再处理
+func (l *link) release(gen uint64) error {
+	l.wmu.Lock()
+	defer l.wmu.Unlock()
+	if l.held == nil || gen != 0 && gen != l.gen {
+		return nil
+	}
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	raw := l.held
+	l.held = nil
+	return l.writeLocked(raw, 0, 0)
+}
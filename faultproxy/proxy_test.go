@@ -0,0 +1,329 @@
+package faultproxy
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CoderI421/tcp-service/checksum"
+	"github.com/CoderI421/tcp-service/encryption"
+	"github.com/CoderI421/tcp-service/frame"
+	"github.com/CoderI421/tcp-service/packet"
+)
+
+// startEcho 启动按 frame 原样回显的上游服务，返回地址
+func startEcho(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				codec := frame.NewCodec()
+				r := bufio.NewReader(c)
+				for {
+					payload, err := codec.Decode(r)
+					if err != nil {
+						return
+					}
+					if codec.Encode(c, payload) != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// startProxy 启动转发到回显服务的代理，返回代理与连接到代理的客户端
+func startProxy(t *testing.T, rules []Rule) (*Proxy, net.Conn) {
+	t.Helper()
+	p, err := New(startEcho(t), rules)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	p.Seed(1)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	go p.Serve(l)
+	t.Cleanup(func() { p.Close() })
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return p, c
+}
+
+func mustEncode(t *testing.T, p packet.Packet) []byte {
+	t.Helper()
+	b, err := packet.Encode(p)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	return b
+}
+
+// exchange 发送 sends，读取回显直到连接在 wait 内没有新数据或被关闭
+func exchange(t *testing.T, c net.Conn, sends [][]byte, wait time.Duration) ([][]byte, error) {
+	t.Helper()
+	codec := frame.NewCodec()
+	for _, s := range sends {
+		if err := codec.Encode(c, s); err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+	}
+	var got [][]byte
+	r := bufio.NewReader(c)
+	for {
+		c.SetReadDeadline(time.Now().Add(wait))
+		payload, err := codec.Decode(r)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				return got, nil
+			}
+			return got, err
+		}
+		got = append(got, payload)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	con := mustEncode(t, &packet.Con{ID: "00000001"})
+	sub := mustEncode(t, &packet.Submit{ID: "00000002", Payload: []byte("hello")})
+	sub2 := mustEncode(t, &packet.Submit{ID: "00000003", Payload: []byte("world")})
+	submits := []uint8{packet.CommandSubmit}
+
+	tests := []struct {
+		name  string
+		rules []Rule
+		sends [][]byte
+		want  [][]byte
+	}{
+		{name: "None", sends: [][]byte{con, sub}, want: [][]byte{con, sub}},
+		{name: "Drop", rules: []Rule{{Dir: DirC2S, Commands: submits, Action: ActionDrop}},
+			sends: [][]byte{con, sub, sub2}, want: [][]byte{con}},
+		{name: "DropLimit", rules: []Rule{{Commands: submits, Limit: 1, Action: ActionDrop}},
+			sends: [][]byte{sub, sub2}, want: [][]byte{sub2}},
+		{name: "DropOtherDir", rules: []Rule{{Dir: DirS2C, Commands: []uint8{packet.CommandConn}, Action: ActionDrop}},
+			sends: [][]byte{con, sub}, want: [][]byte{sub}},
+		{name: "Duplicate", rules: []Rule{{Dir: DirS2C, Commands: submits, Action: ActionDuplicate}},
+			sends: [][]byte{con, sub}, want: [][]byte{con, sub, sub}},
+		{name: "Reorder", rules: []Rule{{Dir: DirC2S, Commands: submits, Limit: 1, Action: ActionReorder}},
+			sends: [][]byte{sub, con, sub2}, want: [][]byte{con, sub, sub2}},
+		{name: "ReorderTimeout", rules: []Rule{{Dir: DirC2S, Commands: submits, Action: ActionReorder, Delay: 10 * time.Millisecond}},
+			sends: [][]byte{con, sub}, want: [][]byte{con, sub}},
+		{name: "Split", rules: []Rule{{Action: ActionSplit, Size: 3}},
+			sends: [][]byte{con, sub}, want: [][]byte{con, sub}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, c := startProxy(t, tt.rules)
+			got, err := exchange(t, c, tt.sends, 200*time.Millisecond)
+			if err != nil {
+				t.Fatalf("exchange() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if len(tt.rules) > 0 && p.Hits()[0] == 0 {
+				t.Errorf("Hits() = %v", p.Hits())
+			}
+		})
+	}
+}
+
+func TestProxy_Corrupt(t *testing.T) {
+	sub := mustEncode(t, &packet.Submit{ID: "00000002", Payload: []byte("hello")})
+	_, c := startProxy(t, []Rule{{Dir: DirC2S, Action: ActionCorrupt, Size: 2}})
+	got, err := exchange(t, c, [][]byte{sub}, 200*time.Millisecond)
+	if err != nil || len(got) != 1 {
+		t.Fatalf("exchange() = %q, %v", got, err)
+	}
+	if len(got[0]) != len(sub) || bytes.Equal(got[0], sub) {
+		t.Errorf("got %q, want same length corrupted %q", got[0], sub)
+	}
+	diff := 0
+	for i := range sub {
+		if got[0][i] != sub[i] {
+			diff++
+		}
+	}
+	if diff < 1 || diff > 2 {
+		t.Errorf("%d bytes corrupted, want 1 or 2", diff)
+	}
+}
+
+func TestProxy_Cut(t *testing.T) {
+	con := mustEncode(t, &packet.Con{ID: "00000001"})
+	sub := mustEncode(t, &packet.Submit{ID: "00000002", Payload: []byte("hello")})
+	_, c := startProxy(t, []Rule{{Dir: DirS2C, Commands: []uint8{packet.CommandSubmit}, Action: ActionCut}})
+	got, err := exchange(t, c, [][]byte{con, sub}, time.Second)
+	if !reflect.DeepEqual(got, [][]byte{con}) {
+		t.Errorf("got %q, want only Con", got)
+	}
+	// 半个 frame 之后连接被关闭
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("exchange() error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestProxy_Delay(t *testing.T) {
+	con := mustEncode(t, &packet.Con{ID: "00000001"})
+	_, c := startProxy(t, []Rule{{Dir: DirC2S, Action: ActionDelay, Delay: 50 * time.Millisecond}})
+	start := time.Now()
+	got, err := exchange(t, c, [][]byte{con}, 500*time.Millisecond)
+	if err != nil || len(got) != 1 {
+		t.Fatalf("exchange() = %q, %v", got, err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("elapsed %v, want >= 50ms", d)
+	}
+}
+
+func TestRule_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    Rule
+		wantErr bool
+	}{
+		{name: "OK", rule: Rule{Dir: DirS2C, Action: ActionReorder, Probability: 0.5}},
+		{name: "Action", rule: Rule{Action: "explode"}, wantErr: true},
+		{name: "Dir", rule: Rule{Dir: "up", Action: ActionDrop}, wantErr: true},
+		{name: "Probability", rule: Rule{Action: ActionDrop, Probability: 2}, wantErr: true},
+		{name: "Size", rule: Rule{Action: ActionSplit, Size: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rule.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidRule) {
+				t.Errorf("Validate() error = %v, want %v", err, ErrInvalidRule)
+			}
+		})
+	}
+}
+
+func TestProxy_CorruptChecksum(t *testing.T) {
+	// 上游按校验和解码，改写的字节应被发现
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer l.Close()
+	errc := make(chan error, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer c.Close()
+		_, err = checksum.NewCodec().Decode(bufio.NewReader(c))
+		errc <- err
+	}()
+
+	p, err := New(l.Addr().String(), []Rule{{Dir: DirC2S, Action: ActionCorrupt, Size: 1}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	p.Seed(1)
+	pl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	go p.Serve(pl)
+	defer p.Close()
+	c, err := net.Dial("tcp", pl.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+
+	enc := checksum.NewCodec()
+	enc.SetAlgorithm(checksum.CRC32C)
+	if err = enc.Encode(c, mustEncode(t, &packet.Submit{ID: "00000002", Payload: []byte("hello")})); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	select {
+	case err = <-errc:
+		if !errors.Is(err, frame.ErrChecksumMismatch) {
+			t.Errorf("upstream Decode() error = %v, want %v", err, frame.ErrChecksumMismatch)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream got no frame")
+	}
+}
+
+func TestProxy_Encrypted(t *testing.T) {
+	// 加密的 frame 无法匹配规则，原样转发，上游能够解密
+	key := bytes.Repeat([]byte{1}, encryption.KeySize)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer l.Close()
+	errc := make(chan error, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer c.Close()
+		dec := encryption.NewCodec()
+		dec.SetRecvKey(encryption.AESGCM, key)
+		_, err = dec.Decode(bufio.NewReader(c))
+		errc <- err
+	}()
+
+	p, err := New(l.Addr().String(), []Rule{{Dir: DirC2S, Action: ActionCorrupt, Size: 1}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	pl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	go p.Serve(pl)
+	defer p.Close()
+	c, err := net.Dial("tcp", pl.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+
+	enc := encryption.NewCodec()
+	enc.SetSendKey(encryption.AESGCM, key)
+	if err = enc.Encode(c, mustEncode(t, &packet.Submit{ID: "00000002", Payload: []byte("hello")})); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	select {
+	case err = <-errc:
+		if err != nil {
+			t.Errorf("upstream Decode() error = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream got no frame")
+	}
+}
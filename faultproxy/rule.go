@@ -0,0 +1,88 @@
+package faultproxy
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Direction frame 的方向
+type Direction string
+
+const (
+	DirAny Direction = ""    // 两个方向
+	DirC2S Direction = "c2s" // 客户端到服务端
+	DirS2C Direction = "s2c" // 服务端到客户端
+)
+
+// Action 命中规则后对 frame 的处理
+type Action string
+
+const (
+	ActionDelay     Action = "delay"     // 延迟 Delay + [0, Jitter) 后转发，顺序不变
+	ActionDrop      Action = "drop"      // 丢弃
+	ActionDuplicate Action = "duplicate" // 转发两次
+	ActionCorrupt   Action = "corrupt"   // 随机改写 payload 中的 Size 个字节（默认 1），长度头不变
+	ActionSplit     Action = "split"     // 每次写入 Size 个字节（默认 1），写入之间间隔 Delay（默认 1ms）
+	ActionReorder   Action = "reorder"   // 扣留到同方向的下一个 frame 之后发送，最长扣留 Delay（默认 1s）
+	ActionCut       Action = "cut"       // 只写入前 Size 个字节（默认一半）后断开连接
+)
+
+// 各 Action 参数的默认值
+const (
+	defaultSplitDelay   = time.Millisecond
+	defaultReorderDelay = time.Second
+)
+
+// ErrInvalidRule 规则不合法
+var ErrInvalidRule = errors.New("faultproxy: invalid rule")
+
+// Rule 一条故障注入规则，一个 frame 可以同时命中多条规则，按各自的 Action 叠加处理
+type Rule struct {
+	Dir         Direction `yaml:"dir"`         // 匹配的方向，为空表示两个方向
+	Commands    []uint8   `yaml:"commands"`    // 匹配的 commandID，为空表示所有 frame
+	Probability float64   `yaml:"probability"` // 命中的概率，0 表示总是命中
+	Limit       int       `yaml:"limit"`       // 最多命中的次数，0 表示不限
+
+	Action Action        `yaml:"action"`
+	Delay  time.Duration `yaml:"delay"`
+	Jitter time.Duration `yaml:"jitter"`
+	Size   int           `yaml:"size"`
+}
+
+// Validate 校验规则
+func (r Rule) Validate() error {
+	switch r.Dir {
+	case DirAny, DirC2S, DirS2C:
+	default:
+		return fmt.Errorf("%w: unknown dir [%s]", ErrInvalidRule, r.Dir)
+	}
+	switch r.Action {
+	case ActionDelay, ActionDrop, ActionDuplicate, ActionCorrupt, ActionSplit, ActionReorder, ActionCut:
+	default:
+		return fmt.Errorf("%w: unknown action [%s]", ErrInvalidRule, r.Action)
+	}
+	if r.Probability < 0 || r.Probability > 1 {
+		return fmt.Errorf("%w: probability %v out of range [0, 1]", ErrInvalidRule, r.Probability)
+	}
+	if r.Limit < 0 || r.Size < 0 || r.Delay < 0 || r.Jitter < 0 {
+		return fmt.Errorf("%w: negative limit, size, delay or jitter", ErrInvalidRule)
+	}
+	return nil
+}
+
+// matches 方向与 commandID 是否匹配，不含概率与次数
+func (r Rule) matches(dir Direction, command uint8) bool {
+	if r.Dir != DirAny && r.Dir != dir {
+		return false
+	}
+	if len(r.Commands) == 0 {
+		return true
+	}
+	for _, c := range r.Commands {
+		if c == command {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package frame
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+/*
+以分隔符结尾的 frame，供只能收发文本的设备使用
+
+	payload（其中的分隔符与转义字符前加转义字符）
+	1 byte: delimiter
+
+未设置转义字符时 payload 中不能出现分隔符
+行格式见 LineCodec，以 CRLF 结尾
+两种格式都不能携带 flags，也不能被 AutoCodec 识别，frame 中的 packet 一般是文本，见 textpacket
+*/
+
+// ErrDelimiterInPayload payload 中含有分隔符且无法转义
+var ErrDelimiterInPayload = errors.New("payload contains frame delimiter")
+
+// DelimiterCodec 分隔符结尾的编解码器，实现 FlagCodec，只支持不带 flag 的 frame
+type DelimiterCodec struct {
+	// Delimiter frame 的结束符
+	Delimiter byte
+	// Escape 转义字符，payload 中的分隔符与转义字符前加上该字符，0 表示不转义
+	Escape byte
+	// MaxLength payload 的最大长度（不含转义字符），超过时编解码都返回 ErrTooLarge，0 表示使用默认值
+	MaxLength int
+}
+
+// NewDelimiterCodec 创建分隔符结尾的编解码器，escape 为 0 表示不转义
+func NewDelimiterCodec(delim, escape byte) *DelimiterCodec {
+	return &DelimiterCodec{Delimiter: delim, Escape: escape}
+}
+
+// Encode 编码一个 frame
+func (c *DelimiterCodec) Encode(w io.Writer, framePayload Payload) error {
+	if err := checkLength(uint64(len(framePayload)), c.MaxLength); err != nil {
+		return err
+	}
+	buf := make([]byte, 0, len(framePayload)+1)
+	for _, b := range framePayload {
+		if b == c.Delimiter || (c.Escape != 0 && b == c.Escape) {
+			if c.Escape == 0 {
+				return ErrDelimiterInPayload
+			}
+			buf = append(buf, c.Escape)
+		}
+		buf = append(buf, b)
+	}
+	buf = append(buf, c.Delimiter)
+	n, err := w.Write(buf)
+	if n != len(buf) {
+		return ErrShortWrite
+	}
+	return err
+}
+
+// EncodeFlags flags 不为 0 时返回 ErrUnsupportedFlags
+func (c *DelimiterCodec) EncodeFlags(w io.Writer, framePayload Payload, flags Flags) error {
+	if flags != 0 {
+		return ErrUnsupportedFlags
+	}
+	return c.Encode(w, framePayload)
+}
+
+// Decode 解码一个 frame，返回去掉转义字符与分隔符的 payload
+// r 实现了 io.ByteReader（如 *bufio.Reader）时逐字节读取不会有额外开销
+func (c *DelimiterCodec) Decode(r io.Reader) (Payload, error) {
+	br := byteReaderOf(r)
+	buf := make([]byte, 0, 64)
+	escaped := false
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			if err == io.EOF && (len(buf) > 0 || escaped) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		switch {
+		case escaped:
+			escaped = false
+		case c.Escape != 0 && b == c.Escape:
+			escaped = true
+			continue
+		case b == c.Delimiter:
+			return buf, nil
+		}
+		if err = checkLength(uint64(len(buf)+1), c.MaxLength); err != nil {
+			return nil, err
+		}
+		buf = append(buf, b)
+	}
+}
+
+// DecodeFlags 同 Decode，flags 总为 0
+func (c *DelimiterCodec) DecodeFlags(r io.Reader) (Payload, Flags, error) {
+	p, err := c.Decode(r)
+	return p, 0, err
+}
+
+// LineCodec 以 CRLF 结尾的行编解码器，实现 FlagCodec，只支持不带 flag 的 frame
+// 解码时也接受只以 LF 结尾的行，payload 中不能含有 CR 或 LF
+type LineCodec struct {
+	// MaxLength 一行的最大长度（不含行尾），超过时编解码都返回 ErrTooLarge，0 表示使用默认值
+	MaxLength int
+}
+
+// NewLineCodec 创建行编解码器
+func NewLineCodec() *LineCodec {
+	return &LineCodec{}
+}
+
+// Encode 编码一行
+func (c *LineCodec) Encode(w io.Writer, framePayload Payload) error {
+	if err := checkLength(uint64(len(framePayload)), c.MaxLength); err != nil {
+		return err
+	}
+	if bytes.ContainsAny(framePayload, "\r\n") {
+		return ErrDelimiterInPayload
+	}
+	buf := make([]byte, 0, len(framePayload)+2)
+	buf = append(append(buf, framePayload...), '\r', '\n')
+	n, err := w.Write(buf)
+	if n != len(buf) {
+		return ErrShortWrite
+	}
+	return err
+}
+
+// EncodeFlags flags 不为 0 时返回 ErrUnsupportedFlags
+func (c *LineCodec) EncodeFlags(w io.Writer, framePayload Payload, flags Flags) error {
+	if flags != 0 {
+		return ErrUnsupportedFlags
+	}
+	return c.Encode(w, framePayload)
+}
+
+// Decode 解码一行，返回去掉行尾的 payload
+func (c *LineCodec) Decode(r io.Reader) (Payload, error) {
+	max := c.MaxLength
+	if max <= 0 {
+		max = DefaultMaxLength
+	}
+	// 行尾的 CR 先按 payload 读出
+	d := DelimiterCodec{Delimiter: '\n', MaxLength: max + 1}
+	p, err := d.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(p); n > 0 && p[n-1] == '\r' {
+		p = p[:n-1]
+	}
+	if err = checkLength(uint64(len(p)), max); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// DecodeFlags 同 Decode，flags 总为 0
+func (c *LineCodec) DecodeFlags(r io.Reader) (Payload, Flags, error) {
+	p, err := c.Decode(r)
+	return p, 0, err
+}
@@ -0,0 +1,135 @@
+package frame
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDelimiterCodec(t *testing.T) {
+	tests := []struct {
+		name    string
+		codec   *DelimiterCodec
+		payload []byte
+		want    string
+		wantErr error
+	}{
+		{name: "Plain", codec: NewDelimiterCodec('\n', 0), payload: []byte("hello"), want: "hello\n"},
+		{name: "Empty", codec: NewDelimiterCodec('\n', 0), payload: []byte{}, want: "\n"},
+		{name: "Nul", codec: NewDelimiterCodec(0, 0), payload: []byte("a\nb"), want: "a\nb\x00"},
+		{name: "Escaped", codec: NewDelimiterCodec(';', '\\'), payload: []byte(`a;b\c`), want: `a\;b\\c;`},
+		{name: "DelimiterInPayload", codec: NewDelimiterCodec('\n', 0), payload: []byte("a\nb"), wantErr: ErrDelimiterInPayload},
+		{name: "TooLarge", codec: &DelimiterCodec{Delimiter: '\n', MaxLength: 2}, payload: []byte("abc"), wantErr: ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := tt.codec.Encode(&buf, tt.payload)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Encode() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if buf.String() != tt.want {
+				t.Errorf("Encode() = %q, want %q", buf.String(), tt.want)
+			}
+
+			for _, r := range []io.Reader{bytes.NewReader(buf.Bytes()), iotest.OneByteReader(bytes.NewReader(buf.Bytes()))} {
+				got, flags, err := tt.codec.DecodeFlags(r)
+				if err != nil {
+					t.Fatalf("DecodeFlags() error = %v", err)
+				}
+				if !bytes.Equal(got, tt.payload) || flags != 0 {
+					t.Errorf("DecodeFlags() = %q, %v, want %q, 0", got, flags, tt.payload)
+				}
+			}
+		})
+	}
+}
+
+func TestDelimiterCodec_Decode(t *testing.T) {
+	tests := []struct {
+		name    string
+		codec   *DelimiterCodec
+		data    string
+		want    []string
+		wantErr error
+	}{
+		{name: "Stream", codec: NewDelimiterCodec('\n', 0), data: "a\nbc\n\n", want: []string{"a", "bc", ""}, wantErr: io.EOF},
+		{name: "EscapedOther", codec: NewDelimiterCodec(';', '\\'), data: `a\;b\x;\\;`, want: []string{"a;bx", `\`}, wantErr: io.EOF},
+		{name: "Truncated", codec: NewDelimiterCodec('\n', 0), data: "a\nbc", want: []string{"a"}, wantErr: io.ErrUnexpectedEOF},
+		{name: "TruncatedEscape", codec: NewDelimiterCodec(';', '\\'), data: `\`, wantErr: io.ErrUnexpectedEOF},
+		{name: "TooLarge", codec: &DelimiterCodec{Delimiter: '\n', MaxLength: 2}, data: "ab\nabc\n", want: []string{"ab"}, wantErr: ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bytes.NewReader([]byte(tt.data))
+			var got []string
+			for {
+				p, err := tt.codec.Decode(r)
+				if err != nil {
+					if !errors.Is(err, tt.wantErr) {
+						t.Errorf("Decode() error = %v, want %v", err, tt.wantErr)
+					}
+					break
+				}
+				got = append(got, string(p))
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Decode() got %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Decode() #%d = %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLineCodec(t *testing.T) {
+	var buf bytes.Buffer
+	c := NewLineCodec()
+	if err := c.Encode(&buf, []byte("hello world")); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if buf.String() != "hello world\r\n" {
+		t.Errorf("Encode() = %q, want %q", buf.String(), "hello world\r\n")
+	}
+	for _, p := range []string{"a\rb", "a\nb"} {
+		if err := c.Encode(&buf, []byte(p)); !errors.Is(err, ErrDelimiterInPayload) {
+			t.Errorf("Encode(%q) error = %v, want %v", p, err, ErrDelimiterInPayload)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		max     int
+		data    string
+		want    string
+		wantErr error
+	}{
+		{name: "CRLF", data: "hello\r\n", want: "hello"},
+		{name: "LF", data: "hello\n", want: "hello"},
+		{name: "Empty", data: "\r\n", want: ""},
+		{name: "InnerCR", data: "a\rb\r\n", want: "a\rb"},
+		{name: "MaxLengthCRLF", max: 5, data: "hello\r\n", want: "hello"},
+		{name: "TooLarge", max: 4, data: "hello\r\n", wantErr: ErrTooLarge},
+		{name: "Truncated", data: "hello", wantErr: io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &LineCodec{MaxLength: tt.max}
+			got, err := c.Decode(bytes.NewReader([]byte(tt.data)))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Decode() error = %v, want %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Decode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package frame
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+)
+
+// Format 帧格式
+type Format uint8
+
+const (
+	FormatAuto      Format = iota // 按对方的第一个 frame 识别，识别之前按旧格式发送
+	FormatLegacy                  // 4 字节长度头，见 Codec
+	FormatHeader                  // 带版本号的帧头，见 HeaderCodec
+	FormatVarint                  // varint 长度前缀，见 VarintCodec，不能携带 flags，也不能被自动识别
+	FormatLine                    // CRLF 结尾的文本行，见 LineCodec，不能携带 flags，也不能被自动识别
+	FormatDelimiter               // 分隔符结尾，见 DelimiterCodec，不能携带 flags，也不能被自动识别
+)
+
+var formatNames = []string{
+	FormatAuto:      "auto",
+	FormatLegacy:    "legacy",
+	FormatHeader:    "header",
+	FormatVarint:    "varint",
+	FormatLine:      "line",
+	FormatDelimiter: "delimiter",
+}
+
+func (f Format) String() string {
+	if int(f) < len(formatNames) {
+		return formatNames[f]
+	}
+	return fmt.Sprintf("format(%d)", uint8(f))
+}
+
+// SupportsFlags 该格式能否携带 Flags，不能时无法压缩或加密 payload
+func (f Format) SupportsFlags() bool {
+	return f <= FormatHeader
+}
+
+// Flags 该格式能携带的 flag，FormatAuto 按识别之前使用的旧格式
+func (f Format) Flags() Flags {
+	switch f {
+	case FormatAuto, FormatLegacy:
+		return FlagCompressed
+	case FormatHeader:
+		return FlagCompressed | FlagEncrypted | FlagPriority | FlagChecksum
+	default:
+		return 0
+	}
+}
+
+// CodecFlags 编解码器能携带的 flag，AutoCodec 按识别出的格式，不认识的编解码器返回 0
+func CodecFlags(c StreamFrameCodec) Flags {
+	switch t := c.(type) {
+	case *Codec:
+		return FormatLegacy.Flags()
+	case *HeaderCodec:
+		return FormatHeader.Flags()
+	case *AutoCodec:
+		return t.Format().Flags()
+	default:
+		return 0
+	}
+}
+
+// Text 该格式是否以分隔符拆分 frame，这类格式一般承载文本 packet，见 textpacket
+func (f Format) Text() bool {
+	return f == FormatLine || f == FormatDelimiter
+}
+
+// ParseFormat 按名称解析帧格式
+func ParseFormat(s string) (Format, error) {
+	for f, name := range formatNames {
+		if strings.EqualFold(s, name) {
+			return Format(f), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown frame format [%s]", s)
+}
+
+// NewFormatCodec 创建指定格式的编解码器，FormatAuto 每次调用创建新的 AutoCodec，不能在连接之间共用
+// FormatDelimiter 以 LF 为分隔符且不转义，其他分隔符直接使用 DelimiterCodec
+func NewFormatCodec(f Format) FlagCodec {
+	return NewFormatCodecLimit(f, 0)
+}
+
+// NewFormatCodecLimit 同 NewFormatCodec，payload 的最大长度为 maxLength，0 表示使用 DefaultMaxLength
+func NewFormatCodecLimit(f Format, maxLength int) FlagCodec {
+	switch f {
+	case FormatLegacy:
+		return &Codec{MaxLength: maxLength}
+	case FormatHeader:
+		return &HeaderCodec{MaxLength: maxLength}
+	case FormatVarint:
+		return &VarintCodec{MaxLength: maxLength}
+	case FormatLine:
+		return &LineCodec{MaxLength: maxLength}
+	case FormatDelimiter:
+		c := NewDelimiterCodec('\n', 0)
+		c.MaxLength = maxLength
+		return c
+	default:
+		c := NewAutoCodec()
+		c.legacy.MaxLength = maxLength
+		c.header.MaxLength = maxLength
+		return c
+	}
+}
+
+// AutoCodec 按读到的第一个 frame 识别对方使用的帧格式（旧格式或带版本号的帧头），之后的收发都使用该格式
+// 识别之前按旧格式发送，因此应由先收到数据的一方（如服务端）使用
+// 读写可在不同协程并发进行
+type AutoCodec struct {
+	legacy Codec
+	header HeaderCodec
+
+	format uint32 // 原子操作，识别出的 Format，识别之前为 FormatAuto
+}
+
+// NewAutoCodec 创建自动识别帧格式的编解码器
+func NewAutoCodec() *AutoCodec {
+	return &AutoCodec{}
+}
+
+// Format 返回识别出的帧格式，识别之前为 FormatAuto
+func (c *AutoCodec) Format() Format {
+	return Format(atomic.LoadUint32(&c.format))
+}
+
+func (c *AutoCodec) current() FlagCodec {
+	if c.Format() == FormatHeader {
+		return &c.header
+	}
+	return &c.legacy
+}
+
+func (c *AutoCodec) Encode(w io.Writer, framePayload Payload) error {
+	return c.current().Encode(w, framePayload)
+}
+
+func (c *AutoCodec) EncodeFlags(w io.Writer, framePayload Payload, flags Flags) error {
+	return c.current().EncodeFlags(w, framePayload, flags)
+}
+
+func (c *AutoCodec) Decode(r io.Reader) (Payload, error) {
+	p, flags, err := c.DecodeFlags(r)
+	if err != nil {
+		return nil, err
+	}
+	if flags&payloadFlags != 0 {
+		return nil, fmt.Errorf("%w [0x%04x]", ErrUnsupportedFlags, uint16(flags))
+	}
+	return p, nil
+}
+
+// DecodeFlags 解码 frame，第一个 frame 按首字节是否为 HeaderMagic 识别帧格式
+func (c *AutoCodec) DecodeFlags(r io.Reader) (Payload, Flags, error) {
+	if c.Format() != FormatAuto {
+		return c.current().DecodeFlags(r)
+	}
+	// 两种格式的帧头都不短于 4 字节，先读 4 字节再按格式读取剩余部分
+	var buf [HeaderSize]byte
+	if _, err := io.ReadFull(r, buf[:4]); err != nil {
+		return nil, 0, err
+	}
+	if buf[0] != HeaderMagic {
+		atomic.StoreUint32(&c.format, uint32(FormatLegacy))
+		return c.legacy.decodeBody(r, binary.BigEndian.Uint32(buf[:4]))
+	}
+	if _, err := io.ReadFull(r, buf[4:]); err != nil {
+		return nil, 0, err
+	}
+	atomic.StoreUint32(&c.format, uint32(FormatHeader))
+	return c.header.decodeBody(r, buf)
+}
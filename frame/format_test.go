@@ -0,0 +1,176 @@
+package frame
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestAutoCodec(t *testing.T) {
+	tests := []struct {
+		name   string
+		peer   FlagCodec
+		flags  Flags
+		want   Format
+		header []byte // 回复的帧头
+	}{
+		{name: "Legacy", peer: &Codec{}, want: FormatLegacy, header: []byte{0, 0, 0, 6}},
+		{name: "LegacyCompressed", peer: &Codec{}, flags: FlagCompressed, want: FormatLegacy, header: []byte{0, 0, 0, 6}},
+		{name: "Header", peer: NewHeaderCodec(), want: FormatHeader, header: []byte{HeaderMagic, HeaderVersion, 0, 0, 0, 0, 0, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewAutoCodec()
+			if c.Format() != FormatAuto {
+				t.Fatalf("Format() = %v before detection", c.Format())
+			}
+
+			var in bytes.Buffer
+			for i := 0; i < 2; i++ {
+				if err := tt.peer.EncodeFlags(&in, Payload("hi"), tt.flags); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i := 0; i < 2; i++ {
+				got, flags, err := c.DecodeFlags(&in)
+				if err != nil || string(got) != "hi" || flags != tt.flags {
+					t.Fatalf("DecodeFlags() = %q, %v, %v", got, flags, err)
+				}
+			}
+			if c.Format() != tt.want {
+				t.Errorf("Format() = %v, want %v", c.Format(), tt.want)
+			}
+
+			var out bytes.Buffer
+			if err := c.Encode(&out, Payload("hi")); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(out.Bytes(), tt.header) {
+				t.Errorf("Encode() = %v, want header %v", out.Bytes(), tt.header)
+			}
+		})
+	}
+}
+
+func TestAutoCodec_Decode(t *testing.T) {
+	var in bytes.Buffer
+	(&Codec{}).EncodeFlags(&in, Payload("hi"), FlagCompressed)
+	if _, err := NewAutoCodec().Decode(&in); !errors.Is(err, ErrUnsupportedFlags) {
+		t.Errorf("Decode() error = %v, want %v", err, ErrUnsupportedFlags)
+	}
+}
+
+func TestCodec_EncodeFlags(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (&Codec{}).EncodeFlags(&buf, Payload("hi"), FlagEncrypted); !errors.Is(err, ErrUnsupportedFlags) {
+		t.Errorf("EncodeFlags() error = %v, want %v", err, ErrUnsupportedFlags)
+	}
+	if err := (&Codec{}).EncodeFlags(&buf, Payload("hi"), FlagCompressed); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x80, 0, 0, 6, 'h', 'i'}; !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("EncodeFlags() = %v, want %v", buf.Bytes(), want)
+	}
+	// 旧的 Decode 不接受带 flag 的 frame
+	if _, err := NewCodec().Decode(&buf); !errors.Is(err, ErrInvalidLength) {
+		t.Errorf("Decode() error = %v, want %v", err, ErrInvalidLength)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, f := range []Format{FormatAuto, FormatLegacy, FormatHeader} {
+		if got, err := ParseFormat(f.String()); err != nil || got != f {
+			t.Errorf("ParseFormat(%s) = %v, %v", f, got, err)
+		}
+	}
+	if _, err := ParseFormat("v2"); err == nil {
+		t.Errorf("ParseFormat(v2) error = nil")
+	}
+}
+
+func TestCodecFlags(t *testing.T) {
+	detected := NewAutoCodec()
+	var buf bytes.Buffer
+	NewHeaderCodec().Encode(&buf, []byte("x"))
+	if _, err := detected.Decode(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		codec StreamFrameCodec
+		want  Flags
+	}{
+		{name: "Legacy", codec: &Codec{}, want: FlagCompressed},
+		{name: "Header", codec: NewHeaderCodec(), want: FlagCompressed | FlagEncrypted | FlagPriority | FlagChecksum},
+		{name: "AutoUndetected", codec: NewAutoCodec(), want: FlagCompressed},
+		{name: "AutoHeader", codec: detected, want: FormatHeader.Flags()},
+		{name: "Varint", codec: NewVarintCodec(), want: 0},
+		{name: "Line", codec: NewLineCodec(), want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CodecFlags(tt.codec); got != tt.want {
+				t.Errorf("CodecFlags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCodec_MaxLength(t *testing.T) {
+	huge := []byte{0x7f, 0xff, 0xff, 0xff} // 伪造的长度头，不能按此分配内存
+	tests := []struct {
+		name    string
+		codec   FlagCodec
+		data    []byte
+		wantErr error
+	}{
+		{name: "Legacy", codec: &Codec{}, data: huge, wantErr: ErrTooLarge},
+		{name: "LegacyCompressed", codec: &Codec{}, data: []byte{0xff, 0xff, 0xff, 0xff}, wantErr: ErrTooLarge},
+		{name: "LegacyLimit", codec: &Codec{MaxLength: 1}, data: []byte{0, 0, 0, 6, 'h', 'i'}, wantErr: ErrTooLarge},
+		{name: "LegacyWithinLimit", codec: &Codec{MaxLength: 2}, data: []byte{0, 0, 0, 6, 'h', 'i'}},
+		{name: "Auto", codec: NewFormatCodecLimit(FormatAuto, 0), data: huge, wantErr: ErrTooLarge},
+		{name: "AutoLimit", codec: NewFormatCodecLimit(FormatAuto, 1), data: []byte{0, 0, 0, 6, 'h', 'i'}, wantErr: ErrTooLarge},
+		{name: "AutoHeaderLimit", codec: NewFormatCodecLimit(FormatAuto, 1), data: []byte{HeaderMagic, HeaderVersion, 0, 0, 0, 0, 0, 2, 'h', 'i'}, wantErr: ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := tt.codec.DecodeFlags(bytes.NewReader(tt.data)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("DecodeFlags() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := NewCodec().Decode(bytes.NewReader(huge)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Decode() error = %v, want %v", err, ErrTooLarge)
+	}
+	var buf bytes.Buffer
+	if err := (&Codec{MaxLength: 1}).Encode(&buf, Payload("hi")); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Encode() error = %v, want %v", err, ErrTooLarge)
+	}
+	// 长度头不能表示的长度按 MaxLegacyLength 限制
+	if got := (&Codec{MaxLength: MaxLegacyLength + 1}).maxLength(); got != MaxLegacyLength {
+		t.Errorf("maxLength() = %d, want %d", got, MaxLegacyLength)
+	}
+	if header := uint32(MaxLegacyLength + 4); header&LegacyCompressedBit != 0 {
+		t.Errorf("MaxLegacyLength header = %#x overlaps LegacyCompressedBit", header)
+	}
+}
+
+func TestNewFormatCodecLimit(t *testing.T) {
+	for _, f := range []Format{FormatLegacy, FormatHeader, FormatVarint, FormatLine, FormatDelimiter, FormatAuto} {
+		t.Run(f.String(), func(t *testing.T) {
+			c := NewFormatCodecLimit(f, 4)
+			var buf bytes.Buffer
+			if err := c.Encode(&buf, Payload("hello")); !errors.Is(err, ErrTooLarge) {
+				t.Errorf("Encode() error = %v, want %v", err, ErrTooLarge)
+			}
+			if err := c.Encode(&buf, Payload("hi")); err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			if got, err := c.Decode(&buf); err != nil || string(got) != "hi" {
+				t.Errorf("Decode() = %q, %v, want hi", got, err)
+			}
+		})
+	}
+}
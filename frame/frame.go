@@ -13,9 +13,13 @@ frameHeader + framePayload(packet)
 
 frameHeader
 	4 bytes: length 整型，帧总长度(含头及payload)
+	最高位为 1 表示 payload 经过压缩（LegacyCompressedBit），只能携带这一个 flag
 
 framePayload
 	Packet
+
+可携带更多 flag 的带版本号的帧头见 HeaderCodec，两种格式可通过 AutoCodec 自动识别
+varint 长度前缀的格式见 VarintCodec，供文本设备使用的分隔符与行格式见 DelimiterCodec 与 LineCodec
 */
 
 // Payload 定义载荷数据类型
@@ -30,7 +34,52 @@ type StreamFrameCodec interface {
 var ErrShortWrite = errors.New("short write")
 var ErrShortRead = errors.New("short read")
 
+// ErrInvalidLength frame 头中的长度小于头本身的长度
+var ErrInvalidLength = errors.New("invalid frame length")
+
+// ErrUnsupportedFlags 帧格式无法携带的 flag
+var ErrUnsupportedFlags = errors.New("unsupported frame flags")
+
+// Flags frame 的标记位，描述 payload 的处理方式
+type Flags uint16
+
+const (
+	FlagCompressed Flags = 1 << iota // payload 经过压缩
+	FlagEncrypted                    // payload 经过加密
+	FlagPriority                     // 高优先级
+	FlagChecksum                     // payload 末尾带有校验和
+)
+
+// payloadFlags 改变了 payload 内容的 flag，只能由 DecodeFlags 的调用方处理
+const payloadFlags = FlagCompressed | FlagEncrypted | FlagChecksum
+
+// ErrChecksumMismatch payload 与 frame 中的校验和不一致
+var ErrChecksumMismatch = errors.New("frame checksum mismatch")
+
+// FlagCodec 可携带 Flags 的编解码器
+type FlagCodec interface {
+	StreamFrameCodec
+	EncodeFlags(io.Writer, Payload, Flags) error
+	DecodeFlags(io.Reader) (Payload, Flags, error)
+}
+
+// LegacyCompressedBit 旧格式长度头中表示 FlagCompressed 的位
+const LegacyCompressedBit = 1 << 31
+
+// MaxLegacyLength 旧格式能表示的最大 payload 长度，长度头包含自身的 4 字节，且不能占用 LegacyCompressedBit
+// MaxLength 超过该值时按该值限制
+const MaxLegacyLength = LegacyCompressedBit - 4 - 1
+
 type Codec struct {
+	// MaxLength payload 的最大长度，超过时编解码都返回 ErrTooLarge，0 表示使用默认值，不超过 MaxLegacyLength
+	MaxLength int
+}
+
+func (c *Codec) maxLength() int {
+	if c.MaxLength > MaxLegacyLength {
+		return MaxLegacyLength
+	}
+	return c.MaxLength
 }
 
 // NewCodec 创建 Frame 编码解码器
@@ -41,6 +90,9 @@ func NewCodec() StreamFrameCodec {
 // Encode Frame 层的编码
 // 进入这里之前 w 应该已经有 frameHeader 了 以也就是 已经写入了 4 位的 totalLen
 func (c *Codec) Encode(w io.Writer, framePayload Payload) error {
+	if err := checkLength(uint64(len(framePayload)), c.maxLength()); err != nil {
+		return err
+	}
 	// 复制出来一份
 	var f = framePayload
 
@@ -59,6 +111,60 @@ func (c *Codec) Encode(w io.Writer, framePayload Payload) error {
 	return nil
 }
 
+// EncodeFlags 编码带 flag 的 frame，旧格式只支持 FlagCompressed
+func (c *Codec) EncodeFlags(w io.Writer, framePayload Payload, flags Flags) error {
+	if flags&^FlagCompressed != 0 {
+		return ErrUnsupportedFlags
+	}
+	if err := checkLength(uint64(len(framePayload)), c.maxLength()); err != nil {
+		return err
+	}
+	header := uint32(len(framePayload) + 4)
+	if flags&FlagCompressed != 0 {
+		header |= LegacyCompressedBit
+	}
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], header)
+	if _, err := w.Write(buf[:]); err != nil {
+		return err
+	}
+	n, err := w.Write(framePayload)
+	if n != len(framePayload) {
+		return ErrShortWrite
+	}
+	return err
+}
+
+// DecodeFlags 解码 frame，长度头的最高位表示 FlagCompressed
+func (c *Codec) DecodeFlags(r io.Reader) (Payload, Flags, error) {
+	var buf [4]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return nil, 0, err
+	}
+	return c.decodeBody(r, binary.BigEndian.Uint32(buf[:]))
+}
+
+// decodeBody 按已读取的长度头读取 payload
+func (c *Codec) decodeBody(r io.Reader, header uint32) (Payload, Flags, error) {
+	var flags Flags
+	if header&LegacyCompressedBit != 0 {
+		flags |= FlagCompressed
+	}
+	totalLen := header &^ LegacyCompressedBit
+	if totalLen < 4 {
+		return nil, 0, ErrInvalidLength
+	}
+	// 分配缓冲区之前检查长度，防止伪造的长度头耗尽内存
+	if err := checkLength(uint64(totalLen-4), c.maxLength()); err != nil {
+		return nil, 0, err
+	}
+	buf := make([]byte, totalLen-4)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, 0, err
+	}
+	return buf, flags, nil
+}
+
 // Decode Frame 层的解码
 // 进入这里之前 r 应该已经有 frameHeader 了 以也就是 已经读取了 4 位的 totalLen
 func (c *Codec) Decode(r io.Reader) (Payload, error) {
@@ -67,6 +173,12 @@ func (c *Codec) Decode(r io.Reader) (Payload, error) {
 	if err != nil {
 		return nil, err
 	}
+	if totalLen < 4 {
+		return nil, ErrInvalidLength
+	}
+	if err = checkLength(uint64(totalLen-4), c.maxLength()); err != nil {
+		return nil, err
+	}
 
 	buf := make([]byte, totalLen-4)
 	n, err := io.ReadFull(r, buf)
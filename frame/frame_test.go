@@ -101,6 +101,7 @@ func TestCodec_Decode(t *testing.T) {
 		wantErr bool
 	}{
 		{name: "Decode", r: bytes.NewReader(data), want: wantRes, wantErr: false},
+		{name: "InvalidLength", r: bytes.NewReader([]byte{0x0, 0x0, 0x0, 0x2, 'h', 'i'}), want: nil, wantErr: true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -0,0 +1,126 @@
+package frame
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+带版本号的帧头
+
+	1 byte:  magic，固定为 HeaderMagic
+	1 byte:  version，当前为 HeaderVersion
+	2 bytes: flags
+	4 bytes: length，payload 的长度（不含帧头）
+
+magic 不可能是旧格式长度头的第一个字节（对应的长度超过 1GiB），据此可以区分两种格式
+之后的版本可以扩展帧头，length 不含帧头，帧头的长度由 version 决定
+*/
+
+const (
+	HeaderMagic   = 0xFB // 帧头的第一个字节
+	HeaderVersion = 1    // 当前的帧头版本
+	HeaderSize    = 8    // 当前版本的帧头长度
+)
+
+// DefaultMaxLength 默认的 payload 最大长度
+const DefaultMaxLength = 16 << 20
+
+var (
+	// ErrBadMagic 帧头的 magic 不正确
+	ErrBadMagic = errors.New("bad frame magic")
+	// ErrUnsupportedVersion 不支持的帧头版本
+	ErrUnsupportedVersion = errors.New("unsupported frame version")
+	// ErrTooLarge payload 超过最大长度
+	ErrTooLarge = errors.New("frame too large")
+)
+
+// HeaderCodec 带版本号与 flags 的帧头编解码器，实现 FlagCodec
+type HeaderCodec struct {
+	// MaxLength payload 的最大长度，超过时编解码都返回 ErrTooLarge，0 表示使用默认值
+	MaxLength int
+}
+
+// NewHeaderCodec 创建带版本号的帧头编解码器
+func NewHeaderCodec() *HeaderCodec {
+	return &HeaderCodec{}
+}
+
+// checkLength payload 长度超过 max 时返回 ErrTooLarge，max 为 0 表示使用默认值
+func checkLength(n uint64, max int) error {
+	if max <= 0 {
+		max = DefaultMaxLength
+	}
+	if n > uint64(max) {
+		return fmt.Errorf("%w: %d bytes", ErrTooLarge, n)
+	}
+	return nil
+}
+
+// Encode 编码不带 flag 的 frame
+func (c *HeaderCodec) Encode(w io.Writer, framePayload Payload) error {
+	return c.EncodeFlags(w, framePayload, 0)
+}
+
+// EncodeFlags 编码带 flag 的 frame
+func (c *HeaderCodec) EncodeFlags(w io.Writer, framePayload Payload, flags Flags) error {
+	if err := checkLength(uint64(len(framePayload)), c.MaxLength); err != nil {
+		return err
+	}
+	var header [HeaderSize]byte
+	header[0] = HeaderMagic
+	header[1] = HeaderVersion
+	binary.BigEndian.PutUint16(header[2:4], uint16(flags))
+	binary.BigEndian.PutUint32(header[4:8], uint32(len(framePayload)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	n, err := w.Write(framePayload)
+	if n != len(framePayload) {
+		return ErrShortWrite
+	}
+	return err
+}
+
+// Decode 解码 frame，payload 经过压缩或加密时返回 ErrUnsupportedFlags
+func (c *HeaderCodec) Decode(r io.Reader) (Payload, error) {
+	p, flags, err := c.DecodeFlags(r)
+	if err != nil {
+		return nil, err
+	}
+	if flags&payloadFlags != 0 {
+		return nil, fmt.Errorf("%w [0x%04x]", ErrUnsupportedFlags, uint16(flags))
+	}
+	return p, nil
+}
+
+// DecodeFlags 解码 frame
+func (c *HeaderCodec) DecodeFlags(r io.Reader) (Payload, Flags, error) {
+	var header [HeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, 0, err
+	}
+	return c.decodeBody(r, header)
+}
+
+// decodeBody 按已读取的帧头读取 payload
+func (c *HeaderCodec) decodeBody(r io.Reader, header [HeaderSize]byte) (Payload, Flags, error) {
+	if header[0] != HeaderMagic {
+		return nil, 0, fmt.Errorf("%w [0x%02x]", ErrBadMagic, header[0])
+	}
+	if header[1] != HeaderVersion {
+		return nil, 0, fmt.Errorf("%w [%d]", ErrUnsupportedVersion, header[1])
+	}
+	flags := Flags(binary.BigEndian.Uint16(header[2:4]))
+	n := binary.BigEndian.Uint32(header[4:8])
+	if err := checkLength(uint64(n), c.MaxLength); err != nil {
+		return nil, 0, err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, 0, err
+	}
+	return buf, flags, nil
+}
@@ -0,0 +1,61 @@
+package frame
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestHeaderCodec(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload Payload
+		flags   Flags
+		want    []byte
+	}{
+		{name: "Plain", payload: Payload("hi"), flags: 0, want: []byte{HeaderMagic, HeaderVersion, 0, 0, 0, 0, 0, 2, 'h', 'i'}},
+		{name: "Flags", payload: Payload("hi"), flags: FlagCompressed | FlagPriority, want: []byte{HeaderMagic, HeaderVersion, 0, 5, 0, 0, 0, 2, 'h', 'i'}},
+		{name: "Empty", payload: Payload{}, flags: 0, want: []byte{HeaderMagic, HeaderVersion, 0, 0, 0, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewHeaderCodec()
+			var buf bytes.Buffer
+			if err := c.EncodeFlags(&buf, tt.payload, tt.flags); err != nil {
+				t.Fatalf("EncodeFlags() error = %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), tt.want) {
+				t.Errorf("EncodeFlags() = %v, want %v", buf.Bytes(), tt.want)
+			}
+			got, flags, err := c.DecodeFlags(&buf)
+			if err != nil {
+				t.Fatalf("DecodeFlags() error = %v", err)
+			}
+			if !bytes.Equal(got, tt.payload) || flags != tt.flags {
+				t.Errorf("DecodeFlags() = %q, %v, want %q, %v", got, flags, tt.payload, tt.flags)
+			}
+		})
+	}
+}
+
+func TestHeaderCodec_Decode(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{name: "BadMagic", data: []byte{0, 0, 0, 9, 1, 'h', 'e', 'l', 'l'}, wantErr: ErrBadMagic},
+		{name: "Version", data: []byte{HeaderMagic, 2, 0, 0, 0, 0, 0, 0}, wantErr: ErrUnsupportedVersion},
+		{name: "TooLarge", data: []byte{HeaderMagic, HeaderVersion, 0, 0, 0x7f, 0, 0, 0}, wantErr: ErrTooLarge},
+		{name: "Compressed", data: []byte{HeaderMagic, HeaderVersion, 0, 1, 0, 0, 0, 1, 'x'}, wantErr: ErrUnsupportedFlags},
+		{name: "Truncated", data: []byte{HeaderMagic, HeaderVersion, 0, 0, 0, 0, 0, 5, 'x'}, wantErr: io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewHeaderCodec().Decode(bytes.NewReader(tt.data)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Decode() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
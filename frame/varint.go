@@ -0,0 +1,125 @@
+package frame
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+/*
+varint 长度前缀的 frame，与 protobuf 的 length-delimited 消息流格式一致
+
+	1~10 bytes: length，unsigned varint，payload 的长度（不含前缀）
+	payload
+
+ack 等小的 frame 只需 1 字节前缀（payload 小于 128 字节时）
+不能携带 flags，因此不能与压缩等需要标记 frame 的功能一起使用，也不能被 AutoCodec 识别
+*/
+
+// ErrVarintOverflow 长度前缀超过 64 位
+var ErrVarintOverflow = errors.New("frame length varint overflows 64 bits")
+
+// VarintCodec varint 长度前缀的编解码器，实现 FlagCodec，只支持不带 flag 的 frame
+type VarintCodec struct {
+	// MaxLength payload 的最大长度，超过时编解码都返回 ErrTooLarge，0 表示使用默认值
+	MaxLength int
+}
+
+// NewVarintCodec 创建 varint 长度前缀的编解码器
+func NewVarintCodec() *VarintCodec {
+	return &VarintCodec{}
+}
+
+// Encode 编码一个 frame
+func (c *VarintCodec) Encode(w io.Writer, framePayload Payload) error {
+	if err := checkLength(uint64(len(framePayload)), c.MaxLength); err != nil {
+		return err
+	}
+	var prefix [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(prefix[:], uint64(len(framePayload)))
+	if _, err := w.Write(prefix[:n]); err != nil {
+		return err
+	}
+	n, err := w.Write(framePayload)
+	if n != len(framePayload) {
+		return ErrShortWrite
+	}
+	return err
+}
+
+// EncodeFlags flags 不为 0 时返回 ErrUnsupportedFlags
+func (c *VarintCodec) EncodeFlags(w io.Writer, framePayload Payload, flags Flags) error {
+	if flags != 0 {
+		return ErrUnsupportedFlags
+	}
+	return c.Encode(w, framePayload)
+}
+
+// Decode 解码一个 frame，r 实现了 io.ByteReader（如 *bufio.Reader）时逐字节读取前缀不会有额外开销
+func (c *VarintCodec) Decode(r io.Reader) (Payload, error) {
+	n, err := readUvarint(byteReaderOf(r))
+	if err != nil {
+		return nil, err
+	}
+	if err = checkLength(n, c.MaxLength); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// DecodeFlags 同 Decode，flags 总为 0
+func (c *VarintCodec) DecodeFlags(r io.Reader) (Payload, Flags, error) {
+	p, err := c.Decode(r)
+	return p, 0, err
+}
+
+// readUvarint 同 binary.ReadUvarint，但区分读取错误与溢出
+func readUvarint(r io.ByteReader) (uint64, error) {
+	var (
+		x uint64
+		s uint
+	)
+	for i := 0; i < binary.MaxVarintLen64; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			if i > 0 && err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		if b < 0x80 {
+			if i == binary.MaxVarintLen64-1 && b > 1 {
+				return 0, ErrVarintOverflow
+			}
+			return x | uint64(b)<<s, nil
+		}
+		x |= uint64(b&0x7f) << s
+		s += 7
+	}
+	return 0, ErrVarintOverflow
+}
+
+// byteReaderOf r 没有实现 io.ByteReader 时包装为逐字节读取
+func byteReaderOf(r io.Reader) io.ByteReader {
+	if br, ok := r.(io.ByteReader); ok {
+		return br
+	}
+	return &byteReader{r: r}
+}
+
+// byteReader 为没有实现 io.ByteReader 的 io.Reader 逐字节读取
+type byteReader struct {
+	r   io.Reader
+	buf [1]byte
+}
+
+func (b *byteReader) ReadByte() (byte, error) {
+	if _, err := io.ReadFull(b.r, b.buf[:]); err != nil {
+		return 0, err
+	}
+	return b.buf[0], nil
+}
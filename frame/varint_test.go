@@ -0,0 +1,79 @@
+package frame
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestVarintCodec_Encode(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    []byte
+		wantPrefix []byte
+	}{
+		{name: "Empty", payload: []byte{}, wantPrefix: []byte{0}},
+		{name: "Short", payload: []byte("hi"), wantPrefix: []byte{2}},
+		{name: "TwoBytes", payload: make([]byte, 300), wantPrefix: []byte{0xac, 0x02}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := NewVarintCodec().Encode(&buf, tt.payload); err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			want := append(append([]byte(nil), tt.wantPrefix...), tt.payload...)
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Errorf("Encode() = %x, want %x", buf.Bytes(), want)
+			}
+
+			// 不实现 io.ByteReader 时逐字节读取前缀
+			for _, r := range []io.Reader{bytes.NewReader(buf.Bytes()), iotest.OneByteReader(bytes.NewReader(buf.Bytes()))} {
+				got, flags, err := NewVarintCodec().DecodeFlags(r)
+				if err != nil {
+					t.Fatalf("DecodeFlags() error = %v", err)
+				}
+				if !bytes.Equal(got, tt.payload) || flags != 0 {
+					t.Errorf("DecodeFlags() = %x, %v, want %x, 0", got, flags, tt.payload)
+				}
+			}
+		})
+	}
+}
+
+func TestVarintCodec_Decode(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		max     int
+		wantErr error
+	}{
+		{name: "TooLarge", data: []byte{0x81, 0x01, 0}, max: 128, wantErr: ErrTooLarge},
+		{name: "Overflow", data: bytes.Repeat([]byte{0xff}, 11), wantErr: ErrVarintOverflow},
+		{name: "Overflow10thByte", data: append(bytes.Repeat([]byte{0xff}, 9), 0x02), wantErr: ErrVarintOverflow},
+		{name: "Empty", data: nil, wantErr: io.EOF},
+		{name: "TruncatedPrefix", data: []byte{0x80}, wantErr: io.ErrUnexpectedEOF},
+		{name: "TruncatedPayload", data: []byte{3, 'h', 'i'}, wantErr: io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &VarintCodec{MaxLength: tt.max}
+			if _, err := c.Decode(bytes.NewReader(tt.data)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Decode() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVarintCodec_EncodeFlags(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewVarintCodec().EncodeFlags(&buf, []byte("hi"), FlagCompressed); !errors.Is(err, ErrUnsupportedFlags) {
+		t.Errorf("EncodeFlags() error = %v, want %v", err, ErrUnsupportedFlags)
+	}
+	c := &VarintCodec{MaxLength: 1}
+	if err := c.Encode(&buf, []byte("hi")); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Encode() error = %v, want %v", err, ErrTooLarge)
+	}
+}
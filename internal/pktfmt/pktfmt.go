@@ -0,0 +1,160 @@
+package pktfmt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/CoderI421/tcp-service/checksum"
+	"github.com/CoderI421/tcp-service/compression"
+	"github.com/CoderI421/tcp-service/encryption"
+	"github.com/CoderI421/tcp-service/frame"
+	"github.com/CoderI421/tcp-service/message"
+	"github.com/CoderI421/tcp-service/packet"
+)
+
+// 把 frame 与 packet 格式化为便于阅读的文本，供 cmd 下的调试工具使用
+
+// MaxPayload Payload 最多显示的字节数，超出部分只显示长度
+var MaxPayload = 64
+
+// Format 返回 packet 的单行描述，如 SubmitAck id=00000001 result=ok
+func Format(p packet.Packet) string {
+	switch t := p.(type) {
+	case *packet.Con:
+		return fmt.Sprintf("Con id=%s payload=%s%s", t.ID, Payload(t.Payload), Options(t.Options, false))
+	case *packet.ConAck:
+		return fmt.Sprintf("ConAck id=%s result=%s%s", t.ID, Result(t.Result), Options(t.Options, true))
+	case *packet.Submit:
+		if t.Kind != "" {
+			return fmt.Sprintf("Submit id=%s kind=%s content-type=%s payload=%s", t.ID, t.Kind, message.ContentType(t.ContentType), Payload(t.Payload))
+		}
+		if t.Topic != "" {
+			return fmt.Sprintf("Submit id=%s topic=%s payload=%s", t.ID, t.Topic, Payload(t.Payload))
+		}
+		return fmt.Sprintf("Submit id=%s payload=%s", t.ID, Payload(t.Payload))
+	case *packet.SubmitAck:
+		return fmt.Sprintf("SubmitAck id=%s result=%s", t.ID, Result(t.Result))
+	case *packet.Deliver:
+		if t.Topic != "" {
+			return fmt.Sprintf("Deliver id=%s topic=%s payload=%s", t.ID, t.Topic, Payload(t.Payload))
+		}
+		return fmt.Sprintf("Deliver id=%s payload=%s", t.ID, Payload(t.Payload))
+	case *packet.DeliverAck:
+		return fmt.Sprintf("DeliverAck id=%s result=%s", t.ID, Result(t.Result))
+	case *packet.Subscribe:
+		return fmt.Sprintf("Subscribe id=%s topic=%s", t.ID, t.Topic)
+	case *packet.SubscribeAck:
+		return fmt.Sprintf("SubscribeAck id=%s result=%s", t.ID, Result(t.Result))
+	case *packet.Unsubscribe:
+		return fmt.Sprintf("Unsubscribe id=%s topic=%s", t.ID, t.Topic)
+	case *packet.UnsubscribeAck:
+		return fmt.Sprintf("UnsubscribeAck id=%s result=%s", t.ID, Result(t.Result))
+	}
+	return fmt.Sprintf("%T %+v", p, p)
+}
+
+// Options 返回扩展选项的描述，每个选项以空格开头，如 " compression=snappy,gzip"
+// ack 表示选项来自 ConAck，部分选项在 Con 与 ConAck 中的格式不同
+func Options(opts []packet.Option, ack bool) string {
+	var b strings.Builder
+	for _, o := range opts {
+		switch o.Type {
+		case packet.OptionCompression:
+			names := make([]string, len(o.Value))
+			for i, a := range compression.Decode(o.Value) {
+				names[i] = a.String()
+			}
+			fmt.Fprintf(&b, " compression=%s", strings.Join(names, ","))
+		case packet.OptionChecksum:
+			names := make([]string, len(o.Value))
+			for i, a := range checksum.Decode(o.Value) {
+				names[i] = a.String()
+			}
+			fmt.Fprintf(&b, " checksum=%s", strings.Join(names, ","))
+		case packet.OptionEncryption:
+			// 只显示算法，不显示公钥
+			var ciphers []encryption.Cipher
+			var err error
+			if ack {
+				var a encryption.Accept
+				a, err = encryption.DecodeAccept(o.Value)
+				ciphers = []encryption.Cipher{a.Cipher}
+			} else {
+				var offer encryption.Offer
+				offer, err = encryption.DecodeOffer(o.Value)
+				ciphers = offer.Ciphers
+			}
+			if err != nil {
+				fmt.Fprintf(&b, " encryption=%s", Payload(o.Value))
+				continue
+			}
+			names := make([]string, len(ciphers))
+			for i, c := range ciphers {
+				names[i] = c.String()
+			}
+			fmt.Fprintf(&b, " encryption=%s", strings.Join(names, ","))
+		default:
+			fmt.Fprintf(&b, " option(0x%02x)=%s", o.Type, Payload(o.Value))
+		}
+	}
+	return b.String()
+}
+
+// Result 返回 ack 中 result 的名称
+func Result(r uint8) string {
+	switch r {
+	case packet.ResultOK:
+		return "ok"
+	case packet.ResultFailed:
+		return "failed"
+	case packet.ResultThrottled:
+		return "throttled"
+	case packet.ResultRefused:
+		return "refused"
+	case packet.ResultDuplicate:
+		return "duplicate"
+	}
+	return fmt.Sprintf("%d", r)
+}
+
+// Payload 可打印的 UTF-8 文本显示为带引号的字符串，否则显示为 hex
+func Payload(b []byte) string {
+	n := len(b)
+	if n > MaxPayload {
+		b = b[:MaxPayload]
+	}
+	var s string
+	if utf8.Valid(b) && printable(string(b)) {
+		s = fmt.Sprintf("%q", b)
+	} else {
+		s = "0x" + hex.EncodeToString(b)
+	}
+	if n > len(b) {
+		s += fmt.Sprintf("...(%d bytes)", n)
+	}
+	return s
+}
+
+func printable(s string) bool {
+	for _, r := range s {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' || r == utf8.RuneError || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// Frame 返回 codec 编码出的完整 frame（含帧头）的 hex dump，codec 为 nil 时按旧格式
+func Frame(codec frame.StreamFrameCodec, payload frame.Payload) string {
+	if codec == nil {
+		codec = frame.NewCodec()
+	}
+	var buf bytes.Buffer
+	if err := codec.Encode(&buf, payload); err != nil {
+		return err.Error() + "\n"
+	}
+	return hex.Dump(buf.Bytes())
+}
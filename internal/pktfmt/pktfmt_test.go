@@ -0,0 +1,64 @@
+package pktfmt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/CoderI421/tcp-service/frame"
+	"github.com/CoderI421/tcp-service/packet"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		p    packet.Packet
+		want string
+	}{
+		{name: "Con", p: &packet.Con{ID: "00000001", Payload: []byte("secret")}, want: `Con id=00000001 payload="secret"`},
+		{name: "Submit", p: &packet.Submit{ID: "00000002", Payload: []byte{0x00, 0xff}}, want: `Submit id=00000002 payload=0x00ff`},
+		{name: "TopicSubmit", p: &packet.Submit{ID: "00000002", Topic: "a/b", Payload: []byte("hi")}, want: `Submit id=00000002 topic=a/b payload="hi"`},
+		{name: "TypedSubmit", p: &packet.Submit{ID: "00000002", Kind: "order", ContentType: 3, Payload: []byte{0x80}}, want: `Submit id=00000002 kind=order content-type=msgpack payload=0x80`},
+		{name: "SubmitAck", p: &packet.SubmitAck{ID: "00000002", Result: packet.ResultThrottled}, want: `SubmitAck id=00000002 result=throttled`},
+		{name: "ConOptions", p: &packet.Con{ID: "00000001", Options: []packet.Option{{Type: packet.OptionCompression, Value: []byte{3, 1}}, {Type: 0x7f, Value: []byte("x")}}}, want: `Con id=00000001 payload="" compression=snappy,gzip option(0x7f)="x"`},
+		{name: "ConAckOptions", p: &packet.ConAck{ID: "00000001", Options: []packet.Option{{Type: packet.OptionCompression, Value: []byte{0}}, {Type: packet.OptionChecksum, Value: []byte{2}}}}, want: `ConAck id=00000001 result=ok compression=none checksum=crc32c`},
+		{name: "ConEncryption", p: &packet.Con{ID: "00000001", Options: []packet.Option{{Type: packet.OptionEncryption, Value: append(make([]byte, 33), 2, 1)}}}, want: `Con id=00000001 payload="" encryption=chacha20-poly1305,aes-gcm`},
+		{name: "ConAckEncryption", p: &packet.ConAck{ID: "00000001", Options: []packet.Option{{Type: packet.OptionEncryption, Value: append([]byte{1}, make([]byte, 64)...)}}}, want: `ConAck id=00000001 result=ok encryption=aes-gcm`},
+		{name: "UnknownResult", p: &packet.ConAck{ID: "00000001", Result: 9}, want: `ConAck id=00000001 result=9`},
+		{name: "Subscribe", p: &packet.Subscribe{ID: "00000003", Topic: "a/#"}, want: `Subscribe id=00000003 topic=a/#`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Format(tt.p); got != tt.want {
+				t.Errorf("Format() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPayload(t *testing.T) {
+	long := []byte(strings.Repeat("a", MaxPayload+1))
+	if got, want := Payload(long), `"`+strings.Repeat("a", MaxPayload)+`"...(65 bytes)`; got != want {
+		t.Errorf("Payload() = %s, want %s", got, want)
+	}
+	if got := Payload(nil); got != `""` {
+		t.Errorf("Payload(nil) = %s", got)
+	}
+}
+
+func TestFrame(t *testing.T) {
+	tests := []struct {
+		name  string
+		codec frame.StreamFrameCodec
+		want  string
+	}{
+		{name: "Legacy", codec: nil, want: "00000000  00 00 00 05 81"},
+		{name: "Header", codec: frame.NewHeaderCodec(), want: "00000000  fb 01 00 00 00 00 00 01  81"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Frame(tt.codec, []byte{packet.CommandSubmitAck}); !strings.HasPrefix(got, tt.want) {
+				t.Errorf("Frame() = %s", got)
+			}
+		})
+	}
+}
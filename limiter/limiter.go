@@ -0,0 +1,275 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+/*
+Submit 限流
+
+三层令牌桶：
+	conn   每个连接一个桶
+	client 每个客户端标识（Con.ID）一个桶，同一标识的多个连接共享
+	global 整个服务共享一个桶
+
+一个 Submit 需要同时从三个桶中各取一个令牌才算放行
+*/
+
+// Mode 超限后的处理方式
+type Mode int
+
+const (
+	ModeReject Mode = iota // 直接回复 throttled 的 SubmitAck
+	ModeDelay              // 等待令牌，延迟读取后续数据，形成背压
+)
+
+// Scope 触发限流的层级，用于 metrics 的 label
+const (
+	ScopeConn   = "conn"
+	ScopeClient = "client"
+	ScopeGlobal = "global"
+)
+
+// Bucket 单个令牌桶的配置，Rate 为每秒令牌数，Rate <= 0 表示不限流
+type Bucket struct {
+	Rate  float64
+	Burst int
+}
+
+// Config 限流配置
+type Config struct {
+	Conn   Bucket
+	Client Bucket
+	Global Bucket
+	Mode   Mode
+}
+
+// Limiter 服务级别的限流器，持有 global 桶以及所有 client 桶
+type Limiter struct {
+	global *rate.Limiter
+
+	mu      sync.Mutex
+	cfg     Config
+	clients map[string]*clientBucket
+	conns   map[*ConnLimiter]struct{} // 存活的连接，SetConfig 时更新其 conn 桶
+}
+
+// clientBucket 同一个客户端标识的桶，refs 为引用它的连接数，归零时回收
+type clientBucket struct {
+	lim  *rate.Limiter
+	refs int
+}
+
+// New 创建限流器
+func New(cfg Config) *Limiter {
+	return &Limiter{
+		cfg:     cfg,
+		global:  newRateLimiter(cfg.Global),
+		clients: make(map[string]*clientBucket),
+		conns:   make(map[*ConnLimiter]struct{}),
+	}
+}
+
+// Config 返回当前的限流配置
+func (l *Limiter) Config() Config {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.cfg
+}
+
+// SetConfig 运行时替换限流配置，已有连接与客户端的桶同样生效
+// 桶中已积累的令牌不会补满，放宽限制后可能需要等待令牌重新积累
+func (l *Limiter) SetConfig(cfg Config) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.cfg = cfg
+	setRateLimiter(l.global, cfg.Global)
+	for _, cb := range l.clients {
+		setRateLimiter(cb.lim, cfg.Client)
+	}
+	for c := range l.conns {
+		setRateLimiter(c.conn, cfg.Conn)
+	}
+}
+
+// Mode 返回超限后的处理方式
+func (l *Limiter) Mode() Mode {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.cfg.Mode
+}
+
+// NewConn 为新连接创建连接级别的限流器，连接关闭时需调用 Close
+func (l *Limiter) NewConn() *ConnLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c := &ConnLimiter{
+		l:    l,
+		conn: newRateLimiter(l.cfg.Conn),
+	}
+	l.conns[c] = struct{}{}
+	return c
+}
+
+func (l *Limiter) acquireClient(id string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cb, ok := l.clients[id]
+	if !ok {
+		cb = &clientBucket{lim: newRateLimiter(l.cfg.Client)}
+		l.clients[id] = cb
+	}
+	cb.refs++
+	return cb.lim
+}
+
+func (l *Limiter) releaseClient(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cb, ok := l.clients[id]
+	if !ok {
+		return
+	}
+	cb.refs--
+	if cb.refs <= 0 {
+		delete(l.clients, id)
+	}
+}
+
+// ConnLimiter 连接级别的限流器，非并发安全，只应在处理该连接的协程中使用
+type ConnLimiter struct {
+	l        *Limiter
+	conn     *rate.Limiter
+	client   *rate.Limiter
+	clientID string
+}
+
+// Mode 返回超限后的处理方式
+func (c *ConnLimiter) Mode() Mode {
+	return c.l.Mode()
+}
+
+// Bind 在 Con 握手之后绑定客户端标识，重复绑定会释放之前的标识
+func (c *ConnLimiter) Bind(clientID string) {
+	if c.client != nil {
+		if c.clientID == clientID {
+			return
+		}
+		c.l.releaseClient(c.clientID)
+	}
+	c.clientID = clientID
+	c.client = c.l.acquireClient(clientID)
+}
+
+// Close 连接关闭时调用，释放客户端标识的引用
+func (c *ConnLimiter) Close() {
+	if c.client != nil {
+		c.l.releaseClient(c.clientID)
+		c.client = nil
+	}
+	c.l.mu.Lock()
+	delete(c.l.conns, c)
+	c.l.mu.Unlock()
+}
+
+// Allow 尝试放行一个 Submit，不放行时返回触发限流的层级
+// 未放行时不会消耗任何一个桶中的令牌
+func (c *ConnLimiter) Allow() (ok bool, scope string) {
+	now := time.Now()
+	rs, delay, scope := c.reserve(now)
+	if delay == 0 {
+		return true, ""
+	}
+	for _, r := range rs {
+		r.CancelAt(now)
+	}
+	return false, scope
+}
+
+// Wait 阻塞直到三个桶都放行，返回等待的时长以及等待最久的层级
+func (c *ConnLimiter) Wait(ctx context.Context) (time.Duration, string, error) {
+	now := time.Now()
+	rs, delay, scope := c.reserve(now)
+	if delay == 0 {
+		return 0, "", nil
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return delay, scope, nil
+	case <-ctx.Done():
+		for _, r := range rs {
+			r.Cancel()
+		}
+		return 0, scope, ctx.Err()
+	}
+}
+
+// reserve 从每个桶各预约一个令牌，返回最长的等待时间及其层级
+func (c *ConnLimiter) reserve(now time.Time) ([]*rate.Reservation, time.Duration, string) {
+	var (
+		rs    = make([]*rate.Reservation, 0, 3)
+		delay time.Duration
+		scope string
+	)
+
+	buckets := []struct {
+		lim   *rate.Limiter
+		scope string
+	}{
+		{c.conn, ScopeConn},
+		{c.client, ScopeClient},
+		{c.l.global, ScopeGlobal},
+	}
+	for _, b := range buckets {
+		if b.lim == nil {
+			continue
+		}
+		r := b.lim.ReserveN(now, 1)
+		if !r.OK() {
+			// burst 小于 1，永远无法放行，按最大等待处理
+			for _, prev := range rs {
+				prev.CancelAt(now)
+			}
+			return nil, rate.InfDuration, b.scope
+		}
+		rs = append(rs, r)
+		if d := r.DelayFrom(now); d > delay {
+			delay = d
+			scope = b.scope
+		}
+	}
+	return rs, delay, scope
+}
+
+func newRateLimiter(b Bucket) *rate.Limiter {
+	limit, burst := bucketLimit(b)
+	return rate.NewLimiter(limit, burst)
+}
+
+func setRateLimiter(lim *rate.Limiter, b Bucket) {
+	limit, burst := bucketLimit(b)
+	lim.SetLimit(limit)
+	lim.SetBurst(burst)
+}
+
+func bucketLimit(b Bucket) (rate.Limit, int) {
+	if b.Rate <= 0 {
+		return rate.Inf, 0
+	}
+	burst := b.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.Limit(b.Rate), burst
+}
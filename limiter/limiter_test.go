@@ -0,0 +1,152 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+)
+
+func TestConnLimiter_Allow(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       Config
+		n         int
+		wantOK    int
+		wantScope string
+	}{
+		{name: "Unlimited", cfg: Config{}, n: 100, wantOK: 100},
+		{name: "Conn", cfg: Config{Conn: Bucket{Rate: 1, Burst: 3}}, n: 5, wantOK: 3, wantScope: ScopeConn},
+		{name: "Client", cfg: Config{Client: Bucket{Rate: 1, Burst: 2}}, n: 5, wantOK: 2, wantScope: ScopeClient},
+		{name: "Global", cfg: Config{Conn: Bucket{Rate: 1, Burst: 5}, Global: Bucket{Rate: 1, Burst: 1}}, n: 5, wantOK: 1, wantScope: ScopeGlobal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := New(tt.cfg).NewConn()
+			cl.Bind("00000001")
+			defer cl.Close()
+
+			var gotOK int
+			var gotScope string
+			for i := 0; i < tt.n; i++ {
+				ok, scope := cl.Allow()
+				if ok {
+					gotOK++
+				} else {
+					gotScope = scope
+				}
+			}
+			if gotOK != tt.wantOK {
+				t.Errorf("Allow() ok = %d, want %d", gotOK, tt.wantOK)
+			}
+			if gotScope != tt.wantScope {
+				t.Errorf("Allow() scope = %q, want %q", gotScope, tt.wantScope)
+			}
+		})
+	}
+}
+
+func TestConnLimiter_AllowNoTokenLeak(t *testing.T) {
+	// global 桶不放行时，conn 桶的令牌不应被消耗
+	l := New(Config{Conn: Bucket{Rate: 0.001, Burst: 1}, Global: Bucket{Rate: 0.001, Burst: 1}})
+	other := l.NewConn()
+	if ok, _ := other.Allow(); !ok {
+		t.Fatal("first Allow() = false, want true")
+	}
+
+	cl := l.NewConn()
+	if ok, scope := cl.Allow(); ok || scope != ScopeGlobal {
+		t.Fatalf("Allow() = %v, %q, want false, %q", ok, scope, ScopeGlobal)
+	}
+	if tokens := cl.conn.Tokens(); tokens < 1 {
+		t.Errorf("conn bucket tokens = %v, want 1 after rejected Allow()", tokens)
+	}
+}
+
+func TestLimiter_ClientShared(t *testing.T) {
+	l := New(Config{Client: Bucket{Rate: 0.001, Burst: 1}})
+	a, b := l.NewConn(), l.NewConn()
+	a.Bind("00000001")
+	b.Bind("00000001")
+
+	if ok, _ := a.Allow(); !ok {
+		t.Fatal("a.Allow() = false, want true")
+	}
+	if ok, scope := b.Allow(); ok || scope != ScopeClient {
+		t.Errorf("b.Allow() = %v, %q, want false, %q", ok, scope, ScopeClient)
+	}
+
+	a.Close()
+	b.Close()
+	if len(l.clients) != 0 {
+		t.Errorf("clients = %d, want 0 after Close()", len(l.clients))
+	}
+}
+
+func TestConnLimiter_Wait(t *testing.T) {
+	cl := New(Config{Conn: Bucket{Rate: 100, Burst: 1}, Mode: ModeDelay}).NewConn()
+	if d, _, err := cl.Wait(context.Background()); err != nil || d != 0 {
+		t.Fatalf("Wait() = %v, %v, want 0, nil", d, err)
+	}
+	d, scope, err := cl.Wait(context.Background())
+	if err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if d <= 0 || scope != ScopeConn {
+		t.Errorf("Wait() = %v, %q, want >0, %q", d, scope, ScopeConn)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cl = New(Config{Conn: Bucket{Rate: 0.001, Burst: 1}}).NewConn()
+	cl.Allow()
+	if _, _, err := cl.Wait(ctx); err == nil {
+		t.Error("Wait() with canceled ctx error = nil, want non-nil")
+	}
+}
+
+func TestLimiter_SetConfig(t *testing.T) {
+	l := New(Config{})
+	cl := l.NewConn()
+	cl.Bind("00000001")
+	defer cl.Close()
+	for i := 0; i < 10; i++ {
+		cl.Allow() // 不限流时消耗令牌
+	}
+
+	tests := []struct {
+		name      string
+		cfg       Config
+		n         int
+		wantOK    int
+		wantScope string
+	}{
+		{name: "Conn", cfg: Config{Conn: Bucket{Rate: 0.001, Burst: 1}}, n: 3, wantOK: 0, wantScope: ScopeConn},
+		{name: "Client", cfg: Config{Client: Bucket{Rate: 0.001, Burst: 1}}, n: 3, wantOK: 0, wantScope: ScopeClient},
+		{name: "Unlimited", cfg: Config{Mode: ModeDelay}, n: 100, wantOK: 100},
+		{name: "Global", cfg: Config{Global: Bucket{Rate: 0.001, Burst: 1}}, n: 3, wantOK: 0, wantScope: ScopeGlobal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l.SetConfig(tt.cfg)
+			if cl.Mode() != tt.cfg.Mode || l.Config() != tt.cfg {
+				t.Errorf("Mode() = %v, Config() = %+v, want %+v", cl.Mode(), l.Config(), tt.cfg)
+			}
+
+			var gotOK int
+			var gotScope string
+			for i := 0; i < tt.n; i++ {
+				ok, scope := cl.Allow()
+				if ok {
+					gotOK++
+				} else {
+					gotScope = scope
+				}
+			}
+			if gotOK != tt.wantOK {
+				t.Errorf("Allow() ok = %d, want %d", gotOK, tt.wantOK)
+			}
+			if gotScope != tt.wantScope {
+				t.Errorf("Allow() scope = %q, want %q", gotScope, tt.wantScope)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package logger
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// Level 日志级别
+type Level int32
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// 当前日志级别，原子操作，默认 info
+var level = int32(InfoLevel)
+
+func (l Level) String() string {
+	if l < DebugLevel || l > ErrorLevel {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel 解析日志级别名称，不区分大小写
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return InfoLevel, fmt.Errorf("unknown log level [%s]", s)
+}
+
+// SetLevel 设置日志级别，可在运行时调用
+func SetLevel(l Level) {
+	atomic.StoreInt32(&level, int32(l))
+}
+
+// GetLevel 返回当前日志级别
+func GetLevel() Level {
+	return Level(atomic.LoadInt32(&level))
+}
+
+// Enabled 判断该级别的日志是否会输出
+func Enabled(l Level) bool {
+	return l >= GetLevel()
+}
+
+func Debugf(format string, args ...interface{}) { logf(DebugLevel, format, args...) }
+func Infof(format string, args ...interface{})  { logf(InfoLevel, format, args...) }
+func Warnf(format string, args ...interface{})  { logf(WarnLevel, format, args...) }
+func Errorf(format string, args ...interface{}) { logf(ErrorLevel, format, args...) }
+
+func logf(l Level, format string, args ...interface{}) {
+	if !Enabled(l) {
+		return
+	}
+	log.Output(3, "["+strings.ToUpper(l.String())+"] "+fmt.Sprintf(format, args...))
+}
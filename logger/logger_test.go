@@ -0,0 +1,55 @@
+package logger
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Level
+		wantErr bool
+	}{
+		{name: "debug", want: DebugLevel},
+		{name: "INFO", want: InfoLevel},
+		{name: "Warn", want: WarnLevel},
+		{name: "error", want: ErrorLevel},
+		{name: "trace", want: InfoLevel, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLevel(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLevel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLevel() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer SetLevel(GetLevel())
+
+	SetLevel(WarnLevel)
+	Infof("hidden %d", 1)
+	Warnf("shown %d", 2)
+	if out := buf.String(); strings.Contains(out, "hidden") || !strings.Contains(out, "[WARN] shown 2") {
+		t.Errorf("output = %q, want only warn line", out)
+	}
+
+	buf.Reset()
+	SetLevel(DebugLevel)
+	Debugf("debug")
+	if !strings.Contains(buf.String(), "[DEBUG] debug") {
+		t.Errorf("output = %q, want debug line", buf.String())
+	}
+}
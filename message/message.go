@@ -0,0 +1,206 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+/*
+带类型的消息
+
+Submit.Payload 为原始字节时由业务各自序列化。带类型的消息在 submit packet 中带上消息类型的名称（kind）
+与 payload 的序列化格式（content type），服务端按 kind 找到注册的 Go 类型，反序列化后再交给 handler：
+
+	客户端 SubmitTyped(ctx, v)：Submit{Kind: KindOf(v), ContentType, Payload: Marshal(v)}
+	服务端 Registry.Dispatch：按 kind 新建注册类型的值，按 content type 反序列化，调用 handler
+
+kind 由 KindOf 决定：实现了 Kinder 的类型为 MessageKind()，protobuf 消息为 message 的完整名称，其余为 Go 类型名
+*/
+
+// ContentType payload 的序列化格式
+type ContentType uint8
+
+const (
+	_        ContentType = iota // 0 保留，表示未指定
+	JSON                        // encoding/json
+	Protobuf                    // protobuf，值需要实现 proto.Message
+	MsgPack                     // msgpack
+)
+
+var (
+	// ErrUnsupported 不支持的序列化格式
+	ErrUnsupported = errors.New("message: unsupported content type")
+	// ErrNotProto protobuf 格式的值没有实现 proto.Message
+	ErrNotProto = errors.New("message: value is not a proto.Message")
+	// ErrNoKind 无法确定值的 kind
+	ErrNoKind = errors.New("message: no kind for value")
+	// ErrUnknownKind kind 没有注册
+	ErrUnknownKind = errors.New("message: unknown kind")
+	// ErrDuplicateKind kind 已经注册过
+	ErrDuplicateKind = errors.New("message: duplicate kind")
+)
+
+var names = map[ContentType]string{
+	JSON:     "json",
+	Protobuf: "protobuf",
+	MsgPack:  "msgpack",
+}
+
+func (c ContentType) String() string {
+	if s, ok := names[c]; ok {
+		return s
+	}
+	return fmt.Sprintf("content-type(%d)", uint8(c))
+}
+
+// Supported 是否支持该格式
+func (c ContentType) Supported() bool {
+	_, ok := names[c]
+	return ok
+}
+
+// Parse 按名称解析序列化格式
+func Parse(s string) (ContentType, error) {
+	for c, name := range names {
+		if strings.EqualFold(s, name) {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("%w [%s]", ErrUnsupported, s)
+}
+
+// Marshal 按 c 序列化 v
+func Marshal(c ContentType, v interface{}) ([]byte, error) {
+	switch c {
+	case JSON:
+		return json.Marshal(v)
+	case Protobuf:
+		m, ok := v.(proto.Message)
+		if !ok {
+			return nil, fmt.Errorf("%w [%T]", ErrNotProto, v)
+		}
+		return proto.Marshal(m)
+	case MsgPack:
+		return msgpack.Marshal(v)
+	default:
+		return nil, fmt.Errorf("%w [%d]", ErrUnsupported, c)
+	}
+}
+
+// Unmarshal 按 c 把 data 反序列化到 v，v 应为指针
+func Unmarshal(c ContentType, data []byte, v interface{}) error {
+	switch c {
+	case JSON:
+		return json.Unmarshal(data, v)
+	case Protobuf:
+		m, ok := v.(proto.Message)
+		if !ok {
+			return fmt.Errorf("%w [%T]", ErrNotProto, v)
+		}
+		return proto.Unmarshal(data, m)
+	case MsgPack:
+		return msgpack.Unmarshal(data, v)
+	default:
+		return fmt.Errorf("%w [%d]", ErrUnsupported, c)
+	}
+}
+
+// Kinder 自定义 kind 的消息类型
+type Kinder interface {
+	MessageKind() string
+}
+
+// KindOf 返回 v 的 kind，无法确定时（如匿名类型）返回空字符串
+func KindOf(v interface{}) string {
+	switch t := v.(type) {
+	case Kinder:
+		return t.MessageKind()
+	case proto.Message:
+		return string(t.ProtoReflect().Descriptor().FullName())
+	}
+	return typeOf(v).Name()
+}
+
+// typeOf 返回 v 去掉指针之后的类型
+func typeOf(v interface{}) reflect.Type {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return reflect.TypeOf(struct{}{})
+	}
+	return t
+}
+
+// Handler 处理反序列化后的消息，v 为注册类型的指针，返回值作为 SubmitAck 的 result
+type Handler func(ctx context.Context, clientID string, v interface{}) uint8
+
+type entry struct {
+	typ reflect.Type
+	h   Handler
+}
+
+// Registry kind 到 Go 类型与 handler 的映射，可并发使用
+type Registry struct {
+	mu    sync.RWMutex
+	kinds map[string]entry
+}
+
+// NewRegistry 创建空的 Registry
+func NewRegistry() *Registry {
+	return &Registry{kinds: make(map[string]entry)}
+}
+
+// Register 注册 kind 对应的 Go 类型与 handler，prototype 为该类型的零值或指针
+// kind 为空时按 KindOf(prototype)，同一个 kind 只能注册一次
+func (r *Registry) Register(kind string, prototype interface{}, h Handler) error {
+	if kind == "" {
+		kind = KindOf(prototype)
+	}
+	if kind == "" || len(kind) > 0xff {
+		return fmt.Errorf("%w [%T]", ErrNoKind, prototype)
+	}
+	if h == nil {
+		return fmt.Errorf("message: nil handler for kind [%s]", kind)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.kinds[kind]; ok {
+		return fmt.Errorf("%w [%s] registered as %s", ErrDuplicateKind, kind, e.typ)
+	}
+	r.kinds[kind] = entry{typ: typeOf(prototype), h: h}
+	return nil
+}
+
+// Decode 按 kind 新建注册类型的值，把 data 反序列化到其中，返回该值的指针与对应的 handler
+func (r *Registry) Decode(c ContentType, kind string, data []byte) (interface{}, Handler, error) {
+	r.mu.RLock()
+	e, ok := r.kinds[kind]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("%w [%s]", ErrUnknownKind, kind)
+	}
+	v := reflect.New(e.typ).Interface()
+	if err := Unmarshal(c, data, v); err != nil {
+		return nil, nil, fmt.Errorf("message: decode %s as %s: %w", kind, c, err)
+	}
+	return v, e.h, nil
+}
+
+// Dispatch 反序列化消息并调用 handler，返回 handler 的 result
+func (r *Registry) Dispatch(ctx context.Context, clientID string, c ContentType, kind string, data []byte) (uint8, error) {
+	v, h, err := r.Decode(c, kind, data)
+	if err != nil {
+		return 0, err
+	}
+	return h(ctx, clientID, v), nil
+}
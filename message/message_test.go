@@ -0,0 +1,131 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type order struct {
+	ID    string `json:"id" msgpack:"id"`
+	Count int    `json:"count" msgpack:"count"`
+}
+
+type custom struct{}
+
+func (custom) MessageKind() string { return "custom.v1" }
+
+func TestMarshal(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       ContentType
+		v       interface{}
+		newV    func() interface{}
+		wantErr error
+	}{
+		{name: "JSON", c: JSON, v: &order{ID: "a", Count: 2}, newV: func() interface{} { return &order{} }},
+		{name: "MsgPack", c: MsgPack, v: &order{ID: "a", Count: 2}, newV: func() interface{} { return &order{} }},
+		{name: "Protobuf", c: Protobuf, v: wrapperspb.String("hello"), newV: func() interface{} { return &wrapperspb.StringValue{} }},
+		{name: "NotProto", c: Protobuf, v: &order{}, wantErr: ErrNotProto},
+		{name: "Unsupported", c: ContentType(9), v: &order{}, wantErr: ErrUnsupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Marshal(tt.c, tt.v)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Marshal() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			got := tt.newV()
+			if err = Unmarshal(tt.c, data, got); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if m, ok := got.(proto.Message); ok {
+				if !proto.Equal(m, tt.v.(proto.Message)) {
+					t.Errorf("Unmarshal() = %v, want %v", got, tt.v)
+				}
+			} else if !reflect.DeepEqual(got, tt.v) {
+				t.Errorf("Unmarshal() = %v, want %v", got, tt.v)
+			}
+		})
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		want string
+	}{
+		{name: "Struct", v: order{}, want: "order"},
+		{name: "Pointer", v: &order{}, want: "order"},
+		{name: "Kinder", v: custom{}, want: "custom.v1"},
+		{name: "Proto", v: &wrapperspb.StringValue{}, want: "google.protobuf.StringValue"},
+		{name: "Anonymous", v: struct{ A int }{}, want: ""},
+		{name: "Nil", v: nil, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := KindOf(tt.v); got != tt.want {
+				t.Errorf("KindOf() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	var got interface{}
+	h := func(ctx context.Context, clientID string, v interface{}) uint8 {
+		got = v
+		return 3
+	}
+	if err := r.Register("", &order{}, h); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err := r.Register("order", order{}, h); !errors.Is(err, ErrDuplicateKind) {
+		t.Errorf("Register() error = %v, want %v", err, ErrDuplicateKind)
+	}
+	if err := r.Register("", struct{}{}, h); !errors.Is(err, ErrNoKind) {
+		t.Errorf("Register() error = %v, want %v", err, ErrNoKind)
+	}
+
+	data, _ := Marshal(MsgPack, &order{ID: "a", Count: 1})
+	tests := []struct {
+		name    string
+		c       ContentType
+		kind    string
+		data    []byte
+		want    interface{}
+		wantErr error
+	}{
+		{name: "JSON", c: JSON, kind: "order", data: []byte(`{"id":"b","count":2}`), want: &order{ID: "b", Count: 2}},
+		{name: "MsgPack", c: MsgPack, kind: "order", data: data, want: &order{ID: "a", Count: 1}},
+		{name: "UnknownKind", c: JSON, kind: "refund", data: []byte(`{}`), wantErr: ErrUnknownKind},
+		{name: "Unsupported", c: ContentType(9), kind: "order", data: []byte(`{}`), wantErr: ErrUnsupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			result, err := r.Dispatch(context.Background(), "00000001", tt.c, tt.kind, tt.data)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Dispatch() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if result != 3 {
+				t.Errorf("Dispatch() result = %d, want 3", result)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("handler got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
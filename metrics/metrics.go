@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -12,7 +13,8 @@ import (
  添加 prometheus 相关
 */
 
-const metricsHTTPPort = 8889 // for prometheus to connect
+// DefaultAddr metrics http 服务的默认监听地址，for prometheus to connect
+const DefaultAddr = ":8889"
 
 var (
 	// ClientConnected tcp-service 瞬时连接数
@@ -21,6 +23,48 @@ var (
 	ReqRecvTotal prometheus.Counter
 	// RspSendTotal tcp-service 发送消息计数
 	RspSendTotal prometheus.Counter
+	// SubmitThrottledTotal tcp-service 被限流的 submit 计数，按触发层级区分
+	SubmitThrottledTotal *prometheus.CounterVec
+	// ConnRejectedTotal tcp-service 被拒绝的连接计数，按拒绝原因区分
+	ConnRejectedTotal *prometheus.CounterVec
+	// AcceptErrorTotal tcp-service accept 出错计数
+	AcceptErrorTotal prometheus.Counter
+	// DeliverSendTotal tcp-service 主动推送计数
+	DeliverSendTotal prometheus.Counter
+	// SessionActive tcp-service 已完成握手的会话数
+	SessionActive prometheus.Gauge
+	// DuplicateLoginTotal tcp-service 重复登录计数，按处理方式区分
+	DuplicateLoginTotal *prometheus.CounterVec
+	// PubSubPublishTotal tcp-service 带 topic 的 submit 计数
+	PubSubPublishTotal prometheus.Counter
+	// PubSubDeliverTotal tcp-service 订阅推送计数
+	PubSubDeliverTotal prometheus.Counter
+	// PubSubDroppedTotal tcp-service 订阅者队列满或消息无法编码（unencodable）时丢弃计数，按处理方式区分
+	PubSubDroppedTotal *prometheus.CounterVec
+	// AuthFailedTotal tcp-service Con 握手鉴权失败计数
+	AuthFailedTotal prometheus.Counter
+	// ConfigGeneration tcp-service 当前生效的配置代数，启动时为 1，每次热加载成功加 1
+	ConfigGeneration prometheus.Gauge
+	// ConfigReloadTotal tcp-service 配置热加载计数，按结果区分
+	ConfigReloadTotal *prometheus.CounterVec
+	// ConfigRestartPending tcp-service 最近一次热加载中需要重启才能生效的变更数
+	ConfigRestartPending prometheus.Gauge
+	// CompressionNegotiatedTotal tcp-service Con 握手协商出的压缩算法计数，未协商出时为 none
+	CompressionNegotiatedTotal *prometheus.CounterVec
+	// CompressionRawBytesTotal tcp-service 压缩 frame 压缩前的字节数，按算法与方向区分
+	CompressionRawBytesTotal *prometheus.CounterVec
+	// CompressionWireBytesTotal tcp-service 压缩 frame 压缩后的字节数，按算法与方向区分
+	CompressionWireBytesTotal *prometheus.CounterVec
+	// CompressionRatio tcp-service 压缩 frame 压缩后与压缩前长度之比，按算法与方向区分
+	CompressionRatio *prometheus.HistogramVec
+	// ChecksumNegotiatedTotal tcp-service Con 握手协商出的校验和算法计数，未协商出时为 none
+	ChecksumNegotiatedTotal *prometheus.CounterVec
+	// ChecksumFailuresTotal tcp-service 收到的 frame 校验和不一致的次数，按算法区分
+	ChecksumFailuresTotal *prometheus.CounterVec
+	// EncryptionNegotiatedTotal tcp-service Con 握手协商出的加密算法计数，未协商出时为 none
+	EncryptionNegotiatedTotal *prometheus.CounterVec
+	// EncryptionFailuresTotal tcp-service 收到的 frame 解密失败的次数，按原因区分：auth、replay、plaintext
+	EncryptionFailuresTotal *prometheus.CounterVec
 )
 
 func init() {
@@ -35,22 +79,102 @@ func init() {
 		Name: "tcp_server_client_connected",
 	})
 
-	prometheus.MustRegister(ReqRecvTotal, RspSendTotal, ClientConnected)
+	SubmitThrottledTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tcp_server_submit_throttled_total",
+	}, []string{"scope"})
 
-	// start the metrics server
-	metricsServer := &http.Server{
-		Addr: fmt.Sprintf(":%d", metricsHTTPPort),
+	ConnRejectedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tcp_server_conn_rejected_total",
+	}, []string{"reason"})
+
+	AcceptErrorTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "tcp_server_accept_error_total",
+	})
+
+	DeliverSendTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "tcp_server_deliver_send_total",
+	})
+
+	SessionActive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "tcp_server_session_active",
+	})
+	DuplicateLoginTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tcp_server_duplicate_login_total",
+	}, []string{"action"})
+
+	PubSubPublishTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "tcp_server_pubsub_publish_total",
+	})
+	PubSubDeliverTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "tcp_server_pubsub_deliver_total",
+	})
+	PubSubDroppedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tcp_server_pubsub_dropped_total",
+	}, []string{"policy"})
+
+	AuthFailedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "tcp_server_auth_failed_total",
+	})
+
+	ConfigGeneration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "tcp_server_config_generation",
+	})
+	ConfigReloadTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tcp_server_config_reload_total",
+	}, []string{"result"})
+	ConfigRestartPending = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "tcp_server_config_restart_pending",
+	})
+
+	CompressionNegotiatedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tcp_server_compression_negotiated_total",
+	}, []string{"algorithm"})
+	CompressionRawBytesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tcp_server_compression_raw_bytes_total",
+	}, []string{"algorithm", "direction"})
+	CompressionWireBytesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tcp_server_compression_wire_bytes_total",
+	}, []string{"algorithm", "direction"})
+	CompressionRatio = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tcp_server_compression_ratio",
+		Buckets: prometheus.LinearBuckets(0.1, 0.1, 10),
+	}, []string{"algorithm", "direction"})
+	ChecksumNegotiatedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tcp_server_checksum_negotiated_total",
+	}, []string{"algorithm"})
+	ChecksumFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tcp_server_checksum_failures_total",
+	}, []string{"algorithm"})
+	EncryptionNegotiatedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tcp_server_encryption_negotiated_total",
+	}, []string{"cipher"})
+	EncryptionFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tcp_server_encryption_failures_total",
+	}, []string{"reason"})
+
+	prometheus.MustRegister(ReqRecvTotal, RspSendTotal, ClientConnected, SubmitThrottledTotal, ConnRejectedTotal,
+		AcceptErrorTotal, DeliverSendTotal, SessionActive, DuplicateLoginTotal,
+		PubSubPublishTotal, PubSubDeliverTotal, PubSubDroppedTotal, AuthFailedTotal,
+		ConfigGeneration, ConfigReloadTotal, ConfigRestartPending,
+		CompressionNegotiatedTotal, CompressionRawBytesTotal, CompressionWireBytesTotal, CompressionRatio,
+		ChecksumNegotiatedTotal, ChecksumFailuresTotal, EncryptionNegotiatedTotal, EncryptionFailuresTotal)
+}
+
+// ListenAndServe 在 addr 上启动 metrics http 服务，阻塞直到出错
+func ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
 	}
+	return Serve(l)
+}
 
+// Serve 在 l 上提供 /metrics
+func Serve(l net.Listener) error {
 	mu := http.NewServeMux()
 	mu.Handle("/metrics", promhttp.Handler())
-	metricsServer.Handler = mu
-
-	go func() {
-		err := metricsServer.ListenAndServe()
-		if err != nil {
-			fmt.Println("prometheus-exporter http server start failed:", err)
-		}
-	}()
-	fmt.Println("metrics server start ok(*:8889)")
+	metricsServer := &http.Server{Handler: mu}
+
+	fmt.Printf("metrics server start ok(%s)\n", l.Addr())
+	return metricsServer.Serve(l)
 }
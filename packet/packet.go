@@ -2,6 +2,8 @@ package packet
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"sync"
 )
@@ -21,22 +23,122 @@ import (
 
 8字节 ID 字符串
 1字节 result
+
+### deliver packet（服务端主动推送）
+
+8字节 ID 字符串
+任意字节 payload
+
+### deliver ack packet
+
+8字节 ID 字符串
+1字节 result
+
+### subscribe/unsubscribe packet
+
+8字节 ID 字符串
+任意字节 topic（订阅时可含通配符）
+
+### subscribe/unsubscribe ack packet
+
+8字节 ID 字符串
+1字节 result
+
+### topic submit/topic deliver packet（带 topic 的 submit/deliver）
+
+8字节 ID 字符串
+2字节 topic 长度
+topic
+任意字节 payload
+
+### typed submit packet（带消息类型的 submit）
+
+8字节 ID 字符串
+1字节 content type，payload 的序列化格式
+1字节 kind 长度
+kind，消息类型的名称
+任意字节 payload
+
+### option conn packet（带扩展选项的 conn）
+
+8字节 ID 字符串
+2字节 选项总长度
+选项列表
+任意字节 payload
+
+### conn ack packet 的扩展选项
+
+result 之后可跟选项列表，旧版本客户端忽略
+
+### 选项
+
+1字节 类型
+2字节 value 长度
+value
 */
 
 const (
-	CommandConn   = iota + 0x01 // 连接请求包（值为0x01）
-	CommandSubmit               // 消息请求包（值为0x02）
+	CommandConn         = iota + 0x01 // 连接请求包（值为0x01）
+	CommandSubmit                     // 消息请求包（值为0x02）
+	CommandDeliver                    // 推送请求包（值为0x03）
+	CommandSubscribe                  // 订阅请求包（值为0x04）
+	CommandUnsubscribe                // 取消订阅请求包（值为0x05）
+	CommandTopicSubmit                // 带 topic 的消息请求包（值为0x06），对应 Topic 不为空的 Submit
+	CommandTopicDeliver               // 带 topic 的推送请求包（值为0x07），对应 Topic 不为空的 Deliver
+	CommandOptionConn                 // 带扩展选项的连接请求包（值为0x08），对应 Options 不为空的 Con
+	CommandTypedSubmit                // 带消息类型的消息请求包（值为0x09），对应 Kind 不为空的 Submit
 )
 
 const (
-	CommandConnAck   = iota + 0x80 // 连接响应包（值为0x81）
-	CommandSubmitAck               // 消息响应包（值为0x82）
+	CommandConnAck        = iota + 0x80 // 连接响应包（值为0x80）
+	CommandSubmitAck                    // 消息响应包（值为0x81）
+	CommandDeliverAck                   // 推送响应包（值为0x82）
+	CommandSubscribeAck                 // 订阅响应包（值为0x83）
+	CommandUnsubscribeAck               // 取消订阅响应包（值为0x84）
 )
 
+// ErrShortPacket packet 长度不足
+var ErrShortPacket = errors.New("short packet")
+
 const (
 	OkResponse = "OK"
 )
 
+// ack 包中 Result 的取值
+const (
+	ResultOK        uint8 = iota // 成功
+	ResultFailed                 // 失败
+	ResultThrottled              // 被限流
+	ResultRefused                // 连接被拒绝
+	ResultDuplicate              // 客户端标识已登录
+)
+
+// 扩展选项的类型
+const (
+	// OptionCompression 压缩算法，Con 中为客户端支持的算法（按优先级），ConAck 中为服务端选定的算法，每个算法 1 字节
+	OptionCompression uint8 = iota + 0x01
+	// OptionChecksum frame 校验和算法，Con 中为客户端支持的算法（按优先级），ConAck 中为服务端选定的算法，每个算法 1 字节
+	OptionChecksum
+	// OptionEncryption 端到端加密，Con 中为客户端的 X25519 公钥加上支持的算法（按优先级），ConAck 中为服务端选定的算法加上服务端的公钥
+	OptionEncryption
+)
+
+// Option Con/ConAck 的扩展选项
+type Option struct {
+	Type  uint8
+	Value []byte
+}
+
+// FindOption 返回第一个类型为 typ 的选项的 value
+func FindOption(opts []Option, typ uint8) ([]byte, bool) {
+	for _, o := range opts {
+		if o.Type == typ {
+			return o.Value, true
+		}
+	}
+	return nil, false
+}
+
 type Packet interface {
 	Decode([]byte) error     // []byte -> struct
 	Encode() ([]byte, error) //  struct -> []byte
@@ -44,18 +146,51 @@ type Packet interface {
 
 type Submit struct {
 	ID      string // ID 消息请求包的ID
+	Topic   string // Topic 发布到的 topic，为空表示普通的 submit
 	Payload []byte // Payload 消息请求包的具体信息
+
+	// Kind 消息类型的名称，为空表示 payload 为原始字节；不能与 Topic 同时使用
+	Kind string
+	// ContentType payload 的序列化格式，Kind 不为空时有效，取值见 message 包
+	ContentType uint8
 }
 
 // Decode 解析 Packet 中的信息
 func (s *Submit) Decode(packetBody []byte) error {
+	if len(packetBody) < 8 {
+		return ErrShortPacket
+	}
 	s.ID = string(packetBody[:8]) // 取前 8 个字符 转换成字符串
-	s.Payload = packetBody[8:]    // 取剩下所有的 具体内容
+	s.Topic, s.Kind, s.ContentType = "", "", 0
+	s.Payload = packetBody[8:] // 取剩下所有的 具体内容
 	return nil
 }
 
+// CommandID 带消息类型、带 topic 的 submit 使用各自的 commandID
+func (s *Submit) CommandID() uint8 {
+	switch {
+	case s.Kind != "":
+		return CommandTypedSubmit
+	case s.Topic != "":
+		return CommandTopicSubmit
+	}
+	return CommandSubmit
+}
+
 // Encode 编译 Packet 中的信息
 func (s *Submit) Encode() ([]byte, error) {
+	if s.Kind != "" {
+		if s.Topic != "" {
+			return nil, errors.New("typed submit with topic")
+		}
+		if len(s.Kind) > 0xff {
+			return nil, fmt.Errorf("kind too long [%d]", len(s.Kind))
+		}
+		return bytes.Join([][]byte{[]byte(s.ID[:8]), {s.ContentType, byte(len(s.Kind))}, []byte(s.Kind), s.Payload}, nil), nil
+	}
+	if s.Topic != "" {
+		return encodeTopicBody(s.ID, s.Topic, s.Payload)
+	}
 	// return []byte(s.ID + string(s.Payload)), nil
 	// 这个地方需要补齐8位s.ID[:8]
 	return bytes.Join([][]byte{[]byte(s.ID[:8]), s.Payload}, nil), nil
@@ -67,6 +202,9 @@ type SubmitAck struct {
 }
 
 func (s *SubmitAck) Decode(packetBody []byte) error {
+	if len(packetBody) < 9 {
+		return ErrShortPacket
+	}
 	s.ID = string(packetBody[:8]) // 取得ID
 	s.Result = packetBody[8]      // 取得结果
 	return nil
@@ -80,116 +218,290 @@ func (s *SubmitAck) Encode() ([]byte, error) {
 type Con struct {
 	ID      string
 	Payload []byte
+	Options []Option // Options 扩展选项，为空表示普通的 conn
 }
 
 func (c *Con) Decode(connBody []byte) error {
+	if len(connBody) < 8 {
+		return ErrShortPacket
+	}
 	c.ID = string(connBody[:8])
-	c.Payload = connBody[8:]
+	c.Options = nil
+	if len(connBody) > 8 {
+		c.Payload = connBody[8:]
+	}
 	return nil
 }
 
+// CommandID 带扩展选项的 conn 使用 CommandOptionConn
+func (c *Con) CommandID() uint8 {
+	if len(c.Options) > 0 {
+		return CommandOptionConn
+	}
+	return CommandConn
+}
+
 func (c *Con) Encode() ([]byte, error) {
+	if len(c.Options) > 0 {
+		opts, err := encodeOptions(c.Options)
+		if err != nil {
+			return nil, err
+		}
+		if len(opts) > 0xffff {
+			return nil, fmt.Errorf("options too long [%d]", len(opts))
+		}
+		var optsLen [2]byte
+		binary.BigEndian.PutUint16(optsLen[:], uint16(len(opts)))
+		return bytes.Join([][]byte{[]byte(c.ID[:8]), optsLen[:], opts, c.Payload}, nil), nil
+	}
 	return bytes.Join([][]byte{[]byte(c.ID[:8]), c.Payload}, nil), nil
 }
 
+// decodeOptionConn 解码带扩展选项的 conn packet body
+func decodeOptionConn(connBody []byte) (*Con, error) {
+	if len(connBody) < 10 {
+		return nil, ErrShortPacket
+	}
+	optsLen := int(binary.BigEndian.Uint16(connBody[8:10]))
+	if len(connBody) < 10+optsLen {
+		return nil, ErrShortPacket
+	}
+	opts, err := decodeOptions(connBody[10 : 10+optsLen])
+	if err != nil {
+		return nil, err
+	}
+	c := &Con{ID: string(connBody[:8]), Options: opts}
+	if len(connBody) > 10+optsLen {
+		c.Payload = connBody[10+optsLen:]
+	}
+	return c, nil
+}
+
 // ConAck 连接请求包
 type ConAck struct {
-	ID     string // 连接响应包Id
-	Result uint8  // 结果 ack 的result 是 0/1
+	ID      string   // 连接响应包Id
+	Result  uint8    // 结果 ack 的result 是 0/1
+	Options []Option // Options 扩展选项，跟在 result 之后
 }
 
 func (c *ConAck) Decode(connBody []byte) error {
+	if len(connBody) < 9 {
+		return ErrShortPacket
+	}
 	c.ID = string(connBody[:8]) // 取得id
 	c.Result = connBody[8]
+	opts, err := decodeOptions(connBody[9:])
+	if err != nil {
+		return err
+	}
+	c.Options = opts
 	return nil
 }
 
 func (c *ConAck) Encode() ([]byte, error) {
-	return bytes.Join([][]byte{[]byte(c.ID[:8]), []byte{c.Result}}, nil), nil
+	opts, err := encodeOptions(c.Options)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Join([][]byte{[]byte(c.ID[:8]), []byte{c.Result}, opts}, nil), nil
 }
 
-var SubmitPool = sync.Pool{
-	New: func() interface{} {
-		return &Submit{}
-	},
+// encodeOptions 编码选项列表：每个选项为 类型 + value 长度 + value
+func encodeOptions(opts []Option) ([]byte, error) {
+	var buf []byte
+	for _, o := range opts {
+		if len(o.Value) > 0xffff {
+			return nil, fmt.Errorf("option %d too long [%d]", o.Type, len(o.Value))
+		}
+		buf = append(buf, o.Type, byte(len(o.Value)>>8), byte(len(o.Value)))
+		buf = append(buf, o.Value...)
+	}
+	return buf, nil
 }
 
-// Decode 根据 frame 的解析结果，继续解析
-func Decode(packet []byte) (Packet, error) {
-	commandID := packet[0] // 1 byte: commandID 类型
-	pktBody := packet[1:]
-
-	switch commandID {
-	case CommandConn:
-		c := &Con{}
-		err := c.Decode(pktBody)
-		if err != nil {
-			return nil, err
+// decodeOptions 解码选项列表
+func decodeOptions(b []byte) ([]Option, error) {
+	var opts []Option
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return nil, ErrShortPacket
 		}
-		return c, nil
-	case CommandConnAck:
-		c := &ConAck{}
-		err := c.Decode(pktBody)
-		if err != nil {
-			return nil, err
+		n := int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < 3+n {
+			return nil, ErrShortPacket
 		}
-		return c, nil
-	case CommandSubmit:
-		// s := Submit{}
-		// 优化堆内存 使用池化 Submit
-		s := SubmitPool.Get().(*Submit) // get submit pool
-		err := s.Decode(pktBody)
-		if err != nil {
-			return nil, err
-		}
-		return s, nil
-	case CommandSubmitAck:
-		s := &SubmitAck{}
-		err := s.Decode(pktBody)
-		if err != nil {
-			return nil, err
-		}
-		return s, nil
-	default:
-		return nil, fmt.Errorf("unknown commandID [%d]", commandID)
+		opts = append(opts, Option{Type: b[0], Value: b[3 : 3+n]})
+		b = b[3+n:]
 	}
+	return opts, nil
 }
 
-// Encode 编译 Packet 将结果向上传递给 Frame
-func Encode(p Packet) ([]byte, error) {
-	var (
-		commandID uint8
-		pktBody   []byte
-		err       error
-	)
+// Deliver 服务端推送给客户端的请求包
+type Deliver struct {
+	ID      string // ID 推送请求包的ID
+	Topic   string // Topic 订阅推送时的 topic，为空表示直接推送
+	Payload []byte // Payload 推送的具体信息
+}
 
-	switch t := p.(type) {
-	case *Con:
-		commandID = CommandConn
-		pktBody, err = p.Encode()
-		if err != nil {
-			return nil, err
-		}
-	case *ConAck:
-		commandID = CommandConnAck
-		pktBody, err = p.Encode()
-		if err != nil {
-			return nil, err
-		}
-	case *Submit:
-		commandID = CommandSubmit
-		pktBody, err = p.Encode()
-		if err != nil {
-			return nil, err
-		}
-	case *SubmitAck:
-		commandID = CommandSubmitAck
-		pktBody, err = p.Encode()
-		if err != nil {
-			return nil, err
-		}
-	default:
-		return nil, fmt.Errorf("unknown type [%s]", t)
+func (d *Deliver) Decode(packetBody []byte) error {
+	if len(packetBody) < 8 {
+		return ErrShortPacket
+	}
+	d.ID = string(packetBody[:8])
+	d.Topic = ""
+	d.Payload = packetBody[8:]
+	return nil
+}
+
+// CommandID 带 topic 的 deliver 使用 CommandTopicDeliver
+func (d *Deliver) CommandID() uint8 {
+	if d.Topic != "" {
+		return CommandTopicDeliver
+	}
+	return CommandDeliver
+}
+
+func (d *Deliver) Encode() ([]byte, error) {
+	if d.Topic != "" {
+		return encodeTopicBody(d.ID, d.Topic, d.Payload)
+	}
+	return bytes.Join([][]byte{[]byte(d.ID[:8]), d.Payload}, nil), nil
+}
+
+// DeliverAck 客户端对推送的响应包
+type DeliverAck struct {
+	ID     string // 推送响应包Id
+	Result uint8  // 结果 ack 的result 是 0/1
+}
+
+func (d *DeliverAck) Decode(packetBody []byte) error {
+	if len(packetBody) < 9 {
+		return ErrShortPacket
+	}
+	d.ID = string(packetBody[:8])
+	d.Result = packetBody[8]
+	return nil
+}
+
+func (d *DeliverAck) Encode() ([]byte, error) {
+	return bytes.Join([][]byte{[]byte(d.ID[:8]), []byte{d.Result}}, nil), nil
+}
+
+// Subscribe 订阅请求包
+type Subscribe struct {
+	ID    string // 订阅请求包Id
+	Topic string // 订阅的 topic，可含通配符
+}
+
+func (s *Subscribe) Decode(packetBody []byte) error {
+	if len(packetBody) < 8 {
+		return ErrShortPacket
+	}
+	s.ID = string(packetBody[:8])
+	s.Topic = string(packetBody[8:])
+	return nil
+}
+
+func (s *Subscribe) Encode() ([]byte, error) {
+	return bytes.Join([][]byte{[]byte(s.ID[:8]), []byte(s.Topic)}, nil), nil
+}
+
+// SubscribeAck 订阅响应包
+type SubscribeAck struct {
+	ID     string // 订阅响应包Id
+	Result uint8  // 结果 ack 的result 是 0/1
+}
+
+func (s *SubscribeAck) Decode(packetBody []byte) error {
+	if len(packetBody) < 9 {
+		return ErrShortPacket
+	}
+	s.ID = string(packetBody[:8])
+	s.Result = packetBody[8]
+	return nil
+}
+
+func (s *SubscribeAck) Encode() ([]byte, error) {
+	return bytes.Join([][]byte{[]byte(s.ID[:8]), []byte{s.Result}}, nil), nil
+}
+
+// Unsubscribe 取消订阅请求包
+type Unsubscribe struct {
+	ID    string // 取消订阅请求包Id
+	Topic string // 取消订阅的 topic，需与订阅时一致
+}
+
+func (u *Unsubscribe) Decode(packetBody []byte) error {
+	if len(packetBody) < 8 {
+		return ErrShortPacket
+	}
+	u.ID = string(packetBody[:8])
+	u.Topic = string(packetBody[8:])
+	return nil
+}
+
+func (u *Unsubscribe) Encode() ([]byte, error) {
+	return bytes.Join([][]byte{[]byte(u.ID[:8]), []byte(u.Topic)}, nil), nil
+}
+
+// UnsubscribeAck 取消订阅响应包
+type UnsubscribeAck struct {
+	ID     string // 取消订阅响应包Id
+	Result uint8  // 结果 ack 的result 是 0/1
+}
+
+func (u *UnsubscribeAck) Decode(packetBody []byte) error {
+	if len(packetBody) < 9 {
+		return ErrShortPacket
 	}
-	return bytes.Join([][]byte{{commandID}, pktBody}, nil), nil
+	u.ID = string(packetBody[:8])
+	u.Result = packetBody[8]
+	return nil
+}
+
+func (u *UnsubscribeAck) Encode() ([]byte, error) {
+	return bytes.Join([][]byte{[]byte(u.ID[:8]), []byte{u.Result}}, nil), nil
+}
+
+// encodeTopicBody 编码带 topic 的 packet body: ID + topic 长度 + topic + payload
+func encodeTopicBody(id, topic string, payload []byte) ([]byte, error) {
+	if len(topic) > 0xffff {
+		return nil, fmt.Errorf("topic too long [%d]", len(topic))
+	}
+	var topicLen [2]byte
+	binary.BigEndian.PutUint16(topicLen[:], uint16(len(topic)))
+	return bytes.Join([][]byte{[]byte(id[:8]), topicLen[:], []byte(topic), payload}, nil), nil
+}
+
+// decodeTypedSubmit 解码带消息类型的 submit packet body 到 s
+func decodeTypedSubmit(s *Submit, packetBody []byte) error {
+	if len(packetBody) < 10 {
+		return ErrShortPacket
+	}
+	kindLen := int(packetBody[9])
+	if len(packetBody) < 10+kindLen {
+		return ErrShortPacket
+	}
+	s.ID, s.Topic = string(packetBody[:8]), ""
+	s.ContentType, s.Kind = packetBody[8], string(packetBody[10:10+kindLen])
+	s.Payload = packetBody[10+kindLen:]
+	return nil
+}
+
+// decodeTopicBody 解码带 topic 的 packet body
+func decodeTopicBody(packetBody []byte) (id, topic string, payload []byte, err error) {
+	if len(packetBody) < 10 {
+		return "", "", nil, ErrShortPacket
+	}
+	topicLen := int(binary.BigEndian.Uint16(packetBody[8:10]))
+	if len(packetBody) < 10+topicLen {
+		return "", "", nil, ErrShortPacket
+	}
+	return string(packetBody[:8]), string(packetBody[10 : 10+topicLen]), packetBody[10+topicLen:], nil
+}
+
+var SubmitPool = sync.Pool{
+	New: func() interface{} {
+		return &Submit{}
+	},
 }
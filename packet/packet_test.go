@@ -179,6 +179,141 @@ func TestDecode(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "DeliverDecodeTest",
+			args: args{packet: []byte{CommandDeliver, '0', '0', '0', '0', '0', '0', '0', '1', 'h', 'e', 'l', 'l', 'o'}},
+			want: &Deliver{
+				ID:      "00000001",
+				Payload: []byte{'h', 'e', 'l', 'l', 'o'},
+			},
+			wantErr: false,
+		},
+		{
+			name: "DeliverAckDecodeTest",
+			args: args{packet: []byte{CommandDeliverAck, '0', '0', '0', '0', '0', '0', '0', '1', 1}},
+			want: &DeliverAck{
+				ID:     "00000001",
+				Result: 1,
+			},
+			wantErr: false,
+		},
+		{
+			name: "SubscribeDecodeTest",
+			args: args{packet: []byte{CommandSubscribe, '0', '0', '0', '0', '0', '0', '0', '1', 'a', '/', '+'}},
+			want: &Subscribe{
+				ID:    "00000001",
+				Topic: "a/+",
+			},
+			wantErr: false,
+		},
+		{
+			name: "UnsubscribeAckDecodeTest",
+			args: args{packet: []byte{CommandUnsubscribeAck, '0', '0', '0', '0', '0', '0', '0', '1', 0}},
+			want: &UnsubscribeAck{
+				ID:     "00000001",
+				Result: 0,
+			},
+			wantErr: false,
+		},
+		{
+			name: "TopicSubmitDecodeTest",
+			args: args{packet: []byte{CommandTopicSubmit, '0', '0', '0', '0', '0', '0', '0', '1', 0, 3, 'a', '/', 'b', 'h', 'i'}},
+			want: &Submit{
+				ID:      "00000001",
+				Topic:   "a/b",
+				Payload: []byte{'h', 'i'},
+			},
+			wantErr: false,
+		},
+		{
+			name: "TypedSubmitDecodeTest",
+			args: args{packet: []byte{CommandTypedSubmit, '0', '0', '0', '0', '0', '0', '0', '1', 1, 2, 'o', 'k', '{', '}'}},
+			want: &Submit{
+				ID:          "00000001",
+				Kind:        "ok",
+				ContentType: 1,
+				Payload:     []byte{'{', '}'},
+			},
+			wantErr: false,
+		},
+		{
+			name: "TopicDeliverDecodeTest",
+			args: args{packet: []byte{CommandTopicDeliver, '0', '0', '0', '0', '0', '0', '0', '1', 0, 1, 'a', 'h', 'i'}},
+			want: &Deliver{
+				ID:      "00000001",
+				Topic:   "a",
+				Payload: []byte{'h', 'i'},
+			},
+			wantErr: false,
+		},
+		{
+			name: "OptionConnDecodeTest",
+			args: args{packet: []byte{CommandOptionConn, '0', '0', '0', '0', '0', '0', '0', '1', 0, 5, OptionCompression, 0, 2, 3, 1, 'p', 'w'}},
+			want: &Con{
+				ID:      "00000001",
+				Payload: []byte{'p', 'w'},
+				Options: []Option{{Type: OptionCompression, Value: []byte{3, 1}}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "ConAckOptionDecodeTest",
+			args: args{packet: []byte{CommandConnAck, '0', '0', '0', '0', '0', '0', '0', '1', 0, OptionCompression, 0, 1, 3}},
+			want: &ConAck{
+				ID:      "00000001",
+				Result:  ResultOK,
+				Options: []Option{{Type: OptionCompression, Value: []byte{3}}},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "OptionConnShortDecodeTest",
+			args:    args{packet: []byte{CommandOptionConn, '0', '0', '0', '0', '0', '0', '0', '1', 0, 5, OptionCompression, 0, 2, 3}},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "ConAckShortOptionDecodeTest",
+			args:    args{packet: []byte{CommandConnAck, '0', '0', '0', '0', '0', '0', '0', '1', 0, OptionCompression, 0, 2, 3}},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "TopicSubmitShortDecodeTest",
+			args:    args{packet: []byte{CommandTopicSubmit, '0', '0', '0', '0', '0', '0', '0', '1', 0, 9, 'a'}},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "TypedSubmitShortDecodeTest",
+			args:    args{packet: []byte{CommandTypedSubmit, '0', '0', '0', '0', '0', '0', '0', '1', 1, 5, 'o', 'k'}},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "EmptyDecodeTest",
+			args:    args{packet: []byte{}},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "SubmitShortDecodeTest",
+			args:    args{packet: []byte{CommandSubmit, '0', '0', '1'}},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "SubmitAckShortDecodeTest",
+			args:    args{packet: []byte{CommandSubmitAck, '0', '0', '0', '0', '0', '0', '0', '1'}},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "UnknownDecodeTest",
+			args:    args{packet: []byte{0x7f, '0', '0', '0', '0', '0', '0', '0', '1'}},
+			want:    nil,
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -235,6 +370,84 @@ func TestEncode(t *testing.T) {
 			want:    []byte{CommandConnAck, '0', '0', '0', '0', '0', '0', '0', '1', 0},
 			wantErr: false,
 		},
+		{
+			name: "DeliverEncodeTest",
+			args: args{
+				p: &Deliver{ID: "00000001", Payload: []byte{'h', 'e', 'l', 'l', 'o'}},
+			},
+			want:    []byte{CommandDeliver, '0', '0', '0', '0', '0', '0', '0', '1', 'h', 'e', 'l', 'l', 'o'},
+			wantErr: false,
+		},
+		{
+			name: "DeliverAckEncodeTest",
+			args: args{
+				p: &DeliverAck{ID: "00000001", Result: 1},
+			},
+			want:    []byte{CommandDeliverAck, '0', '0', '0', '0', '0', '0', '0', '1', 1},
+			wantErr: false,
+		},
+		{
+			name: "UnsubscribeEncodeTest",
+			args: args{
+				p: &Unsubscribe{ID: "00000001", Topic: "a/#"},
+			},
+			want:    []byte{CommandUnsubscribe, '0', '0', '0', '0', '0', '0', '0', '1', 'a', '/', '#'},
+			wantErr: false,
+		},
+		{
+			name: "SubscribeAckEncodeTest",
+			args: args{
+				p: &SubscribeAck{ID: "00000001", Result: 1},
+			},
+			want:    []byte{CommandSubscribeAck, '0', '0', '0', '0', '0', '0', '0', '1', 1},
+			wantErr: false,
+		},
+		{
+			name: "TopicSubmitEncodeTest",
+			args: args{
+				p: &Submit{ID: "00000001", Topic: "a/b", Payload: []byte{'h', 'i'}},
+			},
+			want:    []byte{CommandTopicSubmit, '0', '0', '0', '0', '0', '0', '0', '1', 0, 3, 'a', '/', 'b', 'h', 'i'},
+			wantErr: false,
+		},
+		{
+			name: "TypedSubmitEncodeTest",
+			args: args{
+				p: &Submit{ID: "00000001", Kind: "ok", ContentType: 1, Payload: []byte{'{', '}'}},
+			},
+			want:    []byte{CommandTypedSubmit, '0', '0', '0', '0', '0', '0', '0', '1', 1, 2, 'o', 'k', '{', '}'},
+			wantErr: false,
+		},
+		{
+			name:    "TypedTopicSubmitEncodeTest",
+			args:    args{p: &Submit{ID: "00000001", Topic: "a", Kind: "ok"}},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "TopicDeliverEncodeTest",
+			args: args{
+				p: &Deliver{ID: "00000001", Topic: "a", Payload: []byte{'h', 'i'}},
+			},
+			want:    []byte{CommandTopicDeliver, '0', '0', '0', '0', '0', '0', '0', '1', 0, 1, 'a', 'h', 'i'},
+			wantErr: false,
+		},
+		{
+			name: "OptionConnEncodeTest",
+			args: args{
+				p: &Con{ID: "00000001", Payload: []byte{'p', 'w'}, Options: []Option{{Type: OptionCompression, Value: []byte{3, 1}}}},
+			},
+			want:    []byte{CommandOptionConn, '0', '0', '0', '0', '0', '0', '0', '1', 0, 5, OptionCompression, 0, 2, 3, 1, 'p', 'w'},
+			wantErr: false,
+		},
+		{
+			name: "ConAckOptionEncodeTest",
+			args: args{
+				p: &ConAck{ID: "00000001", Options: []Option{{Type: OptionCompression, Value: []byte{3}}}},
+			},
+			want:    []byte{CommandConnAck, '0', '0', '0', '0', '0', '0', '0', '1', 0, OptionCompression, 0, 1, 3},
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -405,3 +618,74 @@ func TestSubmit_Encode(t *testing.T) {
 		})
 	}
 }
+
+func TestDeliver_Encode(t *testing.T) {
+	type fields struct {
+		ID      string
+		Payload []byte
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		want    []byte
+		wantErr bool
+	}{
+		{
+			name: "DeliverEncodeTest",
+			fields: fields{
+				ID:      "00000001",
+				Payload: []byte{'h', 'e', 'l', 'l', 'o'},
+			},
+			want:    []byte{'0', '0', '0', '0', '0', '0', '0', '1', 'h', 'e', 'l', 'l', 'o'},
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Deliver{
+				ID:      tt.fields.ID,
+				Payload: tt.fields.Payload,
+			}
+			got, err := d.Encode()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Encode() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Encode() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeliverAck_Decode(t *testing.T) {
+	type args struct {
+		packetBody []byte
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    DeliverAck
+		wantErr bool
+	}{
+		{
+			name: "DeliverAckDecodeTest",
+			args: args{
+				packetBody: []byte{'0', '0', '0', '0', '0', '0', '0', '1', 1},
+			},
+			want:    DeliverAck{ID: "00000001", Result: 1},
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &DeliverAck{}
+			if err := d.Decode(tt.args.packetBody); (err != nil) != tt.wantErr {
+				t.Errorf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if *d != tt.want {
+				t.Errorf("Decode() got = %v, want %v", *d, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package packet
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+/*
+packet 类型注册表
+
+Decode 按 commandID、Encode 按 Go 类型在注册表中查找 packet 类型，应用可以注册自己的 packet 而无需修改本包：
+
+	packet.MustRegister(packet.Type{CommandID: 0x20, Name: "Ping", New: func() packet.Packet { return &Ping{} }})
+
+同一个 Go 类型可以按内容使用不同的 commandID（如带 topic 的 Submit），此时该类型需要实现 Commander，
+Encode 使用 CommandID() 的返回值；每个 commandID 的 body 格式不同时由 Type.Decode 解码
+内置的 packet 以同样的方式注册在 DefaultRegistry 中
+*/
+
+var (
+	// ErrUnknownCommand 没有注册的 commandID
+	ErrUnknownCommand = errors.New("unknown commandID")
+	// ErrUnknownType 没有注册的 packet 类型
+	ErrUnknownType = errors.New("unknown type")
+	// ErrDuplicateCommand commandID 已经注册过
+	ErrDuplicateCommand = errors.New("duplicate commandID")
+	// ErrDuplicateType 没有实现 Commander 的 Go 类型注册了多个 commandID
+	ErrDuplicateType = errors.New("duplicate packet type")
+)
+
+// Commander 按内容选择 commandID 的 packet，同一个 Go 类型注册了多个 commandID 时需要实现
+type Commander interface {
+	CommandID() uint8
+}
+
+// Type 一个 commandID 的注册信息
+type Type struct {
+	CommandID uint8
+	Name      string        // 类型名称，用于错误信息
+	New       func() Packet // 创建新实例，返回值的 Go 类型即该 commandID 对应的类型
+	// Decode 解码 packet body，为 nil 时调用 New 创建实例后调用其 Decode
+	// 同一个 Go 类型的其他 commandID（body 格式不同）使用
+	Decode func(body []byte) (Packet, error)
+	// Prototype 只用于在注册时确定 Go 类型，为 nil 时调用一次 New
+	// New 从对象池取实例时设置，避免注册时取出的实例不归还
+	Prototype Packet
+
+	typ reflect.Type
+}
+
+func (t *Type) decode(body []byte) (Packet, error) {
+	if t.Decode != nil {
+		return t.Decode(body)
+	}
+	p := t.New()
+	if err := p.Decode(body); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Registry commandID 与 packet 类型的映射，可并发使用
+type Registry struct {
+	mu     sync.RWMutex
+	byID   [256]*Type
+	byType map[reflect.Type]uint8 // Go 类型第一个注册的 commandID
+}
+
+// NewRegistry 创建空的注册表，不含内置的 packet
+func NewRegistry() *Registry {
+	return &Registry{byType: make(map[reflect.Type]uint8)}
+}
+
+// DefaultRegistry 包级别的 Decode、Encode 使用的注册表，已注册内置的 packet
+var DefaultRegistry = NewRegistry()
+
+// Register 在 DefaultRegistry 中注册 packet 类型
+func Register(t Type) error {
+	return DefaultRegistry.Register(t)
+}
+
+// MustRegister 同 Register，出错时 panic，用于 init
+func MustRegister(t Type) {
+	if err := Register(t); err != nil {
+		panic(err)
+	}
+}
+
+// Register 注册 packet 类型，commandID 已注册、或未实现 Commander 的 Go 类型重复注册时返回错误
+func (r *Registry) Register(t Type) error {
+	if t.New == nil {
+		return fmt.Errorf("packet: nil New for commandID [%d]", t.CommandID)
+	}
+	proto := t.Prototype
+	if proto == nil {
+		proto = t.New()
+	}
+	if proto == nil {
+		return fmt.Errorf("packet: New returns nil for commandID [%d]", t.CommandID)
+	}
+	t.typ = reflect.TypeOf(proto)
+	t.Prototype = nil
+	if t.Name == "" {
+		t.Name = t.typ.String()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if old := r.byID[t.CommandID]; old != nil {
+		return fmt.Errorf("%w [0x%02x]: %s already registered as %s", ErrDuplicateCommand, t.CommandID, t.Name, old.Name)
+	}
+	if id, ok := r.byType[t.typ]; ok {
+		if !t.typ.Implements(reflect.TypeOf((*Commander)(nil)).Elem()) {
+			return fmt.Errorf("%w [%s]: already registered as commandID 0x%02x", ErrDuplicateType, t.typ, id)
+		}
+	} else {
+		r.byType[t.typ] = t.CommandID
+	}
+	r.byID[t.CommandID] = &t
+	return nil
+}
+
+// Decode 按第一个字节的 commandID 解码 packet
+func (r *Registry) Decode(packet []byte) (Packet, error) {
+	if len(packet) == 0 {
+		return nil, ErrShortPacket
+	}
+	commandID := packet[0] // 1 byte: commandID 类型
+	r.mu.RLock()
+	t := r.byID[commandID]
+	r.mu.RUnlock()
+	if t == nil {
+		return nil, fmt.Errorf("%w [%d]", ErrUnknownCommand, commandID)
+	}
+	return t.decode(packet[1:])
+}
+
+// Encode 按 p 的类型（实现了 Commander 时按 CommandID()）加上 commandID 编码
+func (r *Registry) Encode(p Packet) ([]byte, error) {
+	typ := reflect.TypeOf(p)
+	r.mu.RLock()
+	commandID, ok := r.byType[typ]
+	if c, isCommander := p.(Commander); ok && isCommander {
+		commandID = c.CommandID()
+		if t := r.byID[commandID]; t == nil || t.typ != typ {
+			ok = false
+		}
+	}
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w [%T]", ErrUnknownType, p)
+	}
+
+	pktBody, err := p.Encode()
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{commandID}, pktBody...), nil
+}
+
+// Decode 根据 frame 的解析结果，继续解析
+func Decode(packet []byte) (Packet, error) {
+	return DefaultRegistry.Decode(packet)
+}
+
+// Encode 编译 Packet 将结果向上传递给 Frame
+func Encode(p Packet) ([]byte, error) {
+	return DefaultRegistry.Encode(p)
+}
+
+func init() {
+	registerBuiltin(DefaultRegistry)
+}
+
+// registerBuiltin 注册内置的 packet
+func registerBuiltin(r *Registry) {
+	// 优化堆内存 使用池化 Submit
+	newSubmit := func() Packet { return SubmitPool.Get().(*Submit) }
+	types := []Type{
+		{CommandID: CommandConn, Name: "Con", New: func() Packet { return &Con{} }},
+		{CommandID: CommandOptionConn, Name: "OptionCon", New: func() Packet { return &Con{} }, Decode: func(body []byte) (Packet, error) {
+			c, err := decodeOptionConn(body)
+			if err != nil {
+				return nil, err
+			}
+			return c, nil
+		}},
+		{CommandID: CommandConnAck, Name: "ConAck", New: func() Packet { return &ConAck{} }},
+		{CommandID: CommandSubmit, Name: "Submit", New: newSubmit, Prototype: (*Submit)(nil)},
+		{CommandID: CommandTopicSubmit, Name: "TopicSubmit", New: newSubmit, Prototype: (*Submit)(nil), Decode: func(body []byte) (Packet, error) {
+			id, topic, payload, err := decodeTopicBody(body)
+			if err != nil {
+				return nil, err
+			}
+			s := SubmitPool.Get().(*Submit) // get submit pool
+			s.ID, s.Topic, s.Payload = id, topic, payload
+			s.Kind, s.ContentType = "", 0
+			return s, nil
+		}},
+		{CommandID: CommandTypedSubmit, Name: "TypedSubmit", New: newSubmit, Prototype: (*Submit)(nil), Decode: func(body []byte) (Packet, error) {
+			s := SubmitPool.Get().(*Submit) // get submit pool
+			if err := decodeTypedSubmit(s, body); err != nil {
+				return nil, err
+			}
+			return s, nil
+		}},
+		{CommandID: CommandSubmitAck, Name: "SubmitAck", New: func() Packet { return &SubmitAck{} }},
+		{CommandID: CommandDeliver, Name: "Deliver", New: func() Packet { return &Deliver{} }},
+		{CommandID: CommandTopicDeliver, Name: "TopicDeliver", New: func() Packet { return &Deliver{} }, Decode: func(body []byte) (Packet, error) {
+			id, topic, payload, err := decodeTopicBody(body)
+			if err != nil {
+				return nil, err
+			}
+			return &Deliver{ID: id, Topic: topic, Payload: payload}, nil
+		}},
+		{CommandID: CommandDeliverAck, Name: "DeliverAck", New: func() Packet { return &DeliverAck{} }},
+		{CommandID: CommandSubscribe, Name: "Subscribe", New: func() Packet { return &Subscribe{} }},
+		{CommandID: CommandSubscribeAck, Name: "SubscribeAck", New: func() Packet { return &SubscribeAck{} }},
+		{CommandID: CommandUnsubscribe, Name: "Unsubscribe", New: func() Packet { return &Unsubscribe{} }},
+		{CommandID: CommandUnsubscribeAck, Name: "UnsubscribeAck", New: func() Packet { return &UnsubscribeAck{} }},
+	}
+	for _, t := range types {
+		if err := r.Register(t); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package packet
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// ping 测试用的自定义 packet
+type ping struct {
+	Seq uint8
+}
+
+func (p *ping) Decode(body []byte) error {
+	if len(body) < 1 {
+		return ErrShortPacket
+	}
+	p.Seq = body[0]
+	return nil
+}
+
+func (p *ping) Encode() ([]byte, error) {
+	return []byte{p.Seq}, nil
+}
+
+// pong 未注册的 packet
+type pong struct{ ping }
+
+const commandPing = 0x20
+
+func TestRegistry_Register(t *testing.T) {
+	newPing := func() Packet { return &ping{} }
+	tests := []struct {
+		name    string
+		typ     Type
+		wantErr error
+	}{
+		{name: "Custom", typ: Type{CommandID: commandPing, New: newPing}},
+		{name: "BuiltinCommandID", typ: Type{CommandID: CommandSubmit, New: newPing}, wantErr: ErrDuplicateCommand},
+		{name: "DuplicateType", typ: Type{CommandID: commandPing + 1, New: func() Packet { return &SubmitAck{} }}, wantErr: ErrDuplicateType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			registerBuiltin(r)
+			if err := r.Register(tt.typ); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Register() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// 实现了 Commander 的类型可以注册多个 commandID
+	r := NewRegistry()
+	registerBuiltin(r)
+	if err := r.Register(Type{CommandID: commandPing, New: func() Packet { return &Submit{} }}); err != nil {
+		t.Errorf("Register() error = %v, want nil", err)
+	}
+	if err := r.Register(Type{CommandID: commandPing}); err == nil {
+		t.Errorf("Register() without New error = nil")
+	}
+	if err := r.Register(Type{CommandID: commandPing + 1, New: func() Packet { return nil }}); err == nil {
+		t.Errorf("Register() with nil prototype error = nil")
+	}
+
+	// 设置了 Prototype 时注册不调用 New
+	calls := 0
+	newCounted := func() Packet { calls++; return &ping{} }
+	if err := r.Register(Type{CommandID: commandPing + 2, New: newCounted, Prototype: (*ping)(nil)}); err != nil {
+		t.Fatalf("Register() error = %v, want nil", err)
+	}
+	if calls != 0 {
+		t.Errorf("Register() called New %d times, want 0", calls)
+	}
+	if got := r.byID[commandPing+2].typ; got != reflect.TypeOf(&ping{}) {
+		t.Errorf("Register() type = %v, want *packet.ping", got)
+	}
+}
+
+func TestRegistry_Custom(t *testing.T) {
+	r := NewRegistry()
+	registerBuiltin(r)
+	if err := r.Register(Type{CommandID: commandPing, Name: "Ping", New: func() Packet { return &ping{} }}); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := r.Encode(&ping{Seq: 7})
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if want := []byte{commandPing, 7}; !reflect.DeepEqual(b, want) {
+		t.Errorf("Encode() = %v, want %v", b, want)
+	}
+	p, err := r.Decode(b)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !reflect.DeepEqual(p, &ping{Seq: 7}) {
+		t.Errorf("Decode() = %+v, want %+v", p, &ping{Seq: 7})
+	}
+
+	// 内置的 packet 不受影响
+	b, err = r.Encode(&Deliver{ID: "00000001", Topic: "news"})
+	if err != nil || b[0] != CommandTopicDeliver {
+		t.Errorf("Encode() = %v, %v, want commandID %d", b, err, CommandTopicDeliver)
+	}
+
+	// 默认注册表没有注册 ping
+	if _, err = Decode([]byte{commandPing, 7}); !errors.Is(err, ErrUnknownCommand) {
+		t.Errorf("Decode() error = %v, want %v", err, ErrUnknownCommand)
+	}
+	if _, err = r.Encode(&pong{}); !errors.Is(err, ErrUnknownType) {
+		t.Errorf("Encode() error = %v, want %v", err, ErrUnknownType)
+	}
+	if _, err = NewRegistry().Encode(&Submit{ID: "00000001"}); !errors.Is(err, ErrUnknownType) {
+		t.Errorf("Encode() error = %v, want %v", err, ErrUnknownType)
+	}
+}
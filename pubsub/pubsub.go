@@ -0,0 +1,174 @@
+package pubsub
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+/*
+基于 topic 的发布订阅
+
+topic 按 / 分层，如 sensor/room1/temp
+订阅时可使用通配符：
+	+  匹配单独一层，如 sensor/+/temp
+	#  匹配剩余所有层，只能出现在最后，如 sensor/#
+发布时的 topic 不能包含通配符
+*/
+
+const (
+	levelSep       = "/"
+	singleWildcard = "+"
+	multiWildcard  = "#"
+)
+
+var (
+	// ErrInvalidTopic 发布的 topic 不合法
+	ErrInvalidTopic = errors.New("pubsub: invalid topic")
+	// ErrInvalidPattern 订阅的 topic 不合法
+	ErrInvalidPattern = errors.New("pubsub: invalid topic pattern")
+)
+
+// ValidateTopic 校验发布的 topic：非空且不含通配符
+func ValidateTopic(topic string) error {
+	if topic == "" || strings.ContainsAny(topic, singleWildcard+multiWildcard) {
+		return ErrInvalidTopic
+	}
+	return nil
+}
+
+// ValidatePattern 校验订阅的 topic：通配符必须独占一层，# 只能在最后一层
+func ValidatePattern(pattern string) error {
+	if pattern == "" {
+		return ErrInvalidPattern
+	}
+	levels := strings.Split(pattern, levelSep)
+	for i, level := range levels {
+		if level == singleWildcard || level == multiWildcard {
+			if level == multiWildcard && i != len(levels)-1 {
+				return ErrInvalidPattern
+			}
+			continue
+		}
+		if strings.ContainsAny(level, singleWildcard+multiWildcard) {
+			return ErrInvalidPattern
+		}
+	}
+	return nil
+}
+
+// Match 判断 topic 是否匹配订阅的 pattern
+func Match(pattern, topic string) bool {
+	pl := strings.Split(pattern, levelSep)
+	tl := strings.Split(topic, levelSep)
+	for i, p := range pl {
+		if p == multiWildcard {
+			return true
+		}
+		if i >= len(tl) {
+			return false
+		}
+		if p != singleWildcard && p != tl[i] {
+			return false
+		}
+	}
+	return len(pl) == len(tl)
+}
+
+// Message 一条发布的消息
+type Message struct {
+	Topic   string
+	Payload []byte
+}
+
+// Broker 维护订阅关系并把消息分发到订阅者的队列，零值可直接使用，并发安全
+type Broker struct {
+	mu   sync.RWMutex
+	subs map[*Queue]map[string]struct{} // 订阅者队列 -> 订阅的 pattern 集合
+}
+
+// Subscribe 为队列 q 订阅 pattern
+func (b *Broker) Subscribe(q *Queue, pattern string) error {
+	if err := ValidatePattern(pattern); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs == nil {
+		b.subs = make(map[*Queue]map[string]struct{})
+	}
+	patterns, ok := b.subs[q]
+	if !ok {
+		patterns = make(map[string]struct{})
+		b.subs[q] = patterns
+	}
+	patterns[pattern] = struct{}{}
+	return nil
+}
+
+// Unsubscribe 取消队列 q 对 pattern 的订阅
+func (b *Broker) Unsubscribe(q *Queue, pattern string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	patterns, ok := b.subs[q]
+	if !ok {
+		return
+	}
+	delete(patterns, pattern)
+	if len(patterns) == 0 {
+		delete(b.subs, q)
+	}
+}
+
+// UnsubscribeAll 取消队列 q 的所有订阅
+func (b *Broker) UnsubscribeAll(q *Queue) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subs, q)
+}
+
+// PublishResult 一次发布的分发结果
+type PublishResult struct {
+	Delivered    int // 放入的队列数
+	Dropped      int // 按 DropOldest 丢弃了旧消息的队列数
+	Disconnected int // 因慢消费被关闭（或此前已关闭）的队列数
+}
+
+// Publish 把消息放入所有匹配的订阅者队列，同一队列只放入一次
+// 队列满且策略为 Disconnect 时关闭该队列并取消其所有订阅
+func (b *Broker) Publish(topic string, payload []byte) (PublishResult, error) {
+	var res PublishResult
+	if err := ValidateTopic(topic); err != nil {
+		return res, err
+	}
+
+	msg := Message{Topic: topic, Payload: payload}
+	var closed []*Queue
+	b.mu.RLock()
+	for q, patterns := range b.subs {
+		for pattern := range patterns {
+			if !Match(pattern, topic) {
+				continue
+			}
+			ok, dropped := q.Push(msg)
+			if !ok {
+				closed = append(closed, q)
+				break
+			}
+			res.Delivered++
+			if dropped {
+				res.Dropped++
+			}
+			break
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, q := range closed {
+		b.UnsubscribeAll(q)
+	}
+	res.Disconnected = len(closed)
+	return res, nil
+}
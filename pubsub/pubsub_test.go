@@ -0,0 +1,131 @@
+package pubsub
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		topic   string
+		want    bool
+	}{
+		{pattern: "a/b", topic: "a/b", want: true},
+		{pattern: "a/b", topic: "a/c", want: false},
+		{pattern: "a/+", topic: "a/b", want: true},
+		{pattern: "a/+", topic: "a/b/c", want: false},
+		{pattern: "a/+/c", topic: "a/b/c", want: true},
+		{pattern: "+/+", topic: "a/b", want: true},
+		{pattern: "a/#", topic: "a/b/c", want: true},
+		{pattern: "a/#", topic: "a", want: true},
+		{pattern: "#", topic: "a/b", want: true},
+		{pattern: "a/b/c", topic: "a/b", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+"_"+tt.topic, func(t *testing.T) {
+			if got := Match(tt.pattern, tt.topic); got != tt.want {
+				t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.topic, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		wantErr bool
+	}{
+		{pattern: "a/b", wantErr: false},
+		{pattern: "a/+/c", wantErr: false},
+		{pattern: "a/#", wantErr: false},
+		{pattern: "", wantErr: true},
+		{pattern: "a/#/c", wantErr: true},
+		{pattern: "a/b+", wantErr: true},
+		{pattern: "a#", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			if err := ValidatePattern(tt.pattern); (err != nil) != tt.wantErr {
+				t.Errorf("ValidatePattern() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBroker_Publish(t *testing.T) {
+	var b Broker
+	q1 := NewQueue(8, DropOldest)
+	q2 := NewQueue(8, DropOldest)
+	b.Subscribe(q1, "sensor/+/temp")
+	b.Subscribe(q1, "sensor/#") // 同一队列多个 pattern 匹配只放入一次
+	b.Subscribe(q2, "sensor/room2/temp")
+
+	res, err := b.Publish("sensor/room1/temp", []byte("21"))
+	if err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if res.Delivered != 1 || q1.Len() != 1 || q2.Len() != 0 {
+		t.Errorf("Publish() = %+v, q1 = %d, q2 = %d, want 1, 1, 0", res, q1.Len(), q2.Len())
+	}
+
+	b.Unsubscribe(q1, "sensor/+/temp")
+	b.Unsubscribe(q1, "sensor/#")
+	res, _ = b.Publish("sensor/room2/temp", []byte("22"))
+	if res.Delivered != 1 || q1.Len() != 1 || q2.Len() != 1 {
+		t.Errorf("Publish() = %+v, q1 = %d, q2 = %d, want 1, 1, 1", res, q1.Len(), q2.Len())
+	}
+
+	if _, err = b.Publish("sensor/+", nil); !errors.Is(err, ErrInvalidTopic) {
+		t.Errorf("Publish() error = %v, want %v", err, ErrInvalidTopic)
+	}
+	if err = b.Subscribe(q1, "sensor/#/x"); !errors.Is(err, ErrInvalidPattern) {
+		t.Errorf("Subscribe() error = %v, want %v", err, ErrInvalidPattern)
+	}
+}
+
+func TestBroker_SlowConsumer(t *testing.T) {
+	var b Broker
+	drop := NewQueue(2, DropOldest)
+	disc := NewQueue(2, Disconnect)
+	b.Subscribe(drop, "t")
+	b.Subscribe(disc, "t")
+
+	for _, p := range []string{"1", "2", "3"} {
+		b.Publish("t", []byte(p))
+	}
+
+	if msg, ok := drop.Pop(nil); !ok || string(msg.Payload) != "2" {
+		t.Errorf("DropOldest Pop() = %q, %v, want %q", msg.Payload, ok, "2")
+	}
+	if !disc.Closed() {
+		t.Error("Disconnect queue not closed")
+	}
+	res, _ := b.Publish("t", []byte("4"))
+	if res.Delivered != 1 || res.Disconnected != 0 {
+		t.Errorf("Publish() after disconnect = %+v, want Delivered 1", res)
+	}
+}
+
+func TestQueue_Pop(t *testing.T) {
+	q := NewQueue(1, DropOldest)
+	done := make(chan struct{})
+	got := make(chan Message)
+	go func() {
+		msg, _ := q.Pop(done)
+		got <- msg
+	}()
+	q.Push(Message{Topic: "t", Payload: []byte("hello")})
+	if msg := <-got; string(msg.Payload) != "hello" {
+		t.Errorf("Pop() = %q, want %q", msg.Payload, "hello")
+	}
+
+	close(done)
+	if _, ok := q.Pop(done); ok {
+		t.Error("Pop() after done = true, want false")
+	}
+	q.Close()
+	if ok, _ := q.Push(Message{}); ok {
+		t.Error("Push() after Close = true, want false")
+	}
+}
@@ -0,0 +1,119 @@
+package pubsub
+
+import "sync"
+
+// SlowPolicy 订阅者队列满时的处理方式
+type SlowPolicy int
+
+const (
+	DropOldest SlowPolicy = iota // 丢弃队列中最旧的消息
+	Disconnect                   // 关闭队列，由订阅者断开连接
+)
+
+// Queue 订阅者的有界消息队列，并发安全
+type Queue struct {
+	size   int
+	policy SlowPolicy
+
+	mu     sync.Mutex
+	buf    []Message
+	closed bool
+	notify chan struct{} // 有新消息或关闭时通知 Pop
+}
+
+// NewQueue 创建队列，size <= 0 时按 1 处理
+func NewQueue(size int, policy SlowPolicy) *Queue {
+	if size <= 0 {
+		size = 1
+	}
+	return &Queue{
+		size:   size,
+		policy: policy,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// Push 放入消息，队列已关闭或因慢消费被关闭时 ok 为 false
+// 按 DropOldest 丢弃了旧消息时 dropped 为 true
+func (q *Queue) Push(msg Message) (ok, dropped bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return false, false
+	}
+	if len(q.buf) >= q.size {
+		if q.policy == Disconnect {
+			q.closeLocked()
+			return false, false
+		}
+		q.buf[0] = Message{}
+		q.buf = q.buf[1:]
+		dropped = true
+	}
+	q.buf = append(q.buf, msg)
+	q.signal()
+	return true, dropped
+}
+
+// Pop 取出最旧的消息，队列为空时阻塞，队列关闭或 done 关闭时返回 false
+func (q *Queue) Pop(done <-chan struct{}) (Message, bool) {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return Message{}, false
+		}
+		if len(q.buf) > 0 {
+			msg := q.buf[0]
+			q.buf[0] = Message{}
+			q.buf = q.buf[1:]
+			q.mu.Unlock()
+			return msg, true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.notify:
+		case <-done:
+			return Message{}, false
+		}
+	}
+}
+
+// Len 返回队列中的消息数
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.buf)
+}
+
+// Close 关闭队列
+func (q *Queue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closeLocked()
+}
+
+// Closed 队列是否已关闭
+func (q *Queue) Closed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closed
+}
+
+func (q *Queue) closeLocked() {
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.buf = nil
+	q.signal()
+}
+
+func (q *Queue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
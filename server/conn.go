@@ -0,0 +1,247 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/CoderI421/tcp-service/checksum"
+	"github.com/CoderI421/tcp-service/compression"
+	"github.com/CoderI421/tcp-service/encryption"
+	"github.com/CoderI421/tcp-service/frame"
+	"github.com/CoderI421/tcp-service/limiter"
+	"github.com/CoderI421/tcp-service/logger"
+	"github.com/CoderI421/tcp-service/metrics"
+	"github.com/CoderI421/tcp-service/packet"
+	"github.com/CoderI421/tcp-service/textpacket"
+)
+
+// refuseTimeout 发送拒绝包的写超时
+const refuseTimeout = time.Second
+
+// maxRefusing 同时发送拒绝包的连接数上限，超过时不发送拒绝包直接关闭
+// 避免大量被拒绝的连接（如被 deny 的来源持续重连）占用协程与 fd
+const maxRefusing = 128
+
+// frameConn 以 frame 为单位读写的连接
+// tcp/unix 等字节流连接通过 frame.Codec 拆包，WebSocket 连接每条消息即一个 frame
+type frameConn interface {
+	ReadFrame() (frame.Payload, error)
+	WriteFrame(frame.Payload) error
+	Flush() error // 把已写入的 frame 发送出去
+	Close() error
+	RemoteAddr() net.Addr
+	SetReadDeadline(time.Time) error
+	SetWriteDeadline(time.Time) error
+}
+
+// streamConn 字节流连接上的 frameConn
+type streamConn struct {
+	net.Conn
+	codec frame.StreamFrameCodec
+	rbuf  *bufio.Reader
+	wbuf  *bufio.Writer
+
+	base frame.FlagCodec    // 底层的帧格式，文本格式时为 nil
+	comp *compression.Codec // 开启压缩时不为 nil
+	sum  *checksum.Codec    // 开启校验和时不为 nil
+	enc  *encryption.Codec  // 开启加密时不为 nil
+}
+
+func newStreamConn(c net.Conn) *streamConn {
+	return &streamConn{
+		Conn:  c,
+		codec: frame.NewCodec(),
+		// 建立 connection 的读缓冲区
+		rbuf: bufio.NewReader(c),
+		// 建立 connection 的写缓冲区
+		wbuf: bufio.NewWriter(c),
+	}
+}
+
+// newStreamConn 按帧格式、校验和、加密与压缩配置创建 streamConn，开启时协商之前也能处理客户端的 frame
+// 发送时依次压缩、加密、计算校验和，接收时依次校验、解密、解压
+func (s *Server) newStreamConn(c net.Conn) *streamConn {
+	sc := newStreamConn(c)
+	if s.FrameFormat == frame.FormatDelimiter && s.FrameDelimiter != nil {
+		d := *s.FrameDelimiter
+		if d.MaxLength == 0 {
+			d.MaxLength = s.MaxFrameLength
+		}
+		sc.codec = textpacket.NewCodec(&d)
+		return sc
+	}
+	if s.FrameFormat.Text() {
+		sc.codec = textpacket.NewCodec(frame.NewFormatCodecLimit(s.FrameFormat, s.MaxFrameLength))
+		return sc
+	}
+	sc.base = frame.NewFormatCodecLimit(s.FrameFormat, s.MaxFrameLength)
+	sc.codec = sc.base
+	// 不能携带 flags 的格式无法标记压缩或校验和的 frame
+	if !s.FrameFormat.SupportsFlags() {
+		return sc
+	}
+	inner := sc.base
+	if len(s.Checksum) > 0 {
+		sc.sum = checksum.NewCodec()
+		sc.sum.Frame = inner
+		sc.sum.Observe = observeChecksum
+		inner = sc.sum
+		sc.codec = sc.sum
+	}
+	if len(s.Encryption) > 0 {
+		sc.enc = encryption.NewCodec()
+		sc.enc.Frame = inner
+		sc.enc.Observe = observeEncryption
+		inner = sc.enc
+		sc.codec = sc.enc
+	}
+	if len(s.Compression) > 0 {
+		sc.comp = compression.NewCodec()
+		sc.comp.Frame = inner
+		sc.comp.Threshold = s.CompressThreshold
+		// 解压后的长度同样受 MaxFrameLength 限制，压缩比很高的小 frame 不能绕过
+		sc.comp.MaxSize = s.maxFrameLength()
+		sc.comp.Observe = observeCompression
+		sc.codec = sc.comp
+	}
+	return sc
+}
+
+// observeChecksum 记录校验和不一致的 frame
+func observeChecksum(alg checksum.Algorithm, ok bool) {
+	if !ok {
+		metrics.ChecksumFailuresTotal.WithLabelValues(alg.String()).Inc()
+	}
+}
+
+// observeEncryption 记录解密失败的 frame
+func observeEncryption(c encryption.Cipher, err error) {
+	switch {
+	case err == nil:
+	case errors.Is(err, encryption.ErrReplay):
+		metrics.EncryptionFailuresTotal.WithLabelValues("replay").Inc()
+	case errors.Is(err, encryption.ErrPlaintext):
+		metrics.EncryptionFailuresTotal.WithLabelValues("plaintext").Inc()
+	default:
+		metrics.EncryptionFailuresTotal.WithLabelValues("auth").Inc()
+	}
+}
+
+// observeCompression 记录压缩 frame 的压缩率
+func observeCompression(alg compression.Algorithm, out bool, raw, wire int) {
+	dir := "in"
+	if out {
+		dir = "out"
+	}
+	metrics.CompressionRawBytesTotal.WithLabelValues(alg.String(), dir).Add(float64(raw))
+	metrics.CompressionWireBytesTotal.WithLabelValues(alg.String(), dir).Add(float64(wire))
+	metrics.CompressionRatio.WithLabelValues(alg.String(), dir).Observe(float64(wire) / float64(raw))
+}
+
+// setCompression 切换发送使用的压缩算法，未开启压缩时返回 false
+func (sc *streamConn) setCompression(alg compression.Algorithm) bool {
+	if sc.comp == nil {
+		return false
+	}
+	sc.comp.SetAlgorithm(alg)
+	return true
+}
+
+// setChecksum 切换发送使用的校验和算法，未开启校验和或客户端使用的帧格式不能携带校验和时返回 false
+func (sc *streamConn) setChecksum(alg checksum.Algorithm) bool {
+	if sc.sum == nil || frame.CodecFlags(sc.base)&frame.FlagChecksum == 0 {
+		return false
+	}
+	sc.sum.SetAlgorithm(alg)
+	return true
+}
+
+// canEncrypt 是否开启了加密，且客户端使用的帧格式能携带 frame.FlagEncrypted
+func (sc *streamConn) canEncrypt() bool {
+	return sc.enc != nil && frame.CodecFlags(sc.base)&frame.FlagEncrypted != 0
+}
+
+func (sc *streamConn) ReadFrame() (frame.Payload, error) {
+	return sc.codec.Decode(sc.rbuf)
+}
+
+func (sc *streamConn) WriteFrame(p frame.Payload) error {
+	// 使用 写缓冲区替换 c
+	return sc.codec.Encode(sc.wbuf, p)
+}
+
+func (sc *streamConn) Flush() error {
+	return sc.wbuf.Flush()
+}
+
+func (sc *streamConn) Close() error {
+	sc.wbuf.Flush()
+	return sc.Conn.Close()
+}
+
+// throttle 对 submit 进行限流，返回 SubmitAck 的 result
+// reject 模式下超限直接返回 ResultThrottled
+// delay 模式下阻塞等待令牌，此期间不会读取该连接的后续数据
+func throttle(cl *limiter.ConnLimiter) uint8 {
+	if cl == nil {
+		return packet.ResultOK
+	}
+
+	if cl.Mode() == limiter.ModeDelay {
+		d, scope, err := cl.Wait(context.Background())
+		if err != nil {
+			metrics.SubmitThrottledTotal.WithLabelValues(scope).Inc()
+			return packet.ResultThrottled
+		}
+		if d > 0 {
+			metrics.SubmitThrottledTotal.WithLabelValues(scope).Inc()
+		}
+		return packet.ResultOK
+	}
+
+	ok, scope := cl.Allow()
+	if !ok {
+		metrics.SubmitThrottledTotal.WithLabelValues(scope).Inc()
+		return packet.ResultThrottled
+	}
+	return packet.ResultOK
+}
+
+// refuse 在新的协程中向被拒绝的连接发送拒绝包后关闭，发送中的连接数达到 maxRefusing 时直接关闭
+func (s *Server) refuse(c net.Conn) {
+	if atomic.AddInt32(&s.refusing, 1) > maxRefusing {
+		atomic.AddInt32(&s.refusing, -1)
+		c.Close()
+		return
+	}
+	go func() {
+		defer atomic.AddInt32(&s.refusing, -1)
+		refuseConn(s.newStreamConn(c))
+	}()
+}
+
+// refuseConn 向被拒绝的连接发送 result 为 ResultRefused 的 ConAck 后关闭
+func refuseConn(c frameConn) {
+	defer c.Close()
+
+	ackFramePayload, err := packet.Encode(&packet.ConAck{
+		ID:     "00000000",
+		Result: packet.ResultRefused,
+	})
+	if err != nil {
+		logger.Errorf("refuseConn: packet encode error: %v", err)
+		return
+	}
+	c.SetWriteDeadline(time.Now().Add(refuseTimeout))
+	if err = c.WriteFrame(ackFramePayload); err != nil {
+		logger.Infof("refuseConn: frame encode error: %v", err)
+		return
+	}
+	if err = c.Flush(); err != nil {
+		logger.Infof("refuseConn: flush error: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/CoderI421/tcp-service/logger"
+	"github.com/CoderI421/tcp-service/metrics"
+	"github.com/CoderI421/tcp-service/packet"
+	"github.com/CoderI421/tcp-service/pubsub"
+)
+
+// defaultSubQueueSize 订阅者推送队列的默认长度
+const defaultSubQueueSize = 256
+
+// subscribe 订阅 pattern，首次订阅时创建推送队列并启动推送协程
+func (sess *Session) subscribe(pattern string) error {
+	if err := pubsub.ValidatePattern(pattern); err != nil {
+		return err
+	}
+
+	// 持有 sess.mu 直到订阅完成，避免与 closeSubscriptions 交错导致订阅泄漏
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	select {
+	case <-sess.done:
+		return ErrSessionClosed
+	default:
+	}
+	q := sess.subq
+	if q == nil {
+		size := sess.srv.SubQueueSize
+		if size <= 0 {
+			size = defaultSubQueueSize
+		}
+		q = pubsub.NewQueue(size, sess.srv.SlowConsumer)
+		sess.subq = q
+		go sess.pumpLoop(q)
+	}
+	return sess.srv.Broker.Subscribe(q, pattern)
+}
+
+// unsubscribe 取消订阅 pattern
+func (sess *Session) unsubscribe(pattern string) {
+	sess.mu.Lock()
+	q := sess.subq
+	sess.mu.Unlock()
+
+	if q != nil {
+		sess.srv.Broker.Unsubscribe(q, pattern)
+	}
+}
+
+// closeSubscriptions 会话关闭时取消所有订阅
+func (sess *Session) closeSubscriptions() {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if q := sess.subq; q != nil {
+		sess.srv.Broker.UnsubscribeAll(q)
+		q.Close()
+	}
+}
+
+// pumpLoop 推送协程，把推送队列中的消息以带 topic 的 Deliver 发给客户端
+// 订阅推送不等待 DeliverAck，队列因慢消费被关闭时断开会话
+func (sess *Session) pumpLoop(q *pubsub.Queue) {
+	for {
+		msg, ok := q.Pop(sess.done)
+		if !ok {
+			select {
+			case <-sess.done:
+			default:
+				logger.Warnf("slow consumer: id = %s, remote=%s", sess.ID(), sess.RemoteAddr())
+				sess.Close()
+			}
+			return
+		}
+
+		id := fmt.Sprintf("%08x", atomic.AddUint32(&sess.seq, 1))
+		d := &packet.Deliver{ID: id, Topic: msg.Topic, Payload: msg.Payload}
+		if err := sess.check(d); err != nil {
+			logger.Infof("pubsub: drop message: id = %s, topic=%s, remote=%s: %v", sess.ID(), msg.Topic, sess.RemoteAddr(), err)
+			metrics.PubSubDroppedTotal.WithLabelValues("unencodable").Inc()
+			continue
+		}
+		if err := sess.send(context.Background(), d); err != nil {
+			return
+		}
+		metrics.PubSubDeliverTotal.Inc()
+	}
+}
+
+// publish 把带 topic 的 submit 分发给订阅者
+func (s *Server) publish(topic string, payload []byte) error {
+	res, err := s.Broker.Publish(topic, payload)
+	if err != nil {
+		return err
+	}
+	metrics.PubSubPublishTotal.Inc()
+	if res.Dropped > 0 {
+		metrics.PubSubDroppedTotal.WithLabelValues("drop_oldest").Add(float64(res.Dropped))
+	}
+	if res.Disconnected > 0 {
+		metrics.PubSubDroppedTotal.WithLabelValues("disconnect").Add(float64(res.Disconnected))
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/CoderI421/tcp-service/metrics"
+)
+
+// DuplicatePolicy 同一客户端标识重复登录时的处理方式
+type DuplicatePolicy int
+
+const (
+	DuplicateKickOld   DuplicatePolicy = iota // 断开已登录的旧会话，接受新会话
+	DuplicateRejectNew                        // 保留旧会话，拒绝新会话
+)
+
+// ErrDuplicateLogin 按 DuplicateRejectNew 拒绝重复登录
+var ErrDuplicateLogin = errors.New("server: duplicate login")
+
+// Registry 会话注册表，Con 握手成功后按客户端标识登记，并发安全
+type Registry struct {
+	Policy DuplicatePolicy
+
+	mu       sync.RWMutex
+	sessions map[string]*Session
+}
+
+// Get 按客户端标识查找会话
+func (r *Registry) Get(id string) (*Session, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sess, ok := r.sessions[id]
+	return sess, ok
+}
+
+// List 返回所有会话，按客户端标识排序
+func (r *Registry) List() []*Session {
+	r.mu.RLock()
+	list := make([]*Session, 0, len(r.sessions))
+	for _, sess := range r.sessions {
+		list = append(list, sess)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].ID() < list[j].ID() })
+	return list
+}
+
+// Len 返回会话数
+func (r *Registry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.sessions)
+}
+
+// check 按 Policy 检查会话能否登记，策略为 DuplicateRejectNew 且标识已被占用时返回 ErrDuplicateLogin
+// 只是预先检查，register 仍可能因为并发登记的会话失败
+func (r *Registry) check(sess *Session) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, err := r.conflict(sess)
+	return err
+}
+
+// conflict 返回标识相同的旧会话，按 DuplicateRejectNew 拒绝时返回 ErrDuplicateLogin，调用时持有锁
+func (r *Registry) conflict(sess *Session) (*Session, error) {
+	old, ok := r.sessions[sess.ID()]
+	if !ok || old == sess {
+		return nil, nil
+	}
+	if r.Policy == DuplicateRejectNew {
+		metrics.DuplicateLoginTotal.WithLabelValues("reject_new").Inc()
+		return nil, ErrDuplicateLogin
+	}
+	return old, nil
+}
+
+// register 登记会话，返回被踢下线的旧会话
+// 策略为 DuplicateRejectNew 且标识已被占用时返回 ErrDuplicateLogin
+func (r *Registry) register(sess *Session) (kicked *Session, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.sessions == nil {
+		r.sessions = make(map[string]*Session)
+	}
+	if kicked, err = r.conflict(sess); err != nil {
+		return nil, err
+	}
+	if kicked != nil {
+		metrics.DuplicateLoginTotal.WithLabelValues("kick_old").Inc()
+	}
+	r.sessions[sess.ID()] = sess
+	metrics.SessionActive.Set(float64(len(r.sessions)))
+	return kicked, nil
+}
+
+// unregister 移除会话，只有登记的仍是该会话时才移除
+func (r *Registry) unregister(sess *Session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if id := sess.ID(); r.sessions[id] == sess {
+		delete(r.sessions, id)
+		metrics.SessionActive.Set(float64(len(r.sessions)))
+	}
+}
+
+// Session 按客户端标识查找会话
+func (s *Server) Session(id string) (*Session, bool) {
+	return s.Registry.Get(id)
+}
+
+// Sessions 返回所有已完成 Con 握手的会话
+func (s *Server) Sessions() []*Session {
+	return s.Registry.List()
+}
+
+// Disconnect 断开客户端标识为 id 的会话
+func (s *Server) Disconnect(id string) error {
+	sess, ok := s.Session(id)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	return sess.Close()
+}
+
+// Deliver 向客户端标识为 id 的会话推送 payload，并等待客户端的 DeliverAck
+func (s *Server) Deliver(ctx context.Context, id string, payload []byte) error {
+	sess, ok := s.Session(id)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	return sess.Deliver(ctx, payload)
+}
+
+// Broadcast 向 filter 返回 true 的会话并发推送 payload，filter 为 nil 时推送给所有会话
+// 返回推送成功的会话数，以及第一个失败的错误
+func (s *Server) Broadcast(ctx context.Context, payload []byte, filter func(*Session) bool) (int, error) {
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		delivered int
+		firstErr  error
+	)
+	for _, sess := range s.Sessions() {
+		if filter != nil && !filter(sess) {
+			continue
+		}
+		wg.Add(1)
+		go func(sess *Session) {
+			defer wg.Done()
+			err := sess.Deliver(ctx, payload)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			delivered++
+		}(sess)
+	}
+	wg.Wait()
+	return delivered, firstErr
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CoderI421/tcp-service/client"
+	"github.com/CoderI421/tcp-service/encryption"
+	"github.com/CoderI421/tcp-service/frame"
+	"github.com/CoderI421/tcp-service/packet"
+	"github.com/CoderI421/tcp-service/transport"
+)
+
+func startPipeServer(t *testing.T, s *Server) *transport.PipeListener {
+	t.Helper()
+	l := transport.NewPipeListener()
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return l
+}
+
+func dialClient(t *testing.T, l *transport.PipeListener, cfg client.Config) (*client.Client, error) {
+	t.Helper()
+	conn, err := l.Dial()
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	c, err := client.New(conn, cfg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, nil
+}
+
+// waitFor 轮询直到 cond 成立
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("condition not met")
+}
+
+func TestServer_Sessions(t *testing.T) {
+	s := &Server{}
+	l := startPipeServer(t, s)
+	for _, id := range []string{"00000002", "00000001"} {
+		if _, err := dialClient(t, l, client.Config{ID: id}); err != nil {
+			t.Fatalf("dial %s error: %v", id, err)
+		}
+	}
+
+	list := s.Sessions()
+	if len(list) != 2 || list[0].ID() != "00000001" || list[1].ID() != "00000002" {
+		t.Fatalf("Sessions() = %v, want [00000001 00000002]", list)
+	}
+	if _, ok := s.Session("00000001"); !ok {
+		t.Error("Session(00000001) not found")
+	}
+
+	if err := s.Disconnect("00000001"); err != nil {
+		t.Fatalf("Disconnect() error = %v", err)
+	}
+	waitFor(t, func() bool { return s.Registry.Len() == 1 })
+	if err := s.Disconnect("00000001"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Disconnect() error = %v, want %v", err, ErrSessionNotFound)
+	}
+}
+
+func TestServer_DuplicateLogin(t *testing.T) {
+	t.Run("KickOld", func(t *testing.T) {
+		s := &Server{}
+		l := startPipeServer(t, s)
+		old, err := dialClient(t, l, client.Config{ID: "00000001"})
+		if err != nil {
+			t.Fatalf("dial error: %v", err)
+		}
+		if _, err = dialClient(t, l, client.Config{ID: "00000001"}); err != nil {
+			t.Fatalf("second dial error: %v", err)
+		}
+		select {
+		case <-old.Done():
+		case <-time.After(2 * time.Second):
+			t.Fatal("old session not kicked")
+		}
+		if n := s.Registry.Len(); n != 1 {
+			t.Errorf("Registry.Len() = %d, want 1", n)
+		}
+	})
+
+	t.Run("RejectNew", func(t *testing.T) {
+		s := &Server{Registry: Registry{Policy: DuplicateRejectNew}}
+		l := startPipeServer(t, s)
+		old, err := dialClient(t, l, client.Config{ID: "00000001"})
+		if err != nil {
+			t.Fatalf("dial error: %v", err)
+		}
+		_, err = dialClient(t, l, client.Config{ID: "00000001"})
+		if !errors.Is(err, client.ErrHandshake) {
+			t.Fatalf("second dial error = %v, want %v", err, client.ErrHandshake)
+		}
+		if old.Err() != nil {
+			t.Errorf("old session closed: %v", old.Err())
+		}
+	})
+}
+
+func TestServer_DeliverDuringHandshake(t *testing.T) {
+	// 单核机器上协程很少在握手的窗口内切换，多开几个 P 让推送与握手真正并发
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	tests := []struct {
+		name    string
+		format  frame.Format
+		ciphers []encryption.Cipher
+	}{
+		{name: "Plain", format: frame.FormatLegacy},
+		{name: "Encrypted", format: frame.FormatHeader, ciphers: []encryption.Cipher{encryption.AESGCM}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{FrameFormat: tt.format, Encryption: tt.ciphers}
+			l := startPipeServer(t, s)
+
+			// 握手期间多个协程不停推送，推送不能先于 ConAck 写出
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+						s.Deliver(ctx, "00000001", []byte("push"))
+						cancel()
+					}
+				}()
+			}
+			defer func() {
+				close(stop)
+				wg.Wait()
+			}()
+
+			for i := 0; i < 100; i++ {
+				conn, err := l.Dial()
+				if err != nil {
+					t.Fatal(err)
+				}
+				c, err := client.New(conn, client.Config{ID: "00000001", FrameFormat: tt.format, Encryption: tt.ciphers, HandshakeTimeout: time.Second})
+				if err != nil {
+					conn.Close()
+					t.Fatalf("New() #%d error = %v", i, err)
+				}
+				if c.Encryption() != encryption.Negotiate(tt.ciphers, tt.ciphers) {
+					t.Errorf("Encryption() = %v", c.Encryption())
+				}
+				c.Close()
+			}
+		})
+	}
+}
+
+func TestServer_Broadcast(t *testing.T) {
+	s := &Server{}
+	l := startPipeServer(t, s)
+
+	var got int32
+	onDeliver := func(d *packet.Deliver) uint8 {
+		atomic.AddInt32(&got, 1)
+		return packet.ResultOK
+	}
+	for _, id := range []string{"a0000001", "a0000002", "b0000001"} {
+		if _, err := dialClient(t, l, client.Config{ID: id, OnDeliver: onDeliver}); err != nil {
+			t.Fatalf("dial %s error: %v", id, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	n, err := s.Broadcast(ctx, []byte("hello"), func(sess *Session) bool {
+		return strings.HasPrefix(sess.ID(), "a")
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("Broadcast(filter) = %d, %v, want 2, nil", n, err)
+	}
+	n, err = s.Broadcast(ctx, []byte("hello"), nil)
+	if err != nil || n != 3 {
+		t.Fatalf("Broadcast(nil) = %d, %v, want 3, nil", n, err)
+	}
+	if got := atomic.LoadInt32(&got); got != 5 {
+		t.Errorf("deliveries = %d, want 5", got)
+	}
+}
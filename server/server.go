@@ -0,0 +1,381 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CoderI421/tcp-service/auth"
+	"github.com/CoderI421/tcp-service/checksum"
+	"github.com/CoderI421/tcp-service/compression"
+	"github.com/CoderI421/tcp-service/connlimit"
+	"github.com/CoderI421/tcp-service/encryption"
+	"github.com/CoderI421/tcp-service/frame"
+	"github.com/CoderI421/tcp-service/limiter"
+	"github.com/CoderI421/tcp-service/logger"
+	"github.com/CoderI421/tcp-service/message"
+	"github.com/CoderI421/tcp-service/metrics"
+	"github.com/CoderI421/tcp-service/pubsub"
+	"github.com/CoderI421/tcp-service/transport"
+)
+
+// ErrServerClosed Server 关闭后 Serve 返回的错误
+var ErrServerClosed = errors.New("server: Server closed")
+
+// accept 临时错误的退避时间，与 net/http 保持一致
+const (
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = 1 * time.Second
+)
+
+// Server tcp-service 服务端
+// 零值可直接使用，字段需在 Serve 之前设置
+type Server struct {
+	Limiter *limiter.Limiter // submit 限流，nil 表示不限流
+	Gate    *connlimit.Gate  // 连接准入控制，nil 表示不做控制
+
+	// Auth Con 握手鉴权，nil 表示不鉴权
+	// 开启后握手成功之前的其他请求会导致连接被关闭
+	Auth auth.Authenticator
+	// TLSConfig ListenAndServe 中 tls:// 地址使用的 TLS 配置
+	TLSConfig *tls.Config
+
+	HandshakeTimeout time.Duration // 连接建立后完成 Con 握手的时限，0 表示不限
+	IdleTimeout      time.Duration // 两次收到请求之间的最长间隔，0 表示不限
+	WriteTimeout     time.Duration // 单次写连接的超时，0 表示不限
+
+	// Registry 会话注册表，Con 握手后按客户端标识登记
+	// 可在 Serve 之前设置 Registry.Policy 选择重复登录的处理方式
+	Registry Registry
+
+	// Messages 带类型的 submit 按 kind 反序列化后交给注册的 handler，handler 的返回值作为 SubmitAck 的 result
+	// nil 时带类型的 submit 回复 ResultFailed；handler 在读协程中同步调用
+	Messages *message.Registry
+
+	// Broker 订阅关系，带 topic 的 submit 会推送给所有匹配的订阅者
+	Broker pubsub.Broker
+	// SubQueueSize 每个订阅者推送队列的长度，0 表示使用默认值
+	SubQueueSize int
+	// SlowConsumer 订阅者推送队列满时的处理方式
+	SlowConsumer pubsub.SlowPolicy
+
+	// FrameFormat 帧格式，零值按客户端的第一个 frame 自动识别
+	// 文本格式（见 frame.Format.Text）的连接收发文本 packet，见 textpacket
+	FrameFormat frame.Format
+	// FrameDelimiter FormatDelimiter 的分隔符与转义配置，nil 表示以 LF 分隔且不转义
+	FrameDelimiter *frame.DelimiterCodec
+	// MaxFrameLength frame payload 的最大长度，所有帧格式、WebSocket 消息与解压后的 payload 共用，超过时断开连接
+	// 0 表示 frame.DefaultMaxLength；FrameDelimiter 自带 MaxLength 时以其为准
+	MaxFrameLength int
+
+	// Compression 支持的 payload 压缩算法，客户端在 Con 中提出时按客户端的优先级协商
+	// 为空表示不压缩；只对字节流连接生效，WebSocket 连接以及不能携带 flags 的帧格式不协商
+	Compression []compression.Algorithm
+	// CompressThreshold 不小于该长度的 payload 才压缩，0 表示使用默认值
+	CompressThreshold int
+
+	// Checksum 支持的 frame 校验和算法，客户端在 Con 中提出时按客户端的优先级协商
+	// 为空表示不校验；只有使用带版本号帧头（frame.FormatHeader）的字节流连接能够协商
+	Checksum []checksum.Algorithm
+
+	// Encryption 支持的端到端加密算法，客户端在 Con 中提出时按客户端的优先级协商
+	// 为空表示不加密；与 Checksum 一样只有使用带版本号帧头的字节流连接能够协商
+	Encryption []encryption.Cipher
+	// EncryptionKey 服务端的 X25519 静态私钥，参与加密的密钥交换，客户端固定对应的公钥以认证服务端并加密凭证
+	// nil 表示不使用静态密钥，此时密钥交换无法抵御主动的中间人
+	EncryptionKey []byte
+	// RequireEncryption 未协商出加密算法的 Con 回复 ResultRefused 并断开，WebSocket 连接以及不能携带 flags 的帧格式都无法握手
+	// 不开启时只有凭证已加密的 Con 要求加密
+	RequireEncryption bool
+
+	// WSCheckOrigin WebSocket 升级时校验 Origin，nil 时只允许同源
+	WSCheckOrigin func(r *http.Request) bool
+
+	inShutdown int32 // 原子操作，非 0 表示已开始 drain 或已关闭
+	refusing   int32 // 原子操作，正在发送拒绝包的连接数
+
+	mu        sync.Mutex
+	listeners map[*net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+
+	lastSID uint64              // 受 mu 保护
+	live    map[uint64]*Session // 所有存活的会话，包括尚未握手的
+}
+
+// Serve 在 l 上接受连接，每个连接由一个协程处理
+// accept 遇到临时错误（如 EMFILE/ENFILE）时退避重试，只有 listener 关闭才会返回
+func (s *Server) Serve(l net.Listener) error {
+	if !s.trackListener(&l, true) {
+		return ErrServerClosed
+	}
+	defer s.trackListener(&l, false)
+
+	var tempDelay time.Duration
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			metrics.AcceptErrorTotal.Inc()
+			if isTemporary(err) {
+				if tempDelay == 0 {
+					tempDelay = minAcceptDelay
+				} else {
+					tempDelay *= 2
+				}
+				if tempDelay > maxAcceptDelay {
+					tempDelay = maxAcceptDelay
+				}
+				logger.Warnf("accept error: %v; retrying in %v", err, tempDelay)
+				time.Sleep(tempDelay)
+				continue
+			}
+			logger.Errorf("accept error: %v", err)
+			return err
+		}
+		tempDelay = 0
+
+		// 准入控制，拒绝的连接发送拒绝包后关闭
+		release, ok := s.admit(c.RemoteAddr())
+		if !ok {
+			s.refuse(c)
+			continue
+		}
+
+		// start a new goroutine to handle
+		// the new connection.
+		// 每个客户端连接，由一个协成进行处理
+		if !s.trackConn(c, true) {
+			release()
+			c.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.wg.Done()
+			defer s.trackConn(c, false)
+			defer release()
+			s.handleConn(s.newStreamConn(c))
+		}()
+	}
+}
+
+// admit 连接准入控制，接受时返回的 release 需在连接关闭后调用
+func (s *Server) admit(addr net.Addr) (release func(), ok bool) {
+	if s.Gate == nil {
+		return func() {}, true
+	}
+	release, reason, ok := s.Gate.Admit(addr)
+	if !ok {
+		metrics.ConnRejectedTotal.WithLabelValues(reason).Inc()
+		logger.Infof("refuse conn from %s: %s", addr, reason)
+	}
+	return release, ok
+}
+
+// ListenAndServe 同时监听多个地址并在每个 listener 上调用 Serve
+// 地址格式见 transport 包，如 tcp://:8888、unix:///var/run/tcp-service.sock
+// tls:// 地址使用 TLSConfig
+// 任一 Serve 出错返回时关闭 Server，并返回第一个错误
+func (s *Server) ListenAndServe(addrs ...string) error {
+	var listeners []net.Listener
+	for _, addr := range addrs {
+		l, err := transport.ListenTLS(addr, s.TLSConfig)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+	return s.ServeAll(listeners...)
+}
+
+// ServeAll 在多个 listener 上同时调用 Serve
+// 任一 Serve 出错返回时关闭 Server，并返回第一个错误
+func (s *Server) ServeAll(listeners ...net.Listener) error {
+	if len(listeners) == 0 {
+		return errors.New("server: no listener")
+	}
+
+	errc := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			errc <- s.Serve(l)
+		}(l)
+	}
+	err := <-errc
+	// Drain 后返回 ErrServerClosed，已有连接继续服务
+	if !errors.Is(err, ErrServerClosed) {
+		s.Close()
+	}
+	for i := 1; i < len(listeners); i++ {
+		<-errc
+	}
+	return err
+}
+
+// Close 关闭所有 listener 以及所有连接，并等待连接处理协程退出
+func (s *Server) Close() error {
+	atomic.StoreInt32(&s.inShutdown, 1)
+
+	s.mu.Lock()
+	err := s.closeListenersLocked()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+// Drain 关闭所有 listener 停止接受新连接，Serve 返回 ErrServerClosed
+// 已有连接继续服务，直到客户端断开或调用 Close/Shutdown
+func (s *Server) Drain() error {
+	atomic.StoreInt32(&s.inShutdown, 1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeListenersLocked()
+}
+
+// Draining 是否已停止接受新连接
+func (s *Server) Draining() bool {
+	return s.shuttingDown()
+}
+
+// Shutdown 先 Drain，再等待所有连接断开
+// ctx 结束时关闭剩余的连接，并返回 ctx.Err()
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.Drain()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		s.Close()
+		return ctx.Err()
+	}
+}
+
+func (s *Server) closeListenersLocked() error {
+	var err error
+	for l := range s.listeners {
+		if cerr := (*l).Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (s *Server) shuttingDown() bool {
+	return atomic.LoadInt32(&s.inShutdown) != 0
+}
+
+// trackListener 记录或移除 listener，Server 已关闭时返回 false
+func (s *Server) trackListener(l *net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listeners == nil {
+		s.listeners = make(map[*net.Listener]struct{})
+	}
+	if add {
+		if s.shuttingDown() {
+			return false
+		}
+		s.listeners[l] = struct{}{}
+	} else {
+		delete(s.listeners, l)
+	}
+	return true
+}
+
+// trackConn 记录或移除连接，Server 已关闭时返回 false
+func (s *Server) trackConn(c net.Conn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	if add {
+		if s.shuttingDown() {
+			return false
+		}
+		s.wg.Add(1)
+		s.conns[c] = struct{}{}
+	} else {
+		delete(s.conns, c)
+	}
+	return true
+}
+
+// trackSession 记录或移除存活的会话，记录时分配会话序号
+func (s *Server) trackSession(sess *Session, add bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.live == nil {
+		s.live = make(map[uint64]*Session)
+	}
+	if add {
+		s.lastSID++
+		sess.sid = s.lastSID
+		s.live[sess.sid] = sess
+	} else {
+		delete(s.live, sess.sid)
+	}
+}
+
+// LiveSession 按会话序号查找存活的会话，包括尚未握手的
+func (s *Server) LiveSession(sid uint64) (*Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.live[sid]
+	return sess, ok
+}
+
+// LiveSessions 返回所有存活的会话，包括尚未握手的，按会话序号排序
+func (s *Server) LiveSessions() []*Session {
+	s.mu.Lock()
+	list := make([]*Session, 0, len(s.live))
+	for _, sess := range s.live {
+		list = append(list, sess)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].sid < list[j].sid })
+	return list
+}
+
+// isTemporary 判断 accept 错误是否为临时错误
+func isTemporary(err error) bool {
+	var ne interface{ Temporary() bool }
+	return errors.As(err, &ne) && ne.Temporary()
+}
+
+// maxFrameLength 返回 frame payload 的最大长度，未设置时为 frame.DefaultMaxLength
+func (s *Server) maxFrameLength() int {
+	if s.MaxFrameLength > 0 {
+		return s.MaxFrameLength
+	}
+	return frame.DefaultMaxLength
+}
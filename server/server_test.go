@@ -0,0 +1,758 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/CoderI421/tcp-service/auth"
+	"github.com/CoderI421/tcp-service/client"
+	"github.com/CoderI421/tcp-service/compression"
+	"github.com/CoderI421/tcp-service/connlimit"
+	"github.com/CoderI421/tcp-service/encryption"
+	"github.com/CoderI421/tcp-service/frame"
+	"github.com/CoderI421/tcp-service/internal/testcert"
+	"github.com/CoderI421/tcp-service/packet"
+	"github.com/CoderI421/tcp-service/transport"
+)
+
+// tempErrListener 前 n 次 Accept 返回临时错误，之后交给内部 listener
+type tempErrListener struct {
+	net.Listener
+	n     int
+	calls int
+}
+
+func (l *tempErrListener) Accept() (net.Conn, error) {
+	l.calls++
+	if l.calls <= l.n {
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Err: syscall.EMFILE}
+	}
+	return l.Listener.Accept()
+}
+
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error: %v", err)
+	}
+	return l
+}
+
+func serve(t *testing.T, s *Server, l net.Listener) <-chan error {
+	t.Helper()
+	errc := make(chan error, 1)
+	go func() { errc <- s.Serve(l) }()
+	return errc
+}
+
+func roundTrip(t *testing.T, c net.Conn, p packet.Packet) packet.Packet {
+	t.Helper()
+	return roundTripCodec(t, frame.NewCodec(), c, p)
+}
+
+// roundTripCodec 同 roundTrip，以 codec 的帧格式收发
+func roundTripCodec(t *testing.T, codec frame.StreamFrameCodec, c net.Conn, p packet.Packet) packet.Packet {
+	t.Helper()
+	framePayload, err := packet.Encode(p)
+	if err != nil {
+		t.Fatalf("packet encode error: %v", err)
+	}
+	if err = codec.Encode(c, framePayload); err != nil {
+		t.Fatalf("frame encode error: %v", err)
+	}
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	ackFramePayload, err := codec.Decode(c)
+	if err != nil {
+		t.Fatalf("frame decode error: %v", err)
+	}
+	ack, err := packet.Decode(ackFramePayload)
+	if err != nil {
+		t.Fatalf("packet decode error: %v", err)
+	}
+	return ack
+}
+
+func TestServer_ServeRetriesTemporaryErrors(t *testing.T) {
+	l := &tempErrListener{Listener: listen(t), n: 3}
+	s := &Server{}
+	errc := serve(t, s, l)
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	defer c.Close()
+
+	ack := roundTrip(t, c, &packet.Submit{ID: "00000001", Payload: []byte("hello")})
+	if got, ok := ack.(*packet.SubmitAck); !ok || got.ID != "00000001" || got.Result != packet.ResultOK {
+		t.Errorf("ack = %#v, want SubmitAck{00000001, ResultOK}", ack)
+	}
+
+	if err = s.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if err = <-errc; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Serve() error = %v, want %v", err, ErrServerClosed)
+	}
+}
+
+func TestServer_ServeAfterClose(t *testing.T) {
+	s := &Server{}
+	s.Close()
+	l := listen(t)
+	defer l.Close()
+	if err := s.Serve(l); !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Serve() error = %v, want %v", err, ErrServerClosed)
+	}
+}
+
+func TestServer_Refuse(t *testing.T) {
+	gate, err := connlimit.New(connlimit.Config{Deny: []string{"127.0.0.0/8"}})
+	if err != nil {
+		t.Fatalf("connlimit.New() error = %v", err)
+	}
+	l := listen(t)
+	s := &Server{Gate: gate}
+	serve(t, s, l)
+	defer s.Close()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	defer c.Close()
+
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	ackFramePayload, err := frame.NewCodec().Decode(c)
+	if err != nil {
+		t.Fatalf("frame decode error: %v", err)
+	}
+	ack, err := packet.Decode(ackFramePayload)
+	if err != nil {
+		t.Fatalf("packet decode error: %v", err)
+	}
+	if got, ok := ack.(*packet.ConAck); !ok || got.Result != packet.ResultRefused {
+		t.Errorf("ack = %#v, want ConAck{ResultRefused}", ack)
+	}
+}
+
+func TestServer_RefuseLimit(t *testing.T) {
+	gate, err := connlimit.New(connlimit.Config{Deny: []string{"127.0.0.0/8"}})
+	if err != nil {
+		t.Fatalf("connlimit.New() error = %v", err)
+	}
+	l := listen(t)
+	s := &Server{Gate: gate}
+	serve(t, s, l)
+	defer s.Close()
+
+	// 发送中的拒绝包已达上限时直接关闭，不再创建协程
+	atomic.StoreInt32(&s.refusing, maxRefusing)
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err = frame.NewCodec().Decode(c); !errors.Is(err, io.EOF) {
+		t.Errorf("frame decode error = %v, want %v", err, io.EOF)
+	}
+	if n := atomic.LoadInt32(&s.refusing); n != maxRefusing {
+		t.Errorf("refusing = %d, want %d", n, maxRefusing)
+	}
+
+	// 低于上限时发送拒绝包，完成后计数恢复
+	atomic.StoreInt32(&s.refusing, 0)
+	c2, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	defer c2.Close()
+	c2.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err = frame.NewCodec().Decode(c2); err != nil {
+		t.Fatalf("frame decode error: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&s.refusing) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("refusing = %d, want 0", atomic.LoadInt32(&s.refusing))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestServer_Pipe(t *testing.T) {
+	l := transport.NewPipeListener()
+	s := &Server{}
+	serve(t, s, l)
+	defer s.Close()
+
+	c, err := l.Dial()
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	defer c.Close()
+
+	ack := roundTrip(t, c, &packet.Con{ID: "00000001"})
+	if got, ok := ack.(*packet.ConAck); !ok || got.Result != packet.ResultOK {
+		t.Fatalf("ack = %#v, want ConAck{ResultOK}", ack)
+	}
+	for _, id := range []string{"00000001", "00000002", "00000003"} {
+		ack = roundTrip(t, c, &packet.Submit{ID: id, Payload: []byte("hello")})
+		if got, ok := ack.(*packet.SubmitAck); !ok || got.ID != id {
+			t.Errorf("ack = %#v, want SubmitAck{%s}", ack, id)
+		}
+	}
+}
+
+func TestServer_MaxFrameLength(t *testing.T) {
+	tests := []struct {
+		name   string
+		format frame.Format
+		max    int
+		header []byte
+	}{
+		// 伪造的长度头不能让服务端按此分配内存
+		{name: "LegacyDefault", format: frame.FormatLegacy, header: []byte{0x7f, 0xff, 0xff, 0xff}},
+		{name: "AutoDefault", format: frame.FormatAuto, header: []byte{0x7f, 0xff, 0xff, 0xff}},
+		{name: "LegacyLimit", format: frame.FormatLegacy, max: 16, header: []byte{0, 0, 0, 21}},
+		{name: "VarintLimit", format: frame.FormatVarint, max: 16, header: []byte{17}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := transport.NewPipeListener()
+			s := &Server{FrameFormat: tt.format, MaxFrameLength: tt.max}
+			serve(t, s, l)
+			defer s.Close()
+
+			c, err := l.Dial()
+			if err != nil {
+				t.Fatalf("dial error: %v", err)
+			}
+			defer c.Close()
+			if _, err = c.Write(tt.header); err != nil {
+				t.Fatal(err)
+			}
+			// 超过最大长度时服务端立即断开连接，不等待 payload
+			c.SetReadDeadline(time.Now().Add(2 * time.Second))
+			if _, err = c.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Errorf("Read() error = %v, want connection closed", err)
+			}
+		})
+	}
+}
+
+func TestServer_MaxFrameLengthCompressed(t *testing.T) {
+	l := transport.NewPipeListener()
+	s := &Server{FrameFormat: frame.FormatHeader, Compression: []compression.Algorithm{compression.Gzip}, MaxFrameLength: 1024}
+	serve(t, s, l)
+	defer s.Close()
+
+	conn, err := l.Dial()
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	c, err := client.New(conn, client.Config{ID: "00000001", FrameFormat: frame.FormatHeader, Compression: []compression.Algorithm{compression.Gzip}, HandshakeTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("client.New() error = %v", err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if result, err := c.Submit(ctx, bytes.Repeat([]byte("a"), 512)); err != nil || result != packet.ResultOK {
+		t.Fatalf("Submit() = %d, %v, want ok", result, err)
+	}
+	// 压缩后远小于 MaxFrameLength，解压后超过时断开连接
+	if _, err = c.Submit(ctx, bytes.Repeat([]byte("a"), 64<<10)); err == nil {
+		t.Errorf("Submit() error = nil, want connection closed")
+	}
+}
+
+func TestServer_ListenAndServeMulti(t *testing.T) {
+	sock := "unix://" + filepath.Join(t.TempDir(), "server.sock")
+	s := &Server{}
+	errc := make(chan error, 1)
+	go func() { errc <- s.ListenAndServe("pipe://multi", sock) }()
+
+	for _, addr := range []string{"pipe://multi", sock} {
+		var (
+			c   net.Conn
+			err error
+		)
+		// 等待 listener 就绪
+		for i := 0; i < 100; i++ {
+			if c, err = transport.Dial(addr); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("Dial(%s) error = %v", addr, err)
+		}
+		ack := roundTrip(t, c, &packet.Submit{ID: "00000001", Payload: []byte("hello")})
+		if _, ok := ack.(*packet.SubmitAck); !ok {
+			t.Errorf("%s: ack = %#v, want SubmitAck", addr, ack)
+		}
+		c.Close()
+	}
+
+	s.Close()
+	if err := <-errc; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("ListenAndServe() error = %v, want %v", err, ErrServerClosed)
+	}
+}
+
+func TestIsTemporary(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "EMFILE", err: &net.OpError{Op: "accept", Err: syscall.EMFILE}, want: true},
+		{name: "ENFILE", err: &net.OpError{Op: "accept", Err: syscall.ENFILE}, want: true},
+		{name: "Closed", err: net.ErrClosed, want: false},
+		{name: "Other", err: errors.New("other"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTemporary(tt.err); got != tt.want {
+				t.Errorf("isTemporary() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_LiveSessions(t *testing.T) {
+	s := &Server{}
+	l := startPipeServer(t, s)
+	c, err := dialClient(t, l, client.Config{ID: "00000001"})
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	if _, err = c.Submit(context.Background(), []byte("hello")); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+
+	list := s.LiveSessions()
+	if len(list) != 1 {
+		t.Fatalf("LiveSessions() len = %d, want 1", len(list))
+	}
+	info := list[0].Info()
+	// Con + Submit，ConnAck + SubmitAck
+	if info.ClientID != "00000001" || info.MsgsIn != 2 || info.MsgsOut != 2 || info.BytesIn == 0 {
+		t.Errorf("Info() = %+v", info)
+	}
+	if _, ok := s.LiveSession(info.SID); !ok {
+		t.Errorf("LiveSession(%d) not found", info.SID)
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	s := &Server{}
+	l := transport.NewPipeListener()
+	errc := make(chan error, 1)
+	go func() { errc <- s.ServeAll(l) }()
+	c, err := dialClient(t, l, client.Config{ID: "00000001"})
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+
+	if err = s.Drain(); err != nil {
+		t.Fatalf("Drain() error = %v", err)
+	}
+	if err = <-errc; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("ServeAll() error = %v, want %v", err, ErrServerClosed)
+	}
+	// drain 后已有连接继续服务
+	if result, err := c.Submit(context.Background(), []byte("hello")); err != nil || result != packet.ResultOK {
+		t.Errorf("Submit() after Drain = %d, %v", result, err)
+	}
+	if _, err = l.Dial(); err == nil {
+		t.Error("Dial() after Drain succeeded")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err = s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	select {
+	case <-c.Done():
+	case <-time.After(time.Second):
+		t.Error("client not disconnected after Shutdown")
+	}
+}
+
+func TestServer_Auth(t *testing.T) {
+	s := &Server{Auth: auth.NewStatic(map[string]string{"00000001": "secret"})}
+	l := startPipeServer(t, s)
+
+	tests := []struct {
+		name    string
+		cfg     client.Config
+		wantErr bool
+	}{
+		{name: "OK", cfg: client.Config{ID: "00000001", Payload: []byte("secret")}},
+		{name: "WrongSecret", cfg: client.Config{ID: "00000001", Payload: []byte("wrong")}, wantErr: true},
+		{name: "UnknownID", cfg: client.Config{ID: "00000002", Payload: []byte("secret")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := dialClient(t, l, tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("dial error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, client.ErrHandshake) {
+				t.Errorf("dial error = %v, want %v", err, client.ErrHandshake)
+			}
+		})
+	}
+
+	// 握手之前的请求导致连接关闭
+	c, err := l.Dial()
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	defer c.Close()
+	framePayload, _ := packet.Encode(&packet.Submit{ID: "00000001", Payload: []byte("hello")})
+	if err = frame.NewCodec().Encode(c, framePayload); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if _, err = frame.NewCodec().Decode(c); err == nil {
+		t.Error("Decode() error = nil, want connection closed")
+	}
+}
+
+func TestServer_HandshakeTimeout(t *testing.T) {
+	s := &Server{HandshakeTimeout: 20 * time.Millisecond, IdleTimeout: time.Minute}
+	l := startPipeServer(t, s)
+	c, err := l.Dial()
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	defer c.Close()
+
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = frame.NewCodec().Decode(c); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Decode() error = %v, want closed by server", err)
+	}
+}
+
+func TestServer_ListenAndServeTLS(t *testing.T) {
+	serverConfig, clientConfig, err := testcert.Configs()
+	if err != nil {
+		t.Fatalf("generate cert error: %v", err)
+	}
+	l, err := transport.ListenTLS("tls://127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatalf("ListenTLS() error = %v", err)
+	}
+	s := &Server{}
+	go s.Serve(l)
+	defer s.Close()
+
+	c, err := client.Dial("tls://"+l.Addr().String(), client.Config{ID: "00000001", TLSConfig: clientConfig})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+	if result, err := c.Submit(context.Background(), []byte("hello")); err != nil || result != packet.ResultOK {
+		t.Errorf("Submit() = %d, %v", result, err)
+	}
+
+	if err = (&Server{}).ListenAndServe("tls://127.0.0.1:0"); !errors.Is(err, transport.ErrNoTLSConfig) {
+		t.Errorf("ListenAndServe() without TLSConfig error = %v, want %v", err, transport.ErrNoTLSConfig)
+	}
+}
+
+func TestServer_TextFrame(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    frame.Format
+		delimiter *frame.DelimiterCodec
+		requests  []string
+		want      []string
+	}{
+		{
+			name:     "Line",
+			format:   frame.FormatLine,
+			requests: []string{"CONN 00000001\r\n", "\r\n", "submit 00000002 hello world\n"},
+			want:     []string{"CONNACK 00000001 OK\r\n", "SUBMITACK 00000002 OK\r\n"},
+		},
+		{
+			name:      "Delimiter",
+			format:    frame.FormatDelimiter,
+			delimiter: frame.NewDelimiterCodec(';', '\\'),
+			requests:  []string{"CONN 00000001;", `SUBMIT 00000002 a\;b;`},
+			want:      []string{"CONNACK 00000001 OK;", "SUBMITACK 00000002 OK;"},
+		},
+		{
+			name:     "DefaultDelimiter",
+			format:   frame.FormatDelimiter,
+			requests: []string{"CONN 00000001\n", "SUBSCRIBE 00000002 a/b\n"},
+			want:     []string{"CONNACK 00000001 OK\n", "SUBSCRIBEACK 00000002 OK\n"},
+		},
+		{
+			// 无法解析的行回复 FAILED 或跳过，连接不断开
+			name:   "Malformed",
+			format: frame.FormatLine,
+			requests: []string{
+				"CONN 00000001\r\n",
+				"SUBSCRIBE 00000002\r\n",
+				"TYPEDSUBMIT 00000003 xml order <a/>\r\n",
+				"PING 00000004\r\nSUBMIT 0005 x\r\nDELIVERACK 00000006 bad\r\nSUBMIT 00000007 x\r\n",
+			},
+			want: []string{"CONNACK 00000001 OK\r\n", "SUBSCRIBEACK 00000002 FAILED\r\n", "SUBMITACK 00000003 FAILED\r\n", "SUBMITACK 00000007 OK\r\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := transport.NewPipeListener()
+			s := &Server{FrameFormat: tt.format, FrameDelimiter: tt.delimiter}
+			serve(t, s, l)
+			defer s.Close()
+
+			c, err := l.Dial()
+			if err != nil {
+				t.Fatalf("dial error: %v", err)
+			}
+			defer c.Close()
+
+			// 空行不回复，按非空请求逐个读取 ack
+			var got []string
+			buf := make([]byte, 64)
+			for _, req := range tt.requests {
+				if _, err = c.Write([]byte(req)); err != nil {
+					t.Fatalf("write error: %v", err)
+				}
+				if strings.TrimSpace(req) == "" {
+					continue
+				}
+				c.SetReadDeadline(time.Now().Add(2 * time.Second))
+				n, err := c.Read(buf)
+				if err != nil {
+					t.Fatalf("read error: %v", err)
+				}
+				got = append(got, string(buf[:n]))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("acks = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_TextDeliver(t *testing.T) {
+	l := transport.NewPipeListener()
+	s := &Server{FrameFormat: frame.FormatLine}
+	serve(t, s, l)
+	defer s.Close()
+
+	c, err := l.Dial()
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	defer c.Close()
+	r := bufio.NewReader(c)
+	readLine := func() string {
+		t.Helper()
+		c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read error: %v", err)
+		}
+		return line
+	}
+	c.Write([]byte("CONN 00000001\r\nSUBSCRIBE 00000002 news\r\n"))
+	for _, want := range []string{"CONNACK 00000001 OK\r\n", "SUBSCRIBEACK 00000002 OK\r\n"} {
+		if got := readLine(); got != want {
+			t.Fatalf("ack = %q, want %q", got, want)
+		}
+	}
+
+	// 含有行尾的 payload 在放入发送队列前拒绝，会话不受影响
+	if err = s.Deliver(context.Background(), "00000001", []byte("a\r\nb")); !errors.Is(err, frame.ErrDelimiterInPayload) {
+		t.Errorf("Deliver() error = %v, want %v", err, frame.ErrDelimiterInPayload)
+	}
+	// 订阅推送丢弃无法编码的消息
+	if err = s.publish("news", []byte("a\nb")); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.publish("news", []byte("ok")); err != nil {
+		t.Fatal(err)
+	}
+	if line := readLine(); !strings.HasPrefix(line, "TOPICDELIVER ") || !strings.HasSuffix(line, " news ok\r\n") {
+		t.Errorf("deliver = %q, want topic news payload ok", line)
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.Deliver(context.Background(), "00000001", []byte("hello")) }()
+	line := readLine()
+	if !strings.HasPrefix(line, "DELIVER ") || !strings.HasSuffix(line, " hello\r\n") {
+		t.Fatalf("deliver = %q", line)
+	}
+	c.Write([]byte("DELIVERACK " + strings.Fields(line)[1] + " OK\r\n"))
+	if err = <-errc; err != nil {
+		t.Errorf("Deliver() error = %v", err)
+	}
+}
+
+// sealedCon 客户端以固定的服务端公钥加密凭证的 Con，模拟被动截获的握手
+func sealedCon(t *testing.T, pub []byte, id, credential string, ciphers []encryption.Cipher) *packet.Con {
+	t.Helper()
+	h, err := encryption.NewClientHandshake(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offer := encryption.Offer{Public: h.Public(), Ciphers: ciphers, Sealed: true}.Encode()
+	sealed, err := h.SealCredential(id, offer, []byte(credential))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &packet.Con{ID: id, Payload: sealed, Options: []packet.Option{{Type: packet.OptionEncryption, Value: offer}}}
+}
+
+func TestServer_SealedCredentialReplay(t *testing.T) {
+	priv, pub, err := encryption.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphers := []encryption.Cipher{encryption.AESGCM}
+	con := sealedCon(t, pub, "00000001", "secret", ciphers)
+	// 删除 offer 中的算法，凭证保持不变
+	stripped := *con
+	stripped.Options = []packet.Option{{Type: packet.OptionEncryption, Value: encryption.Offer{Public: con.Options[0].Value[1:33], Sealed: true}.Encode()}}
+
+	newServer := func() *Server {
+		return &Server{
+			Encryption:    ciphers,
+			EncryptionKey: priv,
+			Auth:          auth.NewStatic(map[string]string{"00000001": "secret"}),
+		}
+	}
+	conAck := func(t *testing.T, ack packet.Packet) *packet.ConAck {
+		t.Helper()
+		got, ok := ack.(*packet.ConAck)
+		if !ok {
+			t.Fatalf("ack = %#v, want ConAck", ack)
+		}
+		return got
+	}
+
+	// 原样重放到能加密的连接上时只能得到加密的会话，没有客户端临时私钥无法继续
+	t.Run("Header", func(t *testing.T) {
+		s := newServer()
+		l := listen(t)
+		serve(t, s, l)
+		defer s.Close()
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		ack := conAck(t, roundTripCodec(t, frame.NewFormatCodec(frame.FormatHeader), c, con))
+		v, _ := packet.FindOption(ack.Options, packet.OptionEncryption)
+		if a, err := encryption.DecodeAccept(v); ack.Result != packet.ResultOK || err != nil || a.Cipher != encryption.AESGCM {
+			t.Errorf("ConAck = %+v, want ok with %s", ack, encryption.AESGCM)
+		}
+	})
+	tests := []struct {
+		name  string
+		con   *packet.Con
+		codec frame.StreamFrameCodec
+	}{
+		// 不能携带 flags 的帧格式无法加密
+		{name: "Legacy", con: con, codec: frame.NewCodec()},
+		// 凭证与 offer 绑定，删除算法后无法解密
+		{name: "CiphersStripped", con: &stripped, codec: frame.NewFormatCodec(frame.FormatHeader)},
+		{name: "CiphersStrippedLegacy", con: &stripped, codec: frame.NewCodec()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newServer()
+			l := listen(t)
+			serve(t, s, l)
+			defer s.Close()
+			c, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			if ack := conAck(t, roundTripCodec(t, tt.codec, c, tt.con)); ack.Result != packet.ResultRefused {
+				t.Errorf("ConAck result = %d, want %d", ack.Result, packet.ResultRefused)
+			}
+			if n := len(s.Sessions()); n != 0 {
+				t.Errorf("sessions = %d, want 0", n)
+			}
+		})
+	}
+	// WebSocket 连接无法加密
+	for name, p := range map[string]*packet.Con{"WebSocket": con, "WebSocketStripped": &stripped} {
+		t.Run(name, func(t *testing.T) {
+			s := newServer()
+			ws := dialWS(t, s)
+			if ack := conAck(t, wsRoundTrip(t, ws, p)); ack.Result != packet.ResultRefused {
+				t.Errorf("ConAck result = %d, want %d", ack.Result, packet.ResultRefused)
+			}
+		})
+	}
+}
+
+func TestServer_RequireEncryption(t *testing.T) {
+	ciphers := []encryption.Cipher{encryption.AESGCM}
+	offer := func() []packet.Option {
+		h, err := encryption.NewClientHandshake(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []packet.Option{{Type: packet.OptionEncryption, Value: encryption.Offer{Public: h.Public(), Ciphers: ciphers}.Encode()}}
+	}
+	tests := []struct {
+		name    string
+		options []packet.Option
+		codec   frame.StreamFrameCodec
+		want    uint8
+	}{
+		{name: "Encrypted", options: offer(), codec: frame.NewFormatCodec(frame.FormatHeader), want: packet.ResultOK},
+		{name: "Plain", codec: frame.NewFormatCodec(frame.FormatHeader), want: packet.ResultRefused},
+		{name: "EmptyCiphers", options: []packet.Option{{Type: packet.OptionEncryption, Value: encryption.Offer{Public: make([]byte, encryption.PublicKeySize)}.Encode()}},
+			codec: frame.NewFormatCodec(frame.FormatHeader), want: packet.ResultRefused},
+		{name: "Legacy", options: offer(), codec: frame.NewCodec(), want: packet.ResultRefused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Encryption: ciphers, RequireEncryption: true}
+			l := listen(t)
+			serve(t, s, l)
+			defer s.Close()
+			c, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			ack, ok := roundTripCodec(t, tt.codec, c, &packet.Con{ID: "00000001", Options: tt.options}).(*packet.ConAck)
+			if !ok || ack.Result != tt.want {
+				t.Errorf("ack = %+v, want ConAck result %d", ack, tt.want)
+			}
+		})
+	}
+
+	s := &Server{Encryption: ciphers, RequireEncryption: true}
+	ws := dialWS(t, s)
+	if ack, ok := wsRoundTrip(t, ws, &packet.Con{ID: "00000001", Options: offer()}).(*packet.ConAck); !ok || ack.Result != packet.ResultRefused {
+		t.Errorf("WebSocket ack = %+v, want ConAck result %d", ack, packet.ResultRefused)
+	}
+}
@@ -0,0 +1,641 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CoderI421/tcp-service/checksum"
+	"github.com/CoderI421/tcp-service/compression"
+	"github.com/CoderI421/tcp-service/encryption"
+	"github.com/CoderI421/tcp-service/frame"
+	"github.com/CoderI421/tcp-service/limiter"
+	"github.com/CoderI421/tcp-service/logger"
+	"github.com/CoderI421/tcp-service/message"
+	"github.com/CoderI421/tcp-service/metrics"
+	"github.com/CoderI421/tcp-service/packet"
+	"github.com/CoderI421/tcp-service/pubsub"
+	"github.com/CoderI421/tcp-service/textpacket"
+)
+
+const (
+	outQueueSize      = 128         // 每个会话发送队列的长度
+	writeDrainTimeout = time.Second // 关闭会话时等待写完剩余数据的最长时间
+)
+
+var (
+	// ErrSessionClosed 会话已关闭
+	ErrSessionClosed = errors.New("server: session closed")
+	// ErrSessionNotFound 找不到指定的会话
+	ErrSessionNotFound = errors.New("server: session not found")
+	// ErrDeliverFailed 客户端对推送的响应 result 不为 ResultOK
+	ErrDeliverFailed = errors.New("server: deliver failed")
+	// ErrAuthFailed Con 握手鉴权失败
+	ErrAuthFailed = errors.New("server: authentication failed")
+	// ErrNotAuthenticated 开启鉴权时握手之前收到其他请求
+	ErrNotAuthenticated = errors.New("server: not authenticated")
+	// ErrEncryptionRequired 凭证已加密或服务端要求加密时，Con 未能协商出加密算法
+	ErrEncryptionRequired = errors.New("server: encryption required")
+)
+
+// Session 一个客户端连接
+// 读协程负责解析请求，写协程负责把 ack 以及推送写回连接，二者通过发送队列解耦
+type Session struct {
+	// 统计字段，原子操作，放在最前面保证 32 位平台上的 64 位对齐
+	bytesIn      uint64 // 收到的帧载荷字节数
+	bytesOut     uint64 // 发出的帧载荷字节数
+	msgsIn       uint64 // 收到的 packet 数
+	msgsOut      uint64 // 发出的 packet 数
+	lastActivity int64  // 最近一次收发的时间，UnixNano
+
+	sid  uint64 // 服务端分配的会话序号，握手前即可用于定位会话
+	srv  *Server
+	conn frameConn
+	cl   *limiter.ConnLimiter
+
+	id          atomic.Value // string, Con 握手后的客户端标识
+	connectedAt time.Time
+
+	out       chan outFrame
+	done      chan struct{}
+	writeDone chan struct{}
+	closeOnce sync.Once
+
+	seq     uint32 // 原子操作，推送包 ID 序号
+	mu      sync.Mutex
+	pending map[string]chan uint8 // 等待 DeliverAck 的推送
+	subq    *pubsub.Queue         // 订阅推送队列，首次订阅时创建
+}
+
+func newSession(s *Server, c frameConn) *Session {
+	sess := &Session{
+		srv:       s,
+		conn:      c,
+		out:       make(chan outFrame, outQueueSize),
+		done:      make(chan struct{}),
+		writeDone: make(chan struct{}),
+		pending:   make(map[string]chan uint8),
+
+		connectedAt: time.Now(),
+	}
+	sess.id.Store("")
+	sess.lastActivity = sess.connectedAt.UnixNano()
+	// 连接级别的限流器
+	if s.Limiter != nil {
+		sess.cl = s.Limiter.NewConn()
+	}
+	return sess
+}
+
+// ID 返回 Con 握手后的客户端标识，握手前为空
+func (sess *Session) ID() string {
+	return sess.id.Load().(string)
+}
+
+// ConnectedAt 返回连接建立的时间
+func (sess *Session) ConnectedAt() time.Time {
+	return sess.connectedAt
+}
+
+// RemoteAddr 返回客户端地址
+func (sess *Session) RemoteAddr() string {
+	return sess.conn.RemoteAddr().String()
+}
+
+// SID 返回服务端分配的会话序号
+func (sess *Session) SID() uint64 {
+	return sess.sid
+}
+
+// SessionInfo 会话的统计信息，用于管理接口展示
+type SessionInfo struct {
+	SID          uint64    `json:"sid"`
+	ClientID     string    `json:"client_id"`
+	RemoteAddr   string    `json:"remote_addr"`
+	ConnectedAt  time.Time `json:"connected_at"`
+	LastActivity time.Time `json:"last_activity"`
+	BytesIn      uint64    `json:"bytes_in"`
+	BytesOut     uint64    `json:"bytes_out"`
+	MsgsIn       uint64    `json:"msgs_in"`
+	MsgsOut      uint64    `json:"msgs_out"`
+}
+
+// Info 返回会话当前的统计信息
+func (sess *Session) Info() SessionInfo {
+	return SessionInfo{
+		SID:          sess.sid,
+		ClientID:     sess.ID(),
+		RemoteAddr:   sess.RemoteAddr(),
+		ConnectedAt:  sess.connectedAt,
+		LastActivity: time.Unix(0, atomic.LoadInt64(&sess.lastActivity)),
+		BytesIn:      atomic.LoadUint64(&sess.bytesIn),
+		BytesOut:     atomic.LoadUint64(&sess.bytesOut),
+		MsgsIn:       atomic.LoadUint64(&sess.msgsIn),
+		MsgsOut:      atomic.LoadUint64(&sess.msgsOut),
+	}
+}
+
+// Deliver 向客户端推送 payload，并等待客户端的 DeliverAck
+func (sess *Session) Deliver(ctx context.Context, payload []byte) error {
+	id := fmt.Sprintf("%08x", atomic.AddUint32(&sess.seq, 1))
+	ackc := make(chan uint8, 1)
+
+	sess.mu.Lock()
+	sess.pending[id] = ackc
+	sess.mu.Unlock()
+	defer func() {
+		sess.mu.Lock()
+		delete(sess.pending, id)
+		sess.mu.Unlock()
+	}()
+
+	d := &packet.Deliver{ID: id, Payload: payload}
+	if err := sess.check(d); err != nil {
+		return err
+	}
+	if err := sess.send(ctx, d); err != nil {
+		return err
+	}
+	metrics.DeliverSendTotal.Inc()
+
+	select {
+	case result := <-ackc:
+		if result != packet.ResultOK {
+			return fmt.Errorf("%w: result %d", ErrDeliverFailed, result)
+		}
+		return nil
+	case <-sess.done:
+		return ErrSessionClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// check 检查 packet 能否在该会话的连接上编码，文本格式的连接不能发送含有分隔符的 payload
+func (sess *Session) check(p packet.Packet) error {
+	if sc, ok := sess.conn.(*streamConn); ok {
+		if c, ok := sc.codec.(*textpacket.Codec); ok {
+			return c.Check(p)
+		}
+	}
+	return nil
+}
+
+// Close 关闭会话
+func (sess *Session) Close() error {
+	sess.close()
+	return nil
+}
+
+// outFrame 发送队列中的一个 frame
+type outFrame struct {
+	payload frame.Payload
+	written func() // 写入连接之后在写协程中调用，可为 nil
+}
+
+// send 把 packet 放入发送队列，队列满时阻塞
+func (sess *Session) send(ctx context.Context, p packet.Packet) error {
+	return sess.sendThen(ctx, p, nil)
+}
+
+// sendThen 同 send，packet 写入连接之后调用 written，之后的 frame 才会写入
+func (sess *Session) sendThen(ctx context.Context, p packet.Packet, written func()) error {
+	framePayload, err := packet.Encode(p)
+	if err != nil {
+		return err
+	}
+	select {
+	case sess.out <- outFrame{payload: framePayload, written: written}:
+		return nil
+	case <-sess.done:
+		return ErrSessionClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close 通知写协程退出，等待其写完队列中剩余数据后关闭连接
+func (sess *Session) close() {
+	sess.closeOnce.Do(func() {
+		close(sess.done)
+		select {
+		case <-sess.writeDone:
+		case <-time.After(writeDrainTimeout):
+			// 写阻塞（如客户端不读取），直接关闭连接
+			sess.conn.Close()
+			<-sess.writeDone
+		}
+		sess.conn.Close()
+		sess.closeSubscriptions()
+		if sess.cl != nil {
+			sess.cl.Close()
+		}
+		sess.srv.Registry.unregister(sess)
+	})
+}
+
+// writeLoop 写协程，队列为空时才 flush，批量写回
+func (sess *Session) writeLoop() {
+	defer close(sess.writeDone)
+
+	write := func(f outFrame) bool {
+		framePayload := f.payload
+		if d := sess.srv.WriteTimeout; d > 0 {
+			sess.conn.SetWriteDeadline(time.Now().Add(d))
+		}
+		// write ack frame to the connection
+		// Frame 层编码
+		if err := sess.conn.WriteFrame(framePayload); err != nil {
+			logger.Infof("handleConn: frame encode error: %v", err)
+			return false
+		}
+		if f.written != nil {
+			f.written()
+		}
+		// prometheus 响应数据数 +1
+		metrics.RspSendTotal.Inc()
+		atomic.AddUint64(&sess.msgsOut, 1)
+		atomic.AddUint64(&sess.bytesOut, uint64(len(framePayload)))
+		atomic.StoreInt64(&sess.lastActivity, time.Now().UnixNano())
+		if len(sess.out) == 0 {
+			if err := sess.conn.Flush(); err != nil {
+				logger.Infof("handleConn: flush error: %v", err)
+				return false
+			}
+		}
+		return true
+	}
+
+	for {
+		select {
+		case f := <-sess.out:
+			if !write(f) {
+				// 写失败时关闭连接，让读协程退出
+				sess.conn.Close()
+				return
+			}
+		case <-sess.done:
+			// 写完队列中剩余的数据
+			for {
+				select {
+				case f := <-sess.out:
+					if !write(f) {
+						return
+					}
+				default:
+					sess.conn.Flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// handleConn 第一层，解析 Frame 层
+func (s *Server) handleConn(c frameConn) {
+
+	metrics.ClientConnected.Inc()       // conn 连接数 +1
+	defer metrics.ClientConnected.Dec() // conn 连接数 -1
+
+	sess := newSession(s, c)
+	s.trackSession(sess, true)
+	defer s.trackSession(sess, false)
+	go sess.writeLoop()
+	defer sess.close()
+
+	timeouts := s.HandshakeTimeout > 0 || s.IdleTimeout > 0
+	for {
+		// read from the connection
+		if timeouts {
+			c.SetReadDeadline(sess.readDeadline())
+		}
+
+		// decode the frame to get the payload
+		// is undecoded packet
+		framePayload, err := c.ReadFrame()
+		var lineErr *textpacket.LineError
+		if errors.As(err, &lineErr) {
+			// 文本设备发送的一行无法解析时只回复 FAILED，不断开连接
+			logger.Debugf("handleConn: %v, remote=%s", err, c.RemoteAddr())
+			if lineErr.Ack != nil {
+				if err = sess.send(context.Background(), lineErr.Ack); err != nil {
+					return
+				}
+			}
+			continue
+		}
+		if err != nil {
+			// 校验和不一致说明链路上有数据损坏，缺少协商的校验和或解密失败可能是篡改或重放，需要引起注意
+			if errors.Is(err, frame.ErrChecksumMismatch) || errors.Is(err, checksum.ErrUnexpected) || isEncryptionError(err) {
+				logger.Warnf("handleConn: frame decode error: %v, remote=%s", err, c.RemoteAddr())
+			} else {
+				logger.Debugf("handleConn: frame decode error: %v", err)
+			}
+			return
+		}
+		// prometheus 接收数据数 +1
+		metrics.ReqRecvTotal.Add(1)
+		atomic.AddUint64(&sess.msgsIn, 1)
+		atomic.AddUint64(&sess.bytesIn, uint64(len(framePayload)))
+		atomic.StoreInt64(&sess.lastActivity, time.Now().UnixNano())
+
+		// do something with the packet
+		// packet层的响应
+		ack, err := sess.handlePacket(framePayload)
+		if err != nil {
+			logger.Warnf("handleConn: packet handle error: %v", err)
+			return
+		}
+		if ack == nil {
+			continue
+		}
+		if err = sess.send(context.Background(), ack); err != nil {
+			return
+		}
+	}
+}
+
+// readDeadline 握手之前按 HandshakeTimeout，之后按 IdleTimeout 计算读超时
+func (sess *Session) readDeadline() time.Time {
+	s := sess.srv
+	if s.HandshakeTimeout > 0 && sess.ID() == "" {
+		return sess.connectedAt.Add(s.HandshakeTimeout)
+	}
+	if s.IdleTimeout > 0 {
+		return time.Now().Add(s.IdleTimeout)
+	}
+	return time.Time{}
+}
+
+// negotiateCompression 从客户端提出的算法中选出发送使用的压缩算法，并切换连接的编码器
+// 压缩的 frame 自带算法，客户端从发出 Con 起即可解压，ConAck 无需以未压缩的形式发送
+func (sess *Session) negotiateCompression(offered []compression.Algorithm) compression.Algorithm {
+	sc, ok := sess.conn.(*streamConn)
+	if !ok {
+		return compression.None
+	}
+	alg := compression.Negotiate(offered, sess.srv.Compression)
+	if !sc.setCompression(alg) {
+		alg = compression.None
+	}
+	metrics.CompressionNegotiatedTotal.WithLabelValues(alg.String()).Inc()
+	logger.Debugf("compression negotiated: id = %s, algorithm=%s", sess.ID(), alg)
+	return alg
+}
+
+// negotiateChecksum 从客户端提出的算法中选出校验和算法，并切换连接的编码器
+// 带校验和的 frame 自带算法，客户端从发出 Con 起即可校验
+func (sess *Session) negotiateChecksum(offered []checksum.Algorithm) checksum.Algorithm {
+	sc, ok := sess.conn.(*streamConn)
+	if !ok {
+		return checksum.None
+	}
+	alg := checksum.Negotiate(offered, sess.srv.Checksum)
+	if !sc.setChecksum(alg) {
+		alg = checksum.None
+	}
+	metrics.ChecksumNegotiatedTotal.WithLabelValues(alg.String()).Inc()
+	logger.Debugf("checksum negotiated: id = %s, algorithm=%s", sess.ID(), alg)
+	return alg
+}
+
+// openCredential 解码 Con 中的 OptionEncryption 并生成本次密钥交换，凭证已用静态公钥加密时解密后返回
+// 客户端未提出加密时返回 nil 的 Handshake、零值的 Offer 与原始凭证
+func (sess *Session) openCredential(p *packet.Con) (*encryption.Handshake, encryption.Offer, []byte, error) {
+	v, ok := packet.FindOption(p.Options, packet.OptionEncryption)
+	if !ok {
+		return nil, encryption.Offer{}, p.Payload, nil
+	}
+	offer, err := encryption.DecodeOffer(v)
+	if err != nil {
+		return nil, offer, nil, err
+	}
+	h, err := encryption.NewServerHandshake(sess.srv.EncryptionKey)
+	if err != nil {
+		return nil, offer, nil, err
+	}
+	if !offer.Sealed {
+		return h, offer, p.Payload, nil
+	}
+	credential, err := h.OpenCredential(v, p.ID, p.Payload)
+	if err != nil {
+		metrics.EncryptionFailuresTotal.WithLabelValues("credential").Inc()
+		return nil, offer, nil, err
+	}
+	return h, offer, credential, nil
+}
+
+// selectCipher 从客户端提出的算法中选出加密算法，WebSocket 连接以及不能携带 flags 的帧格式为 None
+func (sess *Session) selectCipher(offered []encryption.Cipher) encryption.Cipher {
+	sc, ok := sess.conn.(*streamConn)
+	if !ok || !sc.canEncrypt() {
+		return encryption.None
+	}
+	return encryption.Negotiate(offered, sess.srv.Encryption)
+}
+
+// negotiateEncryption 从客户端提出的算法中选出加密算法并完成密钥交换，返回 ConAck 中 OptionEncryption 的 value
+// 客户端收到 ConAck 之后才开始加密，接收方向立即设置密钥；ConAck 需要以明文发送，
+// 发送方向的密钥由返回的 start 在 ConAck 写出之后设置，未协商出时 start 为 nil
+func (sess *Session) negotiateEncryption(h *encryption.Handshake, v []byte) ([]byte, func(), error) {
+	offer, err := encryption.DecodeOffer(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	c := sess.selectCipher(offer.Ciphers)
+	metrics.EncryptionNegotiatedTotal.WithLabelValues(c.String()).Inc()
+	logger.Debugf("encryption negotiated: id = %s, cipher=%s", sess.ID(), c)
+	if c == encryption.None {
+		return encryption.Accept{}.Encode(), nil, nil
+	}
+
+	sc := sess.conn.(*streamConn)
+	accept, sendKey, recvKey, err := h.Accept(v, c)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = sc.enc.SetRecvKey(c, recvKey); err != nil {
+		return nil, nil, err
+	}
+	start := func() {
+		if err := sc.enc.SetSendKey(c, sendKey); err != nil {
+			logger.Errorf("negotiateEncryption: set send key error: %v", err)
+		}
+	}
+	return accept.Encode(), start, nil
+}
+
+// isEncryptionError 是否为解密失败
+func isEncryptionError(err error) bool {
+	return errors.Is(err, encryption.ErrDecrypt) || errors.Is(err, encryption.ErrReplay) || errors.Is(err, encryption.ErrPlaintext)
+}
+
+// dispatch 把带类型的 submit 交给 Server.Messages 中注册的 handler，返回 SubmitAck 的 result
+func (sess *Session) dispatch(p *packet.Submit) uint8 {
+	if sess.srv.Messages == nil {
+		logger.Debugf("dispatch: typed submit without registry: id = %s, kind=%s", p.ID, p.Kind)
+		return packet.ResultFailed
+	}
+	result, err := sess.srv.Messages.Dispatch(context.Background(), sess.ID(), message.ContentType(p.ContentType), p.Kind, p.Payload)
+	if err != nil {
+		logger.Infof("dispatch: id = %s, remote=%s: %v", p.ID, sess.RemoteAddr(), err)
+		return packet.ResultFailed
+	}
+	return result
+}
+
+// handlePacket 第二层，解析 packet 层，返回需要回复的 ack，无需回复时返回 nil
+func (sess *Session) handlePacket(framePayload []byte) (packet.Packet, error) {
+	// 解析后，获取 packet 实例 或是 submit conn deliverAck
+	p, err := packet.Decode(framePayload)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.(*packet.Con); !ok && sess.srv.Auth != nil && sess.ID() == "" {
+		return nil, ErrNotAuthenticated
+	}
+
+	switch p := p.(type) {
+	case *packet.Submit:
+		// 获取请求信息
+		logger.Debugf("recv submit: id = %s, payload=%s", p.ID, string(p.Payload))
+		// 根据请求信息，响应信息
+		submitAck := &packet.SubmitAck{
+			ID:     p.ID,
+			Result: throttle(sess.cl),
+		}
+		// 带 topic 的 submit 分发给订阅者
+		if p.Topic != "" && submitAck.Result == packet.ResultOK {
+			if err := sess.srv.publish(p.Topic, p.Payload); err != nil {
+				submitAck.Result = packet.ResultFailed
+			}
+		}
+		// 带类型的 submit 反序列化后交给注册的 handler
+		if p.Kind != "" && submitAck.Result == packet.ResultOK {
+			submitAck.Result = sess.dispatch(p)
+		}
+		p.Topic, p.Kind = "", ""
+		packet.SubmitPool.Put(p) // put back to submit pool
+		return submitAck, nil
+	case *packet.Subscribe:
+		logger.Debugf("recv subscribe: id = %s, topic=%s", p.ID, p.Topic)
+		subscribeAck := &packet.SubscribeAck{
+			ID:     p.ID,
+			Result: packet.ResultOK,
+		}
+		if err := sess.subscribe(p.Topic); err != nil {
+			subscribeAck.Result = packet.ResultFailed
+		}
+		return subscribeAck, nil
+	case *packet.Unsubscribe:
+		logger.Debugf("recv unsubscribe: id = %s, topic=%s", p.ID, p.Topic)
+		sess.unsubscribe(p.Topic)
+		return &packet.UnsubscribeAck{
+			ID:     p.ID,
+			Result: packet.ResultOK,
+		}, nil
+	case *packet.Con:
+		// 获取请求信息
+		logger.Debugf("recv conn: id = %s", p.ID)
+		h, offer, credential, err := sess.openCredential(p)
+		if err != nil && !errors.Is(err, encryption.ErrDecrypt) {
+			return nil, err
+		}
+		// 凭证已加密时必须协商出加密，否则截获的 Con 可以在 WebSocket 或不能加密的帧格式上以明文重放
+		if (offer.Sealed || sess.srv.RequireEncryption) && sess.selectCipher(offer.Ciphers) == encryption.None {
+			metrics.EncryptionFailuresTotal.WithLabelValues("required").Inc()
+			logger.Infof("encryption required: id = %s, remote=%s", p.ID, sess.RemoteAddr())
+			sess.send(context.Background(), &packet.ConAck{
+				ID:     p.ID,
+				Result: packet.ResultRefused,
+			})
+			return nil, ErrEncryptionRequired
+		}
+		// 凭证无法解密按鉴权失败处理
+		if err != nil || sess.srv.Auth != nil && !sess.srv.Auth.Authenticate(p.ID, credential) {
+			metrics.AuthFailedTotal.Inc()
+			logger.Infof("auth failed: id = %s, remote=%s", p.ID, sess.RemoteAddr())
+			sess.send(context.Background(), &packet.ConAck{
+				ID:     p.ID,
+				Result: packet.ResultRefused,
+			})
+			return nil, ErrAuthFailed
+		}
+		// 按客户端标识限流
+		if sess.cl != nil {
+			sess.cl.Bind(p.ID)
+		}
+		sess.srv.Registry.unregister(sess)
+		sess.id.Store(p.ID)
+		if err := sess.srv.Registry.check(sess); err != nil {
+			// 回复拒绝包，handleConn 退出时会先写完再关闭连接
+			sess.send(context.Background(), &packet.ConAck{
+				ID:     p.ID,
+				Result: packet.ResultDuplicate,
+			})
+			return nil, err
+		}
+		conAck := &packet.ConAck{
+			ID:     p.ID,
+			Result: packet.ResultOK,
+		}
+		if v, ok := packet.FindOption(p.Options, packet.OptionCompression); ok {
+			alg := sess.negotiateCompression(compression.Decode(v))
+			conAck.Options = append(conAck.Options, packet.Option{
+				Type:  packet.OptionCompression,
+				Value: compression.Encode([]compression.Algorithm{alg}),
+			})
+		}
+		if v, ok := packet.FindOption(p.Options, packet.OptionChecksum); ok {
+			alg := sess.negotiateChecksum(checksum.Decode(v))
+			conAck.Options = append(conAck.Options, packet.Option{
+				Type:  packet.OptionChecksum,
+				Value: checksum.Encode([]checksum.Algorithm{alg}),
+			})
+		}
+		// ConAck 以明文发送，协商出加密时写出之后才开始加密
+		var start func()
+		if v, ok := packet.FindOption(p.Options, packet.OptionEncryption); ok {
+			var value []byte
+			if value, start, err = sess.negotiateEncryption(h, v); err != nil {
+				return nil, err
+			}
+			conAck.Options = append(conAck.Options, packet.Option{
+				Type:  packet.OptionEncryption,
+				Value: value,
+			})
+		}
+		// ConAck 先放入发送队列再登记，登记之后 Deliver、Broadcast、订阅推送才能找到该会话，
+		// 保证推送总是在 ConAck 之后写出
+		if err = sess.sendThen(context.Background(), conAck, start); err != nil {
+			return nil, err
+		}
+		kicked, err := sess.srv.Registry.register(sess)
+		if err != nil {
+			// 预先检查之后标识被并发登记的会话占用，已回复的 ConAck 无法撤回，断开连接
+			logger.Infof("duplicate login after conn ack: id = %s, remote=%s", p.ID, sess.RemoteAddr())
+			return nil, err
+		}
+		if kicked != nil {
+			logger.Infof("kick session: id = %s, remote=%s", kicked.ID(), kicked.RemoteAddr())
+			go kicked.Close()
+		}
+		return nil, nil
+	case *packet.DeliverAck:
+		sess.mu.Lock()
+		ackc, ok := sess.pending[p.ID]
+		sess.mu.Unlock()
+		if ok {
+			select {
+			case ackc <- p.Result:
+			default: // 重复的 ack
+			}
+		}
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown packet type %T", p)
+	}
+}
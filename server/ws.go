@@ -0,0 +1,97 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/CoderI421/tcp-service/frame"
+	"github.com/CoderI421/tcp-service/logger"
+)
+
+/*
+WebSocket 网关
+
+供无法建立原始 tcp 连接的浏览器、移动端使用
+每条 WebSocket binary 消息即一个 frame payload（不含 4 字节长度头），
+之后与 tcp 连接走同一套 packet 处理流程
+*/
+
+// wsCloseTimeout 发送 WebSocket close 消息的超时
+const wsCloseTimeout = time.Second
+
+// ErrNotBinaryMessage 收到了非 binary 类型的 WebSocket 消息
+var ErrNotBinaryMessage = errors.New("server: websocket message is not binary")
+
+// ServeWebSocket 把 http 请求升级为 WebSocket 并按 frame/packet 协议处理
+// 可直接注册到 http.ServeMux 上
+func (s *Server) ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	// drain 或关闭后不再接受新连接
+	if s.shuttingDown() {
+		http.Error(w, ErrServerClosed.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	upgrader := websocket.Upgrader{
+		CheckOrigin: s.WSCheckOrigin,
+	}
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade 已经回复了 http 错误
+		logger.Infof("websocket upgrade error: %v", err)
+		return
+	}
+	// 与字节流连接共用最大长度，gorilla 默认不限制消息长度
+	ws.SetReadLimit(int64(s.maxFrameLength()))
+	c := &wsConn{Conn: ws}
+
+	// 准入控制，拒绝的连接同样发送拒绝包后关闭
+	release, ok := s.admit(ws.RemoteAddr())
+	if !ok {
+		refuseConn(c)
+		return
+	}
+	defer release()
+
+	if !s.trackConn(ws.UnderlyingConn(), true) {
+		c.Close()
+		return
+	}
+	defer s.wg.Done()
+	defer s.trackConn(ws.UnderlyingConn(), false)
+
+	s.handleConn(c)
+}
+
+// wsConn WebSocket 连接上的 frameConn
+type wsConn struct {
+	*websocket.Conn
+}
+
+func (c *wsConn) ReadFrame() (frame.Payload, error) {
+	mt, p, err := c.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	if mt != websocket.BinaryMessage {
+		return nil, ErrNotBinaryMessage
+	}
+	return p, nil
+}
+
+func (c *wsConn) WriteFrame(p frame.Payload) error {
+	return c.WriteMessage(websocket.BinaryMessage, p)
+}
+
+// Flush WebSocket 每条消息都是直接写出的，无需 flush
+func (c *wsConn) Flush() error {
+	return nil
+}
+
+func (c *wsConn) Close() error {
+	c.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(wsCloseTimeout))
+	return c.Conn.Close()
+}
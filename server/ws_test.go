@@ -0,0 +1,122 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/CoderI421/tcp-service/connlimit"
+	"github.com/CoderI421/tcp-service/packet"
+)
+
+func dialWS(t *testing.T, s *Server) *websocket.Conn {
+	t.Helper()
+	hs := httptest.NewServer(http.HandlerFunc(s.ServeWebSocket))
+	t.Cleanup(hs.Close)
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(hs.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("websocket dial error: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+func wsRoundTrip(t *testing.T, ws *websocket.Conn, p packet.Packet) packet.Packet {
+	t.Helper()
+	framePayload, err := packet.Encode(p)
+	if err != nil {
+		t.Fatalf("packet encode error: %v", err)
+	}
+	if err = ws.WriteMessage(websocket.BinaryMessage, framePayload); err != nil {
+		t.Fatalf("websocket write error: %v", err)
+	}
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	mt, ackFramePayload, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("websocket read error: %v", err)
+	}
+	if mt != websocket.BinaryMessage {
+		t.Fatalf("message type = %d, want binary", mt)
+	}
+	ack, err := packet.Decode(ackFramePayload)
+	if err != nil {
+		t.Fatalf("packet decode error: %v", err)
+	}
+	return ack
+}
+
+func TestServer_ServeWebSocket(t *testing.T) {
+	s := &Server{}
+	defer s.Close()
+	ws := dialWS(t, s)
+
+	ack := wsRoundTrip(t, ws, &packet.Con{ID: "00000001"})
+	if got, ok := ack.(*packet.ConAck); !ok || got.Result != packet.ResultOK {
+		t.Fatalf("ack = %#v, want ConAck{ResultOK}", ack)
+	}
+	ack = wsRoundTrip(t, ws, &packet.Submit{ID: "00000002", Payload: []byte("hello")})
+	if got, ok := ack.(*packet.SubmitAck); !ok || got.ID != "00000002" || got.Result != packet.ResultOK {
+		t.Errorf("ack = %#v, want SubmitAck{00000002, ResultOK}", ack)
+	}
+}
+
+func TestServer_ServeWebSocketRefuse(t *testing.T) {
+	gate, _ := connlimit.New(connlimit.Config{Deny: []string{"127.0.0.1"}})
+	s := &Server{Gate: gate}
+	ws := dialWS(t, s)
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, ackFramePayload, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("websocket read error: %v", err)
+	}
+	ack, err := packet.Decode(ackFramePayload)
+	if err != nil {
+		t.Fatalf("packet decode error: %v", err)
+	}
+	if got, ok := ack.(*packet.ConAck); !ok || got.Result != packet.ResultRefused {
+		t.Errorf("ack = %#v, want ConAck{ResultRefused}", ack)
+	}
+}
+
+func TestServer_ServeWebSocketTextMessage(t *testing.T) {
+	s := &Server{}
+	defer s.Close()
+	ws := dialWS(t, s)
+
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatalf("websocket write error: %v", err)
+	}
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := ws.ReadMessage(); err == nil {
+		t.Error("ReadMessage() error = nil, want connection closed")
+	}
+}
+
+func TestServer_ServeWebSocketReadLimit(t *testing.T) {
+	s := &Server{MaxFrameLength: 64}
+	defer s.Close()
+	ws := dialWS(t, s)
+
+	ack := wsRoundTrip(t, ws, &packet.Con{ID: "00000001"})
+	if got, ok := ack.(*packet.ConAck); !ok || got.Result != packet.ResultOK {
+		t.Fatalf("ack = %#v, want ConAck{ResultOK}", ack)
+	}
+	framePayload, err := packet.Encode(&packet.Submit{ID: "00000002", Payload: make([]byte, 128)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ws.WriteMessage(websocket.BinaryMessage, framePayload); err != nil {
+		t.Fatalf("websocket write error: %v", err)
+	}
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err = ws.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("ReadMessage() error = %v, want close %d", err, websocket.CloseMessageTooBig)
+	}
+}
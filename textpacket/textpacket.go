@@ -0,0 +1,322 @@
+package textpacket
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/CoderI421/tcp-service/frame"
+	"github.com/CoderI421/tcp-service/message"
+	"github.com/CoderI421/tcp-service/packet"
+)
+
+/*
+文本 packet，供只能收发文本行的设备使用
+
+每个 frame 为一行文本，字段以一个空格分隔，第一个字段为命令（不区分大小写）：
+
+	CONN <id> [credential]
+	CONNACK <id> <result>
+	SUBMIT <id> [payload]
+	SUBMITACK <id> <result>
+	DELIVER <id> [payload]
+	DELIVERACK <id> <result>
+	SUBSCRIBE <id> <topic>
+	SUBSCRIBEACK <id> <result>
+	UNSUBSCRIBE <id> <topic>
+	UNSUBSCRIBEACK <id> <result>
+	TOPICSUBMIT <id> <topic> [payload]
+	TOPICDELIVER <id> <topic> [payload]
+	TYPEDSUBMIT <id> <content-type> <kind> [payload]
+
+id 为 8 个字符；result 为 OK、FAILED、THROTTLED、REFUSED、DUPLICATE 或十进制数字
+payload 与 credential 为之前的字段之后的全部内容，可以含有空格
+content-type 为 message 包中的名称，如 json，文本设备一般只使用 json
+Con/ConAck 的扩展选项不能用文本表示，编码时忽略
+
+Codec 在文本与二进制 packet 之间转换，服务端的 packet 处理流程不需要区分文本设备
+无法解析的一行以 LineError 返回，不影响之后的行；请求命令的 id 有效时 LineError.Ack 为 result 为 FAILED 的 ack
+*/
+
+var (
+	// ErrUnknownCommand 未知的命令
+	ErrUnknownCommand = errors.New("textpacket: unknown command")
+	// ErrSyntax 字段缺失或格式错误
+	ErrSyntax = errors.New("textpacket: syntax error")
+)
+
+// LineError 无法解析的一行，之后的行仍可继续读取
+type LineError struct {
+	Line []byte
+	Ack  packet.Packet // 需要回复给对端的 FAILED ack，不是请求命令或 id 无效时为 nil
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("%v: %q", e.Err, e.Line)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// failedAck 按请求命令返回 result 为 FAILED 的 ack，不是请求命令或 id 无效时返回 nil
+func failedAck(b []byte) packet.Packet {
+	cmd, rest := cut(b)
+	id, _ := cut(rest)
+	if len(id) != idLen {
+		return nil
+	}
+	switch strings.ToUpper(string(cmd)) {
+	case "CONN":
+		return &packet.ConAck{ID: string(id), Result: packet.ResultFailed}
+	case "SUBMIT", "TOPICSUBMIT", "TYPEDSUBMIT":
+		return &packet.SubmitAck{ID: string(id), Result: packet.ResultFailed}
+	case "SUBSCRIBE":
+		return &packet.SubscribeAck{ID: string(id), Result: packet.ResultFailed}
+	case "UNSUBSCRIBE":
+		return &packet.UnsubscribeAck{ID: string(id), Result: packet.ResultFailed}
+	}
+	return nil
+}
+
+// idLen packet 中 ID 的长度
+const idLen = 8
+
+var resultNames = []string{
+	packet.ResultOK:        "OK",
+	packet.ResultFailed:    "FAILED",
+	packet.ResultThrottled: "THROTTLED",
+	packet.ResultRefused:   "REFUSED",
+	packet.ResultDuplicate: "DUPLICATE",
+}
+
+func formatResult(r uint8) string {
+	if int(r) < len(resultNames) {
+		return resultNames[r]
+	}
+	return strconv.Itoa(int(r))
+}
+
+func parseResult(s string) (uint8, error) {
+	for r, name := range resultNames {
+		if strings.EqualFold(s, name) {
+			return uint8(r), nil
+		}
+	}
+	r, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid result [%s]", ErrSyntax, s)
+	}
+	return uint8(r), nil
+}
+
+// Marshal 把 packet 编码为一行文本（不含行尾）
+func Marshal(p packet.Packet) ([]byte, error) {
+	switch t := p.(type) {
+	case *packet.Con:
+		return line("CONN", t.ID, "", t.Payload), nil
+	case *packet.ConAck:
+		return ack("CONNACK", t.ID, t.Result), nil
+	case *packet.Submit:
+		if t.Kind != "" {
+			return line("TYPEDSUBMIT", t.ID, message.ContentType(t.ContentType).String()+" "+t.Kind, t.Payload), nil
+		}
+		if t.Topic != "" {
+			return line("TOPICSUBMIT", t.ID, t.Topic, t.Payload), nil
+		}
+		return line("SUBMIT", t.ID, "", t.Payload), nil
+	case *packet.SubmitAck:
+		return ack("SUBMITACK", t.ID, t.Result), nil
+	case *packet.Deliver:
+		if t.Topic != "" {
+			return line("TOPICDELIVER", t.ID, t.Topic, t.Payload), nil
+		}
+		return line("DELIVER", t.ID, "", t.Payload), nil
+	case *packet.DeliverAck:
+		return ack("DELIVERACK", t.ID, t.Result), nil
+	case *packet.Subscribe:
+		return line("SUBSCRIBE", t.ID, t.Topic, nil), nil
+	case *packet.SubscribeAck:
+		return ack("SUBSCRIBEACK", t.ID, t.Result), nil
+	case *packet.Unsubscribe:
+		return line("UNSUBSCRIBE", t.ID, t.Topic, nil), nil
+	case *packet.UnsubscribeAck:
+		return ack("UNSUBSCRIBEACK", t.ID, t.Result), nil
+	default:
+		return nil, fmt.Errorf("%w [%T]", ErrUnknownCommand, p)
+	}
+}
+
+// line 按 "cmd id [topic] [payload]" 拼接一行
+func line(cmd, id, topic string, payload []byte) []byte {
+	b := make([]byte, 0, len(cmd)+len(id)+len(topic)+len(payload)+3)
+	b = append(append(append(b, cmd...), ' '), id...)
+	if topic != "" {
+		b = append(append(b, ' '), topic...)
+	}
+	if len(payload) > 0 {
+		b = append(append(b, ' '), payload...)
+	}
+	return b
+}
+
+func ack(cmd, id string, result uint8) []byte {
+	return []byte(cmd + " " + id + " " + formatResult(result))
+}
+
+// Unmarshal 解析一行文本（不含行尾）
+func Unmarshal(b []byte) (packet.Packet, error) {
+	cmd, rest := cut(b)
+	id, rest := cut(rest)
+	if len(id) != idLen {
+		return nil, fmt.Errorf("%w: invalid id [%s]", ErrSyntax, id)
+	}
+	name := strings.ToUpper(string(cmd))
+	switch name {
+	case "CONN":
+		return &packet.Con{ID: string(id), Payload: rest}, nil
+	case "SUBMIT":
+		return &packet.Submit{ID: string(id), Payload: rest}, nil
+	case "DELIVER":
+		return &packet.Deliver{ID: string(id), Payload: rest}, nil
+	case "TOPICSUBMIT":
+		topic, payload, err := topicPayload(rest)
+		if err != nil {
+			return nil, err
+		}
+		return &packet.Submit{ID: string(id), Topic: topic, Payload: payload}, nil
+	case "TYPEDSUBMIT":
+		ct, rest := cut(rest)
+		c, err := message.Parse(string(ct))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrSyntax, err)
+		}
+		kind, payload, err := topicPayload(rest)
+		if err != nil {
+			return nil, err
+		}
+		return &packet.Submit{ID: string(id), Kind: kind, ContentType: uint8(c), Payload: payload}, nil
+	case "TOPICDELIVER":
+		topic, payload, err := topicPayload(rest)
+		if err != nil {
+			return nil, err
+		}
+		return &packet.Deliver{ID: string(id), Topic: topic, Payload: payload}, nil
+	case "SUBSCRIBE":
+		topic, _, err := topicPayload(rest)
+		if err != nil {
+			return nil, err
+		}
+		return &packet.Subscribe{ID: string(id), Topic: topic}, nil
+	case "UNSUBSCRIBE":
+		topic, _, err := topicPayload(rest)
+		if err != nil {
+			return nil, err
+		}
+		return &packet.Unsubscribe{ID: string(id), Topic: topic}, nil
+	}
+
+	if !strings.HasSuffix(name, "ACK") {
+		return nil, fmt.Errorf("%w [%s]", ErrUnknownCommand, cmd)
+	}
+	result, err := parseResult(string(rest))
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case "CONNACK":
+		return &packet.ConAck{ID: string(id), Result: result}, nil
+	case "SUBMITACK":
+		return &packet.SubmitAck{ID: string(id), Result: result}, nil
+	case "DELIVERACK":
+		return &packet.DeliverAck{ID: string(id), Result: result}, nil
+	case "SUBSCRIBEACK":
+		return &packet.SubscribeAck{ID: string(id), Result: result}, nil
+	case "UNSUBSCRIBEACK":
+		return &packet.UnsubscribeAck{ID: string(id), Result: result}, nil
+	default:
+		return nil, fmt.Errorf("%w [%s]", ErrUnknownCommand, cmd)
+	}
+}
+
+// topicPayload 解析 "topic [payload]"
+func topicPayload(b []byte) (string, []byte, error) {
+	topic, payload := cut(b)
+	if len(topic) == 0 {
+		return "", nil, fmt.Errorf("%w: missing topic", ErrSyntax)
+	}
+	return string(topic), payload, nil
+}
+
+// cut 按第一个空格切分，没有空格时 rest 为空
+func cut(b []byte) (field, rest []byte) {
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		return b[:i], b[i+1:]
+	}
+	return b, nil
+}
+
+// Codec 在文本 packet 与二进制 packet 之间转换的编解码器
+// 编码时把二进制 packet 转为文本后交给 Frame，解码时把 Frame 读出的文本转为二进制 packet
+type Codec struct {
+	// Frame 拆分文本行的编解码器，如 frame.LineCodec
+	Frame frame.StreamFrameCodec
+}
+
+// NewCodec 创建文本 packet 编解码器
+func NewCodec(f frame.StreamFrameCodec) *Codec {
+	return &Codec{Frame: f}
+}
+
+// NewFormatCodec 同 frame.NewFormatCodec，文本格式（见 frame.Format.Text）外面包上 Codec
+func NewFormatCodec(f frame.Format) frame.StreamFrameCodec {
+	if f.Text() {
+		return NewCodec(frame.NewFormatCodec(f))
+	}
+	return frame.NewFormatCodec(f)
+}
+
+// Encode 把二进制 packet 编码为一行文本
+func (c *Codec) Encode(w io.Writer, framePayload frame.Payload) error {
+	p, err := packet.Decode(framePayload)
+	if err != nil {
+		return err
+	}
+	b, err := Marshal(p)
+	if err != nil {
+		return err
+	}
+	return c.Frame.Encode(w, b)
+}
+
+// Check 检查 packet 能否编码为一行，如 LineCodec 不能发送含有 CR、LF 的 payload
+// 编码失败发生在写协程中会断开连接，需要在放入发送队列前检查
+func (c *Codec) Check(p packet.Packet) error {
+	b, err := Marshal(p)
+	if err != nil {
+		return err
+	}
+	return c.Frame.Encode(io.Discard, b)
+}
+
+// Decode 读取一行文本并转为二进制 packet，跳过空行，无法解析的行返回 *LineError
+func (c *Codec) Decode(r io.Reader) (frame.Payload, error) {
+	for {
+		b, err := c.Frame.Decode(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(b)) == 0 {
+			continue
+		}
+		p, err := Unmarshal(b)
+		if err != nil {
+			return nil, &LineError{Line: b, Ack: failedAck(b), Err: err}
+		}
+		return packet.Encode(p)
+	}
+}
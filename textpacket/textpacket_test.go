@@ -0,0 +1,176 @@
+package textpacket
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/CoderI421/tcp-service/frame"
+	"github.com/CoderI421/tcp-service/packet"
+)
+
+func TestMarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		p    packet.Packet
+		want string
+	}{
+		{name: "Con", p: &packet.Con{ID: "00000001", Payload: []byte("secret")}, want: "CONN 00000001 secret"},
+		{name: "ConNoCredential", p: &packet.Con{ID: "00000001"}, want: "CONN 00000001"},
+		{name: "ConAck", p: &packet.ConAck{ID: "00000001", Result: packet.ResultRefused}, want: "CONNACK 00000001 REFUSED"},
+		{name: "Submit", p: &packet.Submit{ID: "00000002", Payload: []byte("hello world")}, want: "SUBMIT 00000002 hello world"},
+		{name: "SubmitAck", p: &packet.SubmitAck{ID: "00000002", Result: packet.ResultThrottled}, want: "SUBMITACK 00000002 THROTTLED"},
+		{name: "TopicSubmit", p: &packet.Submit{ID: "00000003", Topic: "a/b", Payload: []byte("21")}, want: "TOPICSUBMIT 00000003 a/b 21"},
+		{name: "TypedSubmit", p: &packet.Submit{ID: "00000003", Kind: "order", ContentType: 1, Payload: []byte(`{"id": 1}`)}, want: `TYPEDSUBMIT 00000003 json order {"id": 1}`},
+		{name: "Deliver", p: &packet.Deliver{ID: "00000004", Payload: []byte("push")}, want: "DELIVER 00000004 push"},
+		{name: "TopicDeliver", p: &packet.Deliver{ID: "00000005", Topic: "a/b", Payload: []byte("22")}, want: "TOPICDELIVER 00000005 a/b 22"},
+		{name: "DeliverAck", p: &packet.DeliverAck{ID: "00000004", Result: 9}, want: "DELIVERACK 00000004 9"},
+		{name: "Subscribe", p: &packet.Subscribe{ID: "00000006", Topic: "a/+"}, want: "SUBSCRIBE 00000006 a/+"},
+		{name: "SubscribeAck", p: &packet.SubscribeAck{ID: "00000006", Result: packet.ResultOK}, want: "SUBSCRIBEACK 00000006 OK"},
+		{name: "Unsubscribe", p: &packet.Unsubscribe{ID: "00000007", Topic: "a/+"}, want: "UNSUBSCRIBE 00000007 a/+"},
+		{name: "UnsubscribeAck", p: &packet.UnsubscribeAck{ID: "00000007", Result: packet.ResultFailed}, want: "UNSUBSCRIBEACK 00000007 FAILED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Marshal(tt.p)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Marshal() = %q, want %q", got, tt.want)
+			}
+			p, err := Unmarshal(got)
+			if err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if !reflect.DeepEqual(p, tt.p) {
+				t.Errorf("Unmarshal() = %#v, want %#v", p, tt.p)
+			}
+		})
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    packet.Packet
+		wantErr error
+	}{
+		{name: "LowerCase", line: "submit 00000001 hi", want: &packet.Submit{ID: "00000001", Payload: []byte("hi")}},
+		{name: "ResultLowerCase", line: "SubmitAck 00000001 throttled", want: &packet.SubmitAck{ID: "00000001", Result: packet.ResultThrottled}},
+		{name: "UnknownCommand", line: "PING 00000001", wantErr: ErrUnknownCommand},
+		{name: "ShortID", line: "SUBMIT 0001 hi", wantErr: ErrSyntax},
+		{name: "MissingID", line: "SUBMIT", wantErr: ErrSyntax},
+		{name: "MissingTopic", line: "SUBSCRIBE 00000001", wantErr: ErrSyntax},
+		{name: "UnknownContentType", line: "TYPEDSUBMIT 00000001 xml order <a/>", wantErr: ErrSyntax},
+		{name: "MissingKind", line: "TYPEDSUBMIT 00000001 json", wantErr: ErrSyntax},
+		{name: "MissingResult", line: "CONNACK 00000001", wantErr: ErrSyntax},
+		{name: "InvalidResult", line: "CONNACK 00000001 256", wantErr: ErrSyntax},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Unmarshal([]byte(tt.line))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Unmarshal() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Unmarshal() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCodec(t *testing.T) {
+	c := NewFormatCodec(frame.FormatLine)
+	framePayload, err := packet.Encode(&packet.Submit{ID: "00000001", Payload: []byte("hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = c.Encode(&buf, framePayload); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if buf.String() != "SUBMIT 00000001 hello\r\n" {
+		t.Errorf("Encode() = %q, want %q", buf.String(), "SUBMIT 00000001 hello\r\n")
+	}
+
+	// 空行被跳过
+	buf.WriteString("\r\n  \r\nsubmitack 00000001 ok\n")
+	for _, want := range []packet.Packet{
+		&packet.Submit{ID: "00000001", Payload: []byte("hello")},
+		&packet.SubmitAck{ID: "00000001", Result: packet.ResultOK},
+	} {
+		got, err := c.Decode(&buf)
+		if err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		wantPayload, _ := packet.Encode(want)
+		if !bytes.Equal(got, wantPayload) {
+			t.Errorf("Decode() = %x, want %x", got, wantPayload)
+		}
+	}
+
+	if _, ok := NewFormatCodec(frame.FormatHeader).(*Codec); ok {
+		t.Error("NewFormatCodec(FormatHeader) is text codec")
+	}
+}
+
+func TestCodec_LineError(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		ack  packet.Packet
+	}{
+		{name: "Submit", line: "TYPEDSUBMIT 00000001 xml order <a/>", ack: &packet.SubmitAck{ID: "00000001", Result: packet.ResultFailed}},
+		{name: "Subscribe", line: "subscribe 00000001", ack: &packet.SubscribeAck{ID: "00000001", Result: packet.ResultFailed}},
+		{name: "Unsubscribe", line: "UNSUBSCRIBE 00000001", ack: &packet.UnsubscribeAck{ID: "00000001", Result: packet.ResultFailed}},
+		{name: "ShortID", line: "SUBMIT 0001 hi"},
+		{name: "UnknownCommand", line: "PING 00000001"},
+		{name: "Ack", line: "DELIVERACK 00000001 bad"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewFormatCodec(frame.FormatLine)
+			buf := bytes.NewBufferString(tt.line + "\r\nSUBMIT 00000002 hi\r\n")
+			_, err := c.Decode(buf)
+			var lineErr *LineError
+			if !errors.As(err, &lineErr) {
+				t.Fatalf("Decode() error = %v, want *LineError", err)
+			}
+			if string(lineErr.Line) != tt.line || !reflect.DeepEqual(lineErr.Ack, tt.ack) {
+				t.Errorf("Decode() error = %+v, want line %q ack %+v", lineErr, tt.line, tt.ack)
+			}
+
+			// 之后的行不受影响
+			got, err := c.Decode(buf)
+			want, _ := packet.Encode(&packet.Submit{ID: "00000002", Payload: []byte("hi")})
+			if err != nil || !bytes.Equal(got, want) {
+				t.Errorf("Decode() = %x, %v, want %x", got, err, want)
+			}
+		})
+	}
+}
+
+func TestCodec_Check(t *testing.T) {
+	tests := []struct {
+		name    string
+		codec   *Codec
+		p       packet.Packet
+		wantErr error
+	}{
+		{name: "Line", codec: NewCodec(frame.NewLineCodec()), p: &packet.Deliver{ID: "00000001", Payload: []byte("a b")}},
+		{name: "LineBreak", codec: NewCodec(frame.NewLineCodec()), p: &packet.Deliver{ID: "00000001", Payload: []byte("a\nb")}, wantErr: frame.ErrDelimiterInPayload},
+		{name: "CarriageReturn", codec: NewCodec(frame.NewLineCodec()), p: &packet.Deliver{ID: "00000001", Topic: "t", Payload: []byte("a\rb")}, wantErr: frame.ErrDelimiterInPayload},
+		{name: "Escaped", codec: NewCodec(frame.NewDelimiterCodec(';', '\\')), p: &packet.Deliver{ID: "00000001", Payload: []byte("a;b")}},
+		{name: "TooLarge", codec: NewCodec(&frame.LineCodec{MaxLength: 8}), p: &packet.Deliver{ID: "00000001", Payload: []byte("hello")}, wantErr: frame.ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.codec.Check(tt.p); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Check() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
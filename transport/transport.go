@@ -0,0 +1,212 @@
+package transport
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+/*
+传输层抽象
+
+地址格式 scheme://address，不带 scheme 时按 tcp 处理
+	tcp://:8888
+	tls://:8443  tcp 之上的 TLS，需要提供 tls.Config
+	unix:///var/run/tcp-service.sock
+	pipe://name  进程内基于 net.Pipe 的传输，主要用于测试
+*/
+
+const (
+	SchemeTCP  = "tcp"
+	SchemeTLS  = "tls"
+	SchemeUnix = "unix"
+	SchemePipe = "pipe"
+)
+
+var (
+	// ErrUnknownScheme 不支持的地址类型
+	ErrUnknownScheme = errors.New("transport: unknown scheme")
+	// ErrNoTLSConfig tls 地址缺少 tls.Config
+	ErrNoTLSConfig = errors.New("transport: tls listener requires a tls.Config")
+)
+
+// ParseAddr 拆分地址为 scheme 与 address
+func ParseAddr(addr string) (scheme, address string, err error) {
+	i := strings.Index(addr, "://")
+	if i < 0 {
+		return SchemeTCP, addr, nil
+	}
+	scheme, address = addr[:i], addr[i+3:]
+	switch scheme {
+	case SchemeTCP, SchemeTLS, SchemeUnix, SchemePipe:
+		return scheme, address, nil
+	default:
+		return "", "", fmt.Errorf("%w [%s]", ErrUnknownScheme, scheme)
+	}
+}
+
+// Listen 按地址创建 listener，tls 地址需使用 ListenTLS
+func Listen(addr string) (net.Listener, error) {
+	return ListenTLS(addr, nil)
+}
+
+// ListenTLS 按地址创建 listener，tls 地址使用 config 包装，其他地址忽略 config
+func ListenTLS(addr string, config *tls.Config) (net.Listener, error) {
+	scheme, address, err := ParseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	switch scheme {
+	case SchemeTLS:
+		if config == nil {
+			return nil, ErrNoTLSConfig
+		}
+		l, err := net.Listen(SchemeTCP, address)
+		if err != nil {
+			return nil, err
+		}
+		return tls.NewListener(l, config), nil
+	case SchemeUnix:
+		removeStaleSocket(address)
+		return net.Listen(SchemeUnix, address)
+	case SchemePipe:
+		return listenPipe(address)
+	default:
+		return net.Listen(SchemeTCP, address)
+	}
+}
+
+// Dial 按地址建立连接，tls 地址使用默认的 tls.Config
+func Dial(addr string) (net.Conn, error) {
+	return DialTLS(addr, nil)
+}
+
+// DialTLS 按地址建立连接，tls 地址使用 config，其他地址忽略 config
+func DialTLS(addr string, config *tls.Config) (net.Conn, error) {
+	scheme, address, err := ParseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	switch scheme {
+	case SchemeTLS:
+		return tls.Dial(SchemeTCP, address, config)
+	case SchemeUnix:
+		return net.Dial(SchemeUnix, address)
+	case SchemePipe:
+		return dialPipe(address)
+	default:
+		return net.Dial(SchemeTCP, address)
+	}
+}
+
+// removeStaleSocket 删除上次进程遗留、已无人监听的 unix socket 文件
+func removeStaleSocket(path string) {
+	fi, err := os.Stat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	if c, err := net.Dial(SchemeUnix, path); err == nil {
+		c.Close()
+		return
+	}
+	os.Remove(path)
+}
+
+var (
+	pipesMu sync.Mutex
+	pipes   = make(map[string]*PipeListener)
+)
+
+func listenPipe(name string) (net.Listener, error) {
+	pipesMu.Lock()
+	defer pipesMu.Unlock()
+
+	if _, ok := pipes[name]; ok {
+		return nil, fmt.Errorf("transport: pipe [%s] already in use", name)
+	}
+	l := NewPipeListener()
+	l.name = name
+	pipes[name] = l
+	return l, nil
+}
+
+func dialPipe(name string) (net.Conn, error) {
+	pipesMu.Lock()
+	l, ok := pipes[name]
+	pipesMu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("transport: pipe [%s] not found", name)
+	}
+	return l.Dial()
+}
+
+// PipeListener 进程内的 listener，Dial 时通过 net.Pipe 创建一对连接
+type PipeListener struct {
+	name   string
+	conns  chan net.Conn
+	done   chan struct{}
+	closed sync.Once
+}
+
+// NewPipeListener 创建进程内 listener，不需要注册地址
+func NewPipeListener() *PipeListener {
+	return &PipeListener{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// Dial 创建一对 net.Pipe，服务端一侧交给 Accept，返回客户端一侧
+func (l *PipeListener) Dial() (net.Conn, error) {
+	server, client := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		server.Close()
+		client.Close()
+		return nil, net.ErrClosed
+	}
+}
+
+// Accept 实现 net.Listener
+func (l *PipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close 实现 net.Listener
+func (l *PipeListener) Close() error {
+	err := net.ErrClosed
+	l.closed.Do(func() {
+		close(l.done)
+		if l.name != "" {
+			pipesMu.Lock()
+			delete(pipes, l.name)
+			pipesMu.Unlock()
+		}
+		err = nil
+	})
+	return err
+}
+
+// Addr 实现 net.Listener
+func (l *PipeListener) Addr() net.Addr {
+	return pipeAddr(l.name)
+}
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return SchemePipe }
+func (a pipeAddr) String() string  { return string(a) }
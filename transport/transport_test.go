@@ -0,0 +1,116 @@
+package transport
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/CoderI421/tcp-service/internal/testcert"
+)
+
+func TestParseAddr(t *testing.T) {
+	tests := []struct {
+		name        string
+		addr        string
+		wantScheme  string
+		wantAddress string
+		wantErr     bool
+	}{
+		{name: "Bare", addr: ":8888", wantScheme: SchemeTCP, wantAddress: ":8888"},
+		{name: "TCP", addr: "tcp://127.0.0.1:8888", wantScheme: SchemeTCP, wantAddress: "127.0.0.1:8888"},
+		{name: "TLS", addr: "tls://:8443", wantScheme: SchemeTLS, wantAddress: ":8443"},
+		{name: "Unix", addr: "unix:///tmp/a.sock", wantScheme: SchemeUnix, wantAddress: "/tmp/a.sock"},
+		{name: "Pipe", addr: "pipe://test", wantScheme: SchemePipe, wantAddress: "test"},
+		{name: "Unknown", addr: "udp://:8888", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme, address, err := ParseAddr(tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseAddr() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if scheme != tt.wantScheme || address != tt.wantAddress {
+				t.Errorf("ParseAddr() = %q, %q, want %q, %q", scheme, address, tt.wantScheme, tt.wantAddress)
+			}
+		})
+	}
+}
+
+func testEcho(t *testing.T, addr string, serverConfig, clientConfig *tls.Config) {
+	t.Helper()
+	l, err := ListenTLS(addr, serverConfig)
+	if err != nil {
+		t.Fatalf("Listen(%s) error = %v", addr, err)
+	}
+	defer l.Close()
+	if l.Addr().Network() == SchemeTCP {
+		// 监听的是随机端口
+		scheme, _, _ := ParseAddr(addr)
+		addr = scheme + "://" + l.Addr().String()
+	}
+
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+
+	c, err := DialTLS(addr, clientConfig)
+	if err != nil {
+		t.Fatalf("Dial(%s) error = %v", addr, err)
+	}
+	defer c.Close()
+
+	if _, err = c.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err = io.ReadFull(c, buf); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("Read() = %q, want %q", buf, "hello")
+	}
+}
+
+func TestListenDial(t *testing.T) {
+	serverConfig, clientConfig, err := testcert.Configs()
+	if err != nil {
+		t.Fatalf("generate cert error: %v", err)
+	}
+	t.Run("TCP", func(t *testing.T) { testEcho(t, "tcp://127.0.0.1:0", nil, nil) })
+	t.Run("TLS", func(t *testing.T) { testEcho(t, "tls://127.0.0.1:0", serverConfig, clientConfig) })
+	t.Run("Unix", func(t *testing.T) { testEcho(t, "unix://"+filepath.Join(t.TempDir(), "t.sock"), nil, nil) })
+	t.Run("Pipe", func(t *testing.T) { testEcho(t, "pipe://echo", nil, nil) })
+
+	if _, err = Listen("tls://127.0.0.1:0"); !errors.Is(err, ErrNoTLSConfig) {
+		t.Errorf("Listen(tls) without config error = %v, want %v", err, ErrNoTLSConfig)
+	}
+}
+
+func TestPipeListener_Close(t *testing.T) {
+	l, err := Listen("pipe://close")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	if _, err = Listen("pipe://close"); err == nil {
+		t.Error("Listen() on used pipe error = nil, want non-nil")
+	}
+	l.Close()
+
+	if _, err = l.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Accept() error = %v, want %v", err, net.ErrClosed)
+	}
+	if _, err = Dial("pipe://close"); err == nil {
+		t.Error("Dial() on closed pipe error = nil, want non-nil")
+	}
+	if err = l.Close(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("second Close() error = %v, want %v", err, net.ErrClosed)
+	}
+}
@@ -0,0 +1,351 @@
+package upgrade
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CoderI421/tcp-service/transport"
+)
+
+/*
+零停机重启：把 listener 的 fd 交给新启动的子进程
+
+	父进程                               子进程
+	Upgrade: 启动子进程，传入 listener fd
+	                                     New: 从环境变量中取回 listener
+	                                     Listen: 优先使用继承的 listener
+	                                     Ready: 通知父进程
+	收到通知后 drain，已有连接处理完后退出
+
+fd 按 systemd socket activation 的约定传递：从 3 开始，LISTEN_FDS 为数量，LISTEN_FDNAMES 为以 : 分隔的名称
+名称为转义后的地址（地址中含有 :）
+因此同样支持由 systemd 直接启动（.socket 单元），此时按名称或地址匹配 listener
+子进程就绪的通知通过额外传入的管道完成，fd 由 TCP_SERVICE_READY_FD 指定
+*/
+
+const (
+	envListenFDs     = "LISTEN_FDS"
+	envListenPID     = "LISTEN_PID"
+	envListenFDNames = "LISTEN_FDNAMES"
+	envReadyFD       = "TCP_SERVICE_READY_FD"
+
+	// listenFDsStart 第一个继承的 fd
+	listenFDsStart = 3
+)
+
+// DefaultReadyTimeout 等待子进程就绪的默认时长
+const DefaultReadyTimeout = 30 * time.Second
+
+var (
+	// ErrUpgraded 已经完成过一次 Upgrade
+	ErrUpgraded = errors.New("upgrade: already upgraded")
+	// ErrChildExited 子进程在就绪之前退出
+	ErrChildExited = errors.New("upgrade: child exited before ready")
+	// ErrReadyTimeout 等待子进程就绪超时
+	ErrReadyTimeout = errors.New("upgrade: child ready timeout")
+)
+
+// filer 可以取出 fd 的 listener，如 *net.TCPListener、*net.UnixListener
+type filer interface {
+	File() (*os.File, error)
+}
+
+// named 一个带地址名称的 listener，名称即 Listen 时的地址
+type named struct {
+	name string
+	l    net.Listener
+}
+
+// Upgrader 管理可交接的 listener，并发安全
+type Upgrader struct {
+	// Path 与 Args 为启动子进程的命令，默认为当前可执行文件及启动参数
+	Path string
+	Args []string
+	// ReadyTimeout 等待子进程就绪的时长，0 表示使用默认值
+	ReadyTimeout time.Duration
+
+	mu        sync.Mutex
+	inherited []named // 继承但尚未被 Listen 取走的 listener
+	active    []named // 当前进程使用中的原始 listener（TLS 包装之前）
+	readyFile *os.File
+	upgraded  bool
+}
+
+// New 创建 Upgrader，并取回从父进程或 systemd 继承的 listener
+// 相关环境变量会被清除，避免再传给之后启动的进程
+func New() (*Upgrader, error) {
+	u := &Upgrader{}
+	defer func() {
+		for _, key := range []string{envListenFDs, envListenPID, envListenFDNames, envReadyFD} {
+			os.Unsetenv(key)
+		}
+	}()
+
+	if s := os.Getenv(envReadyFD); s != "" {
+		fd, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("upgrade: invalid %s [%s]", envReadyFD, s)
+		}
+		u.readyFile = os.NewFile(uintptr(fd), "ready")
+	}
+
+	s := os.Getenv(envListenFDs)
+	if s == "" {
+		return u, nil
+	}
+	// systemd 会设置 LISTEN_PID，父进程交接时无法预知子进程的 pid，不设置
+	if pid := os.Getenv(envListenPID); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return u, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("upgrade: invalid %s [%s]", envListenFDs, s)
+	}
+	names := strings.Split(os.Getenv(envListenFDNames), ":")
+	for i := 0; i < n; i++ {
+		name := ""
+		if i < len(names) {
+			name = decodeName(names[i])
+		}
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			u.closeInherited()
+			return nil, fmt.Errorf("upgrade: inherit fd %d: %w", listenFDsStart+i, err)
+		}
+		u.inherited = append(u.inherited, named{name: name, l: l})
+	}
+	return u, nil
+}
+
+// decodeName 还原转义的地址，systemd 中配置的名称原样返回
+func decodeName(name string) string {
+	if s, err := url.QueryUnescape(name); err == nil {
+		return s
+	}
+	return name
+}
+
+// IsChild 当前进程是否由 Upgrade 启动
+func (u *Upgrader) IsChild() bool {
+	return u.readyFile != nil
+}
+
+// Listen 按地址创建 listener，有匹配的继承 listener 时直接使用
+func (u *Upgrader) Listen(addr string) (net.Listener, error) {
+	return u.ListenTLS(addr, nil)
+}
+
+// ListenTLS 同 transport.ListenTLS，有匹配的继承 listener 时直接使用
+// 交接的是 TLS 包装之前的 listener
+func (u *Upgrader) ListenTLS(addr string, config *tls.Config) (net.Listener, error) {
+	scheme, _, err := transport.ParseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	if scheme == transport.SchemeTLS && config == nil {
+		return nil, transport.ErrNoTLSConfig
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	l := u.takeInherited(addr)
+	if l == nil {
+		// 创建原始 listener，tls 在下面包装
+		raw := addr
+		if scheme == transport.SchemeTLS {
+			raw = transport.SchemeTCP + strings.TrimPrefix(addr, transport.SchemeTLS)
+		}
+		if l, err = transport.Listen(raw); err != nil {
+			return nil, err
+		}
+	}
+	u.active = append(u.active, named{name: addr, l: l})
+	if scheme == transport.SchemeTLS {
+		return tls.NewListener(l, config), nil
+	}
+	return l, nil
+}
+
+// takeInherited 取走名称或地址匹配的继承 listener，调用方需持有 mu
+func (u *Upgrader) takeInherited(addr string) net.Listener {
+	match := func(fn func(n named) bool) net.Listener {
+		for i, n := range u.inherited {
+			if fn(n) {
+				u.inherited = append(u.inherited[:i], u.inherited[i+1:]...)
+				return n.l
+			}
+		}
+		return nil
+	}
+	if l := match(func(n named) bool { return n.name == addr }); l != nil {
+		return l
+	}
+	return match(func(n named) bool { return matchAddr(addr, n.l.Addr()) })
+}
+
+// matchAddr 判断 listener 的地址是否与配置的地址一致，用于没有名称的 systemd 套接字
+func matchAddr(addr string, la net.Addr) bool {
+	scheme, address, err := transport.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	switch scheme {
+	case transport.SchemeTCP, transport.SchemeTLS:
+		ta, ok := la.(*net.TCPAddr)
+		if !ok {
+			return false
+		}
+		want, err := net.ResolveTCPAddr("tcp", address)
+		if err != nil || want.Port != ta.Port {
+			return false
+		}
+		return want.IP == nil || want.IP.IsUnspecified() || want.IP.Equal(ta.IP)
+	case transport.SchemeUnix:
+		return la.Network() == transport.SchemeUnix && la.String() == address
+	}
+	return false
+}
+
+// Ready 子进程完成 Listen 后调用，关闭未被使用的继承 listener 并通知父进程
+// 非子进程时只关闭未被使用的继承 listener
+func (u *Upgrader) Ready() error {
+	u.mu.Lock()
+	u.closeInherited()
+	f := u.readyFile
+	u.readyFile = nil
+	u.mu.Unlock()
+
+	if f == nil {
+		return nil
+	}
+	defer f.Close()
+	_, err := f.Write([]byte{1})
+	return err
+}
+
+func (u *Upgrader) closeInherited() {
+	for _, n := range u.inherited {
+		n.l.Close()
+	}
+	u.inherited = nil
+}
+
+// Upgrade 启动子进程并交出所有 listener，阻塞直到子进程就绪
+// 成功后当前进程应停止 accept（如 Server.Drain），并在已有连接处理完后退出
+// 失败时子进程会被结束，当前进程继续正常服务
+func (u *Upgrader) Upgrade() (*os.Process, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.upgraded {
+		return nil, ErrUpgraded
+	}
+
+	var (
+		files []*os.File
+		names []string
+	)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, n := range u.active {
+		fl, ok := n.l.(filer)
+		if !ok {
+			// 如 pipe 等进程内的 listener，无法交接
+			continue
+		}
+		f, err := fl.File()
+		if err != nil {
+			return nil, fmt.Errorf("upgrade: %s: %w", n.name, err)
+		}
+		files = append(files, f)
+		names = append(names, url.QueryEscape(n.name))
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	path, args := u.Path, u.Args
+	if path == "" {
+		if path, err = os.Executable(); err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+	if len(args) == 0 {
+		args = os.Args
+	}
+	cmd := exec.Command(path, args[1:]...)
+	cmd.Args[0] = args[0]
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, w)
+	cmd.Env = append(os.Environ(),
+		envListenFDs+"="+strconv.Itoa(len(files)),
+		envListenFDNames+"="+strings.Join(names, ":"),
+		envReadyFD+"="+strconv.Itoa(listenFDsStart+len(files)),
+	)
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return nil, fmt.Errorf("upgrade: start child: %w", err)
+	}
+
+	timeout := u.ReadyTimeout
+	if timeout <= 0 {
+		timeout = DefaultReadyTimeout
+	}
+	r.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 1)
+	if _, err = r.Read(buf); err != nil {
+		// 子进程退出时管道的写端随之关闭，读到 EOF
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			err = ErrReadyTimeout
+		} else {
+			err = ErrChildExited
+		}
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+
+	// unix socket 文件已交给子进程，关闭 listener 时不能删除
+	for _, n := range u.active {
+		if ul, ok := n.l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	u.upgraded = true
+	return cmd.Process, nil
+}
+
+// Close 关闭所有 listener
+func (u *Upgrader) Close() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.closeInherited()
+	var err error
+	for _, n := range u.active {
+		if cerr := n.l.Close(); cerr != nil && err == nil && !errors.Is(cerr, net.ErrClosed) {
+			err = cerr
+		}
+	}
+	return err
+}
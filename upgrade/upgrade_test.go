@@ -0,0 +1,170 @@
+package upgrade
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// envHelper 作为子进程运行时的监听地址，以 ; 分隔
+const envHelper = "UPGRADE_TEST_HELPER"
+
+// TestHelperProcess 子进程入口：继承 listener，就绪后对每个连接回复 "child"
+func TestHelperProcess(t *testing.T) {
+	addrs := os.Getenv(envHelper)
+	if addrs == "" {
+		t.Skip("helper process only")
+	}
+	u, err := New()
+	if err != nil {
+		os.Exit(2)
+	}
+	for _, addr := range strings.Split(addrs, ";") {
+		l, err := u.Listen(addr)
+		if err != nil {
+			os.Exit(3)
+		}
+		go serve(l, "child")
+	}
+	if os.Getenv("UPGRADE_TEST_FAIL") != "" {
+		os.Exit(4)
+	}
+	u.Ready()
+	time.Sleep(5 * time.Second)
+	os.Exit(0)
+}
+
+// serve 对每个连接回复 reply 后关闭
+func serve(l net.Listener, reply string) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c.Write([]byte(reply + "\n"))
+		c.Close()
+	}
+}
+
+func request(t *testing.T, network, addr string) string {
+	t.Helper()
+	c, err := net.Dial(network, addr)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	s, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read error = %v", err)
+	}
+	return strings.TrimSpace(s)
+}
+
+func newHelper(t *testing.T, addrs []string, fail bool) *Upgrader {
+	t.Helper()
+	t.Setenv(envHelper, strings.Join(addrs, ";"))
+	if fail {
+		t.Setenv("UPGRADE_TEST_FAIL", "1")
+	}
+	u, err := New()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	u.Args = []string{os.Args[0], "-test.run=^TestHelperProcess$"}
+	u.ReadyTimeout = 10 * time.Second
+	return u
+}
+
+func TestUpgrader_Upgrade(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "upgrade.sock")
+	addrs := []string{"tcp://127.0.0.1:0", "unix://" + sock}
+	u := newHelper(t, addrs, false)
+	defer u.Close()
+
+	tl, err := u.Listen(addrs[0])
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	ul, err := u.Listen(addrs[1])
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	go serve(tl, "parent")
+	go serve(ul, "parent")
+	tcpAddr := tl.Addr().String()
+	if got := request(t, "tcp", tcpAddr); got != "parent" {
+		t.Fatalf("before upgrade got %q, want parent", got)
+	}
+
+	p, err := u.Upgrade()
+	if err != nil {
+		t.Fatalf("Upgrade() error = %v", err)
+	}
+	defer func() {
+		p.Kill()
+		p.Wait()
+	}()
+	if _, err = u.Upgrade(); !errors.Is(err, ErrUpgraded) {
+		t.Errorf("second Upgrade() error = %v, want %v", err, ErrUpgraded)
+	}
+
+	// 父进程停止 accept 后，同一地址由子进程继续服务，unix socket 文件不被删除
+	u.Close()
+	if got := request(t, "tcp", tcpAddr); got != "child" {
+		t.Errorf("after upgrade tcp got %q, want child", got)
+	}
+	if got := request(t, "unix", sock); got != "child" {
+		t.Errorf("after upgrade unix got %q, want child", got)
+	}
+}
+
+func TestUpgrader_UpgradeChildExited(t *testing.T) {
+	addr := "tcp://127.0.0.1:0"
+	u := newHelper(t, []string{addr}, true)
+	defer u.Close()
+
+	l, err := u.Listen(addr)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	go serve(l, "parent")
+	if _, err = u.Upgrade(); !errors.Is(err, ErrChildExited) {
+		t.Fatalf("Upgrade() error = %v, want %v", err, ErrChildExited)
+	}
+	// 失败后父进程继续服务
+	if got := request(t, "tcp", l.Addr().String()); got != "parent" {
+		t.Errorf("got %q, want parent", got)
+	}
+}
+
+func TestMatchAddr(t *testing.T) {
+	tcp := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8888}
+	any := &net.TCPAddr{IP: net.IPv6unspecified, Port: 8888}
+	unix := &net.UnixAddr{Name: "/tmp/a.sock", Net: "unix"}
+	tests := []struct {
+		addr string
+		la   net.Addr
+		want bool
+	}{
+		{":8888", tcp, true},
+		{"tcp://127.0.0.1:8888", tcp, true},
+		{"tls://:8888", any, true},
+		{"127.0.0.2:8888", tcp, false},
+		{":9999", tcp, false},
+		{"unix:///tmp/a.sock", unix, true},
+		{"unix:///tmp/b.sock", unix, false},
+		{":8888", unix, false},
+		{"pipe://a", tcp, false},
+	}
+	for _, tt := range tests {
+		if got := matchAddr(tt.addr, tt.la); got != tt.want {
+			t.Errorf("matchAddr(%q, %v) = %v, want %v", tt.addr, tt.la, got, tt.want)
+		}
+	}
+}